@@ -0,0 +1,98 @@
+// Package astcache keeps parsed Go source in memory, keyed by content hash,
+// so code looked at again and again during a session, such as the other
+// files of a package for every test generated in it, is parsed only once.
+package astcache
+
+import (
+	"crypto/sha256"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"sync"
+)
+
+// Mode is the parser mode of cached files. Comments and object resolution
+// are always included, so one AST serves every caller.
+const Mode = parser.ParseComments
+
+// DefaultMaxFiles is the number of parsed files Shared keeps
+const DefaultMaxFiles = 4096
+
+// Shared is the cache used by the agent's packages
+var Shared = New(DefaultMaxFiles)
+
+// File is a parsed Go file. Its AST is shared by every caller and must not
+// be modified.
+type File struct {
+	Fset *token.FileSet
+	AST  *ast.File
+}
+
+// entry is a cached parse result, including a parse error
+type entry struct {
+	file *File
+	err  error
+}
+
+// Cache holds parsed files up to a maximum number (0 = unlimited), dropping
+// the oldest ones first. It is safe for concurrent use.
+type Cache struct {
+	mu       sync.Mutex
+	entries  map[[sha256.Size]byte]entry
+	order    [][sha256.Size]byte // Keys in insertion order, for eviction
+	maxFiles int
+}
+
+// New creates a cache of up to maxFiles parsed files
+func New(maxFiles int) *Cache {
+	return &Cache{entries: make(map[[sha256.Size]byte]entry), maxFiles: maxFiles}
+}
+
+// ParseFile parses a Go file from disk, or returns the parse of an earlier
+// call if the file's content hasn't changed since
+func (c *Cache) ParseFile(path string) (*File, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.ParseSource(path, src)
+}
+
+// ParseSource parses Go source, or returns the parse of an earlier call
+// with the same name and source. name is used in positions and errors.
+func (c *Cache) ParseSource(name string, src []byte) (*File, error) {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(src)
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		return cached.file, cached.err
+	}
+
+	// Parse outside the lock; a file parsed twice concurrently is harmless
+	fset := token.NewFileSet()
+	parsed, err := parser.ParseFile(fset, name, src, Mode)
+	cached = entry{err: err}
+	if err == nil {
+		cached.file = &File{Fset: fset, AST: parsed}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		if c.maxFiles > 0 && len(c.order) >= c.maxFiles {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.entries[key] = cached
+		c.order = append(c.order, key)
+	}
+	return cached.file, cached.err
+}
@@ -0,0 +1,149 @@
+// Package attest signs and verifies attestations of a session's final
+// coverage, so release pipelines can take a coverage gate the agent passed
+// as evidence instead of running the test suite again.
+package attest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/schema"
+)
+
+// Algorithm is the signature algorithm of attestations
+const Algorithm = "HMAC-SHA256"
+
+// DefaultKeyEnv names the environment variable holding the signing key
+// unless configured otherwise
+const DefaultKeyEnv = "COVERAGE_AGENT_ATTESTATION_KEY"
+
+// Attestation is the signed statement about a session's final coverage
+type Attestation struct {
+	Repository   string            `json:"repository,omitempty"` // Remote URL of the project, if any
+	Commit       string            `json:"commit"`               // Commit the coverage was measured at
+	Branch       string            `json:"branch,omitempty"`
+	Dirty        bool              `json:"dirty,omitempty"` // Uncommitted changes were measured too
+	Language     string            `json:"language"`
+	Coverage     float64           `json:"coverage"`
+	Target       float64           `json:"target"`
+	TargetMet    bool              `json:"target_met"`
+	AgentVersion string            `json:"agent_version"`
+	ToolVersions map[string]string `json:"tool_versions,omitempty"` // Coverage tool versions by tool
+	IssuedAt     time.Time         `json:"issued_at"`
+}
+
+// Document is an attestation file: the attestation and its signature over
+// the attestation's compact JSON encoding
+type Document struct {
+	Schema      string          `json:"$schema,omitempty"` // Published JSON schema of the file
+	Attestation json.RawMessage `json:"attestation"`
+	Algorithm   string          `json:"algorithm"`
+	Signature   string          `json:"signature"` // Hex-encoded
+}
+
+// Key returns the signing key from an environment variable, or
+// DefaultKeyEnv if name is empty
+func Key(name string) ([]byte, error) {
+	if name == "" {
+		name = DefaultKeyEnv
+	}
+	key := os.Getenv(name)
+	if key == "" {
+		return nil, fmt.Errorf("no attestation key: %s is not set", name)
+	}
+	return []byte(key), nil
+}
+
+// Write signs an attestation with key and writes it to path
+func Write(path string, att Attestation, key []byte) error {
+	payload, err := json.Marshal(att)
+	if err != nil {
+		return fmt.Errorf("failed to encode attestation: %w", err)
+	}
+
+	data, err := json.MarshalIndent(Document{
+		Schema:      schema.BaseURL + schema.Attestation,
+		Attestation: payload,
+		Algorithm:   Algorithm,
+		Signature:   sign(payload, key),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode attestation: %w", err)
+	}
+	if err := schema.Validate(schema.Attestation, data); err != nil {
+		return fmt.Errorf("attestation %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write attestation: %w", err)
+	}
+	return nil
+}
+
+// Verify reads an attestation file and checks its signature with key. The
+// signature covers the attestation's content, not its formatting.
+func Verify(path string, key []byte) (*Attestation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation: %w", err)
+	}
+
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation: %w", err)
+	}
+	if doc.Algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported attestation algorithm %q (want %s)", doc.Algorithm, Algorithm)
+	}
+
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, doc.Attestation); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation: %w", err)
+	}
+	signature, err := hex.DecodeString(doc.Signature)
+	if err != nil || !hmac.Equal(signature, mac(payload.Bytes(), key)) {
+		return nil, fmt.Errorf("attestation signature does not match")
+	}
+
+	var att Attestation
+	if err := json.Unmarshal(payload.Bytes(), &att); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation: %w", err)
+	}
+	return &att, nil
+}
+
+// AgentVersion returns the agent's module version, with the VCS revision
+// it was built from when the build recorded one
+func AgentVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			version += " (" + setting.Value + ")"
+		}
+	}
+	return version
+}
+
+// sign returns the hex-encoded signature of a payload
+func sign(payload, key []byte) string {
+	return hex.EncodeToString(mac(payload, key))
+}
+
+// mac computes the HMAC-SHA256 of a payload
+func mac(payload, key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
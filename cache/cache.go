@@ -0,0 +1,291 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DirName is the per-project directory holding all agent artifacts
+const DirName = ".coverage-agent"
+
+// DefaultMaxSize is the default size limit for collectable cache contents
+const DefaultMaxSize int64 = 500 * 1024 * 1024
+
+// Kind identifies a section of the cache
+type Kind string
+
+const (
+	Responses Kind = "responses" // LLM responses keyed by prompt hash
+	Prompts   Kind = "prompts"   // Prompts sent to the LLM, for debugging
+	Coverage  Kind = "coverage"  // Coverage tool output
+	Logs      Kind = "logs"      // Command and validation output
+	Journal   Kind = "journal"   // Change journal for non-git projects (never collected)
+	Toolchain Kind = "toolchain" // Isolated tool caches and build trees (never collected)
+)
+
+// collectable lists the kinds that garbage collection may remove
+var collectable = []Kind{Responses, Prompts, Coverage, Logs}
+
+// Cache is the agent's working directory inside a project
+type Cache struct {
+	root string
+}
+
+// New returns the cache for a project
+func New(projectPath string) *Cache {
+	return &Cache{
+		root: filepath.Join(projectPath, DirName),
+	}
+}
+
+// Root returns the cache root directory
+func (c *Cache) Root() string {
+	return c.root
+}
+
+// Init creates the cache root, keeping it out of version control
+func (c *Cache) Init() error {
+	if err := os.MkdirAll(c.root, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	ignoreFile := filepath.Join(c.root, ".gitignore")
+	if !fileExists(ignoreFile) {
+		if err := os.WriteFile(ignoreFile, []byte("*\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write cache .gitignore: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Dir returns the directory for a kind of artifact, creating it if needed
+func (c *Cache) Dir(kind Kind) (string, error) {
+	if err := c.Init(); err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(c.root, string(kind))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	return dir, nil
+}
+
+// LegacyStateFile is the default state file of earlier versions, which
+// was relative to the directory the agent ran in
+const LegacyStateFile = ".coverage-agent-state.json"
+
+// StateFile returns the default location of the session state file. While
+// it doesn't exist but a legacy state file does, in the project or the
+// working directory, the legacy file is returned, so -resume continues a
+// session started by an earlier version.
+func (c *Cache) StateFile() string {
+	stateFile := filepath.Join(c.root, "state.json")
+	if fileExists(stateFile) {
+		return stateFile
+	}
+	for _, legacy := range []string{filepath.Join(filepath.Dir(c.root), LegacyStateFile), LegacyStateFile} {
+		if fileExists(legacy) {
+			return legacy
+		}
+	}
+	return stateFile
+}
+
+// ReportFile returns the default path of the HTML session report
+func (c *Cache) ReportFile() string {
+	return filepath.Join(c.root, "report.html")
+}
+
+// Path returns the path of a named artifact without creating anything
+func (c *Cache) Path(kind Kind, name string) string {
+	return filepath.Join(c.root, string(kind), name)
+}
+
+// Get returns a cached entry by key
+func (c *Cache) Get(kind Kind, key string) ([]byte, bool) {
+	data, err := os.ReadFile(c.Path(kind, key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put stores an entry by key
+func (c *Cache) Put(kind Kind, key string, data []byte) error {
+	dir, err := c.Dir(kind)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, key), data, 0644); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+// Delete removes an entry by key; a missing entry is not an error
+func (c *Cache) Delete(kind Kind, key string) error {
+	if err := os.Remove(c.Path(kind, key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete cache entry: %w", err)
+	}
+	return nil
+}
+
+// Key derives a stable cache key from the given parts
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Size returns the total size in bytes of collectable cache contents
+func (c *Cache) Size() (int64, error) {
+	files, err := c.collectableFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	return total, nil
+}
+
+// GCResult summarizes a garbage collection pass
+type GCResult struct {
+	Removed int
+	Freed   int64
+}
+
+// GC removes files older than maxAge (if non-zero) and then the oldest
+// files until the collectable contents fit within maxSize bytes. The
+// journal is never collected.
+func (c *Cache) GC(maxSize int64, maxAge time.Duration) (*GCResult, error) {
+	files, err := c.collectableFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// Oldest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+
+	result := &GCResult{}
+	cutoff := time.Now().Add(-maxAge)
+
+	for _, f := range files {
+		expired := maxAge > 0 && f.modTime.Before(cutoff)
+		if !expired && total <= maxSize {
+			continue
+		}
+
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return result, fmt.Errorf("failed to remove %s: %w", f.path, err)
+		}
+		total -= f.size
+		result.Removed++
+		result.Freed += f.size
+	}
+
+	return result, nil
+}
+
+// Prune keeps only the newest keep files of a kind whose names start with
+// prefix, removing the rest
+func (c *Cache) Prune(kind Kind, prefix string, keep int) error {
+	entries, err := os.ReadDir(filepath.Join(c.root, string(kind)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	var files []cacheFile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{path: filepath.Join(c.root, string(kind), entry.Name()), modTime: info.ModTime()})
+	}
+
+	// Newest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	for i := keep; i < len(files); i++ {
+		if err := os.Remove(files[i].path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", files[i].path, err)
+		}
+	}
+
+	return nil
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// collectableFiles lists all files in collectable sections of the cache
+func (c *Cache) collectableFiles() ([]cacheFile, error) {
+	var files []cacheFile
+
+	for _, kind := range collectable {
+		dir := filepath.Join(c.root, string(kind))
+		if !fileExists(dir) {
+			continue
+		}
+
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files = append(files, cacheFile{path: path, size: info.Size(), modTime: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan cache: %w", err)
+		}
+	}
+
+	return files, nil
+}
+
+// fileExists checks if a file exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
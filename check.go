@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tablev/test-coverage-agent/config"
+	"github.com/tablev/test-coverage-agent/coverage"
+)
+
+// Exit codes of check. A check that can't measure coverage, e.g. because
+// of a bad config or a test run that fails to build, exits with
+// exitCheckError like bad flags do, so CI can tell it from low coverage.
+const (
+	exitFloorViolated = 1
+	exitCheckError    = 2
+)
+
+// runCheck measures coverage and fails if it is below the configured
+// floors, without generating tests, so CI can enforce what the agent
+// remediates
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	projectPath := fs.String("project", ".", "Path to the project to check")
+	configFile := fs.String("config", "", "Configuration file with min_coverage, package_targets and baseline (default: <project>/"+config.DefaultConfigFile+" if present)")
+	coverageReport := fs.String("coverage-report", "", "Check an existing coverage report instead of running the test suite")
+	minCoverage := fs.Float64("min-coverage", -1, "Fail if total coverage is below this percentage (default: min_coverage from the config file, else none)")
+	baseline := fs.String("baseline", "", "Fail if total or package coverage dropped below this baseline file (default: baseline from the config file, else none)")
+	tolerance := fs.Float64("tolerance", 0, "Allowed drop in percentage points below the baseline")
+	updateBaseline := fs.Bool("update-baseline", false, "Write the measured coverage to the baseline file after a passing check")
+	fs.Parse(args)
+
+	cfg := &config.Config{}
+	if err := loadCheckConfig(cfg, *projectPath, *configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return exitCheckError
+	}
+	cfg.ProjectPath = *projectPath
+	if *coverageReport != "" {
+		cfg.CoverageReport = *coverageReport
+	}
+	if *minCoverage >= 0 {
+		cfg.MinCoverage = *minCoverage
+	}
+	if *baseline != "" {
+		cfg.Baseline = *baseline
+	}
+
+	if cfg.MinCoverage < 0 || cfg.MinCoverage > 100 {
+		fmt.Fprintf(os.Stderr, "Error: min-coverage must be between 0 and 100\n")
+		return exitCheckError
+	}
+	for pattern, target := range cfg.PackageTargets {
+		if target < 0 || target > 100 {
+			fmt.Fprintf(os.Stderr, "Error: package target %q must be between 0 and 100\n", pattern)
+			return exitCheckError
+		}
+	}
+	if *tolerance < 0 {
+		fmt.Fprintf(os.Stderr, "Error: tolerance must not be negative\n")
+		return exitCheckError
+	}
+	if *updateBaseline && cfg.Baseline == "" {
+		fmt.Fprintf(os.Stderr, "Error: -update-baseline needs -baseline or baseline in the config file\n")
+		return exitCheckError
+	}
+
+	floors := coverage.Floors{Total: cfg.MinCoverage, Packages: cfg.PackageTargets, Tolerance: *tolerance}
+	baselineFile := cfg.Baseline
+	if baselineFile != "" && !filepath.IsAbs(baselineFile) {
+		baselineFile = filepath.Join(cfg.ProjectPath, baselineFile)
+	}
+	if baselineFile != "" {
+		b, err := coverage.LoadBaseline(baselineFile)
+		switch {
+		case err == nil:
+			floors.Baseline = b
+		case errors.Is(err, os.ErrNotExist) && *updateBaseline:
+			// The first run creates the baseline
+		case errors.Is(err, os.ErrNotExist):
+			fmt.Fprintf(os.Stderr, "Error: %v; run with -update-baseline to create it\n", err)
+			return exitCheckError
+		default:
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitCheckError
+		}
+	}
+	if floors.Total == 0 && len(floors.Packages) == 0 && floors.Baseline == nil && !*updateBaseline {
+		fmt.Fprintf(os.Stderr, "Error: nothing to check; set -min-coverage, -baseline, or min_coverage, package_targets or baseline in the config file\n")
+		return exitCheckError
+	}
+
+	report, err := checkCoverage(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitCheckError
+	}
+
+	packages := report.ByPackage(cfg.ProjectPath)
+	fmt.Printf("Total coverage: %.2f%% in %d package(s)\n", report.TotalCoverage, len(packages))
+
+	violations := floors.Check(cfg.ProjectPath, report)
+	for _, v := range violations {
+		fmt.Fprintf(os.Stderr, "Failed: %s\n", v)
+	}
+	if len(violations) > 0 {
+		fmt.Fprintf(os.Stderr, "%d coverage floor(s) violated; run test-coverage-agent to write the missing tests\n", len(violations))
+		return exitFloorViolated
+	}
+	fmt.Println("All coverage floors met")
+
+	if *updateBaseline {
+		if err := coverage.NewBaseline(cfg.ProjectPath, report).Save(baselineFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitCheckError
+		}
+		fmt.Printf("Updated baseline %s\n", cfg.Baseline)
+	}
+	return 0
+}
+
+// loadCheckConfig loads the config file, or the project's default config
+// file if it exists
+func loadCheckConfig(cfg *config.Config, projectPath, path string) error {
+	if path == "" {
+		path = filepath.Join(projectPath, config.DefaultConfigFile)
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+	}
+	return config.LoadConfig(path, cfg)
+}
+
+// checkCoverage measures coverage the way a session starts: from the
+// configured report or by running the test suite, with extra reports
+// merged in and test files left out. Ignore directives only steer the
+// work plan, so they don't count here either.
+func checkCoverage(cfg *config.Config) (*coverage.CoverageReport, error) {
+	analyzer, err := coverage.DetectProjectLanguage(cfg.ProjectPath, cfg.Analyzer)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *coverage.CoverageReport
+	if cfg.CoverageReport != "" {
+		report, err = coverage.LoadReport(cfg.ProjectPath, cfg.CoverageReport, analyzer, cfg.Analyzer)
+	} else {
+		fmt.Printf("Running %s tests with coverage...\n", analyzer.GetLanguageName())
+		report, err = analyzer.RunCoverage(cfg.ProjectPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure coverage: %w", err)
+	}
+
+	if len(cfg.MergeCoverage) > 0 {
+		extra := make([]*coverage.CoverageReport, 0, len(cfg.MergeCoverage))
+		for _, file := range cfg.MergeCoverage {
+			r, err := coverage.LoadReport(cfg.ProjectPath, file, analyzer, cfg.Analyzer)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load coverage to merge: %w", err)
+			}
+			extra = append(extra, r)
+		}
+		report = coverage.MergeReports(report, extra...)
+	}
+
+	coverage.DropTestFiles(cfg.ProjectPath, analyzer.GetLanguageName(), report)
+	coverage.ExcludeGenerated(cfg.ProjectPath, report, cfg.DropGenerated)
+	return report, nil
+}
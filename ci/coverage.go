@@ -0,0 +1,153 @@
+// Package ci fetches the coverage CI reported for a commit, so the agent can
+// check its locally computed numbers against it.
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Codecov selects the Codecov API as the coverage source
+const Codecov = "codecov"
+
+// CodecovAPIURL is the base URL of the Codecov API
+const CodecovAPIURL = "https://api.codecov.io/api/v2"
+
+// CodecovTokenEnv names the environment variable holding a Codecov API
+// token, needed for private repositories
+const CodecovTokenEnv = "CODECOV_API_TOKEN"
+
+// CommitPlaceholder in a custom source URL is replaced by the commit hash
+const CommitPlaceholder = "{commit}"
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// CheckSource validates a coverage source: "codecov" or an http(s) URL
+func CheckSource(source string) error {
+	if source == Codecov || strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return nil
+	}
+	return fmt.Errorf("unsupported CI coverage source %q (want %s or an http(s) URL)", source, Codecov)
+}
+
+// FetchCoverage returns the total coverage CI reported for a commit. With
+// "codecov" the repository is taken from the git remote URL; any other
+// source is a URL returning either a number or a JSON object with a
+// "coverage" or "totals.coverage" field.
+func FetchCoverage(source, remoteURL, commit string) (float64, error) {
+	if err := CheckSource(source); err != nil {
+		return 0, err
+	}
+
+	var req *http.Request
+	var err error
+	if source == Codecov {
+		req, err = codecovRequest(remoteURL, commit)
+	} else {
+		req, err = http.NewRequest("GET", strings.ReplaceAll(source, CommitPlaceholder, commit), nil)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch CI coverage: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read CI coverage: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, fmt.Errorf("no CI coverage found for commit %s", commit)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("CI coverage request failed (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return parseCoverage(body)
+}
+
+// codecovRequest builds the Codecov API request for a commit
+func codecovRequest(remoteURL, commit string) (*http.Request, error) {
+	service, owner, repo, err := parseRemote(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/%s/repos/%s/commits/%s/", CodecovAPIURL,
+		service, url.PathEscape(owner), url.PathEscape(repo), commit)
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv(CodecovTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// codecovServices maps git hosts to Codecov service names
+var codecovServices = map[string]string{
+	"github.com":    "github",
+	"gitlab.com":    "gitlab",
+	"bitbucket.org": "bitbucket",
+}
+
+// parseRemote extracts the Codecov service, owner and repository from a
+// git remote URL in https, ssh or scp-like form
+func parseRemote(remoteURL string) (string, string, string, error) {
+	remote := strings.TrimSuffix(strings.TrimSpace(remoteURL), ".git")
+
+	var host, path string
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else if at := strings.Index(remote, "@"); at >= 0 {
+		// git@github.com:owner/repo
+		host, path, _ = strings.Cut(remote[at+1:], ":")
+	}
+
+	service, ok := codecovServices[host]
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if !ok || len(parts) < 2 {
+		return "", "", "", fmt.Errorf("can't derive a Codecov repository from git remote %q", remoteURL)
+	}
+
+	// GitLab subgroups are part of the owner
+	owner := strings.Join(parts[:len(parts)-1], ":")
+	return service, owner, parts[len(parts)-1], nil
+}
+
+// parseCoverage reads a coverage percentage from a response body
+func parseCoverage(body []byte) (float64, error) {
+	var number float64
+	if err := json.Unmarshal(body, &number); err == nil {
+		return number, nil
+	}
+
+	var response struct {
+		Coverage *float64 `json:"coverage"`
+		Totals   *struct {
+			Coverage *float64 `json:"coverage"`
+		} `json:"totals"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, fmt.Errorf("failed to parse CI coverage: %w", err)
+	}
+
+	switch {
+	case response.Coverage != nil:
+		return *response.Coverage, nil
+	case response.Totals != nil && response.Totals.Coverage != nil:
+		return *response.Totals.Coverage, nil
+	}
+	return 0, fmt.Errorf("CI coverage response has no coverage number")
+}
@@ -0,0 +1,167 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Pull request labels that adjust a session
+const (
+	LabelSkip         = "coverage:skip"    // Don't run the agent for the pull request
+	LabelStrict       = "coverage:strict"  // Raise the target to the strict target
+	LabelTargetPrefix = "coverage:target=" // Set the target, e.g. coverage:target=85
+)
+
+// GitHubAPIURL is the GitHub API used unless GITHUB_API_URL says otherwise,
+// as it does on GitHub Enterprise Server
+const GitHubAPIURL = "https://api.github.com"
+
+// githubPullRef matches the ref GitHub Actions checks out for pull requests
+var githubPullRef = regexp.MustCompile(`^refs/pull/(\d+)/`)
+
+// LabelPolicy is what a pull request's labels ask of a session
+type LabelPolicy struct {
+	Skip    bool
+	Target  float64 // Target coverage to use instead of the configured one
+	AtLeast bool    // Target only raises a lower configured target
+	Label   string  // The label that decided the policy, "" to keep the configured target
+}
+
+// PullRequestLabels returns the labels of the pull or merge request a CI
+// job runs for, and false outside of one. On GitHub Actions the labels are
+// fetched from the API, so labels added after the job was queued count too;
+// GitLab CI passes them in CI_MERGE_REQUEST_LABELS.
+func PullRequestLabels() ([]string, bool, error) {
+	if os.Getenv("GITLAB_CI") == "true" {
+		iid := os.Getenv("CI_MERGE_REQUEST_IID")
+		if iid == "" {
+			return nil, false, nil
+		}
+		var labels []string
+		for _, label := range strings.Split(os.Getenv("CI_MERGE_REQUEST_LABELS"), ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				labels = append(labels, label)
+			}
+		}
+		return labels, true, nil
+	}
+
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		number := githubPullNumber()
+		if number == 0 {
+			return nil, false, nil
+		}
+		labels, err := githubLabels(os.Getenv("GITHUB_REPOSITORY"), number)
+		if err != nil {
+			return nil, true, err
+		}
+		return labels, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// githubPullNumber returns the number of the pull request a GitHub Actions
+// job runs for, or 0
+func githubPullNumber() int {
+	if m := githubPullRef.FindStringSubmatch(os.Getenv("GITHUB_REF")); m != nil {
+		number, _ := strconv.Atoi(m[1])
+		return number
+	}
+
+	// pull_request_target and other events check out a branch instead
+	data, err := os.ReadFile(os.Getenv("GITHUB_EVENT_PATH"))
+	if err != nil {
+		return 0
+	}
+	var event struct {
+		PullRequest *struct {
+			Number int `json:"number"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil || event.PullRequest == nil {
+		return 0
+	}
+	return event.PullRequest.Number
+}
+
+// githubLabels fetches the labels of a pull request from the GitHub API,
+// authenticated with GITHUB_TOKEN if it is set
+func githubLabels(repository string, number int) ([]string, error) {
+	if repository == "" {
+		return nil, fmt.Errorf("GITHUB_REPOSITORY is not set")
+	}
+	base := os.Getenv("GITHUB_API_URL")
+	if base == "" {
+		base = GitHubAPIURL
+	}
+
+	endpoint := fmt.Sprintf("%s/repos/%s/issues/%d/labels?per_page=100", strings.TrimSuffix(base, "/"), repository, number)
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pull request labels: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pull request labels: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pull request labels request failed (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse pull request labels: %w", err)
+	}
+	labels := make([]string, 0, len(response))
+	for _, label := range response {
+		labels = append(labels, label.Name)
+	}
+	return labels, nil
+}
+
+// Policy reads a session policy from pull request labels. coverage:skip
+// wins over everything, and coverage:target=N over coverage:strict, which
+// asks for at least strictTarget. Unknown labels are ignored.
+func Policy(labels []string, strictTarget float64) (LabelPolicy, error) {
+	for _, label := range labels {
+		if strings.EqualFold(strings.TrimSpace(label), LabelSkip) {
+			return LabelPolicy{Skip: true, Label: LabelSkip}, nil
+		}
+	}
+
+	var policy LabelPolicy
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		switch {
+		case strings.HasPrefix(label, LabelTargetPrefix):
+			target, err := strconv.ParseFloat(strings.TrimPrefix(label, LabelTargetPrefix), 64)
+			if err != nil || target < 0 || target > 100 {
+				return LabelPolicy{}, fmt.Errorf("invalid target in label %q (want a percentage)", label)
+			}
+			policy = LabelPolicy{Target: target, Label: label}
+		case label == LabelStrict && (policy.Label == "" || policy.AtLeast):
+			policy = LabelPolicy{Target: strictTarget, AtLeast: true, Label: label}
+		}
+	}
+	return policy, nil
+}
@@ -3,18 +3,30 @@ package claude
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
 )
 
 const (
 	ClaudeAPIURL     = "https://api.anthropic.com/v1/messages"
 	DefaultModel     = "claude-sonnet-4-5-20250929"
-	MaxTokens        = 8000
+	DefaultMaxTokens = 8000
 	RetryMaxAttempts = 3
 	RetryBaseDelay   = 2 * time.Second
+
+	// RetryBudget caps the retries spent across all requests of a client, so
+	// a persistent failure can't keep backing off for every work item
+	RetryBudget = 20
+
+	// CircuitBreakerThreshold is the number of consecutive failed requests
+	// after which the client stops calling the API
+	CircuitBreakerThreshold = 5
 )
 
 // Client handles communication with Claude API
@@ -22,6 +34,19 @@ type Client struct {
 	apiKey     string
 	httpClient *http.Client
 	model      string
+	maxTokens  int
+
+	retriesUsed         int   // Retries spent from RetryBudget
+	consecutiveFailures int   // Failed requests since the last success
+	circuitErr          error // Set once the circuit breaker opens
+
+	uploads  map[string]string // Uploaded file IDs by content hash
+	filesErr error             // Set once the Files API rejects an upload
+
+	usage Usage   // Tokens used since the last TakeUsage
+	cost  float64 // Their cost in USD
+
+	limiter *limiter // Paces requests; nil if unlimited
 }
 
 // NewClient creates a new Claude API client
@@ -31,21 +56,112 @@ func NewClient(apiKey string) *Client {
 		httpClient: &http.Client{
 			Timeout: 120 * time.Second,
 		},
-		model: DefaultModel,
+		model:     DefaultModel,
+		maxTokens: DefaultMaxTokens,
 	}
 }
 
+// Model returns the model name used for requests
+func (c *Client) Model() string {
+	return c.model
+}
+
+// SetModel changes the default model, resolving short aliases
+func (c *Client) SetModel(model string) {
+	c.model = ResolveModel(model)
+}
+
+// MaxTokens returns the output token limit of generation requests
+func (c *Client) MaxTokens() int {
+	return c.maxTokens
+}
+
+// SetMaxTokens changes the output token limit of generation requests
+func (c *Client) SetMaxTokens(maxTokens int) {
+	c.maxTokens = maxTokens
+}
+
+// modelAliases maps short model names accepted in config to model IDs
+var modelAliases = map[string]string{
+	"opus":   "claude-opus-4-1",
+	"sonnet": DefaultModel,
+	"haiku":  "claude-haiku-4-5",
+}
+
+// ResolveModel returns the model ID for a short alias such as "opus", or
+// the name unchanged if it isn't an alias
+func ResolveModel(name string) string {
+	if model, ok := modelAliases[name]; ok {
+		return model
+	}
+	return name
+}
+
 // Message represents a Claude API message
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
+}
+
+// ContentBlock is a block of a message: text, or a document uploaded
+// through the Files API
+type ContentBlock struct {
+	Type         string          `json:"type"`
+	Text         string          `json:"text,omitempty"`
+	Source       *DocumentSource `json:"source,omitempty"`
+	Title        string          `json:"title,omitempty"`
+	CacheControl *CacheControl   `json:"cache_control,omitempty"`
+}
+
+// textBlock returns a text content block
+func textBlock(text string) ContentBlock {
+	return ContentBlock{Type: "text", Text: text}
+}
+
+// cached marks a block as the end of a prompt prefix the API may cache
+func cached(block ContentBlock) ContentBlock {
+	block.CacheControl = ephemeral()
+	return block
 }
 
 // Request represents a Claude API request
 type Request struct {
-	Model     string    `json:"model"`
-	MaxTokens int       `json:"max_tokens"`
-	Messages  []Message `json:"messages"`
+	Model     string        `json:"model"`
+	MaxTokens int           `json:"max_tokens"`
+	System    []SystemBlock `json:"system,omitempty"`
+	Messages  []Message     `json:"messages"`
+}
+
+// SystemBlock is a text block of the system prompt
+type SystemBlock struct {
+	Type         string        `json:"type"`
+	Text         string        `json:"text"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// CacheControl marks the end of a prompt prefix the API may cache
+type CacheControl struct {
+	Type string `json:"type"`
+}
+
+// ephemeral returns the cache control of the API's default, five minute
+// cache, which every request that reads the prefix refreshes
+func ephemeral() *CacheControl {
+	return &CacheControl{Type: "ephemeral"}
+}
+
+// systemBlocks returns the system prompt as a cacheable block, or nil if
+// there is none. The system prompt is identical across files, so caching it
+// saves input tokens on every later request.
+func systemBlocks(system string) []SystemBlock {
+	if system == "" {
+		return nil
+	}
+	return []SystemBlock{{
+		Type:         "text",
+		Text:         system,
+		CacheControl: ephemeral(),
+	}}
 }
 
 // Response represents a Claude API response
@@ -58,6 +174,7 @@ type Response struct {
 		Text string `json:"text"`
 	} `json:"content"`
 	StopReason string `json:"stop_reason"`
+	Usage      Usage  `json:"usage"`
 }
 
 // ErrorResponse represents an API error
@@ -79,15 +196,177 @@ func (e *RateLimitError) Error() string {
 	return fmt.Sprintf("rate limit exceeded, resets at %v", e.ResetTime)
 }
 
-// SendMessage sends a message to Claude and returns the response
+// Is makes rate limit errors match errdefs.ErrRateLimited
+func (e *RateLimitError) Is(target error) bool {
+	return target == errdefs.ErrRateLimited
+}
+
+// CircuitOpenError is returned once the circuit breaker has opened. Every
+// later request fails immediately with the same error.
+type CircuitOpenError struct {
+	Failures int
+	LastErr  error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("Claude API unavailable after %d consecutive failed requests (%s): %v",
+		e.Failures, diagnose(e.LastErr), e.LastErr)
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return e.LastErr
+}
+
+// diagnose suggests the likely cause of a persistent API failure
+func diagnose(err error) string {
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Fatal():
+		return apiErr.hint()
+	case errors.As(err, &apiErr):
+		return "the API keeps returning errors"
+	default:
+		return "the API could not be reached, check network connectivity"
+	}
+}
+
+// APIError is an error response from the API other than a rate limit
+type APIError struct {
+	StatusCode int
+	Type       string // Error type from the response body, e.g. "authentication_error"
+	Message    string
+	Hint       string // How to fix a fatal error, if not the Claude API's
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("API error (status %d): %s - %s", e.StatusCode, e.Type, e.Message)
+	if e.Fatal() {
+		msg += " (" + e.hint() + ")"
+	}
+	return msg
+}
+
+// Retryable reports whether sending the same request again may succeed
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode == http.StatusConflict ||
+		e.StatusCode >= http.StatusInternalServerError
+}
+
+// Fatal reports whether every request will fail the same way, so there is
+// no point in continuing the session
+func (e *APIError) Fatal() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return false
+}
+
+// Is makes errors for prompts that exceed the context window match
+// errdefs.ErrContextTooLarge
+func (e *APIError) Is(target error) bool {
+	return target == errdefs.ErrContextTooLarge && e.contextTooLarge()
+}
+
+// contextTooLarge reports whether the request was rejected for its size
+func (e *APIError) contextTooLarge() bool {
+	return e.StatusCode == http.StatusRequestEntityTooLarge ||
+		e.StatusCode == http.StatusBadRequest && strings.Contains(e.Message, "prompt is too long")
+}
+
+// hint explains how to fix a fatal error
+func (e *APIError) hint() string {
+	if e.Hint != "" {
+		return e.Hint
+	}
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return "the API key was rejected, check -api-key or ANTHROPIC_API_KEY"
+	case http.StatusForbidden:
+		return "the API key lacks permission for this model or organization"
+	case http.StatusNotFound:
+		return "the model or endpoint was not found, check the model name"
+	}
+	return ""
+}
+
+// SendMessage sends a message to Claude and returns the response. After a
+// fatal APIError, or CircuitBreakerThreshold consecutive failures, it stops
+// calling the API and returns the same error for every later request.
 func (c *Client) SendMessage(prompt string) (string, error) {
+	return c.SendMessageWithModel(prompt, c.model)
+}
+
+// SendMessageWithModel is SendMessage with a different model for this
+// request. Retries and the circuit breaker are shared across models.
+func (c *Client) SendMessageWithModel(prompt, model string) (string, error) {
+	return c.SendMessageWithSystem("", prompt, model)
+}
+
+// SendMessageWithSystem is SendMessageWithModel with a system prompt, which
+// holds the instructions shared by every request and is sent apart from the
+// user message
+func (c *Client) SendMessageWithSystem(system, prompt, model string) (string, error) {
+	return c.send(system, []ContentBlock{textBlock(prompt)}, model)
+}
+
+// SendMessageWithPrefix is SendMessageWithSystem with a user message that
+// starts with prefix. The prefix is sent as a block of its own and cached
+// along with the system prompt, so later requests that start with the same
+// prefix, such as retries about the same source file, read it from the
+// cache at a fraction of the input token price.
+func (c *Client) SendMessageWithPrefix(system, prefix, prompt, model string) (string, error) {
+	if prefix == "" {
+		return c.SendMessageWithSystem(system, prompt, model)
+	}
+	return c.send(system, []ContentBlock{cached(textBlock(prefix)), textBlock(prompt)}, model)
+}
+
+// send sends a message made of content blocks, tracking failures for the
+// circuit breaker
+func (c *Client) send(system string, content []ContentBlock, model string) (string, error) {
+	if c.circuitErr != nil {
+		return "", c.circuitErr
+	}
+
+	response, err := c.sendWithRetry(system, content, ResolveModel(model))
+	if err != nil {
+		// Rate limits and oversized prompts say nothing about the API's health
+		if errors.Is(err, errdefs.ErrRateLimited) || errors.Is(err, errdefs.ErrContextTooLarge) {
+			return "", err
+		}
+
+		// Errors that affect every request abort immediately
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Fatal() {
+			c.circuitErr = err
+			return "", err
+		}
+
+		c.consecutiveFailures++
+		if c.consecutiveFailures >= CircuitBreakerThreshold {
+			c.circuitErr = &CircuitOpenError{Failures: c.consecutiveFailures, LastErr: err}
+			return "", c.circuitErr
+		}
+		return "", err
+	}
+
+	c.consecutiveFailures = 0
+	return response, nil
+}
+
+// sendWithRetry sends a message, retrying failed requests with exponential
+// backoff while the retry budget lasts
+func (c *Client) sendWithRetry(system string, content []ContentBlock, model string) (string, error) {
 	req := Request{
-		Model:     c.model,
-		MaxTokens: MaxTokens,
+		Model:     model,
+		MaxTokens: c.maxTokens,
+		System:    systemBlocks(system),
 		Messages: []Message{
 			{
 				Role:    "user",
-				Content: prompt,
+				Content: content,
 			},
 		},
 	}
@@ -95,6 +374,11 @@ func (c *Client) SendMessage(prompt string) (string, error) {
 	var lastErr error
 	for attempt := 0; attempt < RetryMaxAttempts; attempt++ {
 		if attempt > 0 {
+			if c.retriesUsed >= RetryBudget {
+				return "", fmt.Errorf("retry budget of %d exhausted: %w", RetryBudget, lastErr)
+			}
+			c.retriesUsed++
+
 			delay := RetryBaseDelay * time.Duration(1<<uint(attempt-1))
 			time.Sleep(delay)
 		}
@@ -106,6 +390,11 @@ func (c *Client) SendMessage(prompt string) (string, error) {
 				return "", rateLimitErr // Don't retry rate limits, let caller handle
 			}
 
+			// Don't retry requests that will fail the same way again
+			if apiErr, ok := err.(*APIError); ok && !apiErr.Retryable() {
+				return "", apiErr
+			}
+
 			lastErr = err
 			continue
 		}
@@ -121,6 +410,33 @@ func (c *Client) SendMessage(prompt string) (string, error) {
 	return "", fmt.Errorf("failed after %d attempts: %w", RetryMaxAttempts, lastErr)
 }
 
+// CheckAccess makes a minimal request to verify the API key, model name and
+// organization access. Rate limits count as success since they prove the key
+// works; transient failures are returned as-is for the caller to judge.
+func (c *Client) CheckAccess() error {
+	return c.CheckModelAccess(c.model)
+}
+
+// CheckModelAccess is CheckAccess for a specific model
+func (c *Client) CheckModelAccess(model string) error {
+	req := Request{
+		Model:     ResolveModel(model),
+		MaxTokens: 1,
+		Messages: []Message{
+			{
+				Role:    "user",
+				Content: []ContentBlock{textBlock("ping")},
+			},
+		},
+	}
+
+	_, err := c.makeRequest(req)
+	if _, ok := err.(*RateLimitError); ok {
+		return nil
+	}
+	return err
+}
+
 // makeRequest performs the actual HTTP request
 func (c *Client) makeRequest(req Request) (*Response, error) {
 	bodyBytes, err := json.Marshal(req)
@@ -134,6 +450,33 @@ func (c *Client) makeRequest(req Request) (*Response, error) {
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
+	if req.usesFiles() {
+		httpReq.Header.Set("anthropic-beta", FilesBeta)
+	}
+
+	estimate := req.estimateTokens()
+	c.limiter.wait(estimate)
+
+	bodyBytes, err = c.do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse successful response
+	var response Response
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	c.recordUsage(req.Model, response.Usage)
+	c.limiter.settle(estimate, response.Usage)
+
+	return &response, nil
+}
+
+// do sends an authenticated API request and returns the body of a
+// successful response. Rate limits are returned as a RateLimitError, other
+// error responses as an APIError.
+func (c *Client) do(httpReq *http.Request) ([]byte, error) {
 	httpReq.Header.Set("x-api-key", c.apiKey)
 	httpReq.Header.Set("anthropic-version", "2023-06-01")
 
@@ -143,26 +486,14 @@ func (c *Client) makeRequest(req Request) (*Response, error) {
 	}
 	defer resp.Body.Close()
 
-	bodyBytes, err = io.ReadAll(resp.Body)
+	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	// Handle rate limiting (429)
 	if resp.StatusCode == http.StatusTooManyRequests {
-		var resetTime time.Time
-		var retryAfter int
-
-		// Try to parse retry-after header
-		if retryHeader := resp.Header.Get("retry-after"); retryHeader != "" {
-			fmt.Sscanf(retryHeader, "%d", &retryAfter)
-			resetTime = time.Now().Add(time.Duration(retryAfter) * time.Second)
-		} else {
-			// Default to 60 seconds if no header
-			retryAfter = 60
-			resetTime = time.Now().Add(60 * time.Second)
-		}
-
+		resetTime, retryAfter := rateLimitReset(resp.Header, time.Now())
 		return nil, &RateLimitError{
 			ResetTime:  resetTime,
 			RetryAfter: retryAfter,
@@ -170,19 +501,17 @@ func (c *Client) makeRequest(req Request) (*Response, error) {
 	}
 
 	// Handle other errors
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
 		var errResp ErrorResponse
 		if err := json.Unmarshal(bodyBytes, &errResp); err == nil {
-			return nil, fmt.Errorf("API error: %s - %s", errResp.Error.Type, errResp.Error.Message)
+			apiErr.Type = errResp.Error.Type
+			apiErr.Message = errResp.Error.Message
+		} else {
+			apiErr.Message = string(bodyBytes)
 		}
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	// Parse successful response
-	var response Response
-	if err := json.Unmarshal(bodyBytes, &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, apiErr
 	}
 
-	return &response, nil
+	return bodyBytes, nil
 }
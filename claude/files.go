@@ -0,0 +1,183 @@
+package claude
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"sort"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
+)
+
+const (
+	FilesAPIURL = "https://api.anthropic.com/v1/files"
+
+	// FilesBeta is the beta header value that enables the Files API
+	FilesBeta = "files-api-2025-04-14"
+)
+
+// DocumentSource is the content of a document block: a file uploaded
+// through the Files API, or inline text
+type DocumentSource struct {
+	Type      string `json:"type"`
+	FileID    string `json:"file_id,omitempty"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+// fileResponse is the metadata the Files API returns for an upload
+type fileResponse struct {
+	ID string `json:"id"`
+}
+
+// SendMessageWithAttachments is SendMessageWithSystem with files attached
+// to the user message as documents, by title. Each file is uploaded once
+// through the Files API and referenced by ID in every later request. If
+// the API doesn't accept the upload, the file is sent inline as a text
+// document instead. The documents are cached as a prompt prefix.
+func (c *Client) SendMessageWithAttachments(system, prompt, model string, attachments map[string]string) (string, error) {
+	titles := make([]string, 0, len(attachments))
+	for title := range attachments {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	content := make([]ContentBlock, 0, len(titles)+1)
+	for _, title := range titles {
+		block, err := c.documentBlock(title, attachments[title])
+		if err != nil {
+			return "", err
+		}
+		content = append(content, block)
+	}
+	if len(content) > 0 {
+		content[len(content)-1] = cached(content[len(content)-1])
+	}
+	content = append(content, textBlock(prompt))
+
+	return c.send(system, content, model)
+}
+
+// documentBlock returns the document block of an attachment, uploading it
+// unless an earlier request did. Only rate limits are returned as errors,
+// other upload failures fall back to an inline document.
+func (c *Client) documentBlock(title, text string) (ContentBlock, error) {
+	block := ContentBlock{Type: "document", Title: title}
+
+	sum := sha256.Sum256([]byte(text))
+	key := hex.EncodeToString(sum[:])
+	fileID, ok := c.uploads[key]
+	if !ok && c.filesErr == nil {
+		var err error
+		fileID, err = c.uploadFile(title, text)
+		var apiErr *APIError
+		switch {
+		case errors.Is(err, errdefs.ErrRateLimited):
+			return block, err
+		case errors.As(err, &apiErr) && !apiErr.Retryable():
+			// The Files API isn't available to this key; stop trying
+			fmt.Printf("Warning: Files API unavailable, attaching files inline: %v\n", err)
+			c.filesErr = err
+		case err != nil:
+			fmt.Printf("Warning: Could not upload %s, attaching it inline: %v\n", title, err)
+		default:
+			if c.uploads == nil {
+				c.uploads = make(map[string]string)
+			}
+			c.uploads[key] = fileID
+			ok = true
+		}
+	}
+
+	if ok {
+		block.Source = &DocumentSource{Type: "file", FileID: fileID}
+	} else {
+		block.Source = &DocumentSource{Type: "text", MediaType: "text/plain", Data: text}
+	}
+	return block, nil
+}
+
+// uploadFile uploads a text file through the Files API and returns its ID
+func (c *Client) uploadFile(title, text string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, path.Base(title)))
+	header.Set("Content-Type", "text/plain")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload: %w", err)
+	}
+	part.Write([]byte(text))
+	if err := form.Close(); err != nil {
+		return "", fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", FilesAPIURL, &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", form.FormDataContentType())
+	httpReq.Header.Set("anthropic-beta", FilesBeta)
+
+	bodyBytes, err := c.do(httpReq)
+	if err != nil {
+		return "", err
+	}
+
+	var file fileResponse
+	if err := json.Unmarshal(bodyBytes, &file); err != nil {
+		return "", fmt.Errorf("failed to unmarshal upload response: %w", err)
+	}
+	if file.ID == "" {
+		return "", fmt.Errorf("upload response has no file ID")
+	}
+	return file.ID, nil
+}
+
+// DeleteUploads deletes the files uploaded by SendMessageWithAttachments,
+// so they don't count against the organization's storage after the session
+func (c *Client) DeleteUploads() error {
+	var failed int
+	var lastErr error
+	for key, fileID := range c.uploads {
+		httpReq, err := http.NewRequest("DELETE", FilesAPIURL+"/"+fileID, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header.Set("anthropic-beta", FilesBeta)
+
+		if _, err := c.do(httpReq); err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		delete(c.uploads, key)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d uploaded file(s): %w", failed, lastErr)
+	}
+	return nil
+}
+
+// usesFiles reports whether a request refers to uploaded files, which needs
+// the Files API beta header
+func (r Request) usesFiles() bool {
+	for _, message := range r.Messages {
+		for _, block := range message.Content {
+			if block.Source != nil && block.Source.Type == "file" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package claude
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// charsPerToken is the average length of a token, for estimating the input
+// tokens of a request before sending it
+const charsPerToken = 4
+
+// pacingNotice is the wait above which pacing is reported, so short pauses
+// don't clutter the output
+const pacingNotice = 5 * time.Second
+
+// SetRateLimits paces requests to stay under a number of requests and of
+// tokens per minute, so the client waits for capacity instead of running
+// into 429 responses. Tokens are the input tokens not read from the cache
+// plus the output tokens, as the API's rate limits count them. 0 disables
+// a limit.
+func (c *Client) SetRateLimits(requestsPerMinute, tokensPerMinute int) {
+	c.limiter = &limiter{
+		requests: newBucket(requestsPerMinute),
+		tokens:   newBucket(tokensPerMinute),
+	}
+}
+
+// limiter paces requests with a token bucket per limit. Each bucket holds
+// up to a minute's worth and refills continuously; a request takes its
+// share up front and waits while a bucket is in debt.
+type limiter struct {
+	requests *bucket // nil if unlimited
+	tokens   *bucket // nil if unlimited
+}
+
+// wait blocks until a request with an estimated number of tokens fits the
+// limits, and takes its share
+func (l *limiter) wait(estimatedTokens int) {
+	if l == nil {
+		return
+	}
+
+	now := time.Now()
+	delay := l.requests.take(1, now)
+	if tokenDelay := l.tokens.take(float64(estimatedTokens), now); tokenDelay > delay {
+		delay = tokenDelay
+	}
+	if delay <= 0 {
+		return
+	}
+	if delay >= pacingNotice {
+		fmt.Printf("  Pacing requests to stay under the rate limits, waiting %s\n", delay.Round(time.Second))
+	}
+	time.Sleep(delay)
+}
+
+// settle corrects the estimate a request was admitted with by the tokens
+// the API reports it used
+func (l *limiter) settle(estimatedTokens int, usage Usage) {
+	if l == nil || l.tokens == nil {
+		return
+	}
+	used := usage.InputTokens + usage.CacheCreationInputTokens + usage.OutputTokens
+	l.tokens.available -= float64(used - estimatedTokens)
+}
+
+// bucket is a token bucket refilled at a rate per minute
+type bucket struct {
+	perMinute float64
+	available float64
+	updated   time.Time
+}
+
+// newBucket returns a full bucket, or nil for a limit of 0
+func newBucket(perMinute int) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &bucket{perMinute: float64(perMinute), available: float64(perMinute), updated: time.Now()}
+}
+
+// take removes n from the bucket and returns how long to wait until the
+// bucket is out of debt. Requests larger than the bucket take all of it,
+// so they wait at most a minute.
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.available += now.Sub(b.updated).Minutes() * b.perMinute
+	if b.available > b.perMinute {
+		b.available = b.perMinute
+	}
+	b.updated = now
+
+	if n > b.perMinute {
+		n = b.perMinute
+	}
+	b.available -= n
+	if b.available >= 0 {
+		return 0
+	}
+	return time.Duration(-b.available / b.perMinute * float64(time.Minute))
+}
+
+// estimateTokens estimates the input tokens of a request from the length
+// of its text. Documents uploaded through the Files API aren't counted.
+func (r Request) estimateTokens() int {
+	chars := 0
+	for _, block := range r.System {
+		chars += len(block.Text)
+	}
+	for _, message := range r.Messages {
+		for _, block := range message.Content {
+			chars += len(block.Text)
+			if block.Source != nil {
+				chars += len(block.Source.Data)
+			}
+		}
+	}
+	return (chars + charsPerToken - 1) / charsPerToken
+}
+
+// rateLimitReset returns when the limit a 429 response ran into resets:
+// the retry-after header, or else the reset of the exhausted limit in the
+// anthropic-ratelimit headers, or 60 seconds from now without either
+func rateLimitReset(header http.Header, now time.Time) (time.Time, int) {
+	var retryAfter int
+	if _, err := fmt.Sscanf(header.Get("retry-after"), "%d", &retryAfter); err == nil && retryAfter > 0 {
+		return now.Add(time.Duration(retryAfter) * time.Second), retryAfter
+	}
+
+	var latest time.Time
+	for _, limit := range []string{"requests", "tokens", "input-tokens", "output-tokens"} {
+		prefix := "anthropic-ratelimit-" + limit
+		if header.Get(prefix+"-remaining") != "0" {
+			continue
+		}
+		reset, err := time.Parse(time.RFC3339, header.Get(prefix+"-reset"))
+		if err == nil && reset.After(latest) {
+			latest = reset
+		}
+	}
+	if latest.After(now) {
+		return latest, int(latest.Sub(now).Seconds() + 0.999)
+	}
+	return now.Add(60 * time.Second), 60
+}
@@ -0,0 +1,54 @@
+package claude
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ExtractCodeFromResponse attempts to extract code from Claude's response
+// Claude sometimes adds markdown formatting, so we need to clean it up
+func ExtractCodeFromResponse(response string) string {
+	// Remove markdown code blocks
+	response = strings.TrimSpace(response)
+
+	// Check for markdown code fences
+	if strings.HasPrefix(response, "```") {
+		lines := strings.Split(response, "\n")
+		if len(lines) > 2 {
+			// Remove first line (```language) and last line (```)
+			if strings.HasPrefix(lines[0], "```") {
+				lines = lines[1:]
+			}
+			if strings.HasSuffix(lines[len(lines)-1], "```") {
+				lines = lines[:len(lines)-1]
+			}
+			response = strings.Join(lines, "\n")
+		}
+	}
+
+	return strings.TrimSpace(response)
+}
+
+// confidenceLine matches the self-assessment line prompts ask for after the
+// test file, also when written as a comment or with a "/100" scale
+var confidenceLine = regexp.MustCompile(`(?m)^[ \t/#*-]*CONFIDENCE:\s*(\d{1,3})\s*(?:/\s*100)?\s*(?:[-–—:]\s*)?(.*)$`)
+
+// ExtractConfidence removes the self-assessment line from a response and
+// returns the rest, the confidence from 0 to 100 and its reason. ok is
+// false if the response has no self-assessment.
+func ExtractConfidence(response string) (rest string, confidence int, reason string, ok bool) {
+	matches := confidenceLine.FindAllStringSubmatchIndex(response, -1)
+	if len(matches) == 0 {
+		return response, 0, "", false
+	}
+
+	// The last line wins, earlier ones may be part of the tests
+	m := matches[len(matches)-1]
+	confidence, _ = strconv.Atoi(response[m[2]:m[3]])
+	if confidence > 100 {
+		confidence = 100
+	}
+	reason = strings.TrimSpace(response[m[4]:m[5]])
+	return response[:m[0]] + response[m[1]:], confidence, reason, true
+}
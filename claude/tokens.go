@@ -0,0 +1,61 @@
+package claude
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// CountTokensURL is the endpoint that counts the input tokens of a request
+const CountTokensURL = "https://api.anthropic.com/v1/messages/count_tokens"
+
+// countTokensRequest is the body of a token counting request, a message
+// request without the output limit
+type countTokensRequest struct {
+	Model    string        `json:"model"`
+	System   []SystemBlock `json:"system,omitempty"`
+	Messages []Message     `json:"messages"`
+}
+
+// CountTokens returns the number of input tokens a message with a system
+// prompt would use with a model, or the default model if empty. Counting
+// is free and doesn't count toward usage.
+func (c *Client) CountTokens(system, prompt, model string) (int, error) {
+	if model == "" {
+		model = c.model
+	}
+
+	bodyBytes, err := json.Marshal(countTokensRequest{
+		Model:  ResolveModel(model),
+		System: systemBlocks(system),
+		Messages: []Message{
+			{
+				Role:    "user",
+				Content: []ContentBlock{textBlock(prompt)},
+			},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", CountTokensURL, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	bodyBytes, err = c.do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return response.InputTokens, nil
+}
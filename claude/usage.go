@@ -0,0 +1,67 @@
+package claude
+
+import "strings"
+
+// Usage counts the tokens of API requests, as reported by the API
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// Total returns the number of tokens of every kind
+func (u Usage) Total() int {
+	return u.InputTokens + u.OutputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+// add adds other's tokens to u
+func (u *Usage) add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheCreationInputTokens += other.CacheCreationInputTokens
+	u.CacheReadInputTokens += other.CacheReadInputTokens
+}
+
+// price is the cost of a model's tokens in USD per million
+type price struct {
+	input, output, cacheWrite, cacheRead float64
+}
+
+// prices by model family, matched against the model ID
+var prices = map[string]price{
+	"opus":   {input: 15, output: 75, cacheWrite: 18.75, cacheRead: 1.50},
+	"sonnet": {input: 3, output: 15, cacheWrite: 3.75, cacheRead: 0.30},
+	"haiku":  {input: 1, output: 5, cacheWrite: 1.25, cacheRead: 0.10},
+}
+
+// Cost returns the cost of usage with a model in USD. Models of an unknown
+// family are priced like Opus, so spending limits err on the safe side.
+func (u Usage) Cost(model string) float64 {
+	p := prices["opus"]
+	model = ResolveModel(model)
+	for family, familyPrice := range prices {
+		if strings.Contains(model, family) {
+			p = familyPrice
+		}
+	}
+
+	return (float64(u.InputTokens)*p.input +
+		float64(u.OutputTokens)*p.output +
+		float64(u.CacheCreationInputTokens)*p.cacheWrite +
+		float64(u.CacheReadInputTokens)*p.cacheRead) / 1e6
+}
+
+// TakeUsage returns the tokens used and their cost in USD since the last
+// call, and starts counting again
+func (c *Client) TakeUsage() (Usage, float64) {
+	usage, cost := c.usage, c.cost
+	c.usage, c.cost = Usage{}, 0
+	return usage, cost
+}
+
+// recordUsage counts the tokens of a response
+func (c *Client) recordUsage(model string, usage Usage) {
+	c.usage.add(usage)
+	c.cost += usage.Cost(model)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/attest"
+	"github.com/tablev/test-coverage-agent/cache"
+	"github.com/tablev/test-coverage-agent/config"
+	"github.com/tablev/test-coverage-agent/coverage"
+	"github.com/tablev/test-coverage-agent/git"
+	"github.com/tablev/test-coverage-agent/journal"
+	"github.com/tablev/test-coverage-agent/notify"
+	"github.com/tablev/test-coverage-agent/schema"
+	"github.com/tablev/test-coverage-agent/testgen"
+)
+
+// runCommand dispatches a subcommand and returns the process exit code.
+// The second return value is false if name is not a known subcommand.
+func runCommand(name string, args []string) (int, bool) {
+	switch name {
+	case "undo":
+		return runUndo(args), true
+	case "clean":
+		return runClean(args), true
+	case "cache":
+		return runCache(args), true
+	case "quarantine":
+		return runQuarantine(args), true
+	case "rerun-failed":
+		return runRerunFailed(args), true
+	case "init":
+		return runInit(args), true
+	case "state":
+		return runState(args), true
+	case "verify-attestation":
+		return runVerifyAttestation(args), true
+	case "schemas":
+		return runSchemas(args), true
+	case "check":
+		return runCheck(args), true
+	}
+	return 0, false
+}
+
+// runUndo restores files changed by the agent in a non-git project
+func runUndo(args []string) int {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	projectPath := fs.String("project", ".", "Path to the project to restore")
+	fs.Parse(args)
+
+	j, err := journal.Open(*projectPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if len(j.Entries) == 0 {
+		fmt.Println("Nothing to undo.")
+		return 0
+	}
+
+	restored, err := j.Undo()
+	for _, file := range restored {
+		fmt.Printf("Restored: %s\n", file)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Undid changes to %d file(s)\n", len(restored))
+	return 0
+}
+
+// runClean discards the change journal, keeping the agent's changes
+func runClean(args []string) int {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	projectPath := fs.String("project", ".", "Path to the project to clean")
+	fs.Parse(args)
+
+	j, err := journal.Open(*projectPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	count := len(j.Entries)
+	if err := j.Clean(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Removed change journal (%d file(s) kept as-is)\n", count)
+	return 0
+}
+
+// runCache handles cache maintenance subcommands
+func runCache(args []string) int {
+	if len(args) == 0 || args[0] != "gc" {
+		fmt.Fprintf(os.Stderr, "Usage: test-coverage-agent cache gc [-project path] [-max-size MB] [-max-age duration]\n")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("cache gc", flag.ExitOnError)
+	projectPath := fs.String("project", ".", "Path to the project whose cache to collect")
+	maxSize := fs.Int64("max-size", cache.DefaultMaxSize/(1024*1024), "Size limit in MB for collectable cache contents")
+	maxAge := fs.Duration("max-age", 0, "Remove cache entries older than this (e.g. 168h, 0 = no age limit)")
+	fs.Parse(args[1:])
+
+	c := cache.New(*projectPath)
+	before, err := c.Size()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	result, err := c.GC(*maxSize*1024*1024, *maxAge)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Cache %s: removed %d file(s), freed %.1f MB (%.1f MB -> %.1f MB)\n",
+		c.Root(), result.Removed, megabytes(result.Freed), megabytes(before), megabytes(before-result.Freed))
+	return 0
+}
+
+// runQuarantine marks flaky test files as skipped, e.g. from CI after a
+// generated test failed intermittently
+func runQuarantine(args []string) int {
+	fs := flag.NewFlagSet("quarantine", flag.ExitOnError)
+	projectPath := fs.String("project", ".", "Path to the project containing the tests")
+	reason := fs.String("reason", "failed intermittently", "Reason recorded in the skip annotation")
+	stateFile := fs.String("state", "", "State file to record the quarantine in (default: <project>/.coverage-agent/state.json)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: test-coverage-agent quarantine [-project path] [-reason text] test-file...\n")
+		return 1
+	}
+
+	analyzer, err := coverage.DetectProjectLanguage(*projectPath, coverage.Options{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if *stateFile == "" {
+		*stateFile = cache.New(*projectPath).StateFile()
+	}
+	state, _ := config.LoadState(*stateFile) // nil if there is no session to update
+
+	gitMgr := git.NewManager(*projectPath)
+	failed := false
+
+	// Without git, the journal lets undo restore the quarantined files
+	var j *journal.Journal
+	if !gitMgr.IsEnabled() {
+		j, err = journal.Open(*projectPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	for _, testFile := range fs.Args() {
+		if j != nil {
+			if err := j.Record(testFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				failed = true
+				continue
+			}
+		}
+		if err := testgen.Quarantine(analyzer.GetLanguageName(), testFile, *reason); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed = true
+			continue
+		}
+		if err := gitMgr.CreateQuarantineCommit(testFile, *reason); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		if j != nil {
+			if err := j.Snapshot(testFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+		if state != nil {
+			state.QuarantineTest(testFile, *reason)
+		}
+		fmt.Printf("Quarantined: %s\n", testFile)
+	}
+
+	if state != nil {
+		if err := state.SaveState(*stateFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// runRerunFailed requeues the failed files of a session, e.g. after fixing
+// a missing tool, so the next -resume retries them without starting over
+func runRerunFailed(args []string) int {
+	fs := flag.NewFlagSet("rerun-failed", flag.ExitOnError)
+	projectPath := fs.String("project", ".", "Path to the project of the session")
+	stateFile := fs.String("state", "", "State file of the session (default: <project>/.coverage-agent/state.json)")
+	classes := fs.String("class", "", "Only requeue failures of these comma-separated classes: "+strings.Join(config.FailureClasses, ", ")+" (default: all)")
+	fs.Parse(args)
+
+	var selected []string
+	if *classes != "" {
+		for _, class := range strings.Split(*classes, ",") {
+			class = strings.TrimSpace(class)
+			known := false
+			for _, c := range config.FailureClasses {
+				known = known || c == class
+			}
+			if !known {
+				fmt.Fprintf(os.Stderr, "Error: unknown failure class %q (want %s)\n", class, strings.Join(config.FailureClasses, ", "))
+				return 1
+			}
+			selected = append(selected, class)
+		}
+	}
+
+	if *stateFile == "" {
+		*stateFile = cache.New(*projectPath).StateFile()
+	}
+	state, err := config.LoadState(*stateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	failures := make(map[string]string, len(state.FailedFiles))
+	for file, errorMsg := range state.FailedFiles {
+		failures[file] = errorMsg
+	}
+
+	requeued := state.RequeueFailed(selected...)
+	if len(requeued) == 0 {
+		fmt.Println("No failed files to requeue.")
+		return 0
+	}
+
+	for _, file := range requeued {
+		errorMsg, _, _ := strings.Cut(failures[file], "\n")
+		fmt.Printf("Requeued: %s (%s: %s)\n", file, config.FailureClass(failures[file]), errorMsg)
+	}
+	if err := state.SaveState(*stateFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Requeued %d failed file(s); run with -resume to retry them\n", len(requeued))
+	return 0
+}
+
+// runVerifyAttestation checks the signature of a coverage attestation and,
+// optionally, that it covers the expected commit and coverage, so a release
+// pipeline can rely on it instead of running the test suite again
+func runVerifyAttestation(args []string) int {
+	fs := flag.NewFlagSet("verify-attestation", flag.ExitOnError)
+	keyEnv := fs.String("key-env", attest.DefaultKeyEnv, "Environment variable holding the HMAC key the attestation was signed with")
+	commit := fs.String("commit", "", "Fail unless the attestation is for this commit (a full hash or a prefix)")
+	minCoverage := fs.Float64("min-coverage", 0, "Fail unless the attested coverage is at least this percentage")
+	requireTarget := fs.Bool("require-target", false, "Fail unless the session met its target coverage")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: test-coverage-agent verify-attestation [-key-env name] [-commit hash] [-min-coverage pct] [-require-target] attestation.json\n")
+		return 1
+	}
+
+	key, err := attest.Key(*keyEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	att, err := attest.Verify(fs.Arg(0), key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Valid attestation: %.2f%% coverage (target %.2f%%) at commit %s, issued %s by %s\n",
+		att.Coverage, att.Target, att.Commit, att.IssuedAt.Format(time.RFC3339), att.AgentVersion)
+
+	var failed []string
+	if *commit != "" && (att.Commit == "" || !strings.HasPrefix(att.Commit, *commit)) {
+		failed = append(failed, fmt.Sprintf("attested commit %q is not %s", att.Commit, *commit))
+	}
+	if att.Dirty && *commit != "" {
+		failed = append(failed, "the measured tree had uncommitted changes")
+	}
+	if att.Coverage < *minCoverage {
+		failed = append(failed, fmt.Sprintf("coverage %.2f%% is below %.2f%%", att.Coverage, *minCoverage))
+	}
+	if *requireTarget && !att.TargetMet {
+		failed = append(failed, fmt.Sprintf("the target of %.2f%% was not met", att.Target))
+	}
+	for _, reason := range failed {
+		fmt.Fprintf(os.Stderr, "Failed: %s\n", reason)
+	}
+	if len(failed) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// megabytes converts a byte count for display
+func megabytes(n int64) float64 {
+	return float64(n) / (1024 * 1024)
+}
+
+// generatedSchemas derives the schemas of the files other programs read from
+// the current types, by published file name
+func generatedSchemas() map[string]*schema.Schema {
+	state := schema.Generate(config.State{})
+	state.Title = "Test coverage agent state file"
+
+	events := schema.Generate(notify.Event{})
+	events.Title = "Test coverage agent event, a line of the json reporter's event log"
+
+	// The signed payload is kept as raw JSON, describe it as what it holds
+	attestation := schema.Generate(attest.Document{})
+	attestation.Title = "Test coverage agent coverage attestation"
+	payload := schema.Generate(attest.Attestation{})
+	payload.Schema = ""
+	attestation.Properties["attestation"] = payload
+
+	generated := map[string]*schema.Schema{
+		schema.State:       state,
+		schema.Events:      events,
+		schema.Attestation: attestation,
+	}
+	for name, s := range generated {
+		s.ID = schema.BaseURL + name
+	}
+	return generated
+}
+
+// runSchemas writes the JSON schemas of the state file, event log and
+// attestations as the current types describe them, or checks that they
+// match the published schemas
+func runSchemas(args []string) int {
+	fs := flag.NewFlagSet("schemas", flag.ExitOnError)
+	out := fs.String("out", "schema", "Directory to write the schemas to")
+	check := fs.Bool("check", false, "Only check that the types match the published schemas, for CI")
+	fs.Parse(args)
+
+	generated := generatedSchemas()
+	var drifted []string
+	for _, name := range schema.Names() {
+		data, err := json.MarshalIndent(generated[name], "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to encode %s: %v\n", name, err)
+			return 1
+		}
+		data = append(data, '\n')
+
+		if *check {
+			if published, err := schema.Published(name); err != nil || !bytes.Equal(published, data) {
+				drifted = append(drifted, name)
+			}
+			continue
+		}
+
+		path := filepath.Join(*out, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+
+	if len(drifted) > 0 {
+		fmt.Fprintf(os.Stderr, "Schemas out of date: %s\n", strings.Join(drifted, ", "))
+		fmt.Fprintf(os.Stderr, "Run test-coverage-agent schemas, and bump the version of a schema whose fields were removed, renamed or retyped\n")
+		return 1
+	}
+	if *check {
+		fmt.Println("Schemas are up to date")
+	}
+	return 0
+}
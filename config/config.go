@@ -2,19 +2,129 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/tablev/test-coverage-agent/coverage"
+	"github.com/tablev/test-coverage-agent/notify"
+	"github.com/tablev/test-coverage-agent/schema"
 )
 
 // Config holds the application configuration
 type Config struct {
 	ProjectPath    string  `json:"project_path"`
 	TargetCoverage float64 `json:"target_coverage"`
+	Gain           float64 `json:"gain"` // Points to gain over the starting coverage; replaces TargetCoverage if set
 	StateFile      string  `json:"state_file"`
+	HTMLReport     string  `json:"html_report"`     // Session report path, default <project>/.coverage-agent/report.html
+	CoverageReport string  `json:"coverage_report"` // Existing report to start from instead of running the suite
+	CoverageOut    string  `json:"coverage_out"`    // Export of every coverage measurement as format:path, e.g. lcov:coverage.lcov
+	CICoverage     string  `json:"ci_coverage"`     // Source of the coverage CI reported, to check the starting coverage against
+	CITolerance    float64 `json:"ci_tolerance"`    // Allowed difference in points from the CI coverage
+	MinCoverage    float64 `json:"min_coverage"`    // Total coverage the check command requires (0 = none)
+	Baseline       string  `json:"baseline"`        // Project-relative baseline file the check command doesn't let coverage drop below ("" = none)
+	PRLabels       bool    `json:"pr_labels"`       // Adjust the target to the labels of the pull request a CI job runs for
+	StrictTarget   float64 `json:"strict_target"`   // Target coverage of pull requests labeled coverage:strict
 	DryRun         bool    `json:"dry_run"`
 	MaxIterations  int     `json:"max_iterations"`
-	ClaudeAPIKey   string  `json:"-"` // Don't serialize the API key
+	MaxFiles       int     `json:"max_files"`              // 0 means unlimited
+	FileBudgetMin  int     `json:"file_budget_minutes"`    // Minutes to spend on one file before giving up on it (0 = unlimited)
+	SuiteInterval  int     `json:"suite_interval_minutes"` // Minimum minutes between full-suite coverage runs (0 = no limit)
+	SuiteEvery     int     `json:"suite_every"`            // Run the full suite every N iterations, validating only in between (0 or 1 = every iteration)
+	FreshCoverage  bool    `json:"fresh_coverage"`         // Always run the suite instead of reusing the cached report of an unchanged source tree
+	Incremental    bool    `json:"incremental"`            // Re-measure only the packages of changed tests between full runs (Go)
+	ChunkFiles     int     `json:"chunk_files"`            // Roll over to a new branch every N files (0 = never)
+	ChunkGain      float64 `json:"chunk_gain"`             // Roll over to a new branch every X% coverage gained (0 = never)
+	AnnotateTests  bool    `json:"annotate_tests"`         // Comment each generated test with the lines it targets
+	Harness        bool    `json:"integration_harness"`    // Generate integration harnesses for main packages and entrypoint scripts
+	Testcontainers bool    `json:"testcontainers"`         // Test database code against databases started with testcontainers
+	GoMocks        bool    `json:"go_mocks"`               // Generate mocks for interface dependencies of Go files
+	AttachKB       int     `json:"attach_kb"`              // Upload source files above this many KB through the Files API instead of inlining them (0 = never)
+	PackageContext bool    `json:"package_context"`        // Attach the other source files of the file's package as documents
+	DropGenerated  bool    `json:"drop_generated"`         // Leave generated files out of total coverage too, not just the work plan
+	SkipDeadCode   bool    `json:"skip_dead_code"`         // Skip files a dead-code tool finds unused and test partly unused files last
+	SafeImprove    bool    `json:"safe_improve"`           // Validate improved tests in a temporary copy of the project first
+	NoModify       bool    `json:"no_modify"`              // Only create new test files, never change test files the agent didn't write
+	ImproveOnly    bool    `json:"improve_only"`           // Only improve existing test files, skip source files without tests
+	FlakyRuns      int     `json:"flaky_runs"`             // Extra runs of each validated test to detect flakiness
+	CoverageNotes  bool    `json:"coverage_notes"`         // Attach a coverage snapshot git note to each safety commit
+	RevertDrops    bool    `json:"revert_regressions"`     // Revert the safety commit of a test change that lowered a file's coverage
+	FailureLogs    int     `json:"failure_logs"`           // Failed validation outputs kept in the cache (0 = none)
+	FailureLogKB   int     `json:"failure_log_kb"`         // Size limit per kept validation output
+	CacheMaxSizeMB int64   `json:"cache_max_size_mb"`      // Size limit for collectable cache contents
+	ReuseResponses bool    `json:"reuse_responses"`        // Reuse cached responses to prompts identical to an earlier session's instead of calling the API
+	ReportLanguage string  `json:"report_language"`        // Language to translate the final summary into ("" = English)
+	Attestation    string  `json:"attestation"`            // Signed attestation of the final coverage written at the end of the session ("" = none)
+	AttestKeyEnv   string  `json:"attestation_key_env"`    // Environment variable holding the attestation's HMAC key
+	RefactorHints  bool    `json:"suggest_refactorings"`   // Ask for refactorings that make files the agent failed to test testable
+	ReviewBelow    int     `json:"review_below"`           // Flag validated tests with a lower confidence score for review (0 = never)
+	ActivityLog    string  `json:"activity_log"`           // Project-relative Markdown file to append a session entry to, committed on the session branch ("" = none)
+	Partition      string  `json:"partition"`              // Subset of files to work on, path:PREFIX[,PREFIX...] or hash:K/N, so concurrent runs take disjoint files ("" = all)
+	KeepFailed     bool    `json:"keep_failed_tests"`      // Leave test files that failed validation on disk instead of removing or restoring them
+	BlameContext   bool    `json:"blame_context"`          // Include the messages of the commits that last changed the uncovered lines in prompts
+	Provider       string  `json:"provider"`               // Where the models run: anthropic (default), ollama or vertex
+	OllamaHost     string  `json:"ollama_host"`            // Address of the Ollama server ("" = OLLAMA_HOST or http://localhost:11434)
+	VertexProject  string  `json:"vertex_project"`         // Google Cloud project of Vertex AI requests ("" = GOOGLE_CLOUD_PROJECT or the credentials' project)
+	VertexLocation string  `json:"vertex_location"`        // Vertex AI location ("" = GOOGLE_CLOUD_LOCATION or us-central1)
+	Model          string  `json:"model"`                  // Claude model or alias (opus, sonnet, haiku) for files not matched by Models
+	MaxTokens      int     `json:"max_tokens"`             // Output token limit of test generation requests
+	RequestsPerMin int     `json:"requests_per_minute"`    // Pace Claude API requests to at most this many per minute (0 = unlimited)
+	TokensPerMin   int     `json:"tokens_per_minute"`      // Pace Claude API requests to at most this many input and output tokens per minute (0 = unlimited)
+	MaxTokensTotal int     `json:"max_tokens_total"`       // Stop once the session used this many API tokens (0 = unlimited)
+	MaxCost        float64 `json:"max_cost"`               // Stop once the session's estimated API cost reaches this many USD (0 = unlimited)
+	ClaudeAPIKey   string  `json:"-"`                      // Don't serialize the API key
+
+	// PackageTargets maps project-relative path patterns of package
+	// directories to the coverage the check command requires of them, e.g.
+	// "internal/crypto/**": 90; the longest matching pattern applies
+	PackageTargets map[string]float64 `json:"package_targets"`
+
+	// Models maps project-relative path patterns to models, e.g.
+	// "internal/crypto/**": "opus"; the longest matching pattern wins
+	Models map[string]string `json:"models"`
+
+	// PromptTemplates maps prompt template names (system, generate, improve,
+	// fix) to project-relative files of Go text/templates that replace the
+	// built-in ones
+	PromptTemplates map[string]string `json:"prompt_templates"`
+
+	// MergeCoverage lists extra coverage reports, e.g. from integration
+	// tests, merged into every coverage measurement
+	MergeCoverage []string `json:"merge_coverage"`
+
+	// Exclude lists project-relative path patterns ("vendor/**", "**/*.pb.go")
+	// of files to leave out of the work plan
+	Exclude []string `json:"exclude"`
+
+	// Analyzer holds language-specific tool options
+	Analyzer coverage.Options `json:"analyzer"`
+
+	// Reporters receive session events, e.g. {"type": "slack"} or
+	// {"type": "json", "path": "events.jsonl"}
+	Reporters []notify.Spec `json:"reporters"`
+}
+
+// DefaultConfigFile is the config file looked up in the project root
+const DefaultConfigFile = "coverage-agent.json"
+
+// LoadConfig reads a JSON config file into cfg. Fields missing from the file
+// keep their current values.
+func LoadConfig(filename string, cfg *Config) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", filename, err)
+	}
+
+	return nil
 }
 
 // State represents the persistent state for pause/resume functionality
@@ -23,23 +133,45 @@ type State struct {
 	CurrentIteration   int                `json:"current_iteration"`
 	CurrentCoverage    float64            `json:"current_coverage"`
 	TargetCoverage     float64            `json:"target_coverage"`
+	Exclude            []string           `json:"exclude,omitempty"`             // Exclude patterns the session last ran with
+	FileBudgetMin      int                `json:"file_budget_minutes,omitempty"` // File budget the session last ran with
 	ProcessedFiles     map[string]bool    `json:"processed_files"`     // Files we've attempted to improve
 	FailedFiles        map[string]string  `json:"failed_files"`        // Files that failed with error message
+	FailureLogs        map[string]string  `json:"failure_logs,omitempty"` // Full validation output kept in the cache, per failed file
+	Refactorings       map[string][]string `json:"refactorings,omitempty"` // Refactorings suggested for failed files, to make them testable
 	GeneratedTests     []string           `json:"generated_tests"`     // List of test files we created
 	FixedTests         []string           `json:"fixed_tests"`         // List of test files we fixed
 	CoverageHistory    []CoverageSnapshot `json:"coverage_history"`    // Historical coverage data
+	TestQuality        map[string]TestQuality `json:"test_quality,omitempty"` // Quality metrics per validated test file
+	TestModels         map[string]string  `json:"test_models,omitempty"` // Model that last wrote each generated or improved test file
+	Confidence         map[string]Confidence `json:"confidence,omitempty"` // Confidence in each validated test file
+	Quarantined        map[string]string  `json:"quarantined,omitempty"` // Flaky test files marked as skipped, with the reason
+	Exclusions         []coverage.Exclusion `json:"exclusions,omitempty"` // Code left out of the last coverage report or work plan
+
+	// Regression tracking
+	FileCoverage       map[string]float64 `json:"file_coverage,omitempty"`   // Per-file coverage at the last measurement
+	PendingChanges     []TestChange       `json:"pending_changes,omitempty"` // Test changes made since the last measurement
+	Regressions        []Regression       `json:"regressions,omitempty"`     // Coverage drops caused by test changes
+
+	// Chunked output
+	SessionBranch      string             `json:"session_branch,omitempty"` // Base name for chunk branches
+	Chunks             []Chunk            `json:"chunks,omitempty"`         // Branches the work was split into
 
 	// Rate limiting
 	LastAPICall        time.Time          `json:"last_api_call"`
 	APICallCount       int                `json:"api_call_count"`
+	TokensUsed         int                `json:"tokens_used,omitempty"` // API tokens of every kind used by the session
+	CostUSD            float64            `json:"cost_usd,omitempty"`    // Their estimated cost
 	RateLimitResetTime time.Time          `json:"rate_limit_reset_time"`
 
 	// Metadata
+	Schema             string             `json:"$schema,omitempty"` // Published JSON schema of the file
 	ProjectPath        string             `json:"project_path"`
 	StartedAt          time.Time          `json:"started_at"`
 	LastUpdatedAt      time.Time          `json:"last_updated_at"`
 	PausedAt           *time.Time         `json:"paused_at,omitempty"`
 	Language           string             `json:"language"`
+	ToolVersions       map[string]string  `json:"tool_versions,omitempty"` // Coverage tool versions, for comparing runs across machines
 }
 
 // CoverageSnapshot represents coverage at a point in time
@@ -50,6 +182,65 @@ type CoverageSnapshot struct {
 	FilesAdded int       `json:"files_added"`
 }
 
+// TestQuality holds simple quality metrics for a generated test file
+type TestQuality struct {
+	Tests              int `json:"tests"`
+	Assertions         int `json:"assertions"`
+	Mocks              int `json:"mocks"`
+	FunctionsExercised int `json:"functions_exercised"` // Source functions called from the tests
+	FunctionsTotal     int `json:"functions_total"`     // Functions declared in the source file
+}
+
+// Confidence is how far a validated test file can be trusted without a
+// closer look, from the model's self-assessment and the validation signals
+type Confidence struct {
+	Score     int      `json:"score"`              // 0-100
+	SelfScore int      `json:"self_score"`         // The model's own rating, -1 if it gave none
+	Reason    string   `json:"reason,omitempty"`   // The model's reason for its rating
+	Retries   int      `json:"retries"`            // Fix attempts the test needed to pass
+	Concerns  []string `json:"concerns,omitempty"` // Signals that lowered the score
+	Review    bool     `json:"review,omitempty"`   // Flagged for human review
+}
+
+// String describes the confidence for reviewers, e.g. "35/100: needed 1
+// fix attempt(s); model: unsure how retries are counted"
+func (c Confidence) String() string {
+	details := append([]string(nil), c.Concerns...)
+	if c.Reason != "" {
+		details = append(details, "model: "+c.Reason)
+	}
+	if len(details) == 0 {
+		return fmt.Sprintf("%d/100", c.Score)
+	}
+	return fmt.Sprintf("%d/100: %s", c.Score, strings.Join(details, "; "))
+}
+
+// TestChange is a validated test change not yet covered by a measurement
+type TestChange struct {
+	SourceFile string `json:"source_file"`
+	TestFile   string `json:"test_file"`
+	Commit     string `json:"commit,omitempty"` // Safety commit, if git is enabled
+}
+
+// Regression is a drop in a file's coverage between two measurements
+type Regression struct {
+	File      string  `json:"file"`
+	Before    float64 `json:"before"`
+	After     float64 `json:"after"`
+	Iteration int     `json:"iteration"`
+	TestFile  string  `json:"test_file,omitempty"` // Test change blamed for the drop
+	Reverted  bool    `json:"reverted,omitempty"`  // Whether its safety commit was reverted
+}
+
+// Chunk is a slice of the session's work committed to its own branch
+type Chunk struct {
+	Index         int       `json:"index"`
+	Branch        string    `json:"branch"`
+	Files         []string  `json:"files"`
+	StartCoverage float64   `json:"start_coverage"`
+	StartedAt     time.Time `json:"started_at"`
+}
+
 // NewState creates a new state instance
 func NewState(projectPath string, targetCoverage float64, language string) *State {
 	return &State{
@@ -72,11 +263,19 @@ func NewState(projectPath string, targetCoverage float64, language string) *Stat
 // SaveState saves the state to a JSON file
 func (s *State) SaveState(filename string) error {
 	s.LastUpdatedAt = time.Now()
+	s.Schema = schema.BaseURL + schema.State
 
 	data, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
+	if err := schema.Validate(schema.State, data); err != nil {
+		return fmt.Errorf("state %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
 
 	if err := os.WriteFile(filename, data, 0644); err != nil {
 		return fmt.Errorf("failed to write state file: %w", err)
@@ -111,6 +310,14 @@ func (s *State) AddCoverageSnapshot(coverage float64) {
 	s.CurrentCoverage = coverage
 }
 
+// StartingCoverage returns the first coverage measurement of the session
+func (s *State) StartingCoverage() float64 {
+	if len(s.CoverageHistory) == 0 {
+		return s.CurrentCoverage
+	}
+	return s.CoverageHistory[0].Coverage
+}
+
 // MarkFileProcesed marks a file as having been processed
 func (s *State) MarkFileProcessed(filename string) {
 	s.ProcessedFiles[filename] = true
@@ -121,6 +328,122 @@ func (s *State) MarkFileFailed(filename string, errorMsg string) {
 	s.FailedFiles[filename] = errorMsg
 }
 
+// Failure classes of FailedFiles entries, derived from the recorded message
+const (
+	FailureCompile = "compile" // The generated test didn't compile
+	FailureTest    = "test"    // The generated test compiled but failed
+	FailureBudget     = "budget"     // The file's time budget ran out
+	FailureRegression = "regression" // The test change lowered coverage
+	FailureStalled    = "stalled"    // A test or build command hung without output and was killed
+	FailureError      = "error"      // Generation or tooling failed, e.g. a missing tool or network problem
+)
+
+// FailureClasses lists the failure classes in display order
+var FailureClasses = []string{FailureCompile, FailureTest, FailureBudget, FailureRegression, FailureStalled, FailureError}
+
+// FailureClass classifies a FailedFiles message
+func FailureClass(errorMsg string) string {
+	switch {
+	case strings.HasPrefix(errorMsg, "Compilation failed"):
+		return FailureCompile
+	case strings.HasPrefix(errorMsg, "Tests failed"):
+		return FailureTest
+	case errorMsg == "budget exceeded":
+		return FailureBudget
+	case strings.HasPrefix(errorMsg, "Coverage regression"):
+		return FailureRegression
+	case strings.HasPrefix(errorMsg, "Stalled"):
+		return FailureStalled
+	}
+	return FailureError
+}
+
+// RecordRefactorings stores the refactorings suggested for a failed file
+func (s *State) RecordRefactorings(file string, suggestions []string) {
+	if s.Refactorings == nil {
+		s.Refactorings = make(map[string][]string)
+	}
+	s.Refactorings[file] = suggestions
+}
+
+// RequeueFailed forgets failed files so a resumed session retries them,
+// keeping the rest of the session's progress. With classes, only failures
+// of those classes are requeued. It returns the requeued files, sorted.
+func (s *State) RequeueFailed(classes ...string) []string {
+	wanted := make(map[string]bool, len(classes))
+	for _, class := range classes {
+		wanted[class] = true
+	}
+
+	var requeued []string
+	for file, errorMsg := range s.FailedFiles {
+		if len(wanted) > 0 && !wanted[FailureClass(errorMsg)] {
+			continue
+		}
+		requeued = append(requeued, file)
+	}
+	sort.Strings(requeued)
+
+	for _, file := range requeued {
+		delete(s.FailedFiles, file)
+		delete(s.FailureLogs, file)
+		delete(s.Refactorings, file)
+		delete(s.ProcessedFiles, file)
+	}
+	return requeued
+}
+
+// RequeueBelow forgets processed files whose last measured coverage is
+// below target, so a session resumed with a higher target revisits them.
+// Failed files and files without a measurement are left alone. It returns
+// the requeued files, sorted.
+func (s *State) RequeueBelow(target float64) []string {
+	var requeued []string
+	for file := range s.ProcessedFiles {
+		if _, failed := s.FailedFiles[file]; failed {
+			continue
+		}
+		if coverage, measured := s.FileCoverage[file]; measured && coverage < target {
+			requeued = append(requeued, file)
+		}
+	}
+	sort.Strings(requeued)
+
+	for _, file := range requeued {
+		delete(s.ProcessedFiles, file)
+	}
+	return requeued
+}
+
+// RecordTestChange remembers a validated test change until the next
+// coverage measurement shows its effect
+func (s *State) RecordTestChange(change TestChange) {
+	s.PendingChanges = append(s.PendingChanges, change)
+}
+
+// RecordRegression records a coverage drop
+func (s *State) RecordRegression(regression Regression) {
+	s.Regressions = append(s.Regressions, regression)
+}
+
+// RecordFailureLog stores where the full validation output for a failed file was kept
+func (s *State) RecordFailureLog(filename string, logFile string) {
+	if s.FailureLogs == nil {
+		s.FailureLogs = make(map[string]string)
+	}
+	s.FailureLogs[filename] = logFile
+}
+
+// DropMissingFailureLogs forgets the failure logs that no longer exist,
+// e.g. after older logs were pruned from the cache
+func (s *State) DropMissingFailureLogs() {
+	for filename, logFile := range s.FailureLogs {
+		if _, err := os.Stat(logFile); errors.Is(err, os.ErrNotExist) {
+			delete(s.FailureLogs, filename)
+		}
+	}
+}
+
 // IsFileProcessed checks if a file has already been processed
 func (s *State) IsFileProcessed(filename string) bool {
 	return s.ProcessedFiles[filename]
@@ -136,12 +459,164 @@ func (s *State) AddFixedTest(testFile string) {
 	s.FixedTests = append(s.FixedTests, testFile)
 }
 
+// ModifiedFileCount returns the number of distinct test files created or changed
+func (s *State) ModifiedFileCount() int {
+	files := make(map[string]bool)
+	for _, f := range s.GeneratedTests {
+		files[f] = true
+	}
+	for _, f := range s.FixedTests {
+		files[f] = true
+	}
+	return len(files)
+}
+
+// QuarantineTest records a flaky test file that was marked as skipped
+func (s *State) QuarantineTest(testFile string, reason string) {
+	if s.Quarantined == nil {
+		s.Quarantined = make(map[string]string)
+	}
+	s.Quarantined[testFile] = reason
+}
+
+// RecordTestModel records the model that wrote a test file
+func (s *State) RecordTestModel(testFile string, model string) {
+	if s.TestModels == nil {
+		s.TestModels = make(map[string]string)
+	}
+	s.TestModels[testFile] = model
+}
+
+// RecordTestQuality stores the quality metrics of a validated test file
+func (s *State) RecordTestQuality(testFile string, quality TestQuality) {
+	if s.TestQuality == nil {
+		s.TestQuality = make(map[string]TestQuality)
+	}
+	s.TestQuality[testFile] = quality
+}
+
+// RecordConfidence stores the confidence in a validated test file
+func (s *State) RecordConfidence(testFile string, confidence Confidence) {
+	if s.Confidence == nil {
+		s.Confidence = make(map[string]Confidence)
+	}
+	s.Confidence[testFile] = confidence
+}
+
+// NeedsReview returns the test files flagged for review, least confident
+// first
+func (s *State) NeedsReview() []string {
+	var files []string
+	for file, confidence := range s.Confidence {
+		if confidence.Review {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		a, b := s.Confidence[files[i]], s.Confidence[files[j]]
+		if a.Score != b.Score {
+			return a.Score < b.Score
+		}
+		return files[i] < files[j]
+	})
+	return files
+}
+
+// GetQualitySummary returns a human-readable summary of test quality metrics
+func (s *State) GetQualitySummary() string {
+	if len(s.TestQuality) == 0 {
+		return "Test quality: no validated tests"
+	}
+
+	files := make([]string, 0, len(s.TestQuality))
+	byModel := make(map[string][]string)
+	for file := range s.TestQuality {
+		files = append(files, file)
+		if model, ok := s.TestModels[file]; ok {
+			byModel[model] = append(byModel[model], file)
+		}
+	}
+
+	summary := "Test quality: " + s.qualityStats(files)
+
+	// Break the metrics down when the tests were written by different models
+	if len(byModel) > 1 {
+		models := make([]string, 0, len(byModel))
+		for model := range byModel {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		for _, model := range models {
+			summary += fmt.Sprintf("\n  %s: %s", model, s.qualityStats(byModel[model]))
+		}
+	}
+	return summary
+}
+
+// qualityStats sums up the quality metrics of some validated test files
+func (s *State) qualityStats(files []string) string {
+	var total TestQuality
+	for _, file := range files {
+		q := s.TestQuality[file]
+		total.Tests += q.Tests
+		total.Assertions += q.Assertions
+		total.Mocks += q.Mocks
+		total.FunctionsExercised += q.FunctionsExercised
+		total.FunctionsTotal += q.FunctionsTotal
+	}
+
+	assertionsPerTest := 0.0
+	if total.Tests > 0 {
+		assertionsPerTest = float64(total.Assertions) / float64(total.Tests)
+	}
+	mockRatio := 0.0
+	if total.Assertions > 0 {
+		mockRatio = float64(total.Mocks) / float64(total.Assertions)
+	}
+
+	return fmt.Sprintf(
+		"%d files | %d tests | %.1f assertions/test | %d/%d functions exercised | %.2f mocks/assertion",
+		len(files),
+		total.Tests,
+		assertionsPerTest,
+		total.FunctionsExercised,
+		total.FunctionsTotal,
+		mockRatio,
+	)
+}
+
+// StartChunk begins a new chunk on the given branch
+func (s *State) StartChunk(branch string) *Chunk {
+	s.Chunks = append(s.Chunks, Chunk{
+		Index:         len(s.Chunks) + 1,
+		Branch:        branch,
+		Files:         []string{},
+		StartCoverage: s.CurrentCoverage,
+		StartedAt:     time.Now(),
+	})
+	return &s.Chunks[len(s.Chunks)-1]
+}
+
+// CurrentChunk returns the chunk currently receiving commits, or nil
+func (s *State) CurrentChunk() *Chunk {
+	if len(s.Chunks) == 0 {
+		return nil
+	}
+	return &s.Chunks[len(s.Chunks)-1]
+}
+
 // RecordAPICall updates API call tracking for rate limit management
 func (s *State) RecordAPICall() {
 	s.LastAPICall = time.Now()
 	s.APICallCount++
 }
 
+// RecordUsage adds API tokens and their cost to the session's usage
+func (s *State) RecordUsage(tokens int, cost float64) {
+	s.TokensUsed += tokens
+	s.CostUSD += cost
+}
+
 // SetRateLimitReset sets the time when rate limits will reset
 func (s *State) SetRateLimitReset(resetTime time.Time) {
 	s.RateLimitResetTime = resetTime
@@ -163,7 +638,7 @@ func (s *State) ShouldWaitForRateLimit() (bool, time.Duration) {
 
 // GetProgress returns a human-readable progress summary
 func (s *State) GetProgress() string {
-	return fmt.Sprintf(
+	progress := fmt.Sprintf(
 		"Iteration: %d | Coverage: %.2f%% / %.2f%% | Generated: %d | Fixed: %d | Failed: %d",
 		s.CurrentIteration,
 		s.CurrentCoverage,
@@ -172,6 +647,10 @@ func (s *State) GetProgress() string {
 		len(s.FixedTests),
 		len(s.FailedFiles),
 	)
+	if s.TokensUsed > 0 {
+		progress += fmt.Sprintf(" | Tokens: %d (~$%.2f)", s.TokensUsed, s.CostUSD)
+	}
+	return progress
 }
 
 // NeedsTestGeneration checks if test generation is needed based on coverage threshold
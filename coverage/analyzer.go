@@ -1,11 +1,18 @@
 package coverage
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/tablev/test-coverage-agent/cache"
+	"github.com/tablev/test-coverage-agent/errdefs"
 )
 
 // CoverageReport represents coverage information for a project
@@ -15,6 +22,130 @@ type CoverageReport struct {
 	UncoveredFiles []string              `json:"uncovered_files"`
 	UncoveredLines map[string][]int      `json:"uncovered_lines"`
 	Language       string                `json:"language"`
+
+	// UncoveredBranches is only populated in branch coverage mode
+	UncoveredBranches map[string][]Branch `json:"uncovered_branches,omitempty"`
+
+	// TotalLines holds the number of instrumented lines per file, for tools that report it
+	TotalLines map[string]int `json:"total_lines,omitempty"`
+
+	// CoveredLines holds the executed lines per file, for tools that report them
+	CoveredLines map[string][]int `json:"covered_lines,omitempty"`
+
+	// Methods holds the coverage of each method per file, for tools that
+	// report it (JaCoCo)
+	Methods map[string][]Method `json:"methods,omitempty"`
+
+	// Exclusions lists code left out of the report or the work plan, and why
+	Exclusions []Exclusion `json:"exclusions,omitempty"`
+
+	// ParseErrors lists report entries that were skipped because they couldn't be parsed
+	ParseErrors []ParseError `json:"parse_errors,omitempty"`
+}
+
+// Reasons for excluding code
+const (
+	ExcludedGenerated   = "generated code"
+	ExcludedIgnoreFile  = IgnoreFile
+	ExcludedIgnoreLines = Ignore
+	ExcludedByPattern   = "exclude pattern"
+)
+
+// Exclusion describes code left out of a report or its work plan
+type Exclusion struct {
+	File    string `json:"file"`
+	Reason  string `json:"reason"`
+	Lines   int    `json:"lines,omitempty"` // Uncovered lines left out; 0 if the whole file was
+	InTotal bool   `json:"in_total"`        // The code still counts towards the total coverage
+}
+
+// Method is the coverage of one method of a file
+type Method struct {
+	Class           string `json:"class"` // Class name without the package, e.g. Outer$Inner
+	Name            string `json:"name"`
+	Line            int    `json:"line,omitempty"` // First line of the method, 0 if unknown
+	MissedLines     int    `json:"missed_lines"`
+	CoveredLines    int    `json:"covered_lines"`
+	MissedBranches  int    `json:"missed_branches,omitempty"`
+	CoveredBranches int    `json:"covered_branches,omitempty"`
+}
+
+// Tested reports whether any line of the method was executed
+func (m Method) Tested() bool {
+	return m.CoveredLines > 0
+}
+
+// UntestedMethods returns the methods of a file that no test executed, in
+// source order
+func (r *CoverageReport) UntestedMethods(file string) []Method {
+	var untested []Method
+	for _, method := range r.Methods[file] {
+		if !method.Tested() {
+			untested = append(untested, method)
+		}
+	}
+	sort.SliceStable(untested, func(i, j int) bool {
+		return untested[i].Line < untested[j].Line
+	})
+	return untested
+}
+
+// addMethod records the coverage of a method of a file
+func (r *CoverageReport) addMethod(file string, method Method) {
+	if r.Methods == nil {
+		r.Methods = make(map[string][]Method)
+	}
+	r.Methods[file] = append(r.Methods[file], method)
+}
+
+// setTotalLines records the number of instrumented lines of a file
+func (r *CoverageReport) setTotalLines(file string, lines int) {
+	if r.TotalLines == nil {
+		r.TotalLines = make(map[string]int)
+	}
+	r.TotalLines[file] = lines
+}
+
+// uniqueLines sorts line numbers and removes duplicates
+func uniqueLines(lines []int) []int {
+	sort.Ints(lines)
+	unique := lines[:0]
+	for i, line := range lines {
+		if i == 0 || line != lines[i-1] {
+			unique = append(unique, line)
+		}
+	}
+	return unique
+}
+
+// setCoveredLines records the executed lines of a file
+func (r *CoverageReport) setCoveredLines(file string, lines []int) {
+	if len(lines) == 0 {
+		return
+	}
+	if r.CoveredLines == nil {
+		r.CoveredLines = make(map[string][]int)
+	}
+	r.CoveredLines[file] = lines
+}
+
+// addExclusion records code left out of the report or its work plan
+func (r *CoverageReport) addExclusion(file, reason string, lines int, inTotal bool) {
+	r.Exclusions = append(r.Exclusions, Exclusion{File: file, Reason: reason, Lines: lines, InTotal: inTotal})
+}
+
+// Branch describes a branch arm that was never taken
+type Branch struct {
+	Line   int    `json:"line"`   // Line of the branching statement
+	Detail string `json:"detail"` // Which arm is missing, in the tool's terms
+}
+
+// addUncoveredBranch records a missed branch arm in the report
+func (r *CoverageReport) addUncoveredBranch(file string, branch Branch) {
+	if r.UncoveredBranches == nil {
+		r.UncoveredBranches = make(map[string][]Branch)
+	}
+	r.UncoveredBranches[file] = append(r.UncoveredBranches[file], branch)
 }
 
 // Analyzer defines the interface for language-specific coverage analyzers
@@ -39,21 +170,194 @@ type Analyzer interface {
 
 	// ValidateTestFile checks if a test file is valid (compiles, runs)
 	ValidateTestFile(projectPath string, testFile string) (bool, string, error)
+
+	// ToolVersions reports the versions of the tools used for coverage,
+	// keyed by tool name. Tools that can't be queried are omitted.
+	ToolVersions(projectPath string) map[string]string
+}
+
+// Compiler is implemented by analyzers with a compile-only check that is
+// much cheaper than running the tests
+type Compiler interface {
+	// CompileTestFile checks that a test file compiles (or parses) without
+	// running it, returning success/failure and the compiler output
+	CompileTestFile(projectPath string, testFile string) (bool, string, error)
+}
+
+// TestSelector is implemented by analyzers that can run only some of the
+// tests in a test file
+type TestSelector interface {
+	// RunSelectedTests runs the named tests of a test file and returns
+	// success/failure and output
+	RunSelectedTests(projectPath string, testFile string, tests []string) (bool, string, error)
+}
+
+// TestRegistrar is implemented by analyzers whose build lists its test
+// files, so a new test file is only compiled and run once it's added
+type TestRegistrar interface {
+	// TestBuildFile returns the build file RegisterTestFile would change for
+	// a test file, or "" if the test file needs no registration
+	TestBuildFile(projectPath string, testFile string) string
+
+	// RegisterTestFile adds a test file to the build, reporting whether
+	// the build file changed
+	RegisterTestFile(projectPath string, testFile string) (bool, error)
+}
+
+// Options holds language-specific tool settings passed to analyzers
+type Options struct {
+	// BranchCoverage collects uncovered branch arms where the tool supports it
+	BranchCoverage bool `json:"branch_coverage"`
+
+	// CoverPkg is passed to go test -coverpkg, e.g. "./..." so tests are
+	// credited for the code they exercise in other packages
+	CoverPkg string `json:"coverpkg"`
+
+	Maven  MavenOptions  `json:"maven"`
+	Go     GoOptions     `json:"go"`
+	Python PythonOptions `json:"python"`
+	Xcode  XcodeOptions  `json:"xcode"`
+
+	// Env sets extra environment variables (e.g. GOFLAGS, NODE_ENV, PYTHONPATH) for every analyzer command.
+	// ${NAME} in a value is replaced by the host's NAME variable.
+	Env map[string]string `json:"env"`
+
+	// StallMinutes kills an analyzer command that produces no output for this many minutes (0 = never)
+	StallMinutes int `json:"stall_minutes"`
+
+	// Hermetic runs analyzer commands with a minimal environment and tool caches isolated in the agent cache
+	Hermetic bool `json:"hermetic"`
+
+	// Plugins are external analyzers, tried before the built-in ones
+	Plugins []Plugin `json:"plugins"`
+
+	// Custom are analyzers defined by commands and patterns, tried after plugins
+	Custom []GenericSpec `json:"custom"`
+
+	// Commands replace the detected analyzer's coverage and test commands
+	Commands CommandOptions `json:"commands"`
+
+	// TaskRunner runs tests through a monorepo task runner: "nx", "turbo" or "pants".
+	// Detected from nx.json, turbo.json or pants.toml if empty; "none" runs the test tools directly.
+	TaskRunner string `json:"task_runner"`
+
+	// AffectedBase limits task runner coverage runs to projects affected since this git ref
+	AffectedBase string `json:"affected_base"`
+}
+
+// hermeticPassthrough lists the host variables kept in a hermetic
+// environment. Proxy, Go module and certificate settings say how builds
+// reach their dependencies rather than where they cache them, and builds
+// behind a corporate proxy fail without them.
+var hermeticPassthrough = []string{
+	"PATH", "HOME", "USER", "TMPDIR", "TEMP", "TMP", "LANG", "LC_ALL", "TERM",
+	"SYSTEMROOT", "JAVA_HOME", "DEVELOPER_DIR",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	"GOPROXY", "GOPRIVATE", "GONOSUMDB", "GOFLAGS",
+	"SSL_CERT_FILE", "SSL_CERT_DIR",
+}
+
+// environ returns the environment for analyzer commands, or nil to inherit
+// the agent's environment unchanged
+func (o Options) environ(projectPath string) []string {
+	if !o.Hermetic && len(o.Env) == 0 {
+		return nil
+	}
+
+	var env []string
+	if o.Hermetic {
+		for _, name := range hermeticPassthrough {
+			if value, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+value)
+			}
+		}
+
+		if dir, err := filepath.Abs(cache.New(projectPath).Path(cache.Toolchain, "")); err == nil {
+			env = append(env,
+				"GOPATH="+filepath.Join(dir, "gopath"),
+				"GOCACHE="+filepath.Join(dir, "gocache"),
+				"npm_config_cache="+filepath.Join(dir, "npm"),
+				"PYTHONPYCACHEPREFIX="+filepath.Join(dir, "pycache"),
+			)
+		}
+	} else {
+		env = os.Environ()
+	}
+
+	// Later entries win, so configured values override the defaults above
+	names := make([]string, 0, len(o.Env))
+	for name := range o.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+expandHostVars(o.Env[name]))
+	}
+
+	return env
+}
+
+// hostVarPattern matches ${NAME} references in configured values
+var hostVarPattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// expandHostVars replaces ${NAME} references with the host's variables, so
+// secrets like database URLs can be passed through without being written to
+// the config file. A bare $ is left alone.
+func expandHostVars(value string) string {
+	return hostVarPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+}
+
+// MavenOptions configures Maven invocations made by the Java analyzer
+type MavenOptions struct {
+	Offline  bool     `json:"offline"`  // -o: don't touch remote repositories
+	Quiet    bool     `json:"quiet"`    // -q: only log errors
+	SkipITs  bool     `json:"skip_its"` // -DskipITs: skip failsafe integration tests
+	Settings string   `json:"settings"` // -s: custom settings.xml (e.g. for proxies)
+	Args     []string `json:"args"`     // Additional arguments passed verbatim
+}
+
+// GoOptions configures the go commands of the Go analyzer. GOFLAGS and
+// other variables are set through Options.Env.
+type GoOptions struct {
+	Tags []string `json:"tags"` // Build tags for every go build and go test, e.g. integration
+	Args []string `json:"args"` // Additional go test arguments passed verbatim, e.g. -race or -count=1
 }
 
 // DetectProjectLanguage determines the primary language of a project
-func DetectProjectLanguage(projectPath string) (Analyzer, error) {
-	analyzers := []Analyzer{
-		&GoAnalyzer{},
-		&SwiftAnalyzer{},
-		&PythonAnalyzer{},
-		&TypeScriptAnalyzer{},
-		&JavaAnalyzer{},
+func DetectProjectLanguage(projectPath string, opts Options) (Analyzer, error) {
+	if err := checkTaskRunner(opts.TaskRunner); err != nil {
+		return nil, err
+	}
+	if err := checkCommands(opts.Commands); err != nil {
+		return nil, err
 	}
 
+	var analyzers []Analyzer
+	for _, plugin := range opts.Plugins {
+		analyzers = append(analyzers, NewPluginAnalyzer(plugin, opts))
+	}
+	for _, spec := range opts.Custom {
+		analyzers = append(analyzers, NewGenericAnalyzer(spec, opts))
+	}
+
+	analyzers = append(analyzers,
+		&GoAnalyzer{opts: opts},
+		&SwiftAnalyzer{opts: opts},
+		&CppAnalyzer{opts: opts},
+		&ElixirAnalyzer{opts: opts},
+		&DartAnalyzer{opts: opts},
+		&PythonAnalyzer{opts: opts},
+		&TypeScriptAnalyzer{opts: opts},
+		&ScalaAnalyzer{opts: opts},
+		&KotlinAnalyzer{JavaAnalyzer{opts: opts, lang: kotlinLanguage}},
+		&JavaAnalyzer{opts: opts},
+	)
+
 	for _, analyzer := range analyzers {
 		if analyzer.DetectLanguage(projectPath) {
-			return analyzer, nil
+			return withCommands(analyzer, opts), nil
 		}
 	}
 
@@ -66,6 +370,66 @@ func fileExists(path string) bool {
 	return err == nil
 }
 
+// coverageArtifact returns an absolute path in the project cache for coverage
+// tool output, so reports don't clutter the project root
+func coverageArtifact(projectPath, name string) (string, error) {
+	dir, err := cache.New(projectPath).Dir(cache.Coverage)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(filepath.Join(dir, name))
+}
+
+// testResult turns the outcome of a test command into the results of
+// RunTests: failing tests are reported as unsuccessful, while a tool that
+// isn't installed is an ErrToolMissing error, since no test can pass
+// without it, and a killed stalled command is an ErrStalled error
+func testResult(output string, err error) (bool, string, error) {
+	if errors.Is(err, exec.ErrNotFound) {
+		return false, output, errdefs.ToolMissing(err)
+	}
+	if errors.Is(err, errdefs.ErrStalled) {
+		return false, output, err
+	}
+	return err == nil, output, nil
+}
+
+// runFailed returns the error of a coverage run that leaves no report to
+// parse: a missing tool or a stalled command. Failing tests still write
+// coverage, so other errors are left to the report parsing.
+func runFailed(err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, errdefs.ErrStalled) {
+		return errdefs.ToolMissing(err)
+	}
+	return nil
+}
+
+// commandVersion runs a version command and returns the first non-empty
+// line of its output, or "" if the command fails
+func commandVersion(projectPath string, name string, args ...string) string {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = projectPath
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// addVersion records a tool version if it was detected
+func addVersion(versions map[string]string, tool, version string) {
+	if version != "" {
+		versions[tool] = version
+	}
+}
+
 // Helper function to find files with specific extensions
 func findFilesWithExtension(projectPath string, extensions []string) ([]string, error) {
 	var files []string
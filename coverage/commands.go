@@ -0,0 +1,153 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CommandOptions replace an analyzer's own commands, e.g. with Make targets
+// or wrapper scripts, while keeping its language conventions. Commands run
+// in the project directory without a shell; use ["sh", "-c", "..."] for
+// pipes or variables.
+type CommandOptions struct {
+	Coverage []string `json:"coverage"` // Runs the suite with coverage and writes Report, e.g. ["make", "test-cover"]
+	Report   string   `json:"report"`   // Report path relative to the project
+	Format   string   `json:"format"`   // lcov, cobertura, go, ...; detected if empty
+	Test     []string `json:"test"`     // Runs one test file; {test_file} and {package} are replaced
+}
+
+// checkCommands validates configured command overrides
+func checkCommands(commands CommandOptions) error {
+	if len(commands.Coverage) > 0 && commands.Report == "" {
+		return fmt.Errorf("analyzer.commands.coverage needs analyzer.commands.report")
+	}
+	return nil
+}
+
+// commandAnalyzer runs an analyzer with the commands of Options.Commands in
+// place of its own. Commands that aren't configured, and the optional
+// analyzer interfaces, are passed through to the analyzer.
+type commandAnalyzer struct {
+	Analyzer
+	opts Options
+}
+
+// withCommands wraps an analyzer if any command is overridden
+func withCommands(analyzer Analyzer, opts Options) Analyzer {
+	if len(opts.Commands.Coverage) == 0 && len(opts.Commands.Test) == 0 {
+		return analyzer
+	}
+	return &commandAnalyzer{Analyzer: analyzer, opts: opts}
+}
+
+// RunCoverage runs the coverage command and parses the report it writes
+func (c *commandAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
+	commands := c.opts.Commands
+	if len(commands.Coverage) == 0 {
+		return c.Analyzer.RunCoverage(projectPath)
+	}
+
+	reportFile := filepath.Join(projectPath, commands.Report)
+	os.Remove(reportFile) // Don't parse a stale report if the run fails
+
+	output, _ := c.command(projectPath, commands.Coverage, "") // Ignore error, tests might fail
+
+	if !fileExists(reportFile) {
+		return nil, fmt.Errorf("coverage command did not write %s\n%s", commands.Report, output)
+	}
+
+	return ParseReport(projectPath, reportFile, commands.Format, c, c.opts)
+}
+
+// RunCoverageFor re-measures part of the project if the analyzer can and
+// its coverage command isn't overridden
+func (c *commandAnalyzer) RunCoverageFor(projectPath string, testFiles []string) (*CoverageReport, error) {
+	if incremental, ok := c.Analyzer.(IncrementalAnalyzer); ok && len(c.opts.Commands.Coverage) == 0 {
+		return incremental.RunCoverageFor(projectPath, testFiles)
+	}
+	return c.RunCoverage(projectPath)
+}
+
+// RunTests runs the test command for a test file
+func (c *commandAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
+	if len(c.opts.Commands.Test) == 0 {
+		return c.Analyzer.RunTests(projectPath, testFile)
+	}
+
+	output, err := c.command(projectPath, c.opts.Commands.Test, testFile)
+	return testResult(output, err)
+}
+
+// ValidateTestFile runs the test command for a test file, which builds it
+func (c *commandAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
+	if len(c.opts.Commands.Test) == 0 {
+		return c.Analyzer.ValidateTestFile(projectPath, testFile)
+	}
+	return c.RunTests(projectPath, testFile)
+}
+
+// CompileTestFile runs the analyzer's compile-only check, if it has one
+func (c *commandAnalyzer) CompileTestFile(projectPath string, testFile string) (bool, string, error) {
+	if compiler, ok := c.Analyzer.(Compiler); ok {
+		return compiler.CompileTestFile(projectPath, testFile)
+	}
+	return true, "", nil
+}
+
+// RunSelectedTests runs only the named tests if the analyzer can and its
+// test command isn't overridden; otherwise the whole test file runs
+func (c *commandAnalyzer) RunSelectedTests(projectPath string, testFile string, tests []string) (bool, string, error) {
+	if selector, ok := c.Analyzer.(TestSelector); ok && len(c.opts.Commands.Test) == 0 {
+		return selector.RunSelectedTests(projectPath, testFile, tests)
+	}
+	return c.ValidateTestFile(projectPath, testFile)
+}
+
+// TestBuildFile returns the analyzer's build file for a test file, unless
+// the test command is overridden and so decides what is built
+func (c *commandAnalyzer) TestBuildFile(projectPath string, testFile string) string {
+	if registrar, ok := c.Analyzer.(TestRegistrar); ok && len(c.opts.Commands.Test) == 0 {
+		return registrar.TestBuildFile(projectPath, testFile)
+	}
+	return ""
+}
+
+// RegisterTestFile adds a test file to the analyzer's build, unless the
+// test command is overridden
+func (c *commandAnalyzer) RegisterTestFile(projectPath string, testFile string) (bool, error) {
+	if registrar, ok := c.Analyzer.(TestRegistrar); ok && len(c.opts.Commands.Test) == 0 {
+		return registrar.RegisterTestFile(projectPath, testFile)
+	}
+	return false, nil
+}
+
+// command runs a command template with {test_file} and {package} replaced
+// and returns its combined output. {package} is the test file's directory
+// relative to the project, as "./dir".
+func (c *commandAnalyzer) command(projectPath string, template []string, testFile string) (string, error) {
+	pkg := ""
+	if testFile != "" {
+		pkg = "./" + filepath.ToSlash(filepath.Dir(relativeToProject(projectPath, testFile)))
+	}
+	replacer := strings.NewReplacer("{test_file}", testFile, "{package}", pkg)
+
+	args := make([]string, len(template))
+	for i, arg := range template {
+		args[i] = replacer.Replace(arg)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = projectPath
+	cmd.Env = c.opts.environ(projectPath)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := c.opts.run(cmd)
+	return output.String(), err
+}
@@ -0,0 +1,656 @@
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/cache"
+	"github.com/tablev/test-coverage-agent/errdefs"
+)
+
+// CppAnalyzer implements coverage analysis for C and C++ projects built with
+// CMake or Make. Sources are compiled with --coverage, tests run with ctest
+// (or the Makefile's test target), and gcov data is collected with gcovr or lcov.
+type CppAnalyzer struct {
+	opts Options
+}
+
+// C/C++ source extensions; headers are covered through the sources including them
+var cppSourceExtensions = []string{".cpp", ".cc", ".cxx", ".c"}
+
+// C/C++ header extensions, checked for the code under test of header-only libraries
+var cppHeaderExtensions = []string{".h", ".hpp", ".hh", ".hxx"}
+
+// Test directories checked, in order, for the test file of a source file
+var cppTestDirs = []string{"tests", "test"}
+
+// Compiler and linker flags for gcov instrumentation
+const cppCoverageFlags = "-O0 -g --coverage"
+
+// DetectLanguage checks if this is a C/C++ project: a CMake or Make build
+// with C/C++ sources that outnumber Python scripts
+func (c *CppAnalyzer) DetectLanguage(projectPath string) bool {
+	if !isCMakeProject(projectPath) && makefile(projectPath) == "" {
+		return false
+	}
+
+	cppFiles := countFilesWithExtension(projectPath, cppSourceExtensions)
+	return cppFiles > 0 && cppFiles >= countFilesWithExtension(projectPath, []string{".py"})
+}
+
+// GetLanguageName returns "C++"
+func (c *CppAnalyzer) GetLanguageName() string {
+	return "C++"
+}
+
+// RunCoverage builds the project with gcov instrumentation, runs the test
+// suite and collects line coverage with gcovr, or lcov if gcovr is missing
+func (c *CppAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
+	report := &CoverageReport{
+		FileCoverage:   make(map[string]float64),
+		UncoveredFiles: []string{},
+		UncoveredLines: make(map[string][]int),
+		Language:       "C++",
+	}
+
+	if output, err := c.build(projectPath); err != nil {
+		return nil, fmt.Errorf("failed to build with coverage: %w\n%s", errdefs.ToolMissing(err), output)
+	}
+
+	// Counters accumulate across runs, so start from a clean slate
+	dataDir, err := c.objectDir(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeCoverageData(dataDir); err != nil {
+		return nil, fmt.Errorf("failed to remove old coverage data: %w", err)
+	}
+
+	_, _ = c.runTestSuite(projectPath) // Ignore error, tests might fail but we can still get coverage
+
+	var lines map[string]map[int]int
+	var branches map[string][]Branch
+	if _, err := exec.LookPath("gcovr"); err == nil {
+		lines, branches, err = c.collectGcovr(projectPath, dataDir)
+		if err != nil {
+			return nil, err
+		}
+	} else if _, err := exec.LookPath("lcov"); err == nil {
+		lines, branches, err = c.collectLcov(projectPath, dataDir)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("%w: neither gcovr nor lcov is installed", errdefs.ErrToolMissing)
+	}
+
+	addLineCounts(report, lines, branches, c.opts.BranchCoverage, func(file string) (string, bool) {
+		return c.projectFile(projectPath, dataDir, file)
+	})
+	return report, nil
+}
+
+// projectFile maps a file from coverage data to a path relative to the
+// project, and reports whether it is a project source that should be covered
+func (c *CppAnalyzer) projectFile(projectPath, dataDir, file string) (string, bool) {
+	absProject, err := filepath.Abs(projectPath)
+	if err != nil {
+		return "", false
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(absProject, file)
+	}
+
+	// Generated sources and fetched dependencies live in the build directory
+	if absData, err := filepath.Abs(dataDir); err == nil && absData != absProject {
+		if rel, err := filepath.Rel(absData, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return "", false
+		}
+	}
+
+	relPath, err := filepath.Rel(absProject, file)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return "", false // System and third-party headers
+	}
+
+	first := strings.Split(filepath.ToSlash(relPath), "/")[0]
+	if first == "build" || first == "third_party" || first == "external" || strings.HasPrefix(first, ".") {
+		return "", false
+	}
+	if c.isTestFile(relPath) {
+		return "", false
+	}
+
+	return relPath, true
+}
+
+// collectGcovr reads line and branch counts from a gcovr JSON report
+func (c *CppAnalyzer) collectGcovr(projectPath, dataDir string) (map[string]map[int]int, map[string][]Branch, error) {
+	reportFile, err := coverageArtifact(projectPath, "gcovr.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	os.Remove(reportFile) // Don't parse a stale report if the run fails
+
+	absProject, err := filepath.Abs(projectPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	absData, err := filepath.Abs(dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if output, err := c.command(projectPath, "gcovr", "--root", absProject, "--json", reportFile, absData); err != nil {
+		return nil, nil, fmt.Errorf("gcovr failed: %w\n%s", err, output)
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read gcovr report: %w", err)
+	}
+
+	var gcovr struct {
+		Files []struct {
+			File  string `json:"file"`
+			Lines []struct {
+				LineNumber int  `json:"line_number"`
+				Count      int  `json:"count"`
+				Noncode    bool `json:"gcovr/noncode"`
+				Branches   []struct {
+					Count int `json:"count"`
+				} `json:"branches"`
+			} `json:"lines"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(data, &gcovr); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse gcovr report: %w", err)
+	}
+
+	lines := make(map[string]map[int]int)
+	branches := make(map[string][]Branch)
+	for _, file := range gcovr.Files {
+		counts := make(map[int]int)
+		for _, line := range file.Lines {
+			if line.Noncode {
+				continue
+			}
+			counts[line.LineNumber] += line.Count
+
+			for i, branch := range line.Branches {
+				if branch.Count == 0 {
+					branches[file.File] = append(branches[file.File], Branch{
+						Line:   line.LineNumber,
+						Detail: fmt.Sprintf("branch %d never taken", i),
+					})
+				}
+			}
+		}
+		lines[file.File] = counts
+	}
+
+	return lines, branches, nil
+}
+
+// collectLcov reads line and branch counts from an lcov tracefile
+func (c *CppAnalyzer) collectLcov(projectPath, dataDir string) (map[string]map[int]int, map[string][]Branch, error) {
+	traceFile, err := coverageArtifact(projectPath, "coverage.info")
+	if err != nil {
+		return nil, nil, err
+	}
+	os.Remove(traceFile) // Don't parse a stale report if the run fails
+
+	absData, err := filepath.Abs(dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	output, err := c.command(projectPath, "lcov", "--capture", "--directory", absData,
+		"--output-file", traceFile, "--rc", "lcov_branch_coverage=1")
+	if err != nil {
+		return nil, nil, fmt.Errorf("lcov failed: %w\n%s", err, output)
+	}
+
+	file, err := os.Open(traceFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read lcov tracefile: %w", err)
+	}
+	defer file.Close()
+
+	lines, branches := parseLcov(file)
+	return lines, branches, nil
+}
+
+// parseLcov parses the SF, DA and BRDA records of an lcov tracefile
+func parseLcov(r io.Reader) (map[string]map[int]int, map[string][]Branch) {
+	lines := make(map[string]map[int]int)
+	branches := make(map[string][]Branch)
+
+	var current string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		record := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(record, "SF:"):
+			current = strings.TrimPrefix(record, "SF:")
+			if lines[current] == nil {
+				lines[current] = make(map[int]int)
+			}
+
+		case strings.HasPrefix(record, "DA:") && current != "":
+			// DA:<line>,<count>[,<checksum>]
+			fields := strings.Split(strings.TrimPrefix(record, "DA:"), ",")
+			if len(fields) < 2 {
+				continue
+			}
+			line, err1 := strconv.Atoi(fields[0])
+			count, err2 := strconv.Atoi(fields[1])
+			if err1 == nil && err2 == nil {
+				lines[current][line] += count
+			}
+
+		case strings.HasPrefix(record, "BRDA:") && current != "":
+			// BRDA:<line>,<block>,<branch>,<taken or "-">
+			fields := strings.Split(strings.TrimPrefix(record, "BRDA:"), ",")
+			if len(fields) < 4 || (fields[3] != "0" && fields[3] != "-") {
+				continue
+			}
+			if line, err := strconv.Atoi(fields[0]); err == nil {
+				branches[current] = append(branches[current], Branch{
+					Line:   line,
+					Detail: fmt.Sprintf("branch %s of block %s never taken", fields[2], fields[1]),
+				})
+			}
+
+		case record == "end_of_record":
+			current = ""
+		}
+	}
+
+	return lines, branches
+}
+
+// GetTestFilePath returns the test file path for a C/C++ source file:
+// src/net/socket.cpp -> tests/net/socket_test.cpp if the project has a tests
+// (or test) directory, otherwise socket_test.cpp next to the source. Headers
+// under include/ map the same way. Tests are always C++, so C sources get
+// GoogleTest-style C++ tests too.
+func (c *CppAnalyzer) GetTestFilePath(sourceFile string) string {
+	name := strings.TrimSuffix(filepath.Base(sourceFile), filepath.Ext(sourceFile)) + "_test.cpp"
+
+	for _, testDir := range cppTestDirs {
+		if !fileExists(testDir) {
+			continue
+		}
+		subdir := filepath.Dir(sourceFile)
+		parts := strings.SplitN(filepath.ToSlash(subdir), "/", 2)
+		if parts[0] == "src" || parts[0] == "lib" || parts[0] == "source" || parts[0] == "include" {
+			subdir = ""
+			if len(parts) == 2 {
+				subdir = parts[1]
+			}
+		}
+		return filepath.Join(testDir, subdir, name)
+	}
+
+	return filepath.Join(filepath.Dir(sourceFile), name)
+}
+
+// GetSourceFileForTest returns the source file for a C/C++ test file,
+// checking each source extension, then each header extension for tests of
+// header-only code
+func (c *CppAnalyzer) GetSourceFileForTest(testFile string) string {
+	name := strings.TrimSuffix(filepath.Base(testFile), "_test"+filepath.Ext(testFile))
+	dir := filepath.Dir(testFile)
+
+	candidates := []string{dir}
+	parts := strings.SplitN(filepath.ToSlash(dir), "/", 2)
+	for _, testDir := range cppTestDirs {
+		if parts[0] != testDir {
+			continue
+		}
+		subdir := ""
+		if len(parts) == 2 {
+			subdir = parts[1]
+		}
+		candidates = []string{
+			filepath.Join("src", subdir),
+			filepath.Join("lib", subdir),
+			filepath.Join("source", subdir),
+			filepath.Join("include", subdir),
+			filepath.Clean(subdir),
+		}
+	}
+
+	for _, extensions := range [][]string{cppSourceExtensions, cppHeaderExtensions} {
+		for _, candidate := range candidates {
+			for _, ext := range extensions {
+				if sourceFile := filepath.Join(candidate, name+ext); fileExists(sourceFile) {
+					return sourceFile
+				}
+			}
+		}
+	}
+
+	return filepath.Join(candidates[0], name+".cpp")
+}
+
+// RunTests builds the project and runs its test suite. ctest can't select
+// the tests of a single source file, so the whole suite is run; for CMake
+// builds the test file must be compiled into a test target.
+func (c *CppAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
+	if output, err := c.build(projectPath); err != nil {
+		return false, "Compilation failed: " + output, nil
+	}
+
+	if isCMakeProject(projectPath) && testFile != "" {
+		buildDir, err := c.objectDir(projectPath)
+		if err != nil {
+			return false, "", err
+		}
+		if !hasObjectFile(buildDir, filepath.Base(testFile)) {
+			return false, fmt.Sprintf("%s is not compiled into any target; add it to a test executable in CMakeLists.txt", testFile), nil
+		}
+	}
+
+	output, err := c.runTestSuite(projectPath)
+	return testResult(output, err)
+}
+
+// ValidateTestFile validates that a test file compiles and runs
+func (c *CppAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
+	return c.RunTests(projectPath, testFile)
+}
+
+// TestBuildFile returns the CMakeLists.txt nearest to a test file, or "" if
+// the project isn't built with CMake or the file already builds the test:
+// it names the test file, or collects sources with a CONFIGURE_DEPENDS glob
+func (c *CppAnalyzer) TestBuildFile(projectPath string, testFile string) string {
+	if !isCMakeProject(projectPath) {
+		return ""
+	}
+
+	buildFile := nearestCMakeLists(projectPath, testFile)
+	content, err := os.ReadFile(buildFile)
+	if err != nil {
+		return ""
+	}
+	if strings.Contains(string(content), "CONFIGURE_DEPENDS") || mentionsFile(string(content), filepath.Base(testFile)) {
+		return ""
+	}
+	return buildFile
+}
+
+// RegisterTestFile adds a GoogleTest executable for a test file to the
+// nearest CMakeLists.txt. It links what an existing GoogleTest target of
+// that file links, or else GTest::gtest_main and the source under test,
+// and registers the tests with gtest_discover_tests if the file uses it.
+func (c *CppAnalyzer) RegisterTestFile(projectPath string, testFile string) (bool, error) {
+	buildFile := c.TestBuildFile(projectPath, testFile)
+	if buildFile == "" {
+		return false, nil
+	}
+	content, err := os.ReadFile(buildFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", buildFile, err)
+	}
+
+	buildDir := filepath.Dir(buildFile)
+	relative := func(file string) string {
+		abs, _ := filepath.Abs(file)
+		rel, err := filepath.Rel(buildDir, abs)
+		if err != nil {
+			return filepath.ToSlash(abs)
+		}
+		return filepath.ToSlash(rel)
+	}
+
+	target := strings.TrimSuffix(filepath.Base(testFile), filepath.Ext(testFile))
+	sources := []string{relative(testFile)}
+	libraries := gtestLibraries(string(content))
+	if libraries == "" {
+		libraries = "GTest::gtest_main"
+		if source := c.GetSourceFileForTest(testFile); fileExists(source) && isCppSource(source) {
+			sources = append(sources, relative(source))
+		}
+	}
+
+	var snippet strings.Builder
+	if !bytes.HasSuffix(content, []byte("\n")) && len(content) > 0 {
+		snippet.WriteString("\n")
+	}
+	fmt.Fprintf(&snippet, "\n# %s, registered by test-coverage-agent\n", target)
+	rootContent, _ := os.ReadFile(filepath.Join(projectPath, "CMakeLists.txt"))
+	if !mentionsGTest(string(content)) && !mentionsGTest(string(rootContent)) {
+		snippet.WriteString("find_package(GTest REQUIRED)\n")
+	}
+	fmt.Fprintf(&snippet, "add_executable(%s %s)\n", target, strings.Join(sources, " "))
+	fmt.Fprintf(&snippet, "target_link_libraries(%s %s)\n", target, libraries)
+	if strings.Contains(string(content), "gtest_discover_tests") {
+		fmt.Fprintf(&snippet, "gtest_discover_tests(%s)\n", target)
+	} else {
+		fmt.Fprintf(&snippet, "add_test(NAME %s COMMAND %s)\n", target, target)
+	}
+
+	if err := os.WriteFile(buildFile, append(content, snippet.String()...), 0644); err != nil {
+		return false, fmt.Errorf("failed to register %s in %s: %w", testFile, buildFile, err)
+	}
+	return true, nil
+}
+
+// ToolVersions reports the build, compiler and coverage tool versions
+func (c *CppAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := make(map[string]string)
+	if isCMakeProject(projectPath) {
+		addVersion(versions, "cmake", commandVersion(projectPath, "cmake", "--version"))
+	} else {
+		addVersion(versions, "make", commandVersion(projectPath, "make", "--version"))
+	}
+	addVersion(versions, "c++", commandVersion(projectPath, "c++", "--version"))
+	addVersion(versions, "gcov", commandVersion(projectPath, "gcov", "--version"))
+	addVersion(versions, "gcovr", commandVersion(projectPath, "gcovr", "--version"))
+	addVersion(versions, "lcov", commandVersion(projectPath, "lcov", "--version"))
+	return versions
+}
+
+// build compiles the project and its tests with coverage instrumentation.
+// CMake builds go to a directory in the agent cache; Make builds in place.
+func (c *CppAnalyzer) build(projectPath string) (string, error) {
+	if !isCMakeProject(projectPath) {
+		return c.command(projectPath, "make",
+			"CFLAGS="+cppCoverageFlags, "CXXFLAGS="+cppCoverageFlags, "LDFLAGS=--coverage")
+	}
+
+	buildDir, err := c.objectDir(projectPath)
+	if err != nil {
+		return "", err
+	}
+
+	if !fileExists(filepath.Join(buildDir, "CMakeCache.txt")) {
+		output, err := c.command(projectPath, "cmake", "-S", ".", "-B", buildDir,
+			"-DCMAKE_BUILD_TYPE=Debug",
+			"-DCMAKE_C_FLAGS="+cppCoverageFlags,
+			"-DCMAKE_CXX_FLAGS="+cppCoverageFlags,
+			"-DCMAKE_EXE_LINKER_FLAGS=--coverage",
+			"-DCMAKE_SHARED_LINKER_FLAGS=--coverage",
+			"-DBUILD_TESTING=ON")
+		if err != nil {
+			return output, err
+		}
+	}
+
+	// Re-runs the configure step on its own if CMakeLists.txt changed
+	return c.command(projectPath, "cmake", "--build", buildDir)
+}
+
+// runTestSuite runs ctest, or the Makefile's test (or check) target
+func (c *CppAnalyzer) runTestSuite(projectPath string) (string, error) {
+	if isCMakeProject(projectPath) {
+		buildDir, err := c.objectDir(projectPath)
+		if err != nil {
+			return "", err
+		}
+		return c.command(projectPath, "ctest", "--test-dir", buildDir, "--output-on-failure", "--no-tests=error")
+	}
+
+	output, err := c.command(projectPath, "make", "test")
+	if err != nil && strings.Contains(output, "No rule to make target") {
+		return c.command(projectPath, "make", "check")
+	}
+	return output, err
+}
+
+// objectDir returns the directory holding object files and gcov data: the
+// CMake build directory, or the project itself for Make builds. The build
+// directory is kept with the tool caches, so garbage collection never
+// removes half of it.
+func (c *CppAnalyzer) objectDir(projectPath string) (string, error) {
+	if !isCMakeProject(projectPath) {
+		return projectPath, nil
+	}
+
+	dir, err := cache.New(projectPath).Dir(cache.Toolchain)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(filepath.Join(dir, "cmake-build"))
+}
+
+// command runs a tool in the project and returns its combined output
+func (c *CppAnalyzer) command(projectPath, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = projectPath
+	cmd.Env = c.opts.environ(projectPath)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := c.opts.run(cmd)
+	return output.String(), err
+}
+
+// isTestFile reports whether a project-relative path is a test source
+func (c *CppAnalyzer) isTestFile(relPath string) bool {
+	name := strings.TrimSuffix(filepath.Base(relPath), filepath.Ext(relPath))
+	if strings.HasSuffix(name, "_test") || strings.HasSuffix(name, "_tests") || strings.HasPrefix(name, "test_") {
+		return true
+	}
+
+	first := strings.Split(filepath.ToSlash(relPath), "/")[0]
+	for _, testDir := range cppTestDirs {
+		if first == testDir {
+			return true
+		}
+	}
+	return false
+}
+
+// nearestCMakeLists returns the CMakeLists.txt in the test file's directory
+// or the closest directory above it, up to the project's own
+func nearestCMakeLists(projectPath, testFile string) string {
+	root, _ := filepath.Abs(projectPath)
+	dir, _ := filepath.Abs(filepath.Dir(testFile))
+	for strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if buildFile := filepath.Join(dir, "CMakeLists.txt"); fileExists(buildFile) {
+			return buildFile
+		}
+		dir = filepath.Dir(dir)
+	}
+	return filepath.Join(root, "CMakeLists.txt")
+}
+
+// cmakeLinkPattern matches target_link_libraries calls, capturing the
+// target and its libraries
+var cmakeLinkPattern = regexp.MustCompile(`(?s)target_link_libraries\s*\(\s*(\S+)\s+([^)]*)\)`)
+
+// gtestLibraries returns the libraries of the first target in a CMake file
+// that links GoogleTest, or "" if none does
+func gtestLibraries(content string) string {
+	for _, match := range cmakeLinkPattern.FindAllStringSubmatch(content, -1) {
+		if mentionsGTest(match[2]) {
+			return strings.Join(strings.Fields(match[2]), " ")
+		}
+	}
+	return ""
+}
+
+// mentionsGTest reports whether CMake code refers to GoogleTest
+func mentionsGTest(content string) bool {
+	return strings.Contains(strings.ToLower(content), "gtest")
+}
+
+// mentionsFile reports whether CMake code names a file, by itself or at the
+// end of a path
+func mentionsFile(content, name string) bool {
+	pattern := regexp.MustCompile(`(^|[\s/("])` + regexp.QuoteMeta(name) + `($|[\s)"])`)
+	return pattern.MatchString(content)
+}
+
+// isCppSource reports whether a file is a C/C++ source rather than a header
+func isCppSource(file string) bool {
+	for _, ext := range cppSourceExtensions {
+		if filepath.Ext(file) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// isCMakeProject checks for a top-level CMakeLists.txt
+func isCMakeProject(projectPath string) bool {
+	return fileExists(filepath.Join(projectPath, "CMakeLists.txt"))
+}
+
+// makefile returns the name of the project's Makefile, or "" if there is none
+func makefile(projectPath string) string {
+	for _, name := range []string{"GNUmakefile", "makefile", "Makefile"} {
+		if fileExists(filepath.Join(projectPath, name)) {
+			return name
+		}
+	}
+	return ""
+}
+
+// removeCoverageData deletes the gcov counter files (.gcda) under dir
+func removeCoverageData(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".gcda") {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// hasObjectFile reports whether CMake compiled a source file with the given
+// base name, i.e. whether <name>.o (or .obj) exists in the build directory
+func hasObjectFile(buildDir, sourceName string) bool {
+	found := false
+	filepath.WalkDir(buildDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() && (d.Name() == sourceName+".o" || d.Name() == sourceName+".obj") {
+			found = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return found
+}
@@ -0,0 +1,166 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DartAnalyzer implements coverage analysis for Dart and Flutter packages
+type DartAnalyzer struct {
+	opts Options
+}
+
+// flutterSDKPattern matches the Flutter SDK dependency in pubspec.yaml
+var flutterSDKPattern = regexp.MustCompile(`(?m)^\s*sdk:\s*flutter\s*$`)
+
+// DetectLanguage checks if this is a Dart package
+func (d *DartAnalyzer) DetectLanguage(projectPath string) bool {
+	return fileExists(filepath.Join(projectPath, "pubspec.yaml"))
+}
+
+// GetLanguageName returns "Dart"
+func (d *DartAnalyzer) GetLanguageName() string {
+	return "Dart"
+}
+
+// RunCoverage executes the test suite with coverage. Flutter writes the lcov
+// tracefile itself; plain Dart packages collect raw coverage with dart test
+// and convert it with the coverage package's format_coverage.
+func (d *DartAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
+	report := &CoverageReport{
+		FileCoverage:   make(map[string]float64),
+		UncoveredFiles: []string{},
+		UncoveredLines: make(map[string][]int),
+		Language:       "Dart",
+	}
+
+	coverageDir := filepath.Join(projectPath, "coverage")
+	lcovFile := filepath.Join(coverageDir, "lcov.info")
+	os.RemoveAll(coverageDir) // Don't parse stale results if the run fails
+
+	var output string
+	if d.isFlutter(projectPath) {
+		args := []string{"test", "--coverage"}
+		if d.opts.BranchCoverage {
+			args = append(args, "--branch-coverage")
+		}
+		output, _ = d.command(projectPath, "flutter", args...) // Ignore error, tests might fail
+	} else {
+		output, _ = d.command(projectPath, "dart", "test", "--coverage=coverage") // Ignore error, tests might fail
+
+		formatOutput, err := d.command(projectPath, "dart", "run", "coverage:format_coverage",
+			"--lcov",
+			"--in=coverage",
+			"--out="+filepath.Join("coverage", "lcov.info"),
+			"--report-on=lib",
+			"--packages="+filepath.Join(".dart_tool", "package_config.json"),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to format coverage, is the coverage package a dev dependency?\n%s", output+formatOutput)
+		}
+	}
+
+	file, err := os.Open(lcovFile)
+	if err != nil {
+		return nil, fmt.Errorf("no coverage data generated\n%s", output)
+	}
+	defer file.Close()
+
+	lines, branches := parseLcov(file)
+	addLineCounts(report, lines, branches, d.opts.BranchCoverage, func(file string) (string, bool) {
+		relPath := relativeToProject(projectPath, file)
+		if strings.HasSuffix(relPath, ".g.dart") { // Skip generated code
+			report.addExclusion(relPath, ExcludedGenerated, 0, false)
+			return relPath, false
+		}
+		return relPath, true
+	})
+
+	return report, nil
+}
+
+// isFlutter checks whether the package depends on the Flutter SDK
+func (d *DartAnalyzer) isFlutter(projectPath string) bool {
+	data, err := os.ReadFile(filepath.Join(projectPath, "pubspec.yaml"))
+	return err == nil && flutterSDKPattern.Match(data)
+}
+
+// GetTestFilePath returns the test file path for a Dart source file
+func (d *DartAnalyzer) GetTestFilePath(sourceFile string) string {
+	// Convention: lib/src/foo.dart -> test/src/foo_test.dart
+	name := strings.TrimSuffix(filepath.Base(sourceFile), ".dart") + "_test.dart"
+	dir := filepath.Dir(sourceFile)
+
+	parts := strings.SplitN(filepath.ToSlash(dir), "/", 2)
+	if parts[0] == "lib" {
+		dir = "test"
+		if len(parts) == 2 {
+			dir = filepath.Join("test", parts[1])
+		}
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// GetSourceFileForTest returns the source file for a Dart test file
+func (d *DartAnalyzer) GetSourceFileForTest(testFile string) string {
+	name := strings.TrimSuffix(filepath.Base(testFile), "_test.dart") + ".dart"
+	dir := filepath.Dir(testFile)
+
+	parts := strings.SplitN(filepath.ToSlash(dir), "/", 2)
+	if parts[0] == "test" {
+		dir = "lib"
+		if len(parts) == 2 {
+			dir = filepath.Join("lib", parts[1])
+		}
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// RunTests runs tests for a specific test file
+func (d *DartAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
+	tool := "dart"
+	if d.isFlutter(projectPath) {
+		tool = "flutter"
+	}
+
+	output, err := d.command(projectPath, tool, "test", testFile)
+	return testResult(output, err)
+}
+
+// ValidateTestFile validates that a test file compiles and runs
+func (d *DartAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
+	// The test runner compiles the test file before running it
+	return d.RunTests(projectPath, testFile)
+}
+
+// ToolVersions reports the Dart SDK version, and the Flutter version for
+// Flutter packages
+func (d *DartAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := make(map[string]string)
+	addVersion(versions, "dart", commandVersion(projectPath, "dart", "--version"))
+	if d.isFlutter(projectPath) {
+		addVersion(versions, "flutter", commandVersion(projectPath, "flutter", "--version"))
+	}
+	return versions
+}
+
+// command runs a Dart or Flutter tool and returns its combined output
+func (d *DartAnalyzer) command(projectPath, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = projectPath
+	cmd.Env = d.opts.environ(projectPath)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := d.opts.run(cmd)
+	return output.String(), err
+}
@@ -0,0 +1,141 @@
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
+)
+
+// ExcludedDeadCode is the exclusion reason for files no code uses
+const ExcludedDeadCode = "dead code, consider deleting it instead of testing it"
+
+// DeadSymbol is a declaration a dead-code tool reported as unused
+type DeadSymbol struct {
+	Line   int    `json:"line"`
+	Symbol string `json:"symbol"` // The tool's description, e.g. "func parseLegacy is unused"
+}
+
+// DeadCode maps project-relative, slash-separated file paths to the unused
+// symbols they declare
+type DeadCode map[string][]DeadSymbol
+
+// vulturePattern matches vulture findings: "pkg/mod.py:12: unused function 'foo' (60% confidence)"
+var vulturePattern = regexp.MustCompile(`^(.+?):(\d+): (unused (?:function|class|method|property) .+?) \(\d+% confidence\)`)
+
+// tsPrunePattern matches ts-prune findings: "src/util.ts:12 - formatDate"
+var tsPrunePattern = regexp.MustCompile(`^(.+?):(\d+) - (\S+)(.*)$`)
+
+// FindDeadCode runs the dead-code tool for the analyzer's language:
+// staticcheck's U1000 check for Go, vulture for Python and ts-prune for
+// TypeScript. It returns nil for other languages, and an ErrToolMissing
+// error if the tool isn't installed.
+func FindDeadCode(projectPath string, analyzer Analyzer, opts Options) (DeadCode, error) {
+	var args []string
+	switch analyzer.GetLanguageName() {
+	case "Go":
+		args = []string{"staticcheck", "-checks", "U1000", "-f", "json", "./..."}
+	case "Python":
+		args = []string{"vulture", ".", "--exclude", ".venv,venv,.tox,.nox,node_modules"}
+	case "TypeScript":
+		args = detectToolchain(projectPath).tool("ts-prune")
+	default:
+		return nil, nil
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = projectPath
+	cmd.Env = opts.environ(projectPath)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	// The tools exit with an error when they find dead code
+	if err := opts.run(cmd); errors.Is(err, exec.ErrNotFound) {
+		return nil, errdefs.ToolMissing(err)
+	}
+
+	dead := make(DeadCode)
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		file, symbol, ok := parseDeadCodeLine(analyzer.GetLanguageName(), line)
+		if !ok {
+			continue
+		}
+		key := deadCodeKey(projectPath, file)
+		dead[key] = append(dead[key], symbol)
+	}
+	return dead, nil
+}
+
+// parseDeadCodeLine parses one line of a dead-code tool's output
+func parseDeadCodeLine(language, line string) (string, DeadSymbol, bool) {
+	switch language {
+	case "Go":
+		var finding struct {
+			Code     string `json:"code"`
+			Message  string `json:"message"`
+			Location struct {
+				File string `json:"file"`
+				Line int    `json:"line"`
+			} `json:"location"`
+		}
+		if err := json.Unmarshal([]byte(line), &finding); err != nil || finding.Code != "U1000" {
+			return "", DeadSymbol{}, false
+		}
+		return finding.Location.File, DeadSymbol{Line: finding.Location.Line, Symbol: finding.Message}, true
+
+	case "Python":
+		if m := vulturePattern.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			return m[1], DeadSymbol{Line: n, Symbol: m[3]}, true
+		}
+
+	case "TypeScript":
+		// Exports only used inside their own module aren't dead
+		if m := tsPrunePattern.FindStringSubmatch(line); m != nil && !strings.Contains(m[4], "used in module") {
+			n, _ := strconv.Atoi(m[2])
+			return m[1], DeadSymbol{Line: n, Symbol: "unused export " + m[3]}, true
+		}
+	}
+	return "", DeadSymbol{}, false
+}
+
+// Symbols returns the unused symbols of a report file
+func (d DeadCode) Symbols(projectPath, file string) []DeadSymbol {
+	return d[deadCodeKey(projectPath, file)]
+}
+
+// deadCodeKey returns the DeadCode key of a file path
+func deadCodeKey(projectPath, file string) string {
+	return filepath.ToSlash(filepath.Clean(relativeToProject(projectPath, file)))
+}
+
+// ApplyDeadCode removes effectively dead files from a report's uncovered
+// files and records them in its exclusions: files no test executes at all
+// that declare symbols the dead-code tool found unused. Like excluded
+// paths, they still count in total coverage.
+func ApplyDeadCode(projectPath string, report *CoverageReport, dead DeadCode) {
+	if len(dead) == 0 {
+		return
+	}
+
+	files := report.UncoveredFiles[:0]
+	for _, file := range report.UncoveredFiles {
+		if report.FileCoverage[file] == 0 && len(dead.Symbols(projectPath, file)) > 0 {
+			report.addExclusion(file, ExcludedDeadCode, 0, true)
+			delete(report.UncoveredLines, file)
+			delete(report.UncoveredBranches, file)
+			continue
+		}
+		files = append(files, file)
+	}
+	report.UncoveredFiles = files
+}
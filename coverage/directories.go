@@ -0,0 +1,124 @@
+package coverage
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// DirectoryCoverage is the coverage of a directory and everything below it
+type DirectoryCoverage struct {
+	Path      string  // Slash-separated, "." for the root of relative paths
+	Coverage  float64 // Percentage
+	Lines     int     // Instrumented lines, where the tool reported or implied them
+	Uncovered int     // Uncovered lines
+	Files     int     // Files in the directory and its subdirectories
+
+	// Children are the subdirectories, by path. Chains of directories
+	// holding nothing but one subdirectory are collapsed into it.
+	Children []*DirectoryCoverage
+
+	own     int     // Files directly in the directory
+	covered int     // Covered lines of files with known line counts
+	sum     float64 // Sum of the files' coverage
+	unknown int     // Files with unknown line counts
+}
+
+// ByDirectory rolls file coverage up into a directory tree. A directory's
+// coverage is weighted by line counts where every file below it has one,
+// and the mean of its files' coverage otherwise.
+func (r *CoverageReport) ByDirectory() *DirectoryCoverage {
+	root := &DirectoryCoverage{Path: "."}
+	nodes := map[string]*DirectoryCoverage{}
+
+	var dirOf func(dir string) *DirectoryCoverage
+	dirOf = func(dir string) *DirectoryCoverage {
+		if dir == "." || dir == "" {
+			return root
+		}
+		if node, ok := nodes[dir]; ok {
+			return node
+		}
+		node := &DirectoryCoverage{Path: dir}
+		nodes[dir] = node
+		parent := path.Dir(dir)
+		if parent == dir {
+			// The root of absolute paths
+			parent = "."
+		}
+		p := dirOf(parent)
+		p.Children = append(p.Children, node)
+		return node
+	}
+
+	for file, pct := range r.FileCoverage {
+		dir := dirOf(path.Dir(filepath.ToSlash(file)))
+		dir.own++
+		dir.Files++
+		dir.sum += pct
+		dir.Uncovered += len(r.UncoveredLines[file])
+		if lines := r.lineTotal(file); lines > 0 {
+			dir.Lines += lines
+			dir.covered += lines - len(r.UncoveredLines[file])
+		} else if pct < 100 {
+			dir.unknown++
+		}
+	}
+
+	root.rollUp()
+	return root
+}
+
+// rollUp adds the children's totals to a directory, collapses chains of
+// single subdirectories and computes coverage
+func (d *DirectoryCoverage) rollUp() {
+	for i, child := range d.Children {
+		child.rollUp()
+		for child.own == 0 && len(child.Children) == 1 {
+			child = child.Children[0]
+		}
+		d.Children[i] = child
+
+		d.Files += child.Files
+		d.Lines += child.Lines
+		d.Uncovered += child.Uncovered
+		d.covered += child.covered
+		d.sum += child.sum
+		d.unknown += child.unknown
+	}
+	sort.Slice(d.Children, func(i, j int) bool {
+		return d.Children[i].Path < d.Children[j].Path
+	})
+
+	switch {
+	case d.Files == 0:
+		d.Coverage = 0
+	case d.unknown == 0 && d.Lines > 0:
+		d.Coverage = float64(d.covered) / float64(d.Lines) * 100
+	default:
+		d.Coverage = d.sum / float64(d.Files)
+	}
+}
+
+// Walk calls fn for a directory and every directory below it, parents
+// before their children. The depth of the directory itself is 0.
+func (d *DirectoryCoverage) Walk(fn func(dir *DirectoryCoverage, depth int)) {
+	d.walk(fn, 0)
+}
+
+func (d *DirectoryCoverage) walk(fn func(dir *DirectoryCoverage, depth int), depth int) {
+	fn(d, depth)
+	for _, child := range d.Children {
+		child.walk(fn, depth+1)
+	}
+}
+
+// Subsystems returns the top-level directories of a tree: the children of
+// the first directory that has files of its own or more than one
+// subdirectory, so a project under src/ is broken down below src/
+func (d *DirectoryCoverage) Subsystems() []*DirectoryCoverage {
+	for d.own == 0 && len(d.Children) == 1 {
+		d = d.Children[0]
+	}
+	return d.Children
+}
@@ -0,0 +1,268 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ElixirAnalyzer implements coverage analysis for Mix projects, using
+// ExCoveralls when the project depends on it and mix test --cover otherwise
+type ElixirAnalyzer struct {
+	opts Options
+}
+
+// coverSummaryPattern matches a module row of the mix test --cover summary,
+// e.g. "    66.67% | MyApp.Accounts"
+var coverSummaryPattern = regexp.MustCompile(`(?m)^\s*(\d+(?:\.\d+)?)%\s*\|\s*(\S+)\s*$`)
+
+// DetectLanguage checks if this is a Mix project
+func (e *ElixirAnalyzer) DetectLanguage(projectPath string) bool {
+	return fileExists(filepath.Join(projectPath, "mix.exs"))
+}
+
+// GetLanguageName returns "Elixir"
+func (e *ElixirAnalyzer) GetLanguageName() string {
+	return "Elixir"
+}
+
+// RunCoverage executes the test suite with coverage
+func (e *ElixirAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
+	report := &CoverageReport{
+		FileCoverage:   make(map[string]float64),
+		UncoveredFiles: []string{},
+		UncoveredLines: make(map[string][]int),
+		Language:       "Elixir",
+	}
+
+	if !e.usesExCoveralls(projectPath) {
+		return e.runBuiltinCover(projectPath, report)
+	}
+
+	coverageFile := filepath.Join(projectPath, "cover", "excoveralls.json")
+	os.Remove(coverageFile) // Don't parse a stale report if the run fails
+
+	output, _ := e.mix(projectPath, "coveralls.json") // Ignore error, tests might fail
+
+	if !fileExists(coverageFile) {
+		return nil, fmt.Errorf("mix coveralls.json did not write %s\n%s", coverageFile, output)
+	}
+	if err := e.parseCoverallsJSON(coverageFile, report); err != nil {
+		return nil, fmt.Errorf("failed to parse coverage: %w", err)
+	}
+
+	return report, nil
+}
+
+// usesExCoveralls checks whether mix.exs declares the excoveralls dependency
+func (e *ElixirAnalyzer) usesExCoveralls(projectPath string) bool {
+	data, err := os.ReadFile(filepath.Join(projectPath, "mix.exs"))
+	return err == nil && bytes.Contains(data, []byte(":excoveralls"))
+}
+
+// parseCoverallsJSON parses the ExCoveralls JSON report, where each source
+// file has one entry per line: null for non-code lines, else the hit count
+func (e *ElixirAnalyzer) parseCoverallsJSON(filename string, report *CoverageReport) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var coveralls struct {
+		SourceFiles []struct {
+			Name     string `json:"name"`
+			Coverage []*int `json:"coverage"`
+		} `json:"source_files"`
+	}
+
+	if err := json.Unmarshal(data, &coveralls); err != nil {
+		return err
+	}
+
+	lines := make(map[string]map[int]int)
+	for _, sourceFile := range coveralls.SourceFiles {
+		counts := make(map[int]int)
+		for i, hits := range sourceFile.Coverage {
+			if hits != nil {
+				counts[i+1] = *hits
+			}
+		}
+		lines[sourceFile.Name] = counts
+	}
+
+	addLineCounts(report, lines, nil, false, func(file string) (string, bool) {
+		return file, true
+	})
+	return nil
+}
+
+// runBuiltinCover runs mix test --cover and reads its per-module summary.
+// The summary has no line details, so modules are mapped to files by the
+// lib/ naming convention and reported without uncovered lines.
+func (e *ElixirAnalyzer) runBuiltinCover(projectPath string, report *CoverageReport) (*CoverageReport, error) {
+	output, _ := e.mix(projectPath, "test", "--cover") // Ignore error, tests might fail
+
+	matches := coverSummaryPattern.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no coverage summary in mix test --cover output\n%s", output)
+	}
+
+	for _, match := range matches {
+		percentage, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+		if match[2] == "Total" {
+			report.TotalCoverage = percentage
+			continue
+		}
+
+		sourceFile := moduleSourceFile(projectPath, match[2])
+		if sourceFile == "" {
+			continue
+		}
+		report.FileCoverage[sourceFile] = percentage
+		if percentage < 100 {
+			report.UncoveredFiles = append(report.UncoveredFiles, sourceFile)
+		}
+	}
+
+	sort.Strings(report.UncoveredFiles)
+	return report, nil
+}
+
+// moduleSourceFile maps a module name to its conventional source file,
+// e.g. MyApp.UserAuth -> lib/my_app/user_auth.ex, or "" if there is none
+func moduleSourceFile(projectPath, module string) string {
+	var parts []string
+	for _, part := range strings.Split(strings.TrimPrefix(module, "Elixir."), ".") {
+		parts = append(parts, underscore(part))
+	}
+
+	sourceFile := filepath.Join(append([]string{"lib"}, parts...)...) + ".ex"
+	if !fileExists(filepath.Join(projectPath, sourceFile)) {
+		return ""
+	}
+	return sourceFile
+}
+
+// underscore converts a CamelCase alias to snake_case like Macro.underscore
+func underscore(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// GetTestFilePath returns the test file path for an Elixir source file
+func (e *ElixirAnalyzer) GetTestFilePath(sourceFile string) string {
+	// ExUnit convention: lib/my_app/foo.ex -> test/my_app/foo_test.exs
+	name := strings.TrimSuffix(filepath.Base(sourceFile), filepath.Ext(sourceFile)) + "_test.exs"
+	dir := filepath.Dir(sourceFile)
+
+	parts := strings.SplitN(filepath.ToSlash(dir), "/", 2)
+	if parts[0] == "lib" {
+		dir = "test"
+		if len(parts) == 2 {
+			dir = filepath.Join("test", parts[1])
+		}
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// GetSourceFileForTest returns the source file for an Elixir test file
+func (e *ElixirAnalyzer) GetSourceFileForTest(testFile string) string {
+	name := strings.TrimSuffix(filepath.Base(testFile), "_test.exs") + ".ex"
+	dir := filepath.Dir(testFile)
+
+	parts := strings.SplitN(filepath.ToSlash(dir), "/", 2)
+	if parts[0] == "test" {
+		dir = "lib"
+		if len(parts) == 2 {
+			dir = filepath.Join("lib", parts[1])
+		}
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// RunTests runs tests for a specific test file
+func (e *ElixirAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
+	output, err := e.mix(projectPath, "test", testFile)
+	return testResult(output, err)
+}
+
+// ValidateTestFile validates that a test file compiles and runs
+func (e *ElixirAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
+	// mix test compiles the project and the test file before running it
+	return e.RunTests(projectPath, testFile)
+}
+
+// ToolVersions reports the Elixir, Erlang/OTP and ExCoveralls versions
+func (e *ElixirAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := make(map[string]string)
+
+	// elixir --version prints the Erlang/OTP line first, then the Elixir line
+	cmd := exec.Command("elixir", "--version")
+	cmd.Dir = projectPath
+	if output, err := cmd.CombinedOutput(); err == nil {
+		for _, line := range strings.Split(string(output), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "Erlang/OTP") {
+				versions["erlang"] = line
+			} else if strings.HasPrefix(line, "Elixir") {
+				versions["elixir"] = line
+			}
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(projectPath, "mix.lock")); err == nil {
+		lockPattern := regexp.MustCompile(`"excoveralls":\s*\{:hex,\s*:excoveralls,\s*"([^"]+)"`)
+		if m := lockPattern.FindSubmatch(data); m != nil {
+			versions["excoveralls"] = string(m[1])
+		}
+	}
+
+	return versions
+}
+
+// mix runs a mix task in the test environment and returns its combined output
+func (e *ElixirAnalyzer) mix(projectPath string, args ...string) (string, error) {
+	cmd := exec.Command("mix", args...)
+	cmd.Dir = projectPath
+
+	// ExCoveralls tasks only run in the test environment unless the project
+	// sets preferred_cli_env; configured variables still take precedence
+	env := e.opts.environ(projectPath)
+	if env == nil {
+		env = os.Environ()
+	}
+	if _, ok := e.opts.Env["MIX_ENV"]; !ok {
+		env = append(env, "MIX_ENV=test")
+	}
+	cmd.Env = env
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := e.opts.run(cmd)
+	return output.String(), err
+}
@@ -0,0 +1,112 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Export formats understood by Export
+const (
+	ExportLCOV = "lcov" // lcov tracefile, for genhtml, editors and Codecov
+)
+
+// ParseExport splits an export spec of the form "format:path", e.g.
+// "lcov:coverage/lcov.info", and checks that the format is supported
+func ParseExport(spec string) (string, string, error) {
+	format, path, ok := strings.Cut(spec, ":")
+	if !ok || path == "" {
+		return "", "", fmt.Errorf("invalid coverage export %q, expected format:path (e.g. lcov:coverage.lcov)", spec)
+	}
+	switch format {
+	case ExportLCOV:
+		return format, path, nil
+	}
+	return "", "", fmt.Errorf("unsupported coverage export format %q (want %s)", format, ExportLCOV)
+}
+
+// Export writes a report in the format given by a "format:path" spec.
+// Relative paths are resolved against the project.
+func Export(projectPath string, report *CoverageReport, spec string) error {
+	format, path, err := ParseExport(spec)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(projectPath, path)
+	}
+
+	switch format {
+	case ExportLCOV:
+		return WriteLCOV(report, path)
+	}
+	return nil
+}
+
+// WriteLCOV writes a report as an lcov tracefile, whatever tool produced it.
+// Uncovered lines get a hit count of 0 and executed lines a count of 1,
+// since not every tool reports counts. Files whose executed lines are
+// unknown only get line totals, computed from the coverage percentage if
+// the tool didn't report them.
+func WriteLCOV(report *CoverageReport, path string) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create coverage export directory: %w", err)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create lcov file: %w", err)
+	}
+	defer f.Close()
+
+	files := make([]string, 0, len(report.FileCoverage))
+	for file := range report.FileCoverage {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	w := bufio.NewWriter(f)
+	for _, file := range files {
+		hits := make(map[int]int)
+		for _, line := range report.CoveredLines[file] {
+			hits[line] = 1
+		}
+		for _, line := range report.UncoveredLines[file] {
+			hits[line] = 0 // A line with unexecuted code isn't covered
+		}
+
+		lines := make([]int, 0, len(hits))
+		for line := range hits {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+
+		fmt.Fprintf(w, "TN:\nSF:%s\n", filepath.ToSlash(file))
+		found, hit := 0, 0
+		for _, line := range lines {
+			fmt.Fprintf(w, "DA:%d,%d\n", line, hits[line])
+			found++
+			if hits[line] > 0 {
+				hit++
+			}
+		}
+
+		// Without executed lines, only the totals are known
+		if _, ok := report.CoveredLines[file]; !ok {
+			if total := report.lineTotal(file); total > 0 {
+				found, hit = total, total-len(report.UncoveredLines[file])
+			}
+		}
+		fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", found, hit)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write lcov file: %w", err)
+	}
+	return nil
+}
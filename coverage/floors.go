@@ -0,0 +1,184 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// PackageCoverage is the coverage of the files directly in a directory
+type PackageCoverage struct {
+	Path      string  // Project-relative and slash-separated, "." for the root
+	Coverage  float64 // Percentage
+	Files     int
+	Uncovered int // Uncovered lines
+
+	lines   int     // Instrumented lines of files with known line counts
+	sum     float64 // Sum of the files' coverage
+	unknown int     // Files with unknown line counts
+}
+
+// ByPackage rolls file coverage up into the directories holding the files,
+// sorted by path. Like ByDirectory, coverage is weighted by line counts
+// where every file has one, and the mean of the files' coverage otherwise.
+func (r *CoverageReport) ByPackage(projectPath string) []PackageCoverage {
+	byPath := make(map[string]*PackageCoverage)
+	for file, pct := range r.FileCoverage {
+		dir := path.Dir(filepath.ToSlash(relativeToProject(projectPath, file)))
+		pkg := byPath[dir]
+		if pkg == nil {
+			pkg = &PackageCoverage{Path: dir}
+			byPath[dir] = pkg
+		}
+		pkg.Files++
+		pkg.sum += pct
+		pkg.Uncovered += len(r.UncoveredLines[file])
+		if lines := r.lineTotal(file); lines > 0 {
+			pkg.lines += lines
+		} else if pct < 100 {
+			pkg.unknown++
+		}
+	}
+
+	packages := make([]PackageCoverage, 0, len(byPath))
+	for _, pkg := range byPath {
+		if pkg.unknown == 0 && pkg.lines > 0 {
+			pkg.Coverage = float64(pkg.lines-pkg.Uncovered) / float64(pkg.lines) * 100
+		} else {
+			pkg.Coverage = pkg.sum / float64(pkg.Files)
+		}
+		packages = append(packages, *pkg)
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Path < packages[j].Path
+	})
+	return packages
+}
+
+// Baseline is a recorded coverage measurement that later measurements must
+// not fall below, so coverage only ratchets up
+type Baseline struct {
+	TotalCoverage float64            `json:"total_coverage"`
+	Packages      map[string]float64 `json:"packages"` // Coverage by package path
+}
+
+// NewBaseline records a report's total and package coverage
+func NewBaseline(projectPath string, report *CoverageReport) *Baseline {
+	b := &Baseline{TotalCoverage: report.TotalCoverage, Packages: make(map[string]float64)}
+	for _, pkg := range report.ByPackage(projectPath) {
+		b.Packages[pkg.Path] = pkg.Coverage
+	}
+	return b
+}
+
+// LoadBaseline reads a baseline file
+func LoadBaseline(filename string) (*Baseline, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read coverage baseline: %w", err)
+	}
+
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse coverage baseline %s: %w", filename, err)
+	}
+	return &b, nil
+}
+
+// Save writes the baseline to a file
+func (b *Baseline) Save(filename string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal coverage baseline: %w", err)
+	}
+
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write coverage baseline: %w", err)
+	}
+	return nil
+}
+
+// Floors are the coverage minimums a report is checked against
+type Floors struct {
+	// Total is the minimum total coverage (0 = none)
+	Total float64
+
+	// Packages maps project-relative path patterns of package directories
+	// to their minimum coverage, e.g. "internal/crypto/**": 90; the longest
+	// matching pattern applies
+	Packages map[string]float64
+
+	// Baseline, if set, is a measurement no coverage may drop below by more
+	// than Tolerance points. Packages missing from it aren't checked.
+	Baseline  *Baseline
+	Tolerance float64
+}
+
+// Violation is a coverage floor a report falls below
+type Violation struct {
+	Package  string  // Package path, or "" for the total
+	Coverage float64 // Measured coverage
+	Floor    float64 // The minimum it falls below
+	Baseline bool    // Whether the floor is the baseline rather than a target
+}
+
+// String describes the violation
+func (v Violation) String() string {
+	name := "total coverage"
+	if v.Package != "" {
+		name = v.Package
+	}
+	if v.Baseline {
+		return fmt.Sprintf("%s: %.2f%% dropped below its baseline of %.2f%%", name, v.Coverage, v.Floor)
+	}
+	return fmt.Sprintf("%s: %.2f%% is below its target of %.2f%%", name, v.Coverage, v.Floor)
+}
+
+// Check returns the floors a report falls below, the total first and then
+// by package path
+func (f Floors) Check(projectPath string, report *CoverageReport) []Violation {
+	var violations []Violation
+	check := func(pkg string, coverage float64) {
+		if target, ok := f.target(pkg); ok && coverage < target {
+			violations = append(violations, Violation{Package: pkg, Coverage: coverage, Floor: target})
+		}
+		if f.Baseline == nil {
+			return
+		}
+		baseline, ok := f.Baseline.TotalCoverage, pkg == ""
+		if pkg != "" {
+			baseline, ok = f.Baseline.Packages[pkg]
+		}
+		if ok && coverage < baseline-f.Tolerance {
+			violations = append(violations, Violation{Package: pkg, Coverage: coverage, Floor: baseline, Baseline: true})
+		}
+	}
+
+	check("", report.TotalCoverage)
+	for _, pkg := range report.ByPackage(projectPath) {
+		check(pkg.Path, pkg.Coverage)
+	}
+	return violations
+}
+
+// target returns the coverage target of a package path, or of the total
+// for "", and whether there is one
+func (f Floors) target(pkg string) (float64, bool) {
+	if pkg == "" {
+		return f.Total, f.Total > 0
+	}
+
+	best, found := "", false
+	for pattern := range f.Packages {
+		if !MatchGlob(pattern, pkg) {
+			continue
+		}
+		if !found || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			best, found = pattern, true
+		}
+	}
+	return f.Packages[best], found
+}
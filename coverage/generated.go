@@ -0,0 +1,116 @@
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// generatedHeadSize is how much of a file is searched for a
+	// generated-code marker; tools write them in the file header
+	generatedHeadSize = 16 * 1024
+
+	// minifiedSampleSize is how much of a JavaScript file is sampled for
+	// minification
+	minifiedSampleSize = 64 * 1024
+
+	// minifiedLineLength is the average line length above which a
+	// JavaScript file counts as minified
+	minifiedLineLength = 250
+)
+
+// minifiableExtensions are the extensions of files checked for minification
+var minifiableExtensions = map[string]bool{".js": true, ".mjs": true, ".cjs": true}
+
+// ExcludeGenerated removes machine-generated source files from a report's
+// uncovered files and records them in its exclusions, so they never become
+// work items. With fromTotal they are dropped from the report altogether
+// and the total is recomputed without them, like DropTestFiles; otherwise
+// they still count in total coverage.
+func ExcludeGenerated(projectPath string, report *CoverageReport, fromTotal bool) {
+	generated := func(file string) bool {
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projectPath, file)
+		}
+		return IsGenerated(path)
+	}
+
+	if fromTotal {
+		for _, file := range report.dropFiles(generated) {
+			report.addExclusion(file, ExcludedGenerated, 0, false)
+		}
+		return
+	}
+
+	files := report.UncoveredFiles[:0]
+	for _, file := range report.UncoveredFiles {
+		if generated(file) {
+			report.addExclusion(file, ExcludedGenerated, 0, true)
+			delete(report.UncoveredLines, file)
+			delete(report.UncoveredBranches, file)
+			continue
+		}
+		files = append(files, file)
+	}
+	report.UncoveredFiles = files
+}
+
+// IsGenerated reports whether a file was written by a tool: its header has
+// a comment marking it generated ("Code generated by ... DO NOT EDIT.",
+// "@generated", "<auto-generated>"), or it is minified JavaScript.
+// Unreadable files are not generated.
+func IsGenerated(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	sample, err := io.ReadAll(io.LimitReader(f, minifiedSampleSize))
+	if err != nil {
+		return false
+	}
+
+	head := sample
+	if len(head) > generatedHeadSize {
+		head = head[:generatedHeadSize]
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(head))
+	scanner.Buffer(make([]byte, 0, 64*1024), generatedHeadSize)
+	for scanner.Scan() {
+		if isGeneratedMarker(scanner.Text()) {
+			return true
+		}
+	}
+
+	return minifiableExtensions[filepath.Ext(path)] && isMinified(filepath.Base(path), sample)
+}
+
+// isGeneratedMarker reports whether a line is a comment marking its file
+// as generated
+func isGeneratedMarker(line string) bool {
+	if !isCommentOnly(line) && !strings.HasPrefix(strings.TrimSpace(line), "<!--") {
+		return false
+	}
+	if i := strings.Index(line, "DO NOT EDIT"); i > 0 && strings.Contains(strings.ToLower(line[:i]), "generated") {
+		return true
+	}
+	lower := strings.ToLower(line)
+	return strings.Contains(lower, "@generated") || strings.Contains(lower, "<auto-generated")
+}
+
+// isMinified reports whether a JavaScript file is minified: named *.min.js
+// or with lines far longer than anyone writes by hand. A few long lines in a
+// small file don't count.
+func isMinified(name string, sample []byte) bool {
+	if strings.Contains(name, ".min.") {
+		return true
+	}
+	lines := bytes.Count(sample, []byte("\n")) + 1
+	return len(sample) >= 4*minifiedLineLength && len(sample)/lines > minifiedLineLength
+}
@@ -0,0 +1,214 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// GenericSpec defines an analyzer entirely in config, for build systems
+// without a built-in analyzer. Commands are run in the project directory
+// without a shell; use ["sh", "-c", "..."] for pipes or variables.
+type GenericSpec struct {
+	Language        string   `json:"language"`
+	Detect          []string `json:"detect"`           // Files that select this analyzer; always selected if empty
+	CoverageCommand []string `json:"coverage_command"` // Runs the suite and writes Report
+	Report          string   `json:"report"`           // Report path relative to the project
+	Format          string   `json:"format"`           // lcov, cobertura, json, ...; detected if empty
+	TestCommand     []string `json:"test_command"`     // Runs one test file; {test_file} is replaced
+	TestFile        string   `json:"test_file"`        // Test path pattern, e.g. "tests/{dir}/{name}_test{ext}"
+	SourceFile      string   `json:"source_file"`      // Source path pattern, "{dir}/{name}{ext}" if empty
+}
+
+// GenericAnalyzer implements coverage analysis from a GenericSpec
+type GenericAnalyzer struct {
+	spec GenericSpec
+	opts Options
+}
+
+// NewGenericAnalyzer creates an analyzer from a config spec
+func NewGenericAnalyzer(spec GenericSpec, opts Options) *GenericAnalyzer {
+	if spec.Language == "" {
+		spec.Language = "Custom"
+	}
+	if spec.SourceFile == "" {
+		spec.SourceFile = "{dir}/{name}{ext}"
+	}
+	if spec.TestFile == "" {
+		spec.TestFile = "{dir}/{name}_test{ext}"
+	}
+	return &GenericAnalyzer{spec: spec, opts: opts}
+}
+
+// DetectLanguage checks for the spec's marker files
+func (g *GenericAnalyzer) DetectLanguage(projectPath string) bool {
+	for _, name := range g.spec.Detect {
+		if fileExists(filepath.Join(projectPath, name)) {
+			return true
+		}
+	}
+	return len(g.spec.Detect) == 0
+}
+
+// GetLanguageName returns the spec's language
+func (g *GenericAnalyzer) GetLanguageName() string {
+	return g.spec.Language
+}
+
+// RunCoverage runs the coverage command and parses the report it writes
+func (g *GenericAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
+	if len(g.spec.CoverageCommand) == 0 || g.spec.Report == "" {
+		return nil, fmt.Errorf("custom analyzer %s needs coverage_command and report", g.spec.Language)
+	}
+
+	reportFile := filepath.Join(projectPath, g.spec.Report)
+	os.Remove(reportFile) // Don't parse a stale report if the run fails
+
+	output, _ := g.command(projectPath, g.spec.CoverageCommand, "") // Ignore error, tests might fail
+
+	if !fileExists(reportFile) {
+		return nil, fmt.Errorf("coverage command did not write %s\n%s", g.spec.Report, output)
+	}
+
+	return ParseReport(projectPath, reportFile, g.spec.Format, g, g.opts)
+}
+
+// GetTestFilePath fills the test file pattern from a source file. Files
+// outside the source pattern are split into directory, name and extension.
+func (g *GenericAnalyzer) GetTestFilePath(sourceFile string) string {
+	parts, ok := matchPathPattern(g.spec.SourceFile, sourceFile)
+	if !ok {
+		parts, _ = matchPathPattern("{dir}/{name}{ext}", sourceFile)
+	}
+	return fillPathPattern(g.spec.TestFile, parts)
+}
+
+// GetSourceFileForTest fills the source file pattern from a test file
+func (g *GenericAnalyzer) GetSourceFileForTest(testFile string) string {
+	parts, ok := matchPathPattern(g.spec.TestFile, testFile)
+	if !ok {
+		return ""
+	}
+	return fillPathPattern(g.spec.SourceFile, parts)
+}
+
+// RunTests runs the test command for a specific test file
+func (g *GenericAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
+	if len(g.spec.TestCommand) == 0 {
+		return false, "", fmt.Errorf("custom analyzer %s has no test_command", g.spec.Language)
+	}
+
+	output, err := g.command(projectPath, g.spec.TestCommand, testFile)
+	return testResult(output, err)
+}
+
+// ValidateTestFile validates that a test file compiles and runs
+func (g *GenericAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
+	return g.RunTests(projectPath, testFile)
+}
+
+// ToolVersions reports nothing; the spec doesn't name its tools
+func (g *GenericAnalyzer) ToolVersions(projectPath string) map[string]string {
+	return map[string]string{}
+}
+
+// command runs a command template with {test_file} replaced and returns its
+// combined output
+func (g *GenericAnalyzer) command(projectPath string, template []string, testFile string) (string, error) {
+	args := make([]string, len(template))
+	for i, arg := range template {
+		args[i] = strings.ReplaceAll(arg, "{test_file}", testFile)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = projectPath
+	cmd.Env = g.opts.environ(projectPath)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := g.opts.run(cmd)
+	return output.String(), err
+}
+
+// pathPlaceholders are the parts of a file path a pattern can refer to:
+// the directory, the base name without extension, and the extension
+var pathPlaceholders = map[string]string{
+	"dir":  `.+`,
+	"name": `[^/]+?`,
+	"ext":  `\.[^./]+`,
+}
+
+// matchPathPattern matches a slash-separated path against a pattern and
+// returns the placeholder values. "{dir}/" also matches no directory, so
+// patterns work for top-level files.
+func matchPathPattern(pattern, path string) (map[string]string, bool) {
+	path = filepath.ToSlash(path)
+
+	seen := make(map[string]bool)
+	var expr strings.Builder
+	expr.WriteString("^")
+	for rest := filepath.ToSlash(pattern); rest != ""; {
+		i := strings.Index(rest, "{")
+		j := strings.Index(rest, "}")
+		if i < 0 || j < i {
+			expr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		expr.WriteString(regexp.QuoteMeta(rest[:i]))
+		name := rest[i+1 : j]
+		rest = rest[j+1:]
+
+		group, ok := pathPlaceholders[name]
+		if !ok {
+			expr.WriteString(regexp.QuoteMeta("{" + name + "}"))
+			continue
+		}
+		if !seen[name] {
+			group = "(?P<" + name + ">" + group + ")"
+			seen[name] = true
+		}
+		if name == "dir" && strings.HasPrefix(rest, "/") {
+			group = "(?:" + group + "/)?"
+			rest = rest[1:]
+		}
+		expr.WriteString(group)
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, false
+	}
+	m := re.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+
+	parts := make(map[string]string)
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			parts[name] = m[i]
+		}
+	}
+	return parts, true
+}
+
+// fillPathPattern replaces a pattern's placeholders, dropping "{dir}/" for
+// top-level files
+func fillPathPattern(pattern string, parts map[string]string) string {
+	pattern = filepath.ToSlash(pattern)
+	if parts["dir"] == "" || parts["dir"] == "." {
+		pattern = strings.ReplaceAll(pattern, "{dir}/", "")
+	}
+	path := pattern
+	for name := range pathPlaceholders {
+		path = strings.ReplaceAll(path, "{"+name+"}", parts[name])
+	}
+	return filepath.FromSlash(filepath.Clean(path))
+}
@@ -2,16 +2,22 @@ package coverage
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
 )
 
 // GoAnalyzer implements coverage analysis for Go projects
-type GoAnalyzer struct{}
+type GoAnalyzer struct {
+	opts    Options
+	profile []byte // Coverage profile of the last run, for RunCoverageFor
+}
 
 // DetectLanguage checks if this is a Go project
 func (g *GoAnalyzer) DetectLanguage(projectPath string) bool {
@@ -32,17 +38,28 @@ func (g *GoAnalyzer) GetLanguageName() string {
 
 // RunCoverage executes go test with coverage
 func (g *GoAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
-	coverageFile := filepath.Join(projectPath, "coverage.out")
+	coverageFile, err := coverageArtifact(projectPath, "coverage.out")
+	if err != nil {
+		return nil, err
+	}
 
 	// Run tests with coverage (use atomic for consistency with CI)
-	cmd := exec.Command("go", "test", "./...", "-coverprofile="+coverageFile, "-covermode=atomic")
+	args := append([]string{"test", "./...", "-coverprofile=" + coverageFile, "-covermode=atomic"}, g.testFlags()...)
+	if g.opts.CoverPkg != "" {
+		args = append(args, "-coverpkg="+g.opts.CoverPkg)
+	}
+	cmd := exec.Command("go", args...)
 	cmd.Dir = projectPath
+	cmd.Env = g.opts.environ(projectPath)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err = g.opts.run(cmd)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errdefs.ToolMissing(err)
+	}
 	if err != nil {
 		// Tests might fail, but we can still get coverage info if the file exists
 		// Check if coverage file was generated despite test failures
@@ -51,7 +68,13 @@ func (g *GoAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
 		}
 	}
 
-	// Parse coverage file
+	g.profile, _ = os.ReadFile(coverageFile)
+	return g.loadCoverageFile(projectPath, coverageFile)
+}
+
+// loadCoverageFile builds a report from a coverage profile, taking the
+// total from go tool cover so it matches what go test reports
+func (g *GoAnalyzer) loadCoverageFile(projectPath, coverageFile string) (*CoverageReport, error) {
 	report := &CoverageReport{
 		FileCoverage:   make(map[string]float64),
 		UncoveredFiles: []string{},
@@ -61,17 +84,18 @@ func (g *GoAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
 
 	// Read coverage file
 	if fileExists(coverageFile) {
-		if err := g.parseCoverageFile(coverageFile, report); err != nil {
+		if err := g.parseCoverageFile(projectPath, coverageFile, report); err != nil {
 			return nil, fmt.Errorf("failed to parse coverage: %w", err)
 		}
 	}
 
 	// Get total coverage using go tool cover
 	if fileExists(coverageFile) {
-		cmd = exec.Command("go", "tool", "cover", "-func="+coverageFile)
+		cmd := exec.Command("go", "tool", "cover", "-func="+coverageFile)
 		cmd.Dir = projectPath
+		cmd.Env = g.opts.environ(projectPath)
 
-		output, err := cmd.Output()
+		output, err := g.opts.output(cmd)
 		if err == nil {
 			// Parse total from last line: "total:  (statements)  XX.X%"
 			lines := strings.Split(string(output), "\n")
@@ -87,8 +111,10 @@ func (g *GoAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
 	return report, nil
 }
 
-// parseCoverageFile parses a Go coverage file
-func (g *GoAnalyzer) parseCoverageFile(filename string, report *CoverageReport) error {
+// parseCoverageFile parses a Go coverage file. With -coverpkg every test
+// binary reports the blocks of all covered packages, so a block listed more
+// than once counts once and is covered if any test binary executed it.
+func (g *GoAnalyzer) parseCoverageFile(projectPath, filename string, report *CoverageReport) error {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return err
@@ -96,17 +122,36 @@ func (g *GoAnalyzer) parseCoverageFile(filename string, report *CoverageReport)
 
 	lines := strings.Split(string(data), "\n")
 	fileStats := make(map[string]*fileCoverageStats)
+	modules := g.loadGoModules(projectPath)
+
+	// Block positions mapped to their index in lines, and the highest count seen
+	blocks := make(map[string]int)
+	counts := make(map[int]int)
+	for i, line := range lines[1:] {
+		parts := strings.Fields(line)
+		if len(parts) < 3 {
+			continue
+		}
+		count, _ := strconv.Atoi(parts[2])
+		first, seen := blocks[parts[0]]
+		if !seen {
+			blocks[parts[0]] = i
+			counts[i] = count
+		} else if count > counts[first] {
+			counts[first] = count
+		}
+	}
 
 	// Skip first line (mode: ...)
-	for _, line := range lines[1:] {
+	for i, line := range lines[1:] {
 		if line == "" {
 			continue
 		}
 
 		// Format: filename:startLine.startCol,endLine.endCol numStmt count
 		parts := strings.Fields(line)
-		if len(parts) < 3 {
-			continue
+		if len(parts) < 3 || blocks[parts[0]] != i {
+			continue // Repeated block, counted at its first occurrence
 		}
 
 		// Extract filename and line range
@@ -121,18 +166,11 @@ func (g *GoAnalyzer) parseCoverageFile(filename string, report *CoverageReport)
 		// Convert module path to relative path
 		// Coverage output has paths like: github.com/tablev/hls5/internal/handlers/file.go
 		// We need to strip the module prefix and get: internal/handlers/file.go
-		if strings.Contains(filePath, "/") {
-			parts := strings.Split(filePath, "/")
-			// Find where the actual project path starts (after module name)
-			// Typically after the 3rd component (github.com/user/repo)
-			if len(parts) > 3 {
-				filePath = strings.Join(parts[3:], "/")
-			}
-		}
+		filePath = modules.resolve(projectPath, filePath)
 		lineRange := fileAndRange[colonIdx+1:]
 
-		// Parse count (last field)
-		count, _ := strconv.Atoi(parts[2])
+		// Highest count of the block across test binaries
+		count := counts[i]
 
 		// Parse line numbers
 		rangeParts := strings.Split(lineRange, ",")
@@ -165,6 +203,7 @@ func (g *GoAnalyzer) parseCoverageFile(filename string, report *CoverageReport)
 			stats.total++
 			if count > 0 {
 				stats.covered++
+				stats.coveredLines = append(stats.coveredLines, line)
 			} else {
 				stats.uncovered = append(stats.uncovered, line)
 			}
@@ -176,6 +215,8 @@ func (g *GoAnalyzer) parseCoverageFile(filename string, report *CoverageReport)
 		if stats.total > 0 {
 			coverage := (float64(stats.covered) / float64(stats.total)) * 100
 			report.FileCoverage[filename] = coverage
+			report.setTotalLines(filename, stats.total)
+			report.setCoveredLines(filename, uniqueLines(stats.coveredLines))
 
 			if coverage < 100 {
 				report.UncoveredFiles = append(report.UncoveredFiles, filename)
@@ -188,9 +229,10 @@ func (g *GoAnalyzer) parseCoverageFile(filename string, report *CoverageReport)
 }
 
 type fileCoverageStats struct {
-	covered   int
-	total     int
-	uncovered []int
+	covered      int
+	total        int
+	coveredLines []int
+	uncovered    []int
 }
 
 // GetTestFilePath returns the test file path for a Go source file
@@ -216,35 +258,116 @@ func (g *GoAnalyzer) GetSourceFileForTest(testFile string) string {
 // RunTests runs tests for a specific test file
 func (g *GoAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
 	// Get the package directory
-	testDir := filepath.Dir(testFile)
+	testDir := g.packageDir(projectPath, testFile)
 
-	cmd := exec.Command("go", "test", "-v", "./"+testDir)
+	args := append([]string{"test", "-v"}, g.testFlags()...)
+	cmd := exec.Command("go", append(args, "./"+testDir)...)
 	cmd.Dir = projectPath
+	cmd.Env = g.opts.environ(projectPath)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err := g.opts.run(cmd)
 	output := stdout.String() + stderr.String()
 
-	return err == nil, output, nil
+	return testResult(output, err)
+}
+
+// RunSelectedTests runs only the named test functions of a test file's package
+func (g *GoAnalyzer) RunSelectedTests(projectPath string, testFile string, tests []string) (bool, string, error) {
+	testDir := g.packageDir(projectPath, testFile)
+
+	args := append([]string{"test", "-v", "-run", "^(" + strings.Join(tests, "|") + ")$"}, g.testFlags()...)
+	cmd := exec.Command("go", append(args, "./"+testDir)...)
+	cmd.Dir = projectPath
+	cmd.Env = g.opts.environ(projectPath)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := g.opts.run(cmd)
+	output := stdout.String() + stderr.String()
+
+	return testResult(output, err)
+}
+
+// buildFlags returns the flags shared by go build and go test
+func (g *GoAnalyzer) buildFlags() []string {
+	if len(g.opts.Go.Tags) == 0 {
+		return nil
+	}
+	return []string{"-tags=" + strings.Join(g.opts.Go.Tags, ",")}
+}
+
+// testFlags returns the flags for go test runs: the build flags and the
+// configured test arguments
+func (g *GoAnalyzer) testFlags() []string {
+	return append(g.buildFlags(), g.opts.Go.Args...)
+}
+
+// packageDir returns the directory of a test file relative to the project,
+// accepting absolute paths as well as project-relative ones
+func (g *GoAnalyzer) packageDir(projectPath, testFile string) string {
+	if filepath.IsAbs(testFile) {
+		if absProject, err := filepath.Abs(projectPath); err == nil {
+			if rel, err := filepath.Rel(absProject, testFile); err == nil {
+				testFile = rel
+			}
+		}
+	}
+	return filepath.Dir(testFile)
 }
 
 // ValidateTestFile validates that a test file compiles and runs
 func (g *GoAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
 	// First, try to build
-	testDir := filepath.Dir(testFile)
-	cmd := exec.Command("go", "build", "./"+testDir)
+	testDir := g.packageDir(projectPath, testFile)
+	args := append([]string{"build"}, g.buildFlags()...)
+	cmd := exec.Command("go", append(args, "./"+testDir)...)
 	cmd.Dir = projectPath
+	cmd.Env = g.opts.environ(projectPath)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	err := g.opts.run(cmd)
+	if errors.Is(err, errdefs.ErrStalled) {
+		return false, "", err
+	}
+	if err != nil {
 		return false, "Compilation failed: " + stderr.String(), nil
 	}
 
 	// Then run tests
 	return g.RunTests(projectPath, testFile)
 }
+
+// CompileTestFile compiles the test binary of a test file's package without
+// running it, which catches compile errors that go build ignores in tests
+func (g *GoAnalyzer) CompileTestFile(projectPath string, testFile string) (bool, string, error) {
+	testDir := g.packageDir(projectPath, testFile)
+
+	args := append([]string{"test", "-c", "-o", os.DevNull}, g.buildFlags()...)
+	cmd := exec.Command("go", append(args, "./"+testDir)...)
+	cmd.Dir = projectPath
+	cmd.Env = g.opts.environ(projectPath)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := g.opts.run(cmd)
+	output := stdout.String() + stderr.String()
+
+	return testResult(output, err)
+}
+
+// ToolVersions reports the Go toolchain version
+func (g *GoAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := make(map[string]string)
+	addVersion(versions, "go", commandVersion(projectPath, "go", "version"))
+	return versions
+}
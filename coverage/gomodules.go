@@ -0,0 +1,107 @@
+package coverage
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// goModules maps Go module paths to their directories relative to the
+// project, so profile paths like example.com/foo/internal/x.go can be turned
+// into the files on disk
+type goModules map[string]string
+
+// loadGoModules finds the project's modules: the main modules reported by
+// go list -m, which include go.work members, and every go.mod in the tree,
+// which covers nested modules outside a workspace
+func (g *GoAnalyzer) loadGoModules(projectPath string) goModules {
+	modules := make(goModules)
+	absProject, err := filepath.Abs(projectPath)
+	if err != nil {
+		absProject = projectPath
+	}
+
+	for _, goMod := range findReports(projectPath, "go.mod") {
+		if strings.Contains(filepath.ToSlash(goMod), "/vendor/") {
+			continue
+		}
+		dir := filepath.Dir(goMod)
+		if modulePath := goModulePath(dir); modulePath != "" {
+			if rel, err := filepath.Rel(projectPath, dir); err == nil {
+				modules[modulePath] = rel
+			}
+		}
+	}
+
+	cmd := exec.Command("go", "list", "-m", "-json")
+	cmd.Dir = projectPath
+	cmd.Env = g.opts.environ(projectPath)
+	output, err := g.opts.output(cmd)
+	if err != nil {
+		return modules
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(output)))
+	for {
+		var module struct {
+			Path string
+			Dir  string
+		}
+		if err := decoder.Decode(&module); err != nil {
+			break
+		}
+		if module.Path == "" || module.Dir == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(absProject, module.Dir); err == nil && !strings.HasPrefix(rel, "..") {
+			modules[module.Path] = rel
+		}
+	}
+	return modules
+}
+
+// resolve maps a coverage profile path to a file path relative to the
+// project, using the longest matching module path. Paths outside every
+// known module fall back to the shortest suffix that exists on disk.
+func (m goModules) resolve(projectPath, profilePath string) string {
+	best := ""
+	for modulePath := range m {
+		if strings.HasPrefix(profilePath, modulePath+"/") && len(modulePath) > len(best) {
+			best = modulePath
+		}
+	}
+	if best != "" {
+		return filepath.Join(m[best], strings.TrimPrefix(profilePath, best+"/"))
+	}
+
+	parts := strings.Split(profilePath, "/")
+	for i := range parts {
+		candidate := filepath.Join(parts[i:]...)
+		if fileExists(filepath.Join(projectPath, candidate)) {
+			return candidate
+		}
+	}
+
+	// Typically the module is github.com/user/repo
+	if len(parts) > 3 {
+		return strings.Join(parts[3:], "/")
+	}
+	return profilePath
+}
+
+// goModulePath reads the module path from the go.mod in a directory, or
+// returns "" if there is none
+func goModulePath(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,241 @@
+package coverage
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Ignore directives are marker comments in source files that keep code out
+// of the work plan. They work with any comment syntax (//, #, --, /* */).
+const (
+	// IgnoreFile anywhere in a file ignores the whole file
+	IgnoreFile = "coverage-agent:ignore-file"
+
+	// IgnoreStart and IgnoreEnd ignore the lines between them
+	IgnoreStart = "coverage-agent:ignore-start"
+	IgnoreEnd   = "coverage-agent:ignore-end"
+
+	// Ignore at the end of a code line ignores that line. On a line of its
+	// own it ignores the block that follows: the next line, the lines
+	// indented deeper than it and a closing line at its indentation.
+	Ignore = "coverage-agent:ignore"
+)
+
+// ApplyIgnoreDirectives removes lines and files marked with ignore
+// directives from a report's uncovered lines, branches and files, and
+// records them in its exclusions. Total and per-file coverage are left as
+// the tool reported them.
+func ApplyIgnoreDirectives(projectPath string, report *CoverageReport) {
+	files := report.UncoveredFiles[:0]
+	for _, file := range report.UncoveredFiles {
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projectPath, file)
+		}
+
+		ignoreAll, ignored := ignoredLines(path)
+		if ignoreAll {
+			report.addExclusion(file, ExcludedIgnoreFile, 0, true)
+			delete(report.UncoveredLines, file)
+			delete(report.UncoveredBranches, file)
+			continue
+		}
+
+		if len(ignored) > 0 {
+			var lines []int
+			for _, line := range report.UncoveredLines[file] {
+				if !ignored[line] {
+					lines = append(lines, line)
+				}
+			}
+
+			var branches []Branch
+			for _, branch := range report.UncoveredBranches[file] {
+				if !ignored[branch.Line] {
+					branches = append(branches, branch)
+				}
+			}
+
+			if removed := len(report.UncoveredLines[file]) - len(lines); removed > 0 {
+				report.addExclusion(file, ExcludedIgnoreLines, removed, true)
+			}
+
+			// Drop files without any uncovered code left
+			before := len(report.UncoveredLines[file]) + len(report.UncoveredBranches[file])
+			if before > 0 && len(lines)+len(branches) == 0 {
+				delete(report.UncoveredLines, file)
+				delete(report.UncoveredBranches, file)
+				continue
+			}
+
+			report.UncoveredLines[file] = lines
+			if _, ok := report.UncoveredBranches[file]; ok {
+				report.UncoveredBranches[file] = branches
+			}
+		}
+
+		files = append(files, file)
+	}
+	report.UncoveredFiles = files
+}
+
+// ExcludePaths removes the uncovered files matching any of the given
+// project-relative glob patterns ("vendor/**", "**/*.pb.go") from a report
+// and records them in its exclusions. Like ignore directives, excluded files
+// still count in total coverage.
+func ExcludePaths(projectPath string, report *CoverageReport, patterns []string) {
+	if len(patterns) == 0 {
+		return
+	}
+
+	files := report.UncoveredFiles[:0]
+	for _, file := range report.UncoveredFiles {
+		relPath := filepath.ToSlash(relativeToProject(projectPath, file))
+		excluded := false
+		for _, pattern := range patterns {
+			excluded = excluded || MatchGlob(pattern, relPath)
+		}
+		if excluded {
+			report.addExclusion(file, ExcludedByPattern, 0, true)
+			delete(report.UncoveredLines, file)
+			delete(report.UncoveredBranches, file)
+			continue
+		}
+		files = append(files, file)
+	}
+	report.UncoveredFiles = files
+}
+
+// ignoredLines reads a source file's ignore directives and returns whether
+// the whole file is ignored, or else the set of ignored line numbers.
+// Unreadable files have no directives.
+func ignoredLines(path string) (bool, map[int]bool) {
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), Ignore) {
+		return false, nil
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	ignored := make(map[int]bool)
+	inRange := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case hasDirective(line, IgnoreFile):
+			return true, nil
+
+		case hasDirective(line, IgnoreStart):
+			inRange = true
+
+		case hasDirective(line, IgnoreEnd):
+			inRange = false
+
+		case inRange:
+			ignored[i+1] = true
+
+		case hasDirective(line, Ignore):
+			if !isCommentOnly(line) {
+				ignored[i+1] = true
+				continue
+			}
+			end := blockEnd(lines, i+1)
+			for n := i + 1; n < end; n++ {
+				ignored[n+1] = true
+			}
+		}
+	}
+
+	return false, ignored
+}
+
+// hasDirective reports whether a line has a directive in a comment, so the
+// directive's text in a string literal doesn't count
+func hasDirective(line, directive string) bool {
+	i := strings.Index(line, directive)
+	if i < 0 {
+		return false
+	}
+	if rest := line[i+len(directive):]; rest != "" && rest[0] == '-' {
+		return false // A longer directive
+	}
+
+	before := line[:i]
+	for _, token := range []string{"//", "#", "--", "/*", "*"} {
+		if strings.Contains(before, token) {
+			return true
+		}
+	}
+	return isCommentOnly(before)
+}
+
+// isCommentOnly reports whether a line holds nothing but a comment
+func isCommentOnly(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "*" {
+		return true
+	}
+	for _, prefix := range []string{"//", "#", "--", "/*", "* ", "*/", "%", ";"} {
+		if strings.HasPrefix(trimmed, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockEnd returns the index after the block starting at lines[start]: the
+// start line, the lines after it that are blank or indented deeper, and a
+// closing line (}, ), ], end) at the start line's indentation
+func blockEnd(lines []string, start int) int {
+	// Skip blank lines and further comments between the directive and the code
+	for start < len(lines) && (strings.TrimSpace(lines[start]) == "" || isCommentOnly(lines[start])) {
+		start++
+	}
+	if start >= len(lines) {
+		return start
+	}
+
+	indent := indentation(lines[start])
+	end := start + 1
+	for end < len(lines) {
+		line := lines[end]
+		if strings.TrimSpace(line) == "" {
+			end++
+			continue
+		}
+		if indentation(line) > indent {
+			end++
+			continue
+		}
+		if indentation(line) == indent && isClosingLine(line) {
+			end++
+		}
+		break
+	}
+
+	// Don't swallow trailing blank lines
+	for end > start+1 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return end
+}
+
+// indentation returns the width of a line's leading whitespace, counting a
+// tab as one column
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// isClosingLine reports whether a line closes a block
+func isClosingLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "}") || strings.HasPrefix(trimmed, ")") ||
+		strings.HasPrefix(trimmed, "]") || trimmed == "end" || strings.HasPrefix(trimmed, "end ")
+}
@@ -0,0 +1,112 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IncrementalAnalyzer is implemented by analyzers that can re-measure part
+// of a project and merge the result into their last full measurement
+type IncrementalAnalyzer interface {
+	// RunCoverageFor re-measures the code the given test files belong to
+	// and returns the last report updated with the result. Without an
+	// earlier measurement it measures the whole project.
+	RunCoverageFor(projectPath string, testFiles []string) (*CoverageReport, error)
+}
+
+// RunCoverageFor runs go test with coverage for the packages of the given
+// test files only. Their blocks replace those of the same files in the
+// profile of the last run, so the total still comes from go tool cover.
+// With -coverpkg a package's tests cover other packages too, so the whole
+// project is measured.
+func (g *GoAnalyzer) RunCoverageFor(projectPath string, testFiles []string) (*CoverageReport, error) {
+	if len(g.profile) == 0 || g.opts.CoverPkg != "" {
+		return g.RunCoverage(projectPath)
+	}
+
+	dirs := make(map[string]bool)
+	for _, testFile := range testFiles {
+		dirs["./"+filepath.ToSlash(g.packageDir(projectPath, testFile))] = true
+	}
+	packages := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		packages = append(packages, dir)
+	}
+	sort.Strings(packages)
+
+	partialFile, err := coverageArtifact(projectPath, "coverage-partial.out")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(partialFile)
+
+	args := append([]string{"test"}, packages...)
+	args = append(args, "-coverprofile="+partialFile, "-covermode=atomic")
+	cmd := exec.Command("go", append(args, g.testFlags()...)...)
+	cmd.Dir = projectPath
+	cmd.Env = g.opts.environ(projectPath)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// Failing tests still write the profile
+	if err := runFailed(g.opts.run(cmd)); err != nil {
+		return nil, err
+	}
+	partial, err := os.ReadFile(partialFile)
+	if err != nil {
+		return nil, fmt.Errorf("go test %s wrote no coverage profile: %w\nStderr: %s", strings.Join(packages, " "), err, stderr.String())
+	}
+
+	coverageFile, err := coverageArtifact(projectPath, "coverage.out")
+	if err != nil {
+		return nil, err
+	}
+	merged := mergeProfiles(g.profile, partial)
+	if err := os.WriteFile(coverageFile, merged, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write merged coverage profile: %w", err)
+	}
+	g.profile = merged
+	return g.loadCoverageFile(projectPath, coverageFile)
+}
+
+// mergeProfiles replaces the blocks of every file in the partial profile
+// in the full one. A measured package lists all its blocks, covered or not,
+// so blocks of its files that are no longer executed are replaced too.
+func mergeProfiles(full, partial []byte) []byte {
+	partialLines := strings.Split(strings.TrimSpace(string(partial)), "\n")
+	replaced := make(map[string]bool)
+	for _, line := range partialLines[1:] {
+		replaced[profileFile(line)] = true
+	}
+
+	var merged strings.Builder
+	fullLines := strings.Split(strings.TrimSpace(string(full)), "\n")
+	merged.WriteString(fullLines[0] + "\n") // mode: ...
+	for _, line := range fullLines[1:] {
+		if line != "" && !replaced[profileFile(line)] {
+			merged.WriteString(line + "\n")
+		}
+	}
+	for _, line := range partialLines[1:] {
+		if line != "" {
+			merged.WriteString(line + "\n")
+		}
+	}
+	return []byte(merged.String())
+}
+
+// profileFile returns the file of a coverage profile block line, e.g.
+// example.com/mod/pkg/file.go for "example.com/mod/pkg/file.go:3.1,5.2 1 0"
+func profileFile(line string) string {
+	block, _, _ := strings.Cut(line, " ")
+	if i := strings.LastIndex(block, ":"); i >= 0 {
+		return block[:i]
+	}
+	return block
+}
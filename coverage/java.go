@@ -3,15 +3,49 @@ package coverage
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
 )
 
 // JavaAnalyzer implements coverage analysis for Java projects
-type JavaAnalyzer struct{}
+type JavaAnalyzer struct {
+	opts          Options
+	lang          jvmLanguage // Zero value means Java
+	gradleVersion string      // Detected lazily, "unknown" if detection failed
+}
+
+// jvmLanguage describes a language built with Maven or Gradle and measured with JaCoCo
+type jvmLanguage struct {
+	name string // Language name reported by the analyzer
+	ext  string // Source file extension
+}
+
+var (
+	javaLanguage   = jvmLanguage{name: "Java", ext: ".java"}
+	kotlinLanguage = jvmLanguage{name: "Kotlin", ext: ".kt"}
+)
+
+// jvmSourceDirs are the source roots under src/main and src/test
+var jvmSourceDirs = []string{"java", "kotlin"}
+
+// language returns the JVM language this analyzer handles
+func (j *JavaAnalyzer) language() jvmLanguage {
+	if j.lang.name == "" {
+		return javaLanguage
+	}
+	return j.lang
+}
 
 // DetectLanguage checks if this is a Java project
 func (j *JavaAnalyzer) DetectLanguage(projectPath string) bool {
@@ -30,7 +64,7 @@ func (j *JavaAnalyzer) DetectLanguage(projectPath string) bool {
 
 // GetLanguageName returns "Java"
 func (j *JavaAnalyzer) GetLanguageName() string {
-	return "Java"
+	return j.language().name
 }
 
 // RunCoverage executes tests with JaCoCo coverage
@@ -39,7 +73,7 @@ func (j *JavaAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error)
 		FileCoverage:   make(map[string]float64),
 		UncoveredFiles: []string{},
 		UncoveredLines: make(map[string][]int),
-		Language:       "Java",
+		Language:       j.language().name,
 	}
 
 	// Determine build tool
@@ -49,157 +83,380 @@ func (j *JavaAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error)
 
 	var cmd *exec.Cmd
 
-	if isMaven {
+	if isMaven && j.runsFailsafe(projectPath) {
+		// Integration tests run in verify and write jacoco-it.exec
+		cmd = j.mavenCommand("clean", "verify", "jacoco:report", "jacoco:report-integration")
+	} else if isMaven {
 		// Run Maven with JaCoCo
-		cmd = exec.Command("mvn", "clean", "test", "jacoco:report")
+		cmd = j.mavenCommand("clean", "test", "jacoco:report")
 	} else if isGradle {
 		// Run Gradle with JaCoCo
-		cmd = exec.Command("./gradlew", "test", "jacocoTestReport")
-		if !fileExists(filepath.Join(projectPath, "gradlew")) {
-			cmd = exec.Command("gradle", "test", "jacocoTestReport")
-		}
+		cmd = j.gradleCommand(projectPath, "test", "jacocoTestReport")
 	} else {
 		return nil, fmt.Errorf("no supported build tool found (Maven or Gradle)")
 	}
 
 	cmd.Dir = projectPath
+	cmd.Env = j.opts.environ(projectPath)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	_ = cmd.Run() // Ignore error, tests might fail
+	started := time.Now()
+	// Ignore errors, tests might fail, but not a stalled build
+	if err := j.opts.run(cmd); errors.Is(err, errdefs.ErrStalled) {
+		return nil, err
+	}
+
+	// Unit and integration tests, and every module, write their own report
+	reports := make([]*CoverageReport, 0, 1)
+	for _, reportPath := range j.jacocoReports(projectPath, isMaven, started) {
+		r := &CoverageReport{
+			FileCoverage:   make(map[string]float64),
+			UncoveredFiles: []string{},
+			UncoveredLines: make(map[string][]int),
+			Language:       j.language().name,
+		}
+		if err := j.parseJaCoCoXML(projectPath, reportPath, r); err != nil {
+			return nil, fmt.Errorf("failed to parse JaCoCo report: %w", err)
+		}
+		reports = append(reports, r)
+	}
 
-	// Parse JaCoCo XML report
-	var reportPath string
+	switch len(reports) {
+	case 0:
+		return report, nil
+	case 1:
+		return reports[0], nil
+	}
+	return MergeReports(reports[0], reports[1:]...), nil
+}
+
+// runsFailsafe reports whether a Maven build runs integration tests with
+// the Failsafe plugin, unless they are skipped
+func (j *JavaAnalyzer) runsFailsafe(projectPath string) bool {
+	if j.opts.Maven.SkipITs {
+		return false
+	}
+	pom, err := os.ReadFile(filepath.Join(projectPath, "pom.xml"))
+	return err == nil && strings.Contains(string(pom), "maven-failsafe-plugin")
+}
+
+// jacocoReports finds the JaCoCo XML reports written by a coverage run: for
+// Maven every jacoco.xml, e.g. target/site/jacoco and target/site/jacoco-it
+// of each module; for Gradle the reports under build/reports/jacoco of the
+// project and its subprojects written since the run started, as Gradle
+// builds aren't cleaned first
+func (j *JavaAnalyzer) jacocoReports(projectPath string, isMaven bool, since time.Time) []string {
 	if isMaven {
-		reportPath = filepath.Join(projectPath, "target", "site", "jacoco", "jacoco.xml")
-	} else {
-		reportPath = filepath.Join(projectPath, "build", "reports", "jacoco", "test", "jacocoTestReport.xml")
+		return findReports(projectPath, "jacoco.xml")
 	}
 
-	if fileExists(reportPath) {
-		if err := j.parseJaCoCoXML(reportPath, report); err != nil {
-			return nil, fmt.Errorf("failed to parse JaCoCo report: %w", err)
+	// File times can lag the clock by the file system's granularity
+	since = since.Add(-time.Second)
+	var reports []string
+	for _, pattern := range []string{
+		filepath.Join(projectPath, "build", "reports", "jacoco", "*", "*.xml"),
+		filepath.Join(projectPath, "*", "build", "reports", "jacoco", "*", "*.xml"),
+	} {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && !info.ModTime().Before(since) {
+				reports = append(reports, match)
+			}
 		}
 	}
+	return reports
+}
+
+// jacocoCounter is a JaCoCo coverage counter
+type jacocoCounter struct {
+	Type    string `xml:"type,attr"`
+	Missed  int    `xml:"missed,attr"`
+	Covered int    `xml:"covered,attr"`
+}
 
-	return report, nil
+// jacocoSourceFile is a JaCoCo sourcefile element. Line attributes are
+// parsed by hand so a bad value only skips its file.
+type jacocoSourceFile struct {
+	Name  string `xml:"name,attr"`
+	Lines []struct {
+		Number         string `xml:"nr,attr"`
+		Hits           string `xml:"ci,attr"`
+		MissedBranches string `xml:"mb,attr"`
+		CovBranches    string `xml:"cb,attr"`
+	} `xml:"line"`
 }
 
-// parseJaCoCoXML parses JaCoCo XML coverage report
-func (j *JavaAnalyzer) parseJaCoCoXML(filename string, report *CoverageReport) error {
+// jacocoClass is a JaCoCo class element with its methods' counters
+type jacocoClass struct {
+	Name           string `xml:"name,attr"` // Binary name, e.g. com/example/Outer$Inner
+	SourceFileName string `xml:"sourcefilename,attr"`
+	Methods        []struct {
+		Name     string          `xml:"name,attr"`
+		Line     string          `xml:"line,attr"`
+		Counters []jacocoCounter `xml:"counter"`
+	} `xml:"method"`
+}
+
+// parseJaCoCoXML parses JaCoCo XML coverage report. The report is streamed
+// one source file at a time: files that can't be parsed are skipped and
+// recorded in the report's ParseErrors, and a syntax error keeps the files
+// read before it. It fails only if no usable data was found.
+func (j *JavaAnalyzer) parseJaCoCoXML(projectPath, filename string, report *CoverageReport) error {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
 
-	type Counter struct {
-		Type    string `xml:"type,attr"`
-		Missed  int    `xml:"missed,attr"`
-		Covered int    `xml:"covered,attr"`
-	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var path []string // Names of the open elements
+	pkg := ""
+	hasTotal := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.skipEntry(projectPath, filename, "rest of report", err)
+			break
+		}
 
-	type SourceFile struct {
-		Name     string    `xml:"name,attr"`
-		Counters []Counter `xml:"counter"`
-		Lines    []struct {
-			Number int `xml:"nr,attr"`
-			Hits   int `xml:"ci,attr"`
-		} `xml:"line"`
-	}
+		switch t := token.(type) {
+		case xml.StartElement:
+			parent := ""
+			if len(path) > 0 {
+				parent = path[len(path)-1]
+			}
 
-	type Package struct {
-		Name        string       `xml:"name,attr"`
-		SourceFiles []SourceFile `xml:"sourcefile"`
-	}
+			switch {
+			case t.Name.Local == "package":
+				pkg = attr(t, "name")
 
-	var jacocoReport struct {
-		Packages []Package `xml:"package"`
-		Counters []Counter `xml:"counter"`
+			case t.Name.Local == "class":
+				var class jacocoClass
+				if err := decoder.DecodeElement(&class, &t); err != nil {
+					report.skipEntry(projectPath, filename, attr(t, "name"), err)
+					return j.finishJaCoCo(filename, hasTotal, report)
+				}
+				if err := j.addJaCoCoClass(projectPath, pkg, class, report); err != nil {
+					report.skipEntry(projectPath, filename, class.Name, err)
+				}
+				continue // DecodeElement consumed the end element
+
+			case t.Name.Local == "sourcefile":
+				var sourceFile jacocoSourceFile
+				if err := decoder.DecodeElement(&sourceFile, &t); err != nil {
+					report.skipEntry(projectPath, filename, filepath.Join(pkg, attr(t, "name")), err)
+					return j.finishJaCoCo(filename, hasTotal, report)
+				}
+				if err := j.addJaCoCoSourceFile(projectPath, pkg, sourceFile, report); err != nil {
+					report.skipEntry(projectPath, filename, filepath.Join(pkg, sourceFile.Name), err)
+				}
+				continue // DecodeElement consumed the end element
+
+			case t.Name.Local == "counter" && parent == "report":
+				// Calculate total coverage from the report's counters
+				var counter jacocoCounter
+				if err := decoder.DecodeElement(&counter, &t); err != nil {
+					report.skipEntry(projectPath, filename, "report counter", err)
+					continue
+				}
+				if total := counter.Covered + counter.Missed; counter.Type == "LINE" && total > 0 {
+					report.TotalCoverage = (float64(counter.Covered) / float64(total)) * 100
+					hasTotal = true
+				}
+				continue
+			}
+			path = append(path, t.Name.Local)
+
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		}
 	}
 
-	if err := xml.Unmarshal(data, &jacocoReport); err != nil {
-		return err
+	return j.finishJaCoCo(filename, hasTotal, report)
+}
+
+// finishJaCoCo computes the total coverage from the files read if the
+// report's own total was never reached, and checks that data was found
+func (j *JavaAnalyzer) finishJaCoCo(filename string, hasTotal bool, report *CoverageReport) error {
+	if !hasTotal {
+		var total, uncovered int
+		for file, lines := range report.TotalLines {
+			total += lines
+			uncovered += len(report.UncoveredLines[file])
+		}
+		if total > 0 {
+			report.TotalCoverage = float64(total-uncovered) / float64(total) * 100
+		}
 	}
+	return report.checkUsable(filename)
+}
 
-	// Calculate total coverage from counters
-	for _, counter := range jacocoReport.Counters {
-		if counter.Type == "LINE" {
-			total := counter.Covered + counter.Missed
-			if total > 0 {
-				report.TotalCoverage = (float64(counter.Covered) / float64(total)) * 100
+// addJaCoCoSourceFile adds the per-file coverage of a JaCoCo sourcefile
+// element, or returns an error without changing the report
+func (j *JavaAnalyzer) addJaCoCoSourceFile(projectPath, pkg string, sourceFile jacocoSourceFile, report *CoverageReport) error {
+	type line struct{ number, hits, missedBranches, covBranches int }
+	lines := make([]line, 0, len(sourceFile.Lines))
+	for _, l := range sourceFile.Lines {
+		var values [4]int
+		for i, value := range []string{l.Number, l.Hits, l.MissedBranches, l.CovBranches} {
+			if value == "" {
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid attribute of line %s: %w", l.Number, err)
 			}
+			values[i] = n
 		}
+		lines = append(lines, line{values[0], values[1], values[2], values[3]})
 	}
 
-	// Parse per-file coverage
-	for _, pkg := range jacocoReport.Packages {
-		for _, sourceFile := range pkg.SourceFiles {
-			fullPath := filepath.Join(pkg.Name, sourceFile.Name)
+	fullPath := j.resolveSourceFile(projectPath, pkg, sourceFile.Name)
 
-			// Calculate file coverage
-			var covered, total int
-			var uncovered []int
+	// Calculate file coverage
+	var covered, total int
+	var uncovered, executed []int
 
-			for _, line := range sourceFile.Lines {
-				total++
-				if line.Hits > 0 {
-					covered++
-				} else {
-					uncovered = append(uncovered, line.Number)
-				}
-			}
+	for _, line := range lines {
+		total++
+		if line.hits > 0 {
+			covered++
+			executed = append(executed, line.number)
+		} else {
+			uncovered = append(uncovered, line.number)
+		}
 
-			if total > 0 {
-				fileCoverage := (float64(covered) / float64(total)) * 100
-				report.FileCoverage[fullPath] = fileCoverage
+		if j.opts.BranchCoverage && line.missedBranches > 0 {
+			report.addUncoveredBranch(fullPath, Branch{
+				Line: line.number,
+				Detail: fmt.Sprintf("%d of %d branches never taken",
+					line.missedBranches, line.missedBranches+line.covBranches),
+			})
+		}
+	}
 
-				if len(uncovered) > 0 {
-					report.UncoveredFiles = append(report.UncoveredFiles, fullPath)
-					report.UncoveredLines[fullPath] = uncovered
-				}
+	if total > 0 {
+		fileCoverage := (float64(covered) / float64(total)) * 100
+		report.FileCoverage[fullPath] = fileCoverage
+		report.setTotalLines(fullPath, total)
+		report.setCoveredLines(fullPath, executed)
+
+		if len(uncovered) > 0 {
+			report.UncoveredFiles = append(report.UncoveredFiles, fullPath)
+			report.UncoveredLines[fullPath] = uncovered
+		}
+	}
+
+	return nil
+}
+
+// addJaCoCoClass adds the method coverage of a JaCoCo class element to the
+// file the class was compiled from, or returns an error without changing
+// the report. Static initializers and lambda bodies are left out, since
+// tests can't call them by name.
+func (j *JavaAnalyzer) addJaCoCoClass(projectPath, pkg string, class jacocoClass, report *CoverageReport) error {
+	if class.SourceFileName == "" {
+		return nil
+	}
+
+	methods := make([]Method, 0, len(class.Methods))
+	for _, m := range class.Methods {
+		if m.Name == "<clinit>" || strings.HasPrefix(m.Name, "lambda$") {
+			continue
+		}
+		method := Method{Class: path.Base(class.Name), Name: m.Name}
+		if m.Line != "" {
+			line, err := strconv.Atoi(m.Line)
+			if err != nil {
+				return fmt.Errorf("invalid line of method %s: %w", m.Name, err)
 			}
+			method.Line = line
 		}
+		for _, counter := range m.Counters {
+			switch counter.Type {
+			case "LINE":
+				method.MissedLines, method.CoveredLines = counter.Missed, counter.Covered
+			case "BRANCH":
+				method.MissedBranches, method.CoveredBranches = counter.Missed, counter.Covered
+			}
+		}
+		methods = append(methods, method)
 	}
 
+	file := j.resolveSourceFile(projectPath, pkg, class.SourceFileName)
+	for _, method := range methods {
+		report.addMethod(file, method)
+	}
 	return nil
 }
 
-// GetTestFilePath returns the test file path for a Java source file
+// attr returns the value of an element's attribute, or "" if it is missing
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// resolveSourceFile maps a JaCoCo package and source file name to the file
+// under a src/main source root, falling back to the package-relative path
+func (j *JavaAnalyzer) resolveSourceFile(projectPath, pkg, name string) string {
+	for _, dir := range jvmSourceDirs {
+		candidate := filepath.Join("src", "main", dir, pkg, name)
+		if fileExists(filepath.Join(projectPath, candidate)) {
+			return candidate
+		}
+	}
+	return filepath.Join(pkg, name)
+}
+
+// GetTestFilePath returns the test file path for a Java or Kotlin source file
 func (j *JavaAnalyzer) GetTestFilePath(sourceFile string) string {
-	// Java convention: Foo.java in src/main/java -> FooTest.java in src/test/java
+	// Convention: Foo.java in src/main/java -> FooTest.java in src/test/java,
+	// and Foo.kt in src/main/kotlin -> FooTest.kt in src/test/kotlin
+	ext := filepath.Ext(sourceFile)
 	if strings.Contains(sourceFile, "/main/") {
 		testFile := strings.Replace(sourceFile, "/main/", "/test/", 1)
 		base := filepath.Base(testFile)
-		name := strings.TrimSuffix(base, ".java")
+		name := strings.TrimSuffix(base, ext)
 		dir := filepath.Dir(testFile)
-		return filepath.Join(dir, name+"Test.java")
+		return filepath.Join(dir, name+"Test"+ext)
 	}
 
 	// Simple case: Foo.java -> FooTest.java
 	base := filepath.Base(sourceFile)
-	name := strings.TrimSuffix(base, ".java")
+	name := strings.TrimSuffix(base, ext)
 	dir := filepath.Dir(sourceFile)
-	return filepath.Join(dir, name+"Test.java")
+	return filepath.Join(dir, name+"Test"+ext)
 }
 
-// GetSourceFileForTest returns the source file for a Java test file
+// GetSourceFileForTest returns the source file for a Java or Kotlin test file
 func (j *JavaAnalyzer) GetSourceFileForTest(testFile string) string {
+	ext := filepath.Ext(testFile)
+
 	// Remove Test suffix and swap test/main directories
 	if strings.Contains(testFile, "/test/") {
 		sourceFile := strings.Replace(testFile, "/test/", "/main/", 1)
 		base := filepath.Base(sourceFile)
-		if strings.HasSuffix(base, "Test.java") {
-			name := strings.TrimSuffix(base, "Test.java") + ".java"
+		if strings.HasSuffix(base, "Test"+ext) {
+			name := strings.TrimSuffix(base, "Test"+ext) + ext
 			return filepath.Join(filepath.Dir(sourceFile), name)
 		}
 		return sourceFile
 	}
 
 	base := filepath.Base(testFile)
-	if strings.HasSuffix(base, "Test.java") {
-		name := strings.TrimSuffix(base, "Test.java") + ".java"
+	if strings.HasSuffix(base, "Test"+ext) {
+		name := strings.TrimSuffix(base, "Test"+ext) + ext
 		return filepath.Join(filepath.Dir(testFile), name)
 	}
 
@@ -216,50 +473,157 @@ func (j *JavaAnalyzer) RunTests(projectPath string, testFile string) (bool, stri
 	if isMaven {
 		// Extract test class name
 		className := j.getClassName(testFile)
-		cmd = exec.Command("mvn", "test", "-Dtest="+className)
+		cmd = j.mavenCommand("test", "-Dtest="+className)
 	} else {
-		// Gradle
+		// Gradle, filtered to the single test class
 		className := j.getClassName(testFile)
-		cmd = exec.Command("./gradlew", "test", "--tests", className)
-		if !fileExists(filepath.Join(projectPath, "gradlew")) {
-			cmd = exec.Command("gradle", "test", "--tests", className)
+		cmd = j.gradleCommand(projectPath, "test", "--tests", className)
+	}
+
+	cmd.Dir = projectPath
+	cmd.Env = j.opts.environ(projectPath)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := j.opts.run(cmd)
+	output := stdout.String() + stderr.String()
+
+	return testResult(output, err)
+}
+
+// RunSelectedTests runs only the named test methods of a test class, with
+// Maven's -Dtest=Class#a+b or one Gradle --tests filter per method
+func (j *JavaAnalyzer) RunSelectedTests(projectPath string, testFile string, tests []string) (bool, string, error) {
+	className := j.getClassName(testFile)
+
+	var cmd *exec.Cmd
+	if fileExists(filepath.Join(projectPath, "pom.xml")) {
+		cmd = j.mavenCommand("test", "-Dtest="+className+"#"+strings.Join(tests, "+"))
+	} else {
+		args := []string{"test"}
+		for _, test := range tests {
+			args = append(args, "--tests", className+"."+test)
 		}
+		cmd = j.gradleCommand(projectPath, args...)
 	}
 
 	cmd.Dir = projectPath
+	cmd.Env = j.opts.environ(projectPath)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err := j.opts.run(cmd)
 	output := stdout.String() + stderr.String()
 
-	return err == nil, output, nil
+	return testResult(output, err)
+}
+
+// mavenCommand builds a Maven invocation with the configured flags
+func (j *JavaAnalyzer) mavenCommand(goals ...string) *exec.Cmd {
+	maven := j.opts.Maven
+	var args []string
+
+	if maven.Offline {
+		args = append(args, "-o")
+	}
+	if maven.Quiet {
+		args = append(args, "-q")
+	}
+	if maven.SkipITs {
+		args = append(args, "-DskipITs")
+	}
+	if maven.Settings != "" {
+		args = append(args, "-s", maven.Settings)
+	}
+	args = append(args, maven.Args...)
+
+	return exec.Command("mvn", append(args, goals...)...)
+}
+
+// gradleCommand builds a Gradle invocation that reuses the daemon and, where
+// the Gradle version supports it, the configuration cache. Repeated
+// validation runs then skip JVM startup and build configuration.
+func (j *JavaAnalyzer) gradleCommand(projectPath string, args ...string) *exec.Cmd {
+	gradle := "gradle"
+	if fileExists(filepath.Join(projectPath, "gradlew")) {
+		gradle = "./gradlew"
+	}
+
+	flags := []string{"--daemon"}
+	if j.supportsConfigurationCache(projectPath, gradle) {
+		flags = append(flags, "--configuration-cache", "--configuration-cache-problems=warn")
+	}
+
+	cmd := exec.Command(gradle, append(flags, args...)...)
+	cmd.Dir = projectPath
+	cmd.Env = j.opts.environ(projectPath)
+	return cmd
+}
+
+// supportsConfigurationCache reports whether the project's Gradle is 6.6 or
+// newer, the first release with the --configuration-cache flag
+func (j *JavaAnalyzer) supportsConfigurationCache(projectPath, gradle string) bool {
+	var major, minor int
+	if n, _ := fmt.Sscanf(j.detectGradleVersion(projectPath, gradle), "%d.%d", &major, &minor); n != 2 {
+		return false
+	}
+	return major > 6 || (major == 6 && minor >= 6)
+}
+
+// detectGradleVersion returns the Gradle major.minor version, querying it once
+func (j *JavaAnalyzer) detectGradleVersion(projectPath, gradle string) string {
+	if j.gradleVersion == "" {
+		j.gradleVersion = "unknown"
+
+		cmd := exec.Command(gradle, "--version")
+		cmd.Dir = projectPath
+		cmd.Env = j.opts.environ(projectPath)
+		if output, err := j.opts.output(cmd); err == nil {
+			re := regexp.MustCompile(`Gradle (\d+)\.(\d+)`)
+			if m := re.FindStringSubmatch(string(output)); m != nil {
+				j.gradleVersion = m[1] + "." + m[2]
+			}
+		}
+	}
+	return j.gradleVersion
 }
 
 // getClassName extracts the fully qualified class name from a file path
 func (j *JavaAnalyzer) getClassName(testFile string) string {
 	// Extract package and class name from file path
 	// Example: src/test/java/com/example/FooTest.java -> com.example.FooTest
+	// (likewise for src/test/kotlin/.../FooTest.kt)
+	ext := filepath.Ext(testFile)
 
 	parts := strings.Split(testFile, "/")
 	var packageParts []string
-	inPackage := false
 
-	for _, part := range parts {
-		if part == "java" {
-			inPackage = true
+	// The package starts after the source root, e.g. src/test/java
+	start := -1
+	for i, part := range parts {
+		if part != "java" && part != "kotlin" {
 			continue
 		}
-		if inPackage {
-			if strings.HasSuffix(part, ".java") {
-				part = strings.TrimSuffix(part, ".java")
-			}
-			packageParts = append(packageParts, part)
+		if start < 0 || (i > 0 && (parts[i-1] == "test" || parts[i-1] == "main")) {
+			start = i + 1
 		}
 	}
 
+	if start >= 0 {
+		for _, part := range parts[start:] {
+			packageParts = append(packageParts, strings.TrimSuffix(part, ext))
+		}
+	}
+
+	// Not under a source root: fall back to the simple class name
+	if len(packageParts) == 0 {
+		return strings.TrimSuffix(filepath.Base(testFile), ext)
+	}
+
 	return strings.Join(packageParts, ".")
 }
 
@@ -268,3 +632,62 @@ func (j *JavaAnalyzer) ValidateTestFile(projectPath string, testFile string) (bo
 	// Java requires compilation before running, which is handled by the build tool
 	return j.RunTests(projectPath, testFile)
 }
+
+// CompileTestFile compiles the main and test sources with the build tool
+// (mvn test-compile or gradle testClasses) without running any tests
+func (j *JavaAnalyzer) CompileTestFile(projectPath string, testFile string) (bool, string, error) {
+	var cmd *exec.Cmd
+	if fileExists(filepath.Join(projectPath, "pom.xml")) {
+		cmd = j.mavenCommand("test-compile")
+	} else {
+		cmd = j.gradleCommand(projectPath, "testClasses")
+	}
+
+	cmd.Dir = projectPath
+	cmd.Env = j.opts.environ(projectPath)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := j.opts.run(cmd)
+	output := stdout.String() + stderr.String()
+
+	return testResult(output, err)
+}
+
+// ToolVersions reports the JDK, build tool and JaCoCo plugin versions
+func (j *JavaAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := make(map[string]string)
+	addVersion(versions, "java", commandVersion(projectPath, "java", "-version"))
+
+	// JaCoCo is configured in the build file, so read the version from there
+	var jacocoPattern *regexp.Regexp
+	var buildFile string
+	if fileExists(filepath.Join(projectPath, "pom.xml")) {
+		addVersion(versions, "maven", commandVersion(projectPath, "mvn", "-v"))
+		buildFile = filepath.Join(projectPath, "pom.xml")
+		jacocoPattern = regexp.MustCompile(`(?s)<artifactId>jacoco-maven-plugin</artifactId>\s*<version>([^<]+)</version>`)
+	} else {
+		gradle := "gradle"
+		if fileExists(filepath.Join(projectPath, "gradlew")) {
+			gradle = "./gradlew"
+		}
+		if version := j.detectGradleVersion(projectPath, gradle); version != "unknown" {
+			versions["gradle"] = version
+		}
+		buildFile = filepath.Join(projectPath, "build.gradle")
+		if !fileExists(buildFile) {
+			buildFile = filepath.Join(projectPath, "build.gradle.kts")
+		}
+		jacocoPattern = regexp.MustCompile(`toolVersion\s*=\s*["']([^"']+)["']`)
+	}
+
+	if data, err := os.ReadFile(buildFile); err == nil {
+		if m := jacocoPattern.FindSubmatch(data); m != nil {
+			versions["jacoco"] = string(m[1])
+		}
+	}
+
+	return versions
+}
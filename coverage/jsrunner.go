@@ -0,0 +1,155 @@
+package coverage
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// JavaScript test runners
+const (
+	JSJest   = "jest"   // Jest through the test script
+	JSVitest = "vitest" // vitest run --coverage
+	JSMocha  = "mocha"  // Mocha through the test script, instrumented with nyc
+)
+
+// JavaScript package managers
+const (
+	PackageNpm  = "npm"
+	PackageYarn = "yarn"
+	PackagePnpm = "pnpm"
+	PackageBun  = "bun"
+)
+
+// packageJSON holds the parts of package.json used to detect the toolchain
+type packageJSON struct {
+	PackageManager  string            `json:"packageManager"` // e.g. "pnpm@9.1.0"
+	Scripts         map[string]string `json:"scripts"`
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+}
+
+// readPackageJSON reads a project's package.json, or returns an empty one
+func readPackageJSON(projectPath string) packageJSON {
+	var pkg packageJSON
+	if data, err := os.ReadFile(filepath.Join(projectPath, "package.json")); err == nil {
+		json.Unmarshal(data, &pkg)
+	}
+	return pkg
+}
+
+// dependsOn reports whether a package is a dependency or dev dependency
+func (p packageJSON) dependsOn(name string) bool {
+	_, dep := p.Dependencies[name]
+	_, devDep := p.DevDependencies[name]
+	return dep || devDep
+}
+
+// packageManager detects the project's package manager from the
+// packageManager field of package.json, or else from its lockfile
+func packageManager(projectPath string) string {
+	if name, _, _ := strings.Cut(readPackageJSON(projectPath).PackageManager, "@"); name != "" {
+		switch name {
+		case PackageYarn, PackagePnpm, PackageBun:
+			return name
+		}
+		return PackageNpm
+	}
+
+	switch {
+	case fileExists(filepath.Join(projectPath, "pnpm-lock.yaml")):
+		return PackagePnpm
+	case fileExists(filepath.Join(projectPath, "bun.lockb")), fileExists(filepath.Join(projectPath, "bun.lock")):
+		return PackageBun
+	case fileExists(filepath.Join(projectPath, "yarn.lock")):
+		return PackageYarn
+	}
+	return PackageNpm
+}
+
+// jsRunner detects the project's test runner from its dependencies and
+// test script, defaulting to Jest
+func jsRunner(projectPath string) string {
+	pkg := readPackageJSON(projectPath)
+	script := pkg.Scripts["test"]
+	switch {
+	case pkg.dependsOn("vitest") || strings.Contains(script, "vitest"):
+		return JSVitest
+	case pkg.dependsOn("mocha") || strings.Contains(script, "mocha"):
+		return JSMocha
+	}
+	return JSJest
+}
+
+// jsToolchain is how the tests of a JavaScript project are run
+type jsToolchain struct {
+	manager string // One of the Package constants
+	runner  string // One of the JS runner constants
+}
+
+// detectToolchain returns the package manager and test runner of a project
+func detectToolchain(projectPath string) jsToolchain {
+	return jsToolchain{manager: packageManager(projectPath), runner: jsRunner(projectPath)}
+}
+
+// script runs a package.json script with extra arguments
+func (t jsToolchain) script(name string, args ...string) []string {
+	switch t.manager {
+	case PackageYarn, PackagePnpm:
+		return append([]string{t.manager, name}, args...)
+	case PackageBun:
+		// bun test is Bun's own test runner, not the script
+		return append([]string{"bun", "run", name}, args...)
+	}
+	return append([]string{"npm", "run", name, "--"}, args...)
+}
+
+// tool runs a locally installed package binary without installing it
+func (t jsToolchain) tool(bin string, args ...string) []string {
+	switch t.manager {
+	case PackageYarn:
+		return append([]string{"yarn", bin}, args...)
+	case PackagePnpm:
+		return append([]string{"pnpm", "exec", bin}, args...)
+	case PackageBun:
+		return append([]string{"bun", "x", bin}, args...)
+	}
+	return append([]string{"npx", "--no-install", bin}, args...)
+}
+
+// coverageArgs returns the command that runs the whole suite with coverage,
+// writing coverage-final.json to coverageDir
+func (t jsToolchain) coverageArgs(coverageDir string) []string {
+	switch t.runner {
+	case JSVitest:
+		return t.tool("vitest", "run", "--coverage", "--coverage.reporter=json", "--coverage.reporter=text",
+			"--coverage.reportsDirectory="+coverageDir)
+	case JSMocha:
+		// nyc instruments the processes of the test script
+		return t.tool("nyc", append([]string{"--reporter=json", "--reporter=text", "--report-dir=" + coverageDir},
+			t.script("test")...)...)
+	}
+	return t.script("test", "--coverage", "--coverageReporters=json", "--coverageReporters=text",
+		"--coverageDirectory="+coverageDir)
+}
+
+// testArgs returns the command that runs a single test file
+func (t jsToolchain) testArgs(testFile string) []string {
+	switch t.runner {
+	case JSVitest:
+		return t.tool("vitest", "run", testFile)
+	case JSMocha:
+		return t.tool("mocha", testFile)
+	}
+	return t.script("test", testFile)
+}
+
+// command builds a command from arguments returned by the toolchain
+func (t *TypeScriptAnalyzer) command(projectPath string, args []string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = projectPath
+	cmd.Env = t.opts.environ(projectPath)
+	return cmd
+}
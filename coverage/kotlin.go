@@ -0,0 +1,56 @@
+package coverage
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// KotlinAnalyzer implements coverage analysis for Kotlin projects. Kotlin
+// builds with the same Maven/Gradle and JaCoCo tooling as Java, so it reuses
+// the Java analyzer with Kotlin source and test conventions.
+type KotlinAnalyzer struct {
+	JavaAnalyzer
+}
+
+// DetectLanguage checks if this is a Kotlin project: a Maven or Gradle build
+// with more Kotlin than Java sources
+func (k *KotlinAnalyzer) DetectLanguage(projectPath string) bool {
+	indicators := []string{"pom.xml", "build.gradle", "build.gradle.kts"}
+	hasBuild := false
+	for _, indicator := range indicators {
+		if fileExists(filepath.Join(projectPath, indicator)) {
+			hasBuild = true
+			break
+		}
+	}
+	if !hasBuild {
+		return false
+	}
+
+	kotlinFiles := countFilesWithExtension(projectPath, []string{".kt"})
+	return kotlinFiles > 0 && kotlinFiles >= countFilesWithExtension(projectPath, []string{".java"})
+}
+
+// ToolVersions reports the Java tool versions plus the Kotlin plugin version
+func (k *KotlinAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := k.JavaAnalyzer.ToolVersions(projectPath)
+
+	kotlinPattern := regexp.MustCompile(`kotlin\("jvm"\)\s*version\s*"([^"]+)"|org\.jetbrains\.kotlin\.jvm["']\)?\s*version\s*["']([^"']+)["']|<kotlin\.version>([^<]+)</kotlin\.version>`)
+	for _, buildFile := range []string{"build.gradle.kts", "build.gradle", "pom.xml"} {
+		data, err := os.ReadFile(filepath.Join(projectPath, buildFile))
+		if err != nil {
+			continue
+		}
+		if m := kotlinPattern.FindSubmatch(data); m != nil {
+			for _, group := range m[1:] {
+				if len(group) > 0 {
+					versions["kotlin"] = string(group)
+				}
+			}
+			break
+		}
+	}
+
+	return versions
+}
@@ -0,0 +1,168 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// llvmCovExport is the JSON written by llvm-cov export
+type llvmCovExport struct {
+	Data []struct {
+		Files  []json.RawMessage `json:"files"`
+		Totals llvmCovSummary    `json:"totals"`
+	} `json:"data"`
+}
+
+// llvmCovSummary holds the line counts of a file or of the whole export
+type llvmCovSummary struct {
+	Lines struct {
+		Count   int     `json:"count"`
+		Covered int     `json:"covered"`
+		Percent float64 `json:"percent"`
+	} `json:"lines"`
+}
+
+// llvmCovFile is the coverage of one file in an llvm-cov export
+type llvmCovFile struct {
+	Filename string              `json:"filename"`
+	Segments [][]json.RawMessage `json:"segments"` // [line, column, count, hasCount, isRegionEntry, isGapRegion]
+	Summary  llvmCovSummary      `json:"summary"`
+}
+
+// llvmCovSegment is the start of a coverage region, or the end of one when
+// it has no count
+type llvmCovSegment struct {
+	line        int
+	count       int64
+	hasCount    bool
+	regionEntry bool
+	gap         bool
+}
+
+// parseLLVMCovJSON parses an llvm-cov export into a report. File entries
+// that can't be decoded are skipped and recorded in the report's
+// ParseErrors.
+func parseLLVMCovJSON(projectPath, filename string, report *CoverageReport) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var export llvmCovExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return err
+	}
+	if len(export.Data) == 0 {
+		return fmt.Errorf("no coverage data in %s", filename)
+	}
+
+	for _, entry := range export.Data[0].Files {
+		var file llvmCovFile
+		if err := json.Unmarshal(entry, &file); err != nil {
+			report.skipEntry(projectPath, filename, "file entry", err)
+			continue
+		}
+		segments, err := llvmCovSegments(file.Segments)
+		if err != nil {
+			report.skipEntry(projectPath, filename, file.Filename, err)
+			continue
+		}
+
+		name := relativeToProject(projectPath, file.Filename)
+		report.FileCoverage[name] = file.Summary.Lines.Percent
+		report.setTotalLines(name, file.Summary.Lines.Count)
+
+		uncovered, executed := llvmCovLines(segments)
+		report.setCoveredLines(name, executed)
+		if len(uncovered) > 0 {
+			report.UncoveredFiles = append(report.UncoveredFiles, name)
+			report.UncoveredLines[name] = uncovered
+		}
+	}
+
+	report.TotalCoverage = export.Data[0].Totals.Lines.Percent
+	return report.checkUsable(filename)
+}
+
+// llvmCovSegments decodes the segments of a file
+func llvmCovSegments(raw [][]json.RawMessage) ([]llvmCovSegment, error) {
+	segments := make([]llvmCovSegment, 0, len(raw))
+	for _, fields := range raw {
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("segment has %d fields, want at least 5", len(fields))
+		}
+		var segment llvmCovSegment
+		if err := json.Unmarshal(fields[0], &segment.line); err != nil {
+			return nil, fmt.Errorf("invalid segment line: %w", err)
+		}
+		if err := json.Unmarshal(fields[2], &segment.count); err != nil {
+			return nil, fmt.Errorf("invalid segment count: %w", err)
+		}
+		segment.hasCount = llvmCovFlag(fields[3])
+		segment.regionEntry = llvmCovFlag(fields[4])
+		if len(fields) > 5 {
+			segment.gap = llvmCovFlag(fields[5])
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// llvmCovFlag decodes a segment flag, written as a boolean or, by older
+// versions, as 0 or 1
+func llvmCovFlag(field json.RawMessage) bool {
+	value := string(field)
+	return value == "true" || value == "1"
+}
+
+// llvmCovLines computes the uncovered and executed lines of a file from its
+// segments, the way llvm-cov's line coverage does: a line counts if a
+// region starts on it or a region from an earlier line spans it, and its
+// count is the highest of those regions
+func llvmCovLines(segments []llvmCovSegment) (uncovered, executed []int) {
+	if len(segments) == 0 {
+		return nil, nil
+	}
+
+	var wrapped *llvmCovSegment
+	next := 0
+	for line := segments[0].line; line <= segments[len(segments)-1].line; line++ {
+		start := next
+		for next < len(segments) && segments[next].line == line {
+			next++
+		}
+		lineSegments := segments[start:next]
+
+		regions := 0
+		for _, s := range lineSegments {
+			if s.hasCount && s.regionEntry && !s.gap {
+				regions++
+			}
+		}
+		skipped := len(lineSegments) > 0 && !lineSegments[0].hasCount && lineSegments[0].regionEntry
+		mapped := !skipped && ((wrapped != nil && wrapped.hasCount) || regions > 0)
+
+		if mapped {
+			var count int64
+			if wrapped != nil {
+				count = wrapped.count
+			}
+			for _, s := range lineSegments {
+				if s.hasCount && s.regionEntry && !s.gap && s.count > count {
+					count = s.count
+				}
+			}
+			if count > 0 {
+				executed = append(executed, line)
+			} else {
+				uncovered = append(uncovered, line)
+			}
+		}
+
+		if len(lineSegments) > 0 {
+			wrapped = &segments[next-1]
+		}
+	}
+	return uncovered, executed
+}
@@ -0,0 +1,199 @@
+package coverage
+
+import (
+	"math"
+	"path/filepath"
+	"sort"
+)
+
+// MergeReports combines reports on the same code, e.g. from unit and
+// integration test runs: a line is covered if any report covers it. Files
+// and totals are recomputed from line counts, estimated from the coverage
+// percentage for tools that don't report them.
+func MergeReports(base *CoverageReport, others ...*CoverageReport) *CoverageReport {
+	reports := append([]*CoverageReport{base}, others...)
+
+	merged := &CoverageReport{
+		FileCoverage:   make(map[string]float64),
+		UncoveredFiles: []string{},
+		UncoveredLines: make(map[string][]int),
+		Language:       base.Language,
+	}
+
+	// Reports may spell the same path differently
+	files := make(map[string]string) // Cleaned path -> path used in the merged report
+	for _, report := range reports {
+		for file := range report.FileCoverage {
+			key := filepath.ToSlash(filepath.Clean(file))
+			if _, ok := files[key]; !ok {
+				files[key] = file
+			}
+		}
+		merged.Exclusions = append(merged.Exclusions, report.Exclusions...)
+		merged.ParseErrors = append(merged.ParseErrors, report.ParseErrors...)
+	}
+
+	totalLines, coveredLines := 0, 0
+	maxTotal := 0.0
+	for key, file := range files {
+		var uncovered map[int]bool
+		executed := make(map[int]bool)
+		var branches []Branch
+		total := 0
+		fileCoverage := 0.0
+		branchVotes := 0
+
+		for _, report := range reports {
+			name, ok := report.lookup(key)
+			if !ok {
+				continue
+			}
+
+			// Intersect uncovered lines
+			lines := make(map[int]bool)
+			for _, line := range report.UncoveredLines[name] {
+				if uncovered == nil || uncovered[line] {
+					lines[line] = true
+				}
+			}
+			uncovered = lines
+
+			// Lines executed by any report are covered
+			for _, line := range report.CoveredLines[name] {
+				executed[line] = true
+			}
+
+			// Keep branch arms that every report with branch data misses
+			if report.UncoveredBranches != nil {
+				if branchVotes == 0 {
+					branches = report.UncoveredBranches[name]
+				} else {
+					branches = commonBranches(branches, report.UncoveredBranches[name])
+				}
+				branchVotes++
+			}
+
+			total = max(total, report.lineTotal(name))
+			fileCoverage = math.Max(fileCoverage, report.FileCoverage[name])
+		}
+
+		if total > 0 {
+			fileCoverage = float64(total-len(uncovered)) / float64(total) * 100
+			totalLines += total
+			coveredLines += total - len(uncovered)
+			merged.setTotalLines(file, total)
+		}
+		merged.FileCoverage[file] = fileCoverage
+
+		lines := make([]int, 0, len(executed))
+		for line := range executed {
+			lines = append(lines, line)
+		}
+		merged.setCoveredLines(file, uniqueLines(lines))
+
+		if len(uncovered) > 0 {
+			lines := make([]int, 0, len(uncovered))
+			for line := range uncovered {
+				lines = append(lines, line)
+			}
+			sort.Ints(lines)
+			merged.UncoveredFiles = append(merged.UncoveredFiles, file)
+			merged.UncoveredLines[file] = lines
+		}
+		for _, branch := range branches {
+			merged.addUncoveredBranch(file, branch)
+		}
+		for _, method := range mergeMethods(reports, key) {
+			merged.addMethod(file, method)
+		}
+	}
+
+	for _, report := range reports {
+		maxTotal = math.Max(maxTotal, report.TotalCoverage)
+	}
+	merged.TotalCoverage = maxTotal
+	if totalLines > 0 {
+		merged.TotalCoverage = float64(coveredLines) / float64(totalLines) * 100
+	}
+
+	sort.Strings(merged.UncoveredFiles)
+	return merged
+}
+
+// lookup finds a file in the report by its cleaned path
+func (r *CoverageReport) lookup(key string) (string, bool) {
+	if _, ok := r.FileCoverage[key]; ok {
+		return key, true
+	}
+	for file := range r.FileCoverage {
+		if filepath.ToSlash(filepath.Clean(file)) == key {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// lineTotal returns the number of instrumented lines of a file, estimated
+// from its coverage percentage and uncovered lines if the tool didn't
+// report it. It returns 0 if the count is unknown.
+func (r *CoverageReport) lineTotal(file string) int {
+	if total, ok := r.TotalLines[file]; ok {
+		return total
+	}
+	uncovered := len(r.UncoveredLines[file])
+	pct := r.FileCoverage[file]
+	if uncovered == 0 || pct >= 100 {
+		return 0
+	}
+	return int(math.Round(float64(uncovered) * 100 / (100 - pct)))
+}
+
+// mergeMethods combines the method coverage of a file across reports: a
+// method's lines and branches are as covered as in the report covering
+// them most
+func mergeMethods(reports []*CoverageReport, key string) []Method {
+	type methodKey struct {
+		class, name string
+		line        int
+	}
+	var methods []Method
+	index := make(map[methodKey]int)
+	for _, report := range reports {
+		name, ok := report.lookup(key)
+		if !ok {
+			continue
+		}
+		for _, method := range report.Methods[name] {
+			k := methodKey{method.Class, method.Name, method.Line}
+			i, seen := index[k]
+			if !seen {
+				index[k] = len(methods)
+				methods = append(methods, method)
+				continue
+			}
+			if method.CoveredLines > methods[i].CoveredLines {
+				methods[i].CoveredLines, methods[i].MissedLines = method.CoveredLines, method.MissedLines
+			}
+			if method.CoveredBranches > methods[i].CoveredBranches {
+				methods[i].CoveredBranches, methods[i].MissedBranches = method.CoveredBranches, method.MissedBranches
+			}
+		}
+	}
+	return methods
+}
+
+// commonBranches returns the branch arms present in both lists
+func commonBranches(a, b []Branch) []Branch {
+	inB := make(map[Branch]bool, len(b))
+	for _, branch := range b {
+		inB[branch] = true
+	}
+
+	var common []Branch
+	for _, branch := range a {
+		if inB[branch] {
+			common = append(common, branch)
+		}
+	}
+	return common
+}
@@ -0,0 +1,60 @@
+package coverage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tablev/test-coverage-agent/cache"
+)
+
+// ParseError is a report entry that couldn't be parsed and was skipped
+type ParseError struct {
+	Entry  string `json:"entry"`            // File or element of the report that was skipped
+	Error  string `json:"error"`            // Why it couldn't be parsed
+	Report string `json:"report,omitempty"` // Copy of the raw report kept in the cache
+}
+
+// skipEntry records a report entry that couldn't be parsed. The raw report
+// is copied into the cache so the entry can be inspected after the tool has
+// overwritten it.
+func (r *CoverageReport) skipEntry(projectPath, reportFile, entry string, err error) {
+	r.ParseErrors = append(r.ParseErrors, ParseError{
+		Entry:  entry,
+		Error:  err.Error(),
+		Report: preserveReport(projectPath, reportFile),
+	})
+}
+
+// checkUsable fails a report that kept no data after skipping entries, so
+// a broken report isn't mistaken for a project without coverage
+func (r *CoverageReport) checkUsable(reportFile string) error {
+	if len(r.ParseErrors) == 0 || len(r.FileCoverage) > 0 {
+		return nil
+	}
+	last := r.ParseErrors[len(r.ParseErrors)-1]
+	msg := fmt.Sprintf("no usable coverage data in %s (%d entries skipped, last: %s: %s)",
+		reportFile, len(r.ParseErrors), last.Entry, last.Error)
+	if last.Report != "" {
+		msg += ", raw report kept at " + last.Report
+	}
+	return errors.New(msg)
+}
+
+// preserveReport copies a report file into the cache's logs and returns the
+// copy's path, or "" if it couldn't be copied. Copies are named by content,
+// so a report is kept once however many of its entries fail.
+func preserveReport(projectPath, reportFile string) string {
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		return ""
+	}
+
+	c := cache.New(projectPath)
+	name := fmt.Sprintf("coverage-report-%s-%s", cache.Key(string(data))[:12], filepath.Base(reportFile))
+	if err := c.Put(cache.Logs, name, data); err != nil {
+		return ""
+	}
+	return c.Path(cache.Logs, name)
+}
@@ -0,0 +1,177 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// Plugin configures an external analyzer: a program implementing the
+// Analyzer contract over JSON on stdin and stdout
+type Plugin struct {
+	Name     string   `json:"name"`     // Name used in messages
+	Language string   `json:"language"` // Language name; asked from the plugin if empty
+	Command  []string `json:"command"`  // Program and arguments, relative paths resolved against the project
+}
+
+// pluginRequest is written to a plugin's stdin, one per invocation
+type pluginRequest struct {
+	Method      string `json:"method"`
+	ProjectPath string `json:"project_path"`
+	SourceFile  string `json:"source_file,omitempty"`
+	TestFile    string `json:"test_file,omitempty"`
+}
+
+// pluginResponse is read from a plugin's stdout. Each method fills in the
+// fields it returns; a non-empty Error fails the call.
+type pluginResponse struct {
+	Detected bool              `json:"detected"`
+	Language string            `json:"language"`
+	Report   *CoverageReport   `json:"report"`
+	Path     string            `json:"path"`
+	Success  bool              `json:"success"`
+	Output   string            `json:"output"`
+	Versions map[string]string `json:"versions"`
+	Error    string            `json:"error"`
+}
+
+// PluginAnalyzer adapts an external analyzer program to the Analyzer
+// interface. The program is started once per call.
+type PluginAnalyzer struct {
+	plugin Plugin
+	opts   Options
+
+	projectPath string // Last project seen, for calls that don't take one
+}
+
+// NewPluginAnalyzer creates an analyzer backed by a plugin program
+func NewPluginAnalyzer(plugin Plugin, opts Options) *PluginAnalyzer {
+	return &PluginAnalyzer{plugin: plugin, opts: opts}
+}
+
+// DetectLanguage asks the plugin whether it handles the project
+func (p *PluginAnalyzer) DetectLanguage(projectPath string) bool {
+	p.projectPath = projectPath
+	resp, err := p.call(pluginRequest{Method: "detect_language", ProjectPath: projectPath})
+	return err == nil && resp.Detected
+}
+
+// GetLanguageName returns the configured language, or asks the plugin
+func (p *PluginAnalyzer) GetLanguageName() string {
+	if p.plugin.Language == "" {
+		resp, err := p.call(pluginRequest{Method: "language_name", ProjectPath: p.projectPath})
+		if err != nil || resp.Language == "" {
+			return p.plugin.Name
+		}
+		p.plugin.Language = resp.Language
+	}
+	return p.plugin.Language
+}
+
+// RunCoverage asks the plugin to run the test suite with coverage
+func (p *PluginAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
+	p.projectPath = projectPath
+	resp, err := p.call(pluginRequest{Method: "run_coverage", ProjectPath: projectPath})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Report == nil {
+		return nil, fmt.Errorf("plugin %s returned no coverage report", p.plugin.Name)
+	}
+
+	report := resp.Report
+	if report.FileCoverage == nil {
+		report.FileCoverage = make(map[string]float64)
+	}
+	if report.UncoveredFiles == nil {
+		report.UncoveredFiles = []string{}
+	}
+	if report.UncoveredLines == nil {
+		report.UncoveredLines = make(map[string][]int)
+	}
+	report.Language = p.GetLanguageName()
+
+	return report, nil
+}
+
+// GetTestFilePath asks the plugin for the test file of a source file
+func (p *PluginAnalyzer) GetTestFilePath(sourceFile string) string {
+	resp, err := p.call(pluginRequest{Method: "test_file_path", ProjectPath: p.projectPath, SourceFile: sourceFile})
+	if err != nil {
+		return ""
+	}
+	return resp.Path
+}
+
+// GetSourceFileForTest asks the plugin for the source file of a test file
+func (p *PluginAnalyzer) GetSourceFileForTest(testFile string) string {
+	resp, err := p.call(pluginRequest{Method: "source_file_for_test", ProjectPath: p.projectPath, TestFile: testFile})
+	if err != nil {
+		return ""
+	}
+	return resp.Path
+}
+
+// RunTests asks the plugin to run a specific test file
+func (p *PluginAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
+	resp, err := p.call(pluginRequest{Method: "run_tests", ProjectPath: projectPath, TestFile: testFile})
+	if err != nil {
+		return false, "", err
+	}
+	return resp.Success, resp.Output, nil
+}
+
+// ValidateTestFile asks the plugin to check that a test file compiles and runs
+func (p *PluginAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
+	resp, err := p.call(pluginRequest{Method: "validate_test_file", ProjectPath: projectPath, TestFile: testFile})
+	if err != nil {
+		return false, "", err
+	}
+	return resp.Success, resp.Output, nil
+}
+
+// ToolVersions asks the plugin for the versions of the tools it uses
+func (p *PluginAnalyzer) ToolVersions(projectPath string) map[string]string {
+	resp, err := p.call(pluginRequest{Method: "tool_versions", ProjectPath: projectPath})
+	if err != nil || resp.Versions == nil {
+		return map[string]string{}
+	}
+	return resp.Versions
+}
+
+// call runs the plugin program with a request on stdin and decodes its
+// response from stdout
+func (p *PluginAnalyzer) call(req pluginRequest) (*pluginResponse, error) {
+	if len(p.plugin.Command) == 0 {
+		return nil, fmt.Errorf("plugin %s has no command", p.plugin.Name)
+	}
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode plugin request: %w", err)
+	}
+
+	cmd := exec.Command(p.plugin.Command[0], p.plugin.Command[1:]...)
+	cmd.Dir = req.ProjectPath
+	cmd.Env = p.opts.environ(req.ProjectPath)
+	cmd.Stdin = bytes.NewReader(input)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := p.opts.run(cmd); err != nil {
+		return nil, fmt.Errorf("plugin %s failed on %s: %w\n%s", p.plugin.Name, req.Method, err, stderr.String())
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode plugin %s response to %s: %w", p.plugin.Name, req.Method, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", p.plugin.Name, resp.Error)
+	}
+
+	return &resp, nil
+}
@@ -8,10 +8,13 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // PythonAnalyzer implements coverage analysis for Python projects
-type PythonAnalyzer struct{}
+type PythonAnalyzer struct {
+	opts Options
+}
 
 // DetectLanguage checks if this is a Python project
 func (p *PythonAnalyzer) DetectLanguage(projectPath string) bool {
@@ -33,7 +36,7 @@ func (p *PythonAnalyzer) GetLanguageName() string {
 	return "Python"
 }
 
-// RunCoverage executes pytest with coverage
+// RunCoverage runs the tests with coverage through the project's runner
 func (p *PythonAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
 	// Try to use pytest-cov if available, fall back to coverage.py
 	report := &CoverageReport{
@@ -43,33 +46,62 @@ func (p *PythonAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error
 		Language:       "Python",
 	}
 
-	// Run pytest with coverage
-	cmd := exec.Command("pytest", "--cov=.", "--cov-report=json", "--cov-report=term")
-	cmd.Dir = projectPath
+	// In Pants repositories coverage runs through the test targets
+	if runner := p.opts.taskRunner(projectPath); runner.python() {
+		reports, output, err := runner.coverage(projectPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(reports) == 0 {
+			return nil, fmt.Errorf("%s test run wrote no coverage report\n%s", runner.name, output)
+		}
+		if err := p.parseCoverageJSON(reports[0], report); err != nil {
+			return nil, fmt.Errorf("failed to parse coverage: %w", err)
+		}
+		return report, nil
+	}
+
+	coverageFile, err := coverageArtifact(projectPath, "coverage.json")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(coverageFile) // Don't parse a stale report if the run fails
+
+	// Run the tests with coverage
+	runner := p.runner(projectPath)
+	cmd := p.coverageCommand(projectPath, runner, coverageFile)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	_ = cmd.Run() // Ignore error, tests might fail but we can still get coverage
-
-	// Parse JSON coverage report
-	coverageFile := filepath.Join(projectPath, "coverage.json")
-	defer os.Remove(coverageFile)
+	started := time.Now()
+	// Failing tests still write coverage, but a missing runner writes none
+	if err := runFailed(p.opts.run(cmd)); err != nil {
+		return nil, err
+	}
 
-	if fileExists(coverageFile) {
-		if err := p.parseCoverageJSON(coverageFile, report); err != nil {
+	if reportFile := p.harvestCoverage(projectPath, runner, coverageFile, started); reportFile != "" {
+		if err := p.parseCoverageJSON(reportFile, report); err != nil {
 			return nil, fmt.Errorf("failed to parse coverage: %w", err)
 		}
+	} else if runner != PythonPytest || len(p.opts.Python.Command) > 0 {
+		return nil, fmt.Errorf("%s run left no coverage data\n%s", strings.Join(cmd.Args, " "), stdout.String()+stderr.String())
 	} else {
 		// Try alternative: coverage run + coverage json
-		cmd = exec.Command("coverage", "run", "-m", "pytest")
+		runArgs := []string{"run"}
+		if p.opts.BranchCoverage {
+			runArgs = append(runArgs, "--branch")
+		}
+		cmd = exec.Command("coverage", append(runArgs, "-m", "pytest")...)
 		cmd.Dir = projectPath
-		cmd.Run()
+		cmd.Env = p.opts.environ(projectPath)
+		p.opts.run(cmd)
 
-		cmd = exec.Command("coverage", "json")
+		cmd = exec.Command("coverage", "json", "-o", coverageFile)
 		cmd.Dir = projectPath
-		if err := cmd.Run(); err == nil {
+		cmd.Env = p.opts.environ(projectPath)
+		if err := p.opts.run(cmd); err == nil {
 			if fileExists(coverageFile) {
 				p.parseCoverageJSON(coverageFile, report)
 			}
@@ -93,8 +125,11 @@ func (p *PythonAnalyzer) parseCoverageJSON(filename string, report *CoverageRepo
 		Files map[string]struct {
 			Summary struct {
 				PercentCovered float64 `json:"percent_covered"`
+				NumStatements  int     `json:"num_statements"`
 			} `json:"summary"`
-			MissingLines []int `json:"missing_lines"`
+			ExecutedLines   []int   `json:"executed_lines"`
+			MissingLines    []int   `json:"missing_lines"`
+			MissingBranches [][]int `json:"missing_branches"` // [from, to], negative "to" means exit
 		} `json:"files"`
 	}
 
@@ -106,10 +141,23 @@ func (p *PythonAnalyzer) parseCoverageJSON(filename string, report *CoverageRepo
 
 	for filename, fileCov := range coverage.Files {
 		report.FileCoverage[filename] = fileCov.Summary.PercentCovered
+		report.setTotalLines(filename, fileCov.Summary.NumStatements)
+		report.setCoveredLines(filename, fileCov.ExecutedLines)
 		if len(fileCov.MissingLines) > 0 {
 			report.UncoveredFiles = append(report.UncoveredFiles, filename)
 			report.UncoveredLines[filename] = fileCov.MissingLines
 		}
+
+		for _, arc := range fileCov.MissingBranches {
+			if len(arc) != 2 {
+				continue
+			}
+			detail := fmt.Sprintf("jump to line %d never taken", arc[1])
+			if arc[1] < 0 {
+				detail = "exit from this line never taken"
+			}
+			report.addUncoveredBranch(filename, Branch{Line: arc[0], Detail: detail})
+		}
 	}
 
 	return nil
@@ -146,17 +194,22 @@ func (p *PythonAnalyzer) GetSourceFileForTest(testFile string) string {
 
 // RunTests runs tests for a specific test file
 func (p *PythonAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
-	cmd := exec.Command("pytest", "-v", testFile)
-	cmd.Dir = projectPath
+	if runner := p.opts.taskRunner(projectPath); runner.python() {
+		if passed, output, ok := runner.runTests(projectPath, testFile); ok {
+			return passed, output, nil
+		}
+	}
+
+	cmd := p.testCommand(projectPath, p.runner(projectPath), testFile)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err := p.opts.run(cmd)
 	output := stdout.String() + stderr.String()
 
-	return err == nil, output, nil
+	return testResult(output, err)
 }
 
 // ValidateTestFile validates that a test file runs successfully
@@ -164,3 +217,39 @@ func (p *PythonAnalyzer) ValidateTestFile(projectPath string, testFile string) (
 	// Python doesn't have a separate compile step, just run the tests
 	return p.RunTests(projectPath, testFile)
 }
+
+// CompileTestFile byte-compiles a test file to catch syntax errors
+func (p *PythonAnalyzer) CompileTestFile(projectPath string, testFile string) (bool, string, error) {
+	cmd := exec.Command(pythonExecutable(), "-m", "py_compile", testFile)
+	cmd.Dir = projectPath
+	cmd.Env = p.opts.environ(projectPath)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := p.opts.run(cmd)
+	output := stdout.String() + stderr.String()
+
+	return testResult(output, err)
+}
+
+// ToolVersions reports the Python, pytest and coverage.py versions
+func (p *PythonAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := make(map[string]string)
+	python := commandVersion(projectPath, "python3", "--version")
+	if python == "" {
+		python = commandVersion(projectPath, "python", "--version")
+	}
+	addVersion(versions, "python", python)
+	addVersion(versions, "pytest", commandVersion(projectPath, "pytest", "--version"))
+	addVersion(versions, "coverage", commandVersion(projectPath, "coverage", "--version"))
+	if runner := p.opts.taskRunner(projectPath); runner.python() {
+		addVersion(versions, runner.name, runner.version(projectPath))
+	}
+	switch runner := p.runner(projectPath); runner {
+	case PythonPoetry, PythonTox, PythonNox:
+		addVersion(versions, runner, commandVersion(projectPath, runner, "--version"))
+	}
+	return versions
+}
@@ -0,0 +1,178 @@
+package coverage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Python test runners
+const (
+	PythonPytest   = "pytest"   // pytest with pytest-cov
+	PythonUnittest = "unittest" // python -m unittest under coverage run
+	PythonPoetry   = "poetry"   // pytest in the Poetry environment
+	PythonTox      = "tox"      // tox, passing the pytest-cov arguments as {posargs}
+	PythonNox      = "nox"      // nox, passing the pytest-cov arguments as session.posargs
+)
+
+// PythonRunners lists the supported Python test runners
+var PythonRunners = []string{PythonPytest, PythonUnittest, PythonPoetry, PythonTox, PythonNox}
+
+// PythonOptions configures how the Python analyzer runs tests
+type PythonOptions struct {
+	// Runner is one of PythonRunners; detected if empty
+	Runner string `json:"runner"`
+
+	// Command replaces the runner's coverage command, e.g. a Poetry script
+	// (["poetry", "run", "test-cov"]). The coverage data it leaves behind
+	// (.coverage or coverage.json) is harvested; validation still uses Runner.
+	Command []string `json:"command"`
+}
+
+// CheckPythonRunner validates a configured Python runner
+func CheckPythonRunner(runner string) error {
+	if runner == "" {
+		return nil
+	}
+	for _, known := range PythonRunners {
+		if runner == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown Python runner %q (want %s)", runner, strings.Join(PythonRunners, ", "))
+}
+
+// runner returns the configured Python runner or detects one: Poetry
+// projects run pytest in their environment; without pytest installed, tox,
+// nox or unittest are used, in that order
+func (p *PythonAnalyzer) runner(projectPath string) string {
+	if p.opts.Python.Runner != "" {
+		return p.opts.Python.Runner
+	}
+
+	if data, err := os.ReadFile(filepath.Join(projectPath, "pyproject.toml")); err == nil &&
+		strings.Contains(string(data), "[tool.poetry]") {
+		if _, err := exec.LookPath("poetry"); err == nil {
+			return PythonPoetry
+		}
+	}
+	if _, err := exec.LookPath("pytest"); err == nil {
+		return PythonPytest
+	}
+	if _, err := exec.LookPath("tox"); err == nil && fileExists(filepath.Join(projectPath, "tox.ini")) {
+		return PythonTox
+	}
+	if _, err := exec.LookPath("nox"); err == nil && fileExists(filepath.Join(projectPath, "noxfile.py")) {
+		return PythonNox
+	}
+	return PythonUnittest
+}
+
+// pytestCoverageArgs are the pytest-cov arguments writing a JSON report
+func (p *PythonAnalyzer) pytestCoverageArgs(coverageFile string) []string {
+	args := []string{"--cov=.", "--cov-report=json:" + coverageFile, "--cov-report=term"}
+	if p.opts.BranchCoverage {
+		args = append(args, "--cov-branch")
+	}
+	return args
+}
+
+// command builds a command in the runner's environment: Poetry projects
+// run their tools through poetry run. coverage runs as a module if the
+// coverage script isn't on the PATH.
+func (p *PythonAnalyzer) command(projectPath, runner, name string, args ...string) *exec.Cmd {
+	if name == "coverage" && runner != PythonPoetry {
+		if _, err := exec.LookPath(name); err != nil {
+			name, args = pythonExecutable(), append([]string{"-m", "coverage"}, args...)
+		}
+	}
+
+	cmd := exec.Command(name, args...)
+	if runner == PythonPoetry {
+		cmd = exec.Command("poetry", append([]string{"run", name}, args...)...)
+	}
+	cmd.Dir = projectPath
+	cmd.Env = p.opts.environ(projectPath)
+	return cmd
+}
+
+// coverageCommand returns the command that runs the whole suite under
+// coverage for a runner
+func (p *PythonAnalyzer) coverageCommand(projectPath, runner, coverageFile string) *exec.Cmd {
+	if command := p.opts.Python.Command; len(command) > 0 {
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Dir = projectPath
+		cmd.Env = p.opts.environ(projectPath)
+		return cmd
+	}
+
+	switch runner {
+	case PythonUnittest:
+		args := []string{"run"}
+		if p.opts.BranchCoverage {
+			args = append(args, "--branch")
+		}
+		return p.command(projectPath, runner, "coverage", append(args, "-m", "unittest", "discover")...)
+	case PythonTox, PythonNox:
+		return p.command(projectPath, runner, runner, append([]string{"--"}, p.pytestCoverageArgs(coverageFile)...)...)
+	}
+	return p.command(projectPath, runner, "pytest", p.pytestCoverageArgs(coverageFile)...)
+}
+
+// testCommand returns the command that runs a single test file
+func (p *PythonAnalyzer) testCommand(projectPath, runner, testFile string) *exec.Cmd {
+	switch runner {
+	case PythonUnittest:
+		return p.command(projectPath, runner, pythonExecutable(), "-m", "unittest", "-v", testFile)
+	case PythonTox, PythonNox:
+		return p.command(projectPath, runner, runner, "--", "-v", testFile)
+	}
+	return p.command(projectPath, runner, "pytest", "-v", testFile)
+}
+
+// pythonExecutable returns python3, or python where there is no python3
+func pythonExecutable() string {
+	if _, err := exec.LookPath("python3"); err != nil {
+		return "python"
+	}
+	return "python3"
+}
+
+// harvestCoverage looks for coverage data a run left behind when it didn't
+// write the JSON report itself: a coverage.json in the project, or
+// .coverage data files, which are combined and exported to coverageFile.
+// Only files written since the run started count. It returns the report to
+// parse, or "" if there is none.
+func (p *PythonAnalyzer) harvestCoverage(projectPath, runner, coverageFile string, since time.Time) string {
+	if fileExists(coverageFile) {
+		return coverageFile
+	}
+
+	// File times can lag the clock by the file system's granularity
+	since = since.Add(-time.Second)
+	fresh := func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && !info.ModTime().Before(since)
+	}
+
+	if report := filepath.Join(projectPath, "coverage.json"); fresh(report) {
+		return report
+	}
+
+	// Parallel runs, e.g. tox environments, leave .coverage.<suffix> files
+	parallel, _ := filepath.Glob(filepath.Join(projectPath, ".coverage.*"))
+	if len(parallel) > 0 {
+		p.opts.run(p.command(projectPath, runner, "coverage", "combine"))
+	}
+	if !fresh(filepath.Join(projectPath, ".coverage")) {
+		return ""
+	}
+
+	if err := p.opts.run(p.command(projectPath, runner, "coverage", "json", "-o", coverageFile)); err != nil || !fileExists(coverageFile) {
+		return ""
+	}
+	return coverageFile
+}
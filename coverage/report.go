@@ -0,0 +1,271 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Coverage report formats understood by LoadReport and ParseReport
+const (
+	FormatGo        = "go"        // Go coverprofile
+	FormatLcov      = "lcov"      // lcov tracefile
+	FormatCobertura = "cobertura" // Cobertura XML, written by many tools
+	FormatJaCoCo    = "jacoco"    // JaCoCo XML
+	FormatScoverage = "scoverage" // scoverage XML
+	FormatJSON      = "json"      // coverage.py or Jest JSON summary
+)
+
+// LoadReport builds a coverage report from a report file produced elsewhere,
+// e.g. by CI, instead of running the test suite. Supported formats are Go
+// coverprofiles, lcov tracefiles, Cobertura, JaCoCo and scoverage XML,
+// coverage.py JSON and Jest JSON summaries; the format is detected from the
+// file contents.
+func LoadReport(projectPath, reportFile string, analyzer Analyzer, opts Options) (*CoverageReport, error) {
+	return ParseReport(projectPath, reportFile, "", analyzer, opts)
+}
+
+// ParseReport builds a coverage report from a report file in the given
+// format, or in the format detected from its contents if format is empty
+func ParseReport(projectPath, reportFile, format string, analyzer Analyzer, opts Options) (*CoverageReport, error) {
+	reportFile, err := filepath.Abs(reportFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve coverage report path: %w", err)
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read coverage report: %w", err)
+	}
+
+	report := &CoverageReport{
+		FileCoverage:   make(map[string]float64),
+		UncoveredFiles: []string{},
+		UncoveredLines: make(map[string][]int),
+		Language:       analyzer.GetLanguageName(),
+	}
+
+	content := bytes.TrimSpace(data)
+	if format == "" {
+		format = detectReportFormat(content)
+	}
+
+	switch format {
+	case FormatGo:
+		report, err = (&GoAnalyzer{opts: opts}).loadCoverageFile(projectPath, reportFile)
+
+	case FormatLcov:
+		lines, branches := parseLcov(bytes.NewReader(content))
+		addLineCounts(report, lines, branches, opts.BranchCoverage, func(file string) (string, bool) {
+			return relativeToProject(projectPath, file), true
+		})
+
+	case FormatCobertura:
+		err = parseCobertura(projectPath, content, report, opts.BranchCoverage)
+
+	case FormatScoverage:
+		scala := &ScalaAnalyzer{opts: opts}
+		var statements, invoked int
+		statements, invoked, err = scala.parseScoverageXML(projectPath, reportFile, report)
+		if statements > 0 {
+			report.TotalCoverage = float64(invoked) / float64(statements) * 100
+		}
+
+	case FormatJaCoCo:
+		if commands, ok := analyzer.(*commandAnalyzer); ok {
+			analyzer = commands.Analyzer
+		}
+		java, ok := analyzer.(*JavaAnalyzer)
+		if kotlin, isKotlin := analyzer.(*KotlinAnalyzer); isKotlin {
+			java, ok = &kotlin.JavaAnalyzer, true
+		}
+		if !ok {
+			java = &JavaAnalyzer{opts: opts}
+		}
+		err = java.parseJaCoCoXML(projectPath, reportFile, report)
+
+	case FormatJSON:
+		var probe struct {
+			Totals json.RawMessage `json:"totals"`
+		}
+		if err = json.Unmarshal(content, &probe); err != nil {
+			break
+		}
+		if probe.Totals != nil {
+			err = (&PythonAnalyzer{opts: opts}).parseCoverageJSON(reportFile, report)
+		} else {
+			err = (&TypeScriptAnalyzer{opts: opts}).parseCoverageJSON(projectPath, reportFile, report)
+		}
+
+	case "":
+		return nil, fmt.Errorf("unrecognized coverage report format in %s", reportFile)
+
+	default:
+		return nil, fmt.Errorf("unsupported coverage report format %q", format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse coverage report %s: %w", reportFile, err)
+	}
+
+	report.Language = analyzer.GetLanguageName()
+	sort.Strings(report.UncoveredFiles)
+	return report, nil
+}
+
+// detectReportFormat guesses a report's format from its contents, or
+// returns "" if it isn't recognized
+func detectReportFormat(content []byte) string {
+	switch {
+	case bytes.HasPrefix(content, []byte("mode:")):
+		return FormatGo
+	case bytes.HasPrefix(content, []byte("TN:")) || bytes.HasPrefix(content, []byte("SF:")):
+		return FormatLcov
+	case bytes.HasPrefix(content, []byte("<")) && bytes.Contains(content, []byte("<scoverage")):
+		return FormatScoverage
+	case bytes.HasPrefix(content, []byte("<")) && bytes.Contains(content, []byte("<coverage")) && bytes.Contains(content, []byte("line-rate")):
+		return FormatCobertura
+	case bytes.HasPrefix(content, []byte("<")):
+		return FormatJaCoCo
+	case bytes.HasPrefix(content, []byte("{")):
+		return FormatJSON
+	}
+	return ""
+}
+
+// parseCobertura parses a Cobertura XML report. Class file names are
+// relative to one of the report's source directories.
+func parseCobertura(projectPath string, data []byte, report *CoverageReport, branchCoverage bool) error {
+	var cobertura struct {
+		Sources  []string `xml:"sources>source"`
+		Packages []struct {
+			Classes []struct {
+				Filename string `xml:"filename,attr"`
+				Lines    []struct {
+					Number            int    `xml:"number,attr"`
+					Hits              int    `xml:"hits,attr"`
+					Branch            bool   `xml:"branch,attr"`
+					ConditionCoverage string `xml:"condition-coverage,attr"`
+				} `xml:"lines>line"`
+			} `xml:"classes>class"`
+		} `xml:"packages>package"`
+	}
+
+	if err := xml.Unmarshal(data, &cobertura); err != nil {
+		return err
+	}
+
+	lines := make(map[string]map[int]int)
+	branches := make(map[string][]Branch)
+	for _, pkg := range cobertura.Packages {
+		for _, class := range pkg.Classes {
+			file := resolveCoberturaFile(projectPath, cobertura.Sources, class.Filename)
+			if lines[file] == nil {
+				lines[file] = make(map[int]int)
+			}
+			for _, line := range class.Lines {
+				lines[file][line.Number] += line.Hits
+				if line.Branch && line.ConditionCoverage != "" && !strings.HasPrefix(line.ConditionCoverage, "100%") {
+					branches[file] = append(branches[file], Branch{
+						Line:   line.Number,
+						Detail: "condition coverage " + line.ConditionCoverage,
+					})
+				}
+			}
+		}
+	}
+
+	addLineCounts(report, lines, branches, branchCoverage, func(file string) (string, bool) {
+		return file, true
+	})
+	return nil
+}
+
+// resolveCoberturaFile returns a class file's path relative to the project,
+// using the first source directory that contains it
+func resolveCoberturaFile(projectPath string, sources []string, filename string) string {
+	if filepath.IsAbs(filename) {
+		return relativeToProject(projectPath, filename)
+	}
+
+	for _, source := range sources {
+		source = strings.TrimSpace(source)
+		candidate := filepath.Join(source, filename)
+		if !filepath.IsAbs(candidate) {
+			candidate, _ = filepath.Abs(filepath.Join(projectPath, candidate))
+		}
+		if fileExists(candidate) {
+			return relativeToProject(projectPath, candidate)
+		}
+	}
+
+	return filename
+}
+
+// addLineCounts adds per-line hit counts to a report and computes the total
+// line coverage. resolve maps each file to the path used in the report, or
+// returns false to leave the file out.
+func addLineCounts(report *CoverageReport, lines map[string]map[int]int, branches map[string][]Branch, branchCoverage bool, resolve func(file string) (string, bool)) {
+	totalLines, coveredLines := 0, 0
+	for file, counts := range lines {
+		relPath, ok := resolve(file)
+		if !ok || len(counts) == 0 {
+			continue
+		}
+
+		var uncovered, executed []int
+		for line, count := range counts {
+			if count == 0 {
+				uncovered = append(uncovered, line)
+			} else {
+				executed = append(executed, line)
+			}
+		}
+		sort.Ints(uncovered)
+		sort.Ints(executed)
+
+		covered := len(counts) - len(uncovered)
+		totalLines += len(counts)
+		coveredLines += covered
+		report.FileCoverage[relPath] = float64(covered) / float64(len(counts)) * 100
+		report.setTotalLines(relPath, len(counts))
+		report.setCoveredLines(relPath, executed)
+
+		if len(uncovered) > 0 {
+			report.UncoveredFiles = append(report.UncoveredFiles, relPath)
+			report.UncoveredLines[relPath] = uncovered
+		}
+
+		if branchCoverage {
+			for _, branch := range branches[file] {
+				report.addUncoveredBranch(relPath, branch)
+			}
+		}
+	}
+
+	sort.Strings(report.UncoveredFiles)
+	if totalLines > 0 {
+		report.TotalCoverage = float64(coveredLines) / float64(totalLines) * 100
+	}
+}
+
+// relativeToProject makes an absolute path from a coverage report relative
+// to the project, leaving paths outside the project unchanged
+func relativeToProject(projectPath, file string) string {
+	if !filepath.IsAbs(file) {
+		return file
+	}
+	absProject, err := filepath.Abs(projectPath)
+	if err != nil {
+		return file
+	}
+	if rel, err := filepath.Rel(absProject, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
@@ -0,0 +1,132 @@
+package coverage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/cache"
+)
+
+// cachedReports is the number of coverage reports kept in the cache
+const cachedReports = 10
+
+// treeSkipDirs are left out of the tree hash outside git checkouts:
+// version control, agent artifacts, dependencies, build output and coverage
+// output
+var treeSkipDirs = map[string]bool{
+	".git": true, cache.DirName: true, "node_modules": true, ".venv": true, "venv": true,
+	".tox": true, ".nox": true, "target": true, "build": true, "dist": true, ".build": true,
+	".gradle": true, ".dart_tool": true, "_build": true, "deps": true, "__pycache__": true,
+	".pytest_cache": true, "coverage": true, "htmlcov": true, "cover": true, ".nyc_output": true,
+}
+
+// ReportKey returns the cache key of a coverage run: a hash of the
+// project's source tree, the analyzer's language and its options. Any
+// change to a source, test or build file gives a new key.
+func ReportKey(projectPath string, analyzer Analyzer, opts Options) (string, error) {
+	files, err := treeFiles(projectPath)
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, file := range files {
+		h.Write([]byte(filepath.ToSlash(file)))
+		h.Write([]byte{0})
+		// Files deleted from a git checkout are still listed; they hash as empty
+		if f, err := os.Open(filepath.Join(projectPath, file)); err == nil {
+			io.Copy(h, f)
+			f.Close()
+		}
+		h.Write([]byte{0})
+	}
+
+	// The stall timeout doesn't change what a run measures
+	opts.StallMinutes = 0
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	return cache.Key(hex.EncodeToString(h.Sum(nil)), analyzer.GetLanguageName(), string(options)), nil
+}
+
+// treeFiles lists the project-relative files whose content determines a
+// coverage run: in a git checkout, the tracked and untracked files git
+// doesn't ignore; otherwise every file outside treeSkipDirs
+func treeFiles(projectPath string) ([]string, error) {
+	cmd := exec.Command("git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = projectPath
+	if output, err := cmd.Output(); err == nil {
+		var files []string
+		for _, file := range strings.Split(string(output), "\x00") {
+			if file != "" {
+				files = append(files, file)
+			}
+		}
+		return files, nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(projectPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != projectPath && treeSkipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(projectPath, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, err
+}
+
+// CachedReport returns the coverage report cached under a key
+func CachedReport(projectPath, key string) (*CoverageReport, bool) {
+	data, ok := cache.New(projectPath).Get(cache.Coverage, reportCacheName(key))
+	if !ok {
+		return nil, false
+	}
+	var report CoverageReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, false
+	}
+	return &report, true
+}
+
+// CacheReport stores a coverage report under a key, keeping only the most
+// recent reports
+func CacheReport(projectPath, key string, report *CoverageReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	c := cache.New(projectPath)
+	if err := c.Put(cache.Coverage, reportCacheName(key), data); err != nil {
+		return err
+	}
+	return c.Prune(cache.Coverage, "report-", cachedReports)
+}
+
+// reportCacheName returns the file name of a cached report
+func reportCacheName(key string) string {
+	return "report-" + key[:16] + ".json"
+}
@@ -0,0 +1,284 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
+)
+
+// ScalaAnalyzer implements coverage analysis for sbt projects measured with
+// the sbt-scoverage plugin
+type ScalaAnalyzer struct {
+	opts Options
+}
+
+// DetectLanguage checks if this is an sbt project
+func (s *ScalaAnalyzer) DetectLanguage(projectPath string) bool {
+	return fileExists(filepath.Join(projectPath, "build.sbt"))
+}
+
+// GetLanguageName returns "Scala"
+func (s *ScalaAnalyzer) GetLanguageName() string {
+	return "Scala"
+}
+
+// RunCoverage executes sbt with scoverage instrumentation
+func (s *ScalaAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
+	report := &CoverageReport{
+		FileCoverage:   make(map[string]float64),
+		UncoveredFiles: []string{},
+		UncoveredLines: make(map[string][]int),
+		Language:       "Scala",
+	}
+
+	// Don't parse stale reports if the run fails
+	reports := s.findReports(projectPath)
+	for _, reportPath := range reports {
+		os.Remove(reportPath)
+	}
+
+	cmd := exec.Command("sbt", "coverage", "test", "coverageReport")
+	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// Ignore errors, tests might fail, but not a stalled build
+	if err := s.opts.run(cmd); errors.Is(err, errdefs.ErrStalled) {
+		return nil, err
+	}
+
+	// Each sbt module writes its own report
+	reports = s.findReports(projectPath)
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("no scoverage report generated, is the sbt-scoverage plugin enabled?\n%s", stdout.String()+stderr.String())
+	}
+
+	var statements, invoked int
+	for _, reportPath := range reports {
+		total, covered, err := s.parseScoverageXML(projectPath, reportPath, report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse scoverage report: %w", err)
+		}
+		statements += total
+		invoked += covered
+	}
+
+	sort.Strings(report.UncoveredFiles)
+	if statements > 0 {
+		report.TotalCoverage = float64(invoked) / float64(statements) * 100
+	}
+
+	return report, nil
+}
+
+// findReports returns the scoverage XML reports of the root project and
+// its direct submodules
+func (s *ScalaAnalyzer) findReports(projectPath string) []string {
+	var reports []string
+	for _, pattern := range []string{
+		filepath.Join(projectPath, "target", "scala-*", "scoverage-report", "scoverage.xml"),
+		filepath.Join(projectPath, "*", "target", "scala-*", "scoverage-report", "scoverage.xml"),
+	} {
+		matches, _ := filepath.Glob(pattern)
+		reports = append(reports, matches...)
+	}
+	return reports
+}
+
+// parseScoverageXML parses a scoverage XML report into the coverage report.
+// scoverage measures statements: a line is uncovered if any statement on it
+// never ran. It returns the report's statement and invoked statement counts.
+func (s *ScalaAnalyzer) parseScoverageXML(projectPath, filename string, report *CoverageReport) (int, int, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	type Statement struct {
+		Source          string `xml:"source,attr"`
+		Line            int    `xml:"line,attr"`
+		Branch          bool   `xml:"branch,attr"`
+		InvocationCount int    `xml:"invocation-count,attr"`
+		Ignored         bool   `xml:"ignored,attr"`
+	}
+
+	var scoverage struct {
+		Packages []struct {
+			Classes []struct {
+				Methods []struct {
+					Statements []Statement `xml:"statements>statement"`
+				} `xml:"methods>method"`
+			} `xml:"classes>class"`
+		} `xml:"packages>package"`
+	}
+
+	if err := xml.Unmarshal(data, &scoverage); err != nil {
+		return 0, 0, err
+	}
+
+	type fileStats struct {
+		statements, invoked int
+		uncovered, executed map[int]bool
+	}
+	files := make(map[string]*fileStats)
+
+	for _, pkg := range scoverage.Packages {
+		for _, class := range pkg.Classes {
+			for _, method := range class.Methods {
+				for _, statement := range method.Statements {
+					if statement.Ignored {
+						continue
+					}
+
+					path := relativeToProject(projectPath, statement.Source)
+					stats, ok := files[path]
+					if !ok {
+						stats = &fileStats{uncovered: make(map[int]bool), executed: make(map[int]bool)}
+						files[path] = stats
+					}
+
+					stats.statements++
+					if statement.InvocationCount > 0 {
+						stats.invoked++
+						stats.executed[statement.Line] = true
+						continue
+					}
+					stats.uncovered[statement.Line] = true
+
+					if s.opts.BranchCoverage && statement.Branch {
+						report.addUncoveredBranch(path, Branch{
+							Line:   statement.Line,
+							Detail: "branch never taken",
+						})
+					}
+				}
+			}
+		}
+	}
+
+	var statements, invoked int
+	for path, stats := range files {
+		statements += stats.statements
+		invoked += stats.invoked
+		report.FileCoverage[path] = float64(stats.invoked) / float64(stats.statements) * 100
+
+		executed := make([]int, 0, len(stats.executed))
+		for line := range stats.executed {
+			executed = append(executed, line)
+		}
+		report.setCoveredLines(path, uniqueLines(executed))
+
+		if len(stats.uncovered) > 0 {
+			lines := make([]int, 0, len(stats.uncovered))
+			for line := range stats.uncovered {
+				lines = append(lines, line)
+			}
+			sort.Ints(lines)
+			report.UncoveredFiles = append(report.UncoveredFiles, path)
+			report.UncoveredLines[path] = lines
+		}
+	}
+
+	return statements, invoked, nil
+}
+
+// GetTestFilePath returns the test file path for a Scala source file
+func (s *ScalaAnalyzer) GetTestFilePath(sourceFile string) string {
+	// Convention: src/main/scala/.../Foo.scala -> src/test/scala/.../FooSpec.scala
+	name := strings.TrimSuffix(filepath.Base(sourceFile), ".scala") + "Spec.scala"
+	dir := filepath.Dir(sourceFile)
+	if strings.Contains(sourceFile, "/main/") {
+		dir = filepath.Dir(strings.Replace(sourceFile, "/main/", "/test/", 1))
+	}
+	return filepath.Join(dir, name)
+}
+
+// GetSourceFileForTest returns the source file for a Scala test file
+func (s *ScalaAnalyzer) GetSourceFileForTest(testFile string) string {
+	base := filepath.Base(testFile)
+	name := base
+	for _, suffix := range []string{"Spec.scala", "Test.scala", "Suite.scala"} {
+		if strings.HasSuffix(base, suffix) {
+			name = strings.TrimSuffix(base, suffix) + ".scala"
+			break
+		}
+	}
+
+	dir := filepath.Dir(testFile)
+	if strings.Contains(testFile, "/test/") {
+		dir = filepath.Dir(strings.Replace(testFile, "/test/", "/main/", 1))
+	}
+	return filepath.Join(dir, name)
+}
+
+// RunTests runs the test class of a specific test file with sbt testOnly
+func (s *ScalaAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
+	cmd := exec.Command("sbt", "testOnly "+s.getClassName(testFile))
+	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := s.opts.run(cmd)
+	output := stdout.String() + stderr.String()
+
+	return testResult(output, err)
+}
+
+// getClassName extracts the fully qualified class name from a test file path
+// under src/test/scala, falling back to the simple class name
+func (s *ScalaAnalyzer) getClassName(testFile string) string {
+	path := filepath.ToSlash(strings.TrimSuffix(testFile, ".scala"))
+	if i := strings.Index(path, "src/test/scala/"); i >= 0 {
+		return strings.ReplaceAll(path[i+len("src/test/scala/"):], "/", ".")
+	}
+	return filepath.Base(path)
+}
+
+// ValidateTestFile validates that a test file compiles and runs
+func (s *ScalaAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
+	// sbt compiles the test sources before running them
+	return s.RunTests(projectPath, testFile)
+}
+
+// ToolVersions reports the JDK, sbt, Scala and scoverage plugin versions.
+// sbt is slow to start, so versions are read from the build definition.
+func (s *ScalaAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := make(map[string]string)
+	addVersion(versions, "java", commandVersion(projectPath, "java", "-version"))
+
+	sources := []struct {
+		tool    string
+		file    string
+		pattern *regexp.Regexp
+	}{
+		{"sbt", filepath.Join("project", "build.properties"), regexp.MustCompile(`sbt\.version\s*=\s*(\S+)`)},
+		{"scala", "build.sbt", regexp.MustCompile(`scalaVersion\s*:=\s*"([^"]+)"`)},
+		{"scoverage", filepath.Join("project", "plugins.sbt"), regexp.MustCompile(`"sbt-scoverage"\s*%\s*"([^"]+)"`)},
+	}
+	for _, source := range sources {
+		data, err := os.ReadFile(filepath.Join(projectPath, source.file))
+		if err != nil {
+			continue
+		}
+		if m := source.pattern.FindSubmatch(data); m != nil {
+			versions[source.tool] = string(m[1])
+		}
+	}
+
+	return versions
+}
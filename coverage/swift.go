@@ -2,16 +2,20 @@ package coverage
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
 )
 
 // SwiftAnalyzer implements coverage analysis for Swift projects
-type SwiftAnalyzer struct{}
+type SwiftAnalyzer struct {
+	opts Options
+}
 
 // DetectLanguage checks if this is a Swift project
 func (s *SwiftAnalyzer) DetectLanguage(projectPath string) bool {
@@ -39,7 +43,9 @@ func (s *SwiftAnalyzer) GetLanguageName() string {
 	return "Swift"
 }
 
-// RunCoverage executes swift test with coverage
+// RunCoverage runs swift test with coverage and exports the profile of the
+// package's test binary with llvm-cov. Xcode projects without a
+// Package.swift run xcodebuild test instead.
 func (s *SwiftAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
 	report := &CoverageReport{
 		FileCoverage:   make(map[string]float64),
@@ -48,68 +54,106 @@ func (s *SwiftAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error)
 		Language:       "Swift",
 	}
 
+	if !fileExists(filepath.Join(projectPath, "Package.swift")) {
+		return s.runXcodeCoverage(projectPath, report)
+	}
+
 	// Run swift test with coverage enabled
 	cmd := exec.Command("swift", "test", "--enable-code-coverage")
 	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	_ = cmd.Run() // Ignore error, tests might fail
+	// Failing tests still write a profile, but a missing toolchain writes none
+	if err := runFailed(s.opts.run(cmd)); err != nil {
+		return nil, err
+	}
 
-	// Export coverage data
-	cmd = exec.Command("xcrun", "llvm-cov", "export",
-		".build/debug/<PackageName>PackageTests.xctest/Contents/MacOS/<PackageName>PackageTests",
-		"-instr-profile=.build/debug/codecov/default.profdata",
-		"-format=text")
+	binPath, err := s.binPath(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	profile := filepath.Join(binPath, "codecov", "default.profdata")
+	if !fileExists(profile) {
+		return nil, fmt.Errorf("swift test wrote no coverage profile at %s\n%s", profile, stdout.String()+stderr.String())
+	}
+	binary, err := testBinary(binPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The text format of llvm-cov export is JSON
+	args := []string{"export", "-format=text", "-instr-profile=" + profile, binary,
+		`-ignore-filename-regex=(\.build|Tests)/`}
+	cmd = exec.Command("llvm-cov", args...)
+	if _, err := exec.LookPath("xcrun"); err == nil {
+		cmd = exec.Command("xcrun", append([]string{"llvm-cov"}, args...)...)
+	}
 	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
+	stderr.Reset()
+	cmd.Stderr = &stderr
 
-	output, err := cmd.Output()
+	output, err := s.opts.output(cmd)
 	if err != nil {
-		// Try alternative approach for SPM
-		return s.runCoverageAlternative(projectPath, report)
+		return nil, fmt.Errorf("llvm-cov export failed: %w\n%s", errdefs.ToolMissing(err), stderr.String())
 	}
 
-	// Parse coverage output
-	if err := s.parseCoverageOutput(string(output), report); err != nil {
+	exportFile, err := coverageArtifact(projectPath, "llvm-cov.json")
+	if err != nil {
 		return nil, err
 	}
-
+	if err := os.WriteFile(exportFile, output, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save llvm-cov export: %w", err)
+	}
+	if err := parseLLVMCovJSON(projectPath, exportFile, report); err != nil {
+		return nil, fmt.Errorf("failed to parse coverage: %w", err)
+	}
 	return report, nil
 }
 
-// runCoverageAlternative tries an alternative coverage method
-func (s *SwiftAnalyzer) runCoverageAlternative(projectPath string, report *CoverageReport) (*CoverageReport, error) {
-	// For Xcode projects, try xcodebuild
-	cmd := exec.Command("xcodebuild", "test",
-		"-scheme", "YourScheme",
-		"-enableCodeCoverage", "YES")
+// binPath returns the build directory of the debug build, e.g.
+// .build/arm64-apple-macosx/debug
+func (s *SwiftAnalyzer) binPath(projectPath string) (string, error) {
+	cmd := exec.Command("swift", "build", "--show-bin-path")
 	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
+	output, err := s.opts.output(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the Swift build directory: %w", err)
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	_ = cmd.Run()
-
-	// Parse xcodebuild output for coverage info
-	output := stdout.String()
-	s.parseCoverageOutput(output, report)
-
-	return report, nil
+	binPath := strings.TrimSpace(string(output))
+	if lines := strings.Split(binPath, "\n"); len(lines) > 1 {
+		binPath = strings.TrimSpace(lines[len(lines)-1]) // Build progress comes first
+	}
+	if !filepath.IsAbs(binPath) {
+		binPath = filepath.Join(projectPath, binPath)
+	}
+	return binPath, nil
 }
 
-// parseCoverageOutput parses coverage information from output
-func (s *SwiftAnalyzer) parseCoverageOutput(output string, report *CoverageReport) error {
-	// Parse coverage percentage
-	re := regexp.MustCompile(`(\d+\.?\d*)%\s+coverage`)
-	matches := re.FindStringSubmatch(output)
-	if len(matches) > 1 {
-		fmt.Sscanf(matches[1], "%f", &report.TotalCoverage)
+// testBinary finds the package's test binary in the build directory: the
+// executable inside the .xctest bundle on macOS, or the .xctest file itself
+// on Linux
+func testBinary(binPath string) (string, error) {
+	bundles, _ := filepath.Glob(filepath.Join(binPath, "*PackageTests.xctest"))
+	if len(bundles) == 0 {
+		return "", fmt.Errorf("no .xctest test binary in %s", binPath)
 	}
 
-	return nil
+	bundle := bundles[0]
+	info, err := os.Stat(bundle)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return bundle, nil
+	}
+	return filepath.Join(bundle, "Contents", "MacOS", strings.TrimSuffix(filepath.Base(bundle), ".xctest")), nil
 }
 
 // GetTestFilePath returns the test file path for a Swift source file
@@ -142,32 +186,56 @@ func (s *SwiftAnalyzer) GetSourceFileForTest(testFile string) string {
 
 // RunTests runs tests for a specific test file
 func (s *SwiftAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
-	// For SPM projects
 	cmd := exec.Command("swift", "test")
+	if !fileExists(filepath.Join(projectPath, "Package.swift")) {
+		var err error
+		if cmd, err = s.xcodebuild(projectPath, "test"); err != nil {
+			return false, "", err
+		}
+	}
 	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err := s.opts.run(cmd)
 	output := stdout.String() + stderr.String()
 
-	return err == nil, output, nil
+	return testResult(output, err)
 }
 
 // ValidateTestFile validates that a test file compiles and runs
 func (s *SwiftAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
 	// Try to build first
 	cmd := exec.Command("swift", "build", "--build-tests")
+	if !fileExists(filepath.Join(projectPath, "Package.swift")) {
+		var err error
+		if cmd, err = s.xcodebuild(projectPath, "build-for-testing"); err != nil {
+			return false, "", err
+		}
+	}
 	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	err := s.opts.run(cmd)
+	if errors.Is(err, errdefs.ErrStalled) {
+		return false, "", err
+	}
+	if err != nil {
 		return false, "Compilation failed: " + stderr.String(), nil
 	}
 
 	return s.RunTests(projectPath, testFile)
 }
+
+// ToolVersions reports the Swift toolchain version
+func (s *SwiftAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := make(map[string]string)
+	addVersion(versions, "swift", commandVersion(projectPath, "swift", "--version"))
+	return versions
+}
@@ -0,0 +1,267 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/cache"
+)
+
+// Monorepo task runners that own the test targets of a workspace
+const (
+	RunnerNx    = "nx"
+	RunnerTurbo = "turbo"
+	RunnerPants = "pants"
+)
+
+// taskRunner runs tests through a monorepo task runner, so runs follow the
+// workspace graph and use the runner's cache instead of invoking the test
+// tools directly
+type taskRunner struct {
+	name string
+	opts Options
+}
+
+// taskRunner returns the task runner configured or detected for a project,
+// or nil to invoke the test tools directly
+func (o Options) taskRunner(projectPath string) *taskRunner {
+	name := o.TaskRunner
+	if name == "" {
+		switch {
+		case fileExists(filepath.Join(projectPath, "nx.json")):
+			name = RunnerNx
+		case fileExists(filepath.Join(projectPath, "turbo.json")):
+			name = RunnerTurbo
+		case fileExists(filepath.Join(projectPath, "pants.toml")):
+			name = RunnerPants
+		}
+	}
+
+	switch name {
+	case RunnerNx, RunnerTurbo, RunnerPants:
+		return &taskRunner{name: name, opts: o}
+	}
+	return nil
+}
+
+// checkTaskRunner rejects unknown task_runner values
+func checkTaskRunner(name string) error {
+	switch name {
+	case "", "none", RunnerNx, RunnerTurbo, RunnerPants:
+		return nil
+	}
+	return fmt.Errorf("unknown task runner %q (want nx, turbo, pants or none)", name)
+}
+
+// javaScript reports whether the runner drives Jest test targets
+func (r *taskRunner) javaScript() bool {
+	return r != nil && (r.name == RunnerNx || r.name == RunnerTurbo)
+}
+
+// python reports whether the runner drives pytest test targets
+func (r *taskRunner) python() bool {
+	return r != nil && r.name == RunnerPants
+}
+
+// coverage runs the test targets with coverage, limited to projects affected
+// since AffectedBase if set, and returns the report files they wrote: one
+// Jest coverage-final.json per project for Nx and Turborepo, or a single
+// coverage.py JSON report for Pants
+func (r *taskRunner) coverage(projectPath string) ([]string, string, error) {
+	base := r.opts.AffectedBase
+	jestArgs := []string{"--coverage", "--coverageReporters=json", "--coverageReporters=text"}
+
+	var output string
+	switch r.name {
+	case RunnerNx:
+		// Don't parse stale reports of projects that aren't run this time
+		if err := removeReports(projectPath, "coverage-final.json"); err != nil {
+			return nil, "", err
+		}
+
+		args := []string{"--no-install", "nx", "run-many"}
+		if base != "" {
+			args = []string{"--no-install", "nx", "affected", "--base=" + base}
+		}
+		args = append(append(args, "-t", "test"), jestArgs...)
+		output, _ = r.command(projectPath, "npx", args...) // Ignore error, tests might fail
+		return findReports(projectPath, "coverage-final.json"), output, nil
+
+	case RunnerTurbo:
+		if err := removeReports(projectPath, "coverage-final.json"); err != nil {
+			return nil, "", err
+		}
+
+		// "...[base]" selects changed packages and their dependents
+		args := []string{"--no-install", "turbo", "run", "test"}
+		if base != "" {
+			args = append(args, "--filter=...["+base+"]")
+		}
+		args = append(append(args, "--"), jestArgs...)
+		output, _ = r.command(projectPath, "npx", args...)
+		return findReports(projectPath, "coverage-final.json"), output, nil
+
+	case RunnerPants:
+		// Pants writes coverage.py reports under dist/ in the build root
+		reportFile := filepath.Join(projectPath, "dist", "coverage", "python", "coverage.json")
+		os.Remove(reportFile)
+
+		var args []string
+		if base != "" {
+			args = append(args, "--changed-since="+base, "--changed-dependents=transitive")
+		}
+		args = append(args, "test", "--use-coverage", "--coverage-py-report=json")
+		if base == "" {
+			args = append(args, "::")
+		}
+		output, _ = r.command(projectPath, r.pants(projectPath), args...)
+		if !fileExists(reportFile) {
+			return nil, output, nil
+		}
+		return []string{reportFile}, output, nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported task runner %q", r.name)
+}
+
+// runTests runs one test file through the workspace project that owns it.
+// ok is false if no owning project was found, so the caller can fall back
+// to running the test tool directly.
+func (r *taskRunner) runTests(projectPath, testFile string) (passed bool, output string, ok bool) {
+	relTest := filepath.ToSlash(relativeToProject(projectPath, testFile))
+
+	var err error
+	switch r.name {
+	case RunnerNx:
+		name, dir, found := workspaceProject(projectPath, testFile, "project.json", "package.json")
+		if !found {
+			return false, "", false
+		}
+		output, err = r.command(projectPath, "npx", "--no-install", "nx", "test", name, "--testFile="+relativeTo(dir, relTest))
+
+	case RunnerTurbo:
+		name, dir, found := workspaceProject(projectPath, testFile, "package.json")
+		if !found {
+			return false, "", false
+		}
+		output, err = r.command(projectPath, "npx", "--no-install", "turbo", "run", "test", "--filter="+name, "--", relativeTo(dir, relTest))
+
+	case RunnerPants:
+		output, err = r.command(projectPath, r.pants(projectPath), "test", relTest)
+
+	default:
+		return false, "", false
+	}
+
+	return err == nil, output, true
+}
+
+// version reports the task runner's version
+func (r *taskRunner) version(projectPath string) string {
+	switch r.name {
+	case RunnerNx:
+		return commandVersion(projectPath, "npx", "--no-install", "nx", "--version")
+	case RunnerTurbo:
+		return commandVersion(projectPath, "npx", "--no-install", "turbo", "--version")
+	case RunnerPants:
+		return commandVersion(projectPath, r.pants(projectPath), "--version")
+	}
+	return ""
+}
+
+// pants returns the Pants launcher, preferring a ./pants script checked
+// into older repositories over the installed launcher
+func (r *taskRunner) pants(projectPath string) string {
+	if fileExists(filepath.Join(projectPath, "pants")) {
+		return "./pants"
+	}
+	return "pants"
+}
+
+// command runs a task runner command and returns its combined output
+func (r *taskRunner) command(projectPath, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = projectPath
+	cmd.Env = r.opts.environ(projectPath)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := r.opts.run(cmd)
+	return output.String(), err
+}
+
+// workspaceProject finds the workspace project owning a file: the nearest
+// enclosing directory within the project with one of the marker files and
+// a "name" in it. It returns the project name and its directory relative
+// to the project root.
+func workspaceProject(projectPath, file string, markers ...string) (string, string, bool) {
+	dir := filepath.Dir(relativeToProject(projectPath, file))
+	for !filepath.IsAbs(dir) && !strings.HasPrefix(dir, "..") {
+		for _, marker := range markers {
+			data, err := os.ReadFile(filepath.Join(projectPath, dir, marker))
+			if err != nil {
+				continue
+			}
+			var manifest struct {
+				Name string `json:"name"`
+			}
+			if json.Unmarshal(data, &manifest) == nil && manifest.Name != "" {
+				return manifest.Name, filepath.ToSlash(dir), true
+			}
+		}
+
+		if dir == "." {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	return "", "", false
+}
+
+// relativeTo makes a slash-separated project path relative to a directory
+// within the project
+func relativeTo(dir, path string) string {
+	if dir == "." {
+		return path
+	}
+	return strings.TrimPrefix(path, dir+"/")
+}
+
+// findReports returns the files with the given name in the project,
+// skipping dependencies and the agent cache
+func findReports(projectPath, name string) []string {
+	var reports []string
+	filepath.Walk(projectPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if info.Name() == "node_modules" || info.Name() == ".git" || info.Name() == cache.DirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() == name {
+			reports = append(reports, path)
+		}
+		return nil
+	})
+	return reports
+}
+
+// removeReports deletes report files left over from earlier runs
+func removeReports(projectPath, name string) error {
+	for _, report := range findReports(projectPath, name) {
+		if err := os.Remove(report); err != nil {
+			return fmt.Errorf("failed to remove stale coverage report: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package coverage
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// testFileConvention describes how a language names and places its tests
+type testFileConvention struct {
+	suffixes []string // File name endings, e.g. "_test.go"
+	prefixes []string // File name beginnings, e.g. "test_"
+	dirs     []string // Directories holding only test code, e.g. "src/test"
+}
+
+// testFileConventions maps language names to their test file conventions.
+// JVM languages go by directory only: class names ending in Test can be
+// production code (ABTest.java).
+var testFileConventions = map[string]testFileConvention{
+	"Go":         {suffixes: []string{"_test.go"}},
+	"Python":     {suffixes: []string{"_test.py"}, prefixes: []string{"test_", "conftest.py"}, dirs: []string{"tests", "test"}},
+	"TypeScript": {suffixes: []string{".test.ts", ".test.tsx", ".test.js", ".test.jsx", ".spec.ts", ".spec.tsx", ".spec.js", ".spec.jsx"}, dirs: []string{"__tests__"}},
+	"Java":       {dirs: []string{"src/test", "src/integrationTest"}},
+	"Kotlin":     {dirs: []string{"src/test", "src/integrationTest"}},
+	"Scala":      {dirs: []string{"src/test", "src/it"}},
+	"Swift":      {suffixes: []string{"Tests.swift"}, dirs: []string{"Tests"}},
+	"Dart":       {suffixes: []string{"_test.dart"}, dirs: []string{"test"}},
+	"Elixir":     {suffixes: []string{"_test.exs"}, dirs: []string{"test"}},
+	"C++":        {suffixes: []string{"_test.cpp", "_test.cc", "_test.cxx", "_test.c"}, dirs: []string{"tests", "test"}},
+}
+
+// IsTestFile reports whether a file is test code by the conventions of a
+// language. Languages without known conventions have no test files.
+func IsTestFile(language, file string) bool {
+	convention, ok := testFileConventions[language]
+	if !ok {
+		return false
+	}
+
+	slashed := "/" + filepath.ToSlash(file)
+	name := path.Base(slashed)
+	for _, suffix := range convention.suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	for _, prefix := range convention.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, dir := range convention.dirs {
+		if strings.Contains(slashed, "/"+dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// DropTestFiles removes test files, which some tools measure along with
+// the code under test, from a report. The total is recomputed from the
+// remaining files when the tool reported their line counts; otherwise it is
+// left as reported.
+func DropTestFiles(projectPath, language string, report *CoverageReport) {
+	report.dropFiles(func(file string) bool {
+		return IsTestFile(language, relativeToProject(projectPath, file))
+	})
+}
+
+// dropFiles removes the files drop selects from a report and returns them.
+// The total is recomputed from the remaining files when the tool reported
+// their line counts; otherwise it is left as reported.
+func (r *CoverageReport) dropFiles(drop func(file string) bool) []string {
+	var dropped []string
+	for file := range r.FileCoverage {
+		if !drop(file) {
+			continue
+		}
+		delete(r.FileCoverage, file)
+		delete(r.UncoveredLines, file)
+		delete(r.UncoveredBranches, file)
+		delete(r.TotalLines, file)
+		delete(r.CoveredLines, file)
+		delete(r.Methods, file)
+		dropped = append(dropped, file)
+	}
+	if len(dropped) == 0 {
+		return nil
+	}
+	sort.Strings(dropped)
+
+	files := r.UncoveredFiles[:0]
+	for _, file := range r.UncoveredFiles {
+		if _, ok := r.FileCoverage[file]; ok {
+			files = append(files, file)
+		}
+	}
+	r.UncoveredFiles = files
+
+	var totalLines int
+	var coveredLines float64
+	for file, fileCoverage := range r.FileCoverage {
+		total, ok := r.TotalLines[file]
+		if !ok {
+			return dropped
+		}
+		totalLines += total
+		coveredLines += float64(total) * fileCoverage / 100
+	}
+	if totalLines > 0 {
+		r.TotalCoverage = coveredLines / float64(totalLines) * 100
+	}
+	return dropped
+}
@@ -5,13 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
 
 // TypeScriptAnalyzer implements coverage analysis for TypeScript/JavaScript projects
-type TypeScriptAnalyzer struct{}
+type TypeScriptAnalyzer struct {
+	opts Options
+}
 
 // DetectLanguage checks if this is a TypeScript/JavaScript project
 func (t *TypeScriptAnalyzer) DetectLanguage(projectPath string) bool {
@@ -35,7 +36,8 @@ func (t *TypeScriptAnalyzer) GetLanguageName() string {
 	return "TypeScript"
 }
 
-// RunCoverage executes Jest with coverage
+// RunCoverage runs the tests with coverage through the project's package
+// manager and test runner: Jest, vitest, or Mocha under nyc
 func (t *TypeScriptAnalyzer) RunCoverage(projectPath string) (*CoverageReport, error) {
 	report := &CoverageReport{
 		FileCoverage:   make(map[string]float64),
@@ -44,27 +46,44 @@ func (t *TypeScriptAnalyzer) RunCoverage(projectPath string) (*CoverageReport, e
 		Language:       "TypeScript",
 	}
 
-	// Run Jest with coverage
-	cmd := exec.Command("npm", "test", "--", "--coverage", "--coverageReporters=json", "--coverageReporters=text")
-	cmd.Dir = projectPath
+	// In Nx and Turborepo workspaces each project writes its own report
+	if runner := t.opts.taskRunner(projectPath); runner.javaScript() {
+		reports, output, err := runner.coverage(projectPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(reports) == 0 {
+			return nil, fmt.Errorf("%s test run wrote no coverage-final.json\n%s", runner.name, output)
+		}
+		if err := t.parseCoverageFiles(projectPath, reports, report); err != nil {
+			return nil, fmt.Errorf("failed to parse coverage: %w", err)
+		}
+		return report, nil
+	}
 
-	// Check if using yarn
-	if fileExists(filepath.Join(projectPath, "yarn.lock")) {
-		cmd = exec.Command("yarn", "test", "--coverage", "--coverageReporters=json", "--coverageReporters=text")
+	coverageDir, err := coverageArtifact(projectPath, "js")
+	if err != nil {
+		return nil, err
 	}
+	os.RemoveAll(coverageDir) // Don't parse a stale report if the run fails
+
+	// Every runner writes an Istanbul coverage-final.json
+	cmd := t.command(projectPath, detectToolchain(projectPath).coverageArgs(coverageDir))
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	_ = cmd.Run() // Ignore error, tests might fail
+	// Failing tests still write coverage, but a missing runner writes none
+	if err := runFailed(t.opts.run(cmd)); err != nil {
+		return nil, err
+	}
 
 	// Parse coverage-final.json
-	coverageFile := filepath.Join(projectPath, "coverage", "coverage-final.json")
-	defer os.RemoveAll(filepath.Join(projectPath, "coverage")) // Cleanup
+	coverageFile := filepath.Join(coverageDir, "coverage-final.json")
 
 	if fileExists(coverageFile) {
-		if err := t.parseCoverageJSON(coverageFile, report); err != nil {
+		if err := t.parseCoverageJSON(projectPath, coverageFile, report); err != nil {
 			return nil, fmt.Errorf("failed to parse coverage: %w", err)
 		}
 	}
@@ -72,50 +91,93 @@ func (t *TypeScriptAnalyzer) RunCoverage(projectPath string) (*CoverageReport, e
 	return report, nil
 }
 
-// parseCoverageJSON parses Jest coverage-final.json format
-func (t *TypeScriptAnalyzer) parseCoverageJSON(filename string, report *CoverageReport) error {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return err
+// parseCoverageJSON parses an Istanbul coverage-final.json as written by
+// Jest, vitest and nyc
+func (t *TypeScriptAnalyzer) parseCoverageJSON(projectPath, filename string, report *CoverageReport) error {
+	return t.parseCoverageFiles(projectPath, []string{filename}, report)
+}
+
+// parseCoverageFiles merges Jest coverage-final.json reports, e.g. one per
+// workspace project. Entries and reports that can't be parsed are skipped
+// and recorded in the report's ParseErrors; it fails only if no usable data
+// was found.
+func (t *TypeScriptAnalyzer) parseCoverageFiles(projectPath string, filenames []string, report *CoverageReport) error {
+	var totalLines, totalCovered int
+	for _, filename := range filenames {
+		lines, covered, err := t.addCoverageJSON(projectPath, filename, report)
+		if err != nil {
+			report.skipEntry(projectPath, filename, filepath.Base(filename), err)
+			continue
+		}
+		totalLines += lines
+		totalCovered += covered
 	}
 
-	var coverage map[string]struct {
-		Lines struct {
-			Total   int            `json:"total"`
-			Covered int            `json:"covered"`
-			Pct     float64        `json:"pct"`
-			Details map[string]int `json:"details"` // line number -> hits
-		} `json:"lines"`
-		Statements struct {
-			Total   int     `json:"total"`
-			Covered int     `json:"covered"`
-			Pct     float64 `json:"pct"`
-		} `json:"statements"`
+	if totalLines > 0 {
+		report.TotalCoverage = (float64(totalCovered) / float64(totalLines)) * 100
 	}
 
+	return report.checkUsable(strings.Join(filenames, ", "))
+}
+
+// jestFileCoverage is the coverage of one file in a Jest report
+type jestFileCoverage struct {
+	Lines struct {
+		Total   int            `json:"total"`
+		Covered int            `json:"covered"`
+		Pct     float64        `json:"pct"`
+		Details map[string]int `json:"details"` // line number -> hits
+	} `json:"lines"`
+	Statements struct {
+		Total   int     `json:"total"`
+		Covered int     `json:"covered"`
+		Pct     float64 `json:"pct"`
+	} `json:"statements"`
+}
+
+// addCoverageJSON adds the files of one Jest report to a report and returns
+// its total and covered line counts. File entries that can't be decoded are
+// skipped; an unreadable or malformed report is an error.
+func (t *TypeScriptAnalyzer) addCoverageJSON(projectPath, reportFile string, report *CoverageReport) (int, int, error) {
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var coverage map[string]json.RawMessage
 	if err := json.Unmarshal(data, &coverage); err != nil {
-		return err
+		return 0, 0, err
 	}
 
 	var totalLines, totalCovered int
 
-	for filename, fileCov := range coverage {
+	for filename, entry := range coverage {
 		// Skip node_modules
 		if strings.Contains(filename, "node_modules") {
 			continue
 		}
 
+		var fileCov jestFileCoverage
+		if err := json.Unmarshal(entry, &fileCov); err != nil {
+			report.skipEntry(projectPath, reportFile, filename, err)
+			continue
+		}
+
 		report.FileCoverage[filename] = fileCov.Lines.Pct
+		report.setTotalLines(filename, fileCov.Lines.Total)
 
 		// Find uncovered lines
-		var uncovered []int
+		var uncovered, executed []int
 		for lineStr, hits := range fileCov.Lines.Details {
+			var lineNum int
+			fmt.Sscanf(lineStr, "%d", &lineNum)
 			if hits == 0 {
-				var lineNum int
-				fmt.Sscanf(lineStr, "%d", &lineNum)
 				uncovered = append(uncovered, lineNum)
+			} else {
+				executed = append(executed, lineNum)
 			}
 		}
+		report.setCoveredLines(filename, uniqueLines(executed))
 
 		if len(uncovered) > 0 {
 			report.UncoveredFiles = append(report.UncoveredFiles, filename)
@@ -126,11 +188,7 @@ func (t *TypeScriptAnalyzer) parseCoverageJSON(filename string, report *Coverage
 		totalCovered += fileCov.Lines.Covered
 	}
 
-	if totalLines > 0 {
-		report.TotalCoverage = (float64(totalCovered) / float64(totalLines)) * 100
-	}
-
-	return nil
+	return totalLines, totalCovered, nil
 }
 
 // GetTestFilePath returns the test file path for a TypeScript source file
@@ -157,25 +215,77 @@ func (t *TypeScriptAnalyzer) GetSourceFileForTest(testFile string) string {
 
 // RunTests runs tests for a specific test file
 func (t *TypeScriptAnalyzer) RunTests(projectPath string, testFile string) (bool, string, error) {
-	// Determine package manager
-	cmd := exec.Command("npm", "test", "--", testFile)
-	if fileExists(filepath.Join(projectPath, "yarn.lock")) {
-		cmd = exec.Command("yarn", "test", testFile)
+	if runner := t.opts.taskRunner(projectPath); runner.javaScript() {
+		if passed, output, ok := runner.runTests(projectPath, testFile); ok {
+			return passed, output, nil
+		}
 	}
 
-	cmd.Dir = projectPath
+	cmd := t.command(projectPath, detectToolchain(projectPath).testArgs(testFile))
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err := t.opts.run(cmd)
 	output := stdout.String() + stderr.String()
 
-	return err == nil, output, nil
+	return testResult(output, err)
 }
 
 // ValidateTestFile validates that a test file runs successfully
 func (t *TypeScriptAnalyzer) ValidateTestFile(projectPath string, testFile string) (bool, string, error) {
 	return t.RunTests(projectPath, testFile)
 }
+
+// CompileTestFile type-checks the project with tsc and fails only on errors
+// in the test file, so existing type errors elsewhere don't block it.
+// JavaScript tests and projects without a tsconfig.json pass unchecked.
+func (t *TypeScriptAnalyzer) CompileTestFile(projectPath string, testFile string) (bool, string, error) {
+	ext := filepath.Ext(testFile)
+	if (ext != ".ts" && ext != ".tsx") || !fileExists(filepath.Join(projectPath, "tsconfig.json")) {
+		return true, "", nil
+	}
+
+	cmd := t.command(projectPath, detectToolchain(projectPath).tool("tsc", "--noEmit", "-p", "."))
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := t.opts.run(cmd); err == nil {
+		return true, "", nil
+	}
+
+	// tsc reports errors as "path/to/file.ts(line,col): error TS...", with
+	// paths relative to the project
+	testPath := filepath.ToSlash(relativeToProject(projectPath, testFile))
+	var errors []string
+	for _, line := range strings.Split(stdout.String()+stderr.String(), "\n") {
+		if strings.HasPrefix(filepath.ToSlash(line), testPath+"(") {
+			errors = append(errors, line)
+		}
+	}
+
+	return len(errors) == 0, strings.Join(errors, "\n"), nil
+}
+
+// ToolVersions reports the Node.js, package manager and test runner versions
+func (t *TypeScriptAnalyzer) ToolVersions(projectPath string) map[string]string {
+	versions := make(map[string]string)
+	toolchain := detectToolchain(projectPath)
+	addVersion(versions, "node", commandVersion(projectPath, "node", "--version"))
+	addVersion(versions, toolchain.manager, commandVersion(projectPath, toolchain.manager, "--version"))
+	tools := []string{toolchain.runner}
+	if toolchain.runner == JSMocha {
+		tools = append(tools, "nyc")
+	}
+	for _, tool := range tools {
+		args := toolchain.tool(tool, "--version")
+		addVersion(versions, tool, commandVersion(projectPath, args[0], args[1:]...))
+	}
+	if runner := t.opts.taskRunner(projectPath); runner.javaScript() {
+		addVersion(versions, runner.name, runner.version(projectPath))
+	}
+	return versions
+}
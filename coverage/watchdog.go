@@ -0,0 +1,102 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
+)
+
+// stallWaitDelay is how long a killed command's output is still read, in
+// case a child process it started keeps the output pipes open
+const stallWaitDelay = 10 * time.Second
+
+// watchdog kills a command once it has written nothing to stdout or stderr
+// for its timeout
+type watchdog struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	timeout time.Duration
+	stalled bool
+}
+
+// Write resets the timeout on any output
+func (w *watchdog) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.timer.Reset(w.timeout)
+	w.mu.Unlock()
+	return len(p), nil
+}
+
+// run runs a command like cmd.Run, killing it with an errdefs.ErrStalled
+// error if it produces no output for StallMinutes
+func (o Options) run(cmd *exec.Cmd) error {
+	if o.StallMinutes <= 0 {
+		return cmd.Run()
+	}
+
+	w := &watchdog{timeout: time.Duration(o.StallMinutes) * time.Minute}
+	// A shared writer stays shared, so exec keeps writing it from one pipe
+	combined := cmd.Stdout != nil && cmd.Stdout == cmd.Stderr
+	cmd.Stdout = heartbeat(cmd.Stdout, w)
+	if combined {
+		cmd.Stderr = cmd.Stdout
+	} else {
+		cmd.Stderr = heartbeat(cmd.Stderr, w)
+	}
+	cmd.WaitDelay = stallWaitDelay
+
+	w.timer = time.AfterFunc(w.timeout, func() {
+		w.mu.Lock()
+		w.stalled = true
+		w.mu.Unlock()
+		cmd.Process.Kill()
+	})
+	if err := cmd.Start(); err != nil {
+		w.timer.Stop()
+		return err
+	}
+
+	err := cmd.Wait()
+	w.mu.Lock()
+	w.timer.Stop()
+	stalled := w.stalled
+	w.mu.Unlock()
+
+	if stalled {
+		return fmt.Errorf("%w: %s produced no output for %s and was killed",
+			errdefs.ErrStalled, filepath.Base(cmd.Path), w.timeout)
+	}
+	return err
+}
+
+// output runs a command like cmd.Output, under the same watchdog as run
+func (o Options) output(cmd *exec.Cmd) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := o.run(cmd)
+	return stdout.Bytes(), err
+}
+
+// combinedOutput runs a command like cmd.CombinedOutput, under the same
+// watchdog as run
+func (o Options) combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := o.run(cmd)
+	return output.Bytes(), err
+}
+
+// heartbeat copies a command's output to dst, if any, and to the watchdog
+func heartbeat(dst io.Writer, w *watchdog) io.Writer {
+	if dst == nil {
+		return w
+	}
+	return io.MultiWriter(dst, w)
+}
@@ -0,0 +1,236 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
+)
+
+// XcodeOptions configures xcodebuild for Xcode projects without a
+// Package.swift. Empty fields are detected.
+type XcodeOptions struct {
+	Workspace   string `json:"workspace"`   // -workspace, e.g. App.xcworkspace
+	Project     string `json:"project"`     // -project, used when there is no workspace
+	Scheme      string `json:"scheme"`      // -scheme
+	Destination string `json:"destination"` // -destination, e.g. "platform=iOS Simulator,name=iPhone 15"
+}
+
+// xcodeBuildArgs returns the -workspace or -project, -scheme and
+// -destination arguments of xcodebuild. A workspace in the project root is
+// preferred over a project; the scheme is the one named like the workspace
+// or project, or else the first that xcodebuild -list reports.
+func (s *SwiftAnalyzer) xcodeBuildArgs(projectPath string) ([]string, error) {
+	opts := s.opts.Xcode
+	var args []string
+	switch {
+	case opts.Workspace != "":
+		args = []string{"-workspace", opts.Workspace}
+	case opts.Project != "":
+		args = []string{"-project", opts.Project}
+	default:
+		if workspaces, _ := filepath.Glob(filepath.Join(projectPath, "*.xcworkspace")); len(workspaces) > 0 {
+			args = []string{"-workspace", filepath.Base(workspaces[0])}
+		} else if projects, _ := filepath.Glob(filepath.Join(projectPath, "*.xcodeproj")); len(projects) > 0 {
+			args = []string{"-project", filepath.Base(projects[0])}
+		} else {
+			return nil, fmt.Errorf("no Package.swift, .xcworkspace or .xcodeproj in %s", projectPath)
+		}
+	}
+
+	scheme := opts.Scheme
+	if scheme == "" {
+		var err error
+		if scheme, err = s.detectScheme(projectPath, args); err != nil {
+			return nil, err
+		}
+	}
+	args = append(args, "-scheme", scheme)
+
+	if opts.Destination != "" {
+		args = append(args, "-destination", opts.Destination)
+	}
+	return args, nil
+}
+
+// detectScheme picks a scheme from xcodebuild -list
+func (s *SwiftAnalyzer) detectScheme(projectPath string, containerArgs []string) (string, error) {
+	cmd := exec.Command("xcodebuild", append([]string{"-list", "-json"}, containerArgs...)...)
+	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
+	output, err := s.opts.output(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to list Xcode schemes: %w", errdefs.ToolMissing(err))
+	}
+
+	type container struct {
+		Name    string   `json:"name"`
+		Schemes []string `json:"schemes"`
+	}
+	var list struct {
+		Project   *container `json:"project"`
+		Workspace *container `json:"workspace"`
+	}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return "", fmt.Errorf("failed to parse xcodebuild -list output: %w", err)
+	}
+
+	found := list.Workspace
+	if found == nil {
+		found = list.Project
+	}
+	if found == nil || len(found.Schemes) == 0 {
+		return "", fmt.Errorf("no Xcode schemes found; set analyzer.xcode.scheme")
+	}
+	for _, scheme := range found.Schemes {
+		if scheme == found.Name {
+			return scheme, nil
+		}
+	}
+	return found.Schemes[0], nil
+}
+
+// xcodebuild builds an xcodebuild command for the detected scheme
+func (s *SwiftAnalyzer) xcodebuild(projectPath, action string, extra ...string) (*exec.Cmd, error) {
+	args, err := s.xcodeBuildArgs(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("xcodebuild", append(append([]string{action}, args...), extra...)...)
+	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
+	return cmd, nil
+}
+
+// runXcodeCoverage runs xcodebuild test with coverage into a result bundle
+// and reads per-file coverage from it with xccov
+func (s *SwiftAnalyzer) runXcodeCoverage(projectPath string, report *CoverageReport) (*CoverageReport, error) {
+	resultBundle, err := coverageArtifact(projectPath, "xcode.xcresult")
+	if err != nil {
+		return nil, err
+	}
+	// xcodebuild refuses to overwrite a result bundle
+	if err := os.RemoveAll(resultBundle); err != nil {
+		return nil, fmt.Errorf("failed to remove old result bundle: %w", err)
+	}
+
+	cmd, err := s.xcodebuild(projectPath, "test", "-enableCodeCoverage", "YES", "-resultBundlePath", resultBundle)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// Failing tests still write the result bundle, but a missing Xcode writes none
+	if err := runFailed(s.opts.run(cmd)); err != nil {
+		return nil, err
+	}
+	if !fileExists(resultBundle) {
+		return nil, fmt.Errorf("xcodebuild wrote no result bundle\n%s", stdout.String()+stderr.String())
+	}
+
+	summary, err := s.xccov(projectPath, "view", "--report", "--json", resultBundle)
+	if err != nil {
+		return nil, err
+	}
+	// Line details are optional: without them files only get percentages
+	lines, _ := s.xccov(projectPath, "view", "--archive", "--json", resultBundle)
+
+	if err := parseXccov(projectPath, summary, lines, report); err != nil {
+		return nil, fmt.Errorf("failed to parse coverage: %w", err)
+	}
+	return report, nil
+}
+
+// xccov runs xcrun xccov and returns its output
+func (s *SwiftAnalyzer) xccov(projectPath string, args ...string) ([]byte, error) {
+	cmd := exec.Command("xcrun", append([]string{"xccov"}, args...)...)
+	cmd.Dir = projectPath
+	cmd.Env = s.opts.environ(projectPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := s.opts.output(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("xccov %s failed: %w\n%s", args[1], errdefs.ToolMissing(err), stderr.String())
+	}
+	return output, nil
+}
+
+// xccovReport is the JSON written by xccov view --report
+type xccovReport struct {
+	Targets []struct {
+		Name  string `json:"name"` // e.g. App.app or AppTests.xctest
+		Files []struct {
+			Path            string  `json:"path"`
+			LineCoverage    float64 `json:"lineCoverage"` // Fraction, 0 to 1
+			CoveredLines    int     `json:"coveredLines"`
+			ExecutableLines int     `json:"executableLines"`
+		} `json:"files"`
+	} `json:"targets"`
+}
+
+// xccovLine is a line in the JSON written by xccov view --archive
+type xccovLine struct {
+	Line           int  `json:"line"`
+	IsExecutable   bool `json:"isExecutable"`
+	ExecutionCount int  `json:"executionCount"`
+}
+
+// parseXccov parses xccov's coverage report and, if available, its
+// per-line archive dump into a report. Test bundles are left out.
+func parseXccov(projectPath string, summary, lines []byte, report *CoverageReport) error {
+	var xccov xccovReport
+	if err := json.Unmarshal(summary, &xccov); err != nil {
+		return err
+	}
+
+	// The archive maps absolute file paths to their lines
+	var archive map[string][]xccovLine
+	if len(lines) > 0 {
+		json.Unmarshal(lines, &archive)
+	}
+
+	var totalLines, totalCovered int
+	for _, target := range xccov.Targets {
+		if strings.HasSuffix(target.Name, ".xctest") {
+			continue
+		}
+		for _, file := range target.Files {
+			name := relativeToProject(projectPath, file.Path)
+			report.FileCoverage[name] = file.LineCoverage * 100
+			report.setTotalLines(name, file.ExecutableLines)
+			totalLines += file.ExecutableLines
+			totalCovered += file.CoveredLines
+
+			var uncovered, executed []int
+			for _, line := range archive[file.Path] {
+				switch {
+				case !line.IsExecutable:
+				case line.ExecutionCount == 0:
+					uncovered = append(uncovered, line.Line)
+				default:
+					executed = append(executed, line.Line)
+				}
+			}
+			report.setCoveredLines(name, uniqueLines(executed))
+
+			if file.CoveredLines < file.ExecutableLines {
+				report.UncoveredFiles = append(report.UncoveredFiles, name)
+				if len(uncovered) > 0 {
+					report.UncoveredLines[name] = uniqueLines(uncovered)
+				}
+			}
+		}
+	}
+
+	if totalLines > 0 {
+		report.TotalCoverage = float64(totalCovered) / float64(totalLines) * 100
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Package errdefs defines the kinds of errors shared across the agent's
+// packages. Errors returned by coverage, testgen and claude match these
+// with errors.Is, so callers can branch on the kind of failure instead of
+// matching error messages.
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+var (
+	// ErrToolMissing means a tool needed to build, test or measure coverage
+	// isn't installed
+	ErrToolMissing = errors.New("required tool not found")
+
+	// ErrRateLimited means the API rejected a request because of its rate
+	// limit; the request can be sent again once the limit resets
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrValidationFailed means a generated test didn't compile or its
+	// tests failed
+	ErrValidationFailed = errors.New("test validation failed")
+
+	// ErrBudgetExceeded means a file's time budget ran out
+	ErrBudgetExceeded = errors.New("time budget exceeded")
+
+	// ErrContextTooLarge means a prompt doesn't fit the model's context
+	// window; sending it again won't help
+	ErrContextTooLarge = errors.New("prompt too large for the model's context window")
+
+	// ErrOutsideProject means a file the agent was about to write resolves
+	// outside the project directory
+	ErrOutsideProject = errors.New("path outside the project directory")
+
+	// ErrStalled means an external command produced no output for too long,
+	// e.g. waiting on a hung connection or an interactive prompt, and was
+	// killed
+	ErrStalled = errors.New("command stalled")
+
+	// ErrSpendLimit means the session used up its token or cost budget
+	ErrSpendLimit = errors.New("spend limit reached")
+)
+
+// ToolMissing returns an error from running a command that isn't installed
+// as an ErrToolMissing error. Other errors are returned unchanged.
+func ToolMissing(err error) error {
+	if errors.Is(err, exec.ErrNotFound) && !errors.Is(err, ErrToolMissing) {
+		return fmt.Errorf("%w: %w", ErrToolMissing, err)
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxBlameRanges caps the line ranges blamed at once, so files with
+// scattered uncovered lines don't make for a huge command line
+const maxBlameRanges = 50
+
+// maxMessageChars caps the length of a commit message body
+const maxMessageChars = 600
+
+// LineCommit is a commit that last changed some of a file's lines
+type LineCommit struct {
+	Hash    string
+	Message string // Subject and body, the body truncated
+	Lines   []int  // The lines it last changed, sorted
+	Time    int64  // Author time, Unix seconds
+}
+
+// LineHistory returns the commits that last changed the given lines of a
+// file, most recent first and at most limit of them. Uncommitted lines are
+// left out.
+func (m *Manager) LineHistory(file string, lines []int, limit int) ([]LineCommit, error) {
+	if !m.enabled || len(lines) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	args := []string{"blame", "--porcelain"}
+	for i, r := range lineRanges(lines) {
+		if i == maxBlameRanges {
+			break
+		}
+		args = append(args, "-L", fmt.Sprintf("%d,%d", r[0], r[1]))
+	}
+	cmd := exec.Command("git", append(args, "--", file)...)
+	cmd.Dir = m.projectPath
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to blame %s: %w", file, err)
+	}
+
+	commits := parseBlame(string(output))
+	sort.Slice(commits, func(i, j int) bool {
+		if commits[i].Time != commits[j].Time {
+			return commits[i].Time > commits[j].Time
+		}
+		return commits[i].Hash < commits[j].Hash
+	})
+	if len(commits) > limit {
+		commits = commits[:limit]
+	}
+
+	for i := range commits {
+		message, err := m.commitMessage(commits[i].Hash)
+		if err != nil {
+			return nil, err
+		}
+		commits[i].Message = message
+	}
+	return commits, nil
+}
+
+// commitMessage returns a commit's message, the body truncated to
+// maxMessageChars
+func (m *Manager) commitMessage(hash string) (string, error) {
+	cmd := exec.Command("git", "show", "-s", "--format=%B", hash)
+	cmd.Dir = m.projectPath
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+
+	message := strings.TrimSpace(string(output))
+	if len(message) > maxMessageChars {
+		message = strings.TrimSpace(message[:maxMessageChars]) + " ..."
+	}
+	return message, nil
+}
+
+// lineRanges groups sorted line numbers into inclusive ranges
+func lineRanges(lines []int) [][2]int {
+	var ranges [][2]int
+	for _, line := range lines {
+		if n := len(ranges); n > 0 && line == ranges[n-1][1]+1 {
+			ranges[n-1][1] = line
+			continue
+		}
+		ranges = append(ranges, [2]int{line, line})
+	}
+	return ranges
+}
+
+// parseBlame collects the commits of `git blame --porcelain` output. Each
+// blamed line starts with a header "<hash> <original line> <final line>
+// [<lines in group>]"; the commit's details follow its first header only.
+func parseBlame(output string) []LineCommit {
+	byHash := make(map[string]*LineCommit)
+	var order []string
+	var current *LineCommit
+
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			continue // The line's content
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && len(fields[0]) == 40 {
+			finalLine, err := strconv.Atoi(fields[2])
+			if err != nil {
+				continue
+			}
+			hash := fields[0]
+			current = byHash[hash]
+			if current == nil {
+				current = &LineCommit{Hash: hash}
+				byHash[hash] = current
+				order = append(order, hash)
+			}
+			current.Lines = append(current.Lines, finalLine)
+			continue
+		}
+
+		if current != nil && len(fields) == 2 && fields[0] == "author-time" {
+			current.Time, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+
+	var commits []LineCommit
+	for _, hash := range order {
+		// Lines changed in the working tree aren't committed yet
+		if strings.Trim(hash, "0") == "" {
+			continue
+		}
+		commit := byHash[hash]
+		sort.Ints(commit.Lines)
+		commits = append(commits, *commit)
+	}
+	return commits
+}
@@ -0,0 +1,158 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// CreateBundle writes the given branches to a bundle file. Commits already
+// on a remote-tracking branch are left out, so the bundle only carries the
+// session's own commits when the receiving clone has the same remote.
+func (m *Manager) CreateBundle(file string, branches []string) error {
+	if !m.enabled {
+		return nil
+	}
+
+	args := append([]string{"bundle", "create", file}, branches...)
+	cmd := exec.Command("git", append(args, "--not", "--remotes")...)
+	cmd.Dir = m.projectPath
+	if _, err := cmd.CombinedOutput(); err == nil {
+		return nil
+	}
+
+	// Without remotes, or with nothing beyond them, bundle the whole history
+	cmd = exec.Command("git", args...)
+	cmd.Dir = m.projectPath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create git bundle: %w\n%s", err, output)
+	}
+	return nil
+}
+
+// bundleRefs is the namespace branches are fetched into from a bundle,
+// before local branches are updated
+const bundleRefs = "refs/coverage-agent/bundle/"
+
+// FetchBundle fetches branches from a bundle file without touching local
+// branches, and returns their commits by branch. DropBundleRefs removes
+// what it fetched once the commits are no longer needed.
+func (m *Manager) FetchBundle(file string, branches []string) (map[string]string, error) {
+	if !m.enabled {
+		return nil, nil
+	}
+
+	m.DropBundleRefs()
+	args := []string{"fetch", file}
+	for _, branch := range branches {
+		args = append(args, "refs/heads/"+branch+":"+bundleRefs+branch)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = m.projectPath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to fetch git bundle: %w\n%s", err, output)
+	}
+
+	commits := make(map[string]string, len(branches))
+	for _, branch := range branches {
+		commit, err := m.resolve(bundleRefs + branch)
+		if err != nil {
+			return nil, err
+		}
+		commits[branch] = commit
+	}
+	return commits, nil
+}
+
+// DropBundleRefs removes the refs FetchBundle created
+func (m *Manager) DropBundleRefs() {
+	cmd := exec.Command("git", "for-each-ref", "--format=%(refname)", bundleRefs)
+	cmd.Dir = m.projectPath
+	output, err := cmd.Output()
+	if err != nil {
+		return
+	}
+	for _, ref := range strings.Fields(string(output)) {
+		cmd := exec.Command("git", "update-ref", "-d", ref)
+		cmd.Dir = m.projectPath
+		_ = cmd.Run()
+	}
+}
+
+// UpdateBranches creates or fast-forwards local branches to the given
+// commits. It changes nothing if a branch exists and has commits that the
+// new commit lacks, since they would be lost. The checked-out branch is
+// fast-forwarded with its working tree, which fails on conflicting local
+// changes.
+func (m *Manager) UpdateBranches(commits map[string]string) error {
+	if !m.enabled {
+		return nil
+	}
+
+	current, _ := m.GetCurrentBranch()
+	branches := make([]string, 0, len(commits))
+	for branch := range commits {
+		branches = append(branches, branch)
+	}
+	sort.Strings(branches)
+
+	existing := make(map[string]string)
+	for _, branch := range branches {
+		local, err := m.resolve("refs/heads/" + branch)
+		if err != nil {
+			continue // A new branch
+		}
+		cmd := exec.Command("git", "merge-base", "--is-ancestor", local, commits[branch])
+		cmd.Dir = m.projectPath
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("local branch %s has commits that the imported session lacks; rename or delete it first", branch)
+		}
+		existing[branch] = local
+	}
+
+	for _, branch := range branches {
+		commit := commits[branch]
+		var cmd *exec.Cmd
+		switch local, ok := existing[branch]; {
+		case ok && local == commit:
+			continue
+		case ok && branch == current:
+			cmd = exec.Command("git", "merge", "--ff-only", commit)
+		case ok:
+			cmd = exec.Command("git", "update-ref", "refs/heads/"+branch, commit, local)
+		default:
+			cmd = exec.Command("git", "branch", branch, commit)
+		}
+		cmd.Dir = m.projectPath
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to update branch %s: %w\n%s", branch, err, output)
+		}
+	}
+	return nil
+}
+
+// resolve returns the commit a ref points to
+func (m *Manager) resolve(ref string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	cmd.Dir = m.projectPath
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Checkout switches to an existing branch
+func (m *Manager) Checkout(branch string) error {
+	if !m.enabled {
+		return nil
+	}
+
+	cmd := exec.Command("git", "checkout", branch)
+	cmd.Dir = m.projectPath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to checkout %s: %w\n%s", branch, err, output)
+	}
+	return nil
+}
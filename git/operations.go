@@ -98,8 +98,9 @@ func (m *Manager) CreateCommit(files []string, message string) error {
 	return nil
 }
 
-// CreateSafetyCommit creates a commit with auto-generated message
-func (m *Manager) CreateSafetyCommit(testFile string, coverageGain float64) error {
+// CreateSafetyCommit creates a commit with auto-generated message. Extra
+// files, such as generated mocks, are committed along with the test file.
+func (m *Manager) CreateSafetyCommit(testFile string, coverageGain float64, extraFiles ...string) error {
 	if !m.enabled {
 		return nil
 	}
@@ -111,9 +112,45 @@ func (m *Manager) CreateSafetyCommit(testFile string, coverageGain float64) erro
 		time.Now().Format(time.RFC3339),
 	)
 
+	return m.CreateCommit(append([]string{testFile}, extraFiles...), message)
+}
+
+// CreateQuarantineCommit commits a test file that was marked as skipped because it is flaky
+func (m *Manager) CreateQuarantineCommit(testFile string, reason string) error {
+	if !m.enabled {
+		return nil
+	}
+
+	message := fmt.Sprintf(
+		"test: Quarantine flaky tests in %s\n\n%s\n\nQuarantined by test-coverage-agent at %s",
+		testFile,
+		reason,
+		time.Now().Format(time.RFC3339),
+	)
+
 	return m.CreateCommit([]string{testFile}, message)
 }
 
+// AddNote attaches a note to a commit under the given notes ref, replacing
+// any existing note for that commit
+func (m *Manager) AddNote(ref, commitHash, message string) error {
+	if !m.enabled {
+		return nil
+	}
+
+	cmd := exec.Command("git", "notes", "--ref="+ref, "add", "-f", "-m", message, commitHash)
+	cmd.Dir = m.projectPath
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to add note: %s", stderr.String())
+	}
+
+	return nil
+}
+
 // CreateBranchForSession creates a new branch for this test generation session
 func (m *Manager) CreateBranchForSession(branchName string) error {
 	if !m.enabled {
@@ -188,6 +225,23 @@ func (m *Manager) GetLastCommitHash() (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
+// GetRemoteURL returns the URL of a remote, e.g. "origin"
+func (m *Manager) GetRemoteURL(name string) (string, error) {
+	if !m.enabled {
+		return "", nil
+	}
+
+	cmd := exec.Command("git", "remote", "get-url", name)
+	cmd.Dir = m.projectPath
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote URL: %w", err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
 // ResetToCommit resets to a specific commit (for rollback)
 func (m *Manager) ResetToCommit(commitHash string) error {
 	if !m.enabled {
@@ -204,6 +258,26 @@ func (m *Manager) ResetToCommit(commitHash string) error {
 	return nil
 }
 
+// RevertCommit creates a commit undoing the given one, keeping the history
+// after it. A revert that conflicts with later commits is aborted.
+func (m *Manager) RevertCommit(commitHash string) error {
+	if !m.enabled {
+		return nil
+	}
+
+	cmd := exec.Command("git", "revert", "--no-edit", commitHash)
+	cmd.Dir = m.projectPath
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		abort := exec.Command("git", "revert", "--abort")
+		abort.Dir = m.projectPath
+		abort.Run()
+		return fmt.Errorf("failed to revert commit %s: %w\n%s", commitHash, err, output)
+	}
+
+	return nil
+}
+
 // ShowDiff shows the diff of uncommitted changes
 func (m *Manager) ShowDiff() (string, error) {
 	if !m.enabled {
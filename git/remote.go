@@ -0,0 +1,66 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// IsRemoteURL reports whether a project argument is a git URL rather than a
+// local path: an http(s), ssh, git or file URL, or scp-like user@host:path
+func IsRemoteURL(project string) bool {
+	for _, scheme := range []string{"https://", "http://", "ssh://", "git://", "file://"} {
+		if strings.HasPrefix(project, scheme) {
+			return true
+		}
+	}
+	at := strings.Index(project, "@")
+	colon := strings.Index(project, ":")
+	return at > 0 && colon > at && !strings.Contains(project[:at], "/")
+}
+
+// Clone makes a shallow clone of a repository's default branch into dir
+func Clone(url, dir string) error {
+	cmd := exec.Command("git", "clone", "--depth", "1", url, dir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to clone %s: %w\n%s", url, err, output)
+	}
+	return nil
+}
+
+// RemoteURL returns the URL of a remote of the repository in dir
+func RemoteURL(dir, remote string) (string, error) {
+	cmd := exec.Command("git", "remote", "get-url", remote)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the URL of remote %s: %w", remote, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Fetch updates the remote-tracking branches of the repository in dir
+func Fetch(dir, remote string) error {
+	cmd := exec.Command("git", "fetch", remote)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to fetch %s: %w\n%s", remote, err, output)
+	}
+	return nil
+}
+
+// Push pushes a branch to a remote, setting it as the branch's upstream
+func (m *Manager) Push(remote, branch string) error {
+	if !m.enabled {
+		return nil
+	}
+
+	cmd := exec.Command("git", "push", "--set-upstream", remote, branch)
+	cmd.Dir = m.projectPath
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to push branch %s: %w\n%s", branch, err, output)
+	}
+
+	return nil
+}
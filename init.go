@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/cache"
+	"github.com/tablev/test-coverage-agent/config"
+	"github.com/tablev/test-coverage-agent/coverage"
+)
+
+// workflowFile is where init writes the GitHub Actions workflow
+const workflowFile = ".github/workflows/test-coverage-agent.yml"
+
+// maxInitFiles caps the files init looks at when choosing excludes
+const maxInitFiles = 50000
+
+// projectInfo is what init learned about a project
+type projectInfo struct {
+	Language   string
+	Framework  string
+	TaskRunner string   // Monorepo task runner, "" if none
+	SourceDirs []string // Top-level source directories
+	CI         []string // CI providers in use
+	Excludes   []string // Exclude patterns matching files of the project
+}
+
+// excludeCandidates are the exclude patterns init proposes per language,
+// if they match files of the project: generated code, vendored
+// dependencies and build output
+var excludeCandidates = map[string][]string{
+	"Go":         {"vendor/**", "**/*.pb.go", "**/*_gen.go", "**/mocks/**", "**/mock_*.go"},
+	"Python":     {"**/migrations/**", "**/*_pb2.py", "**/*_pb2_grpc.py", "venv/**", ".venv/**"},
+	"TypeScript": {"dist/**", "build/**", "coverage/**", "**/*.d.ts", "**/*.config.js", "**/*.config.ts"},
+	"Java":       {"**/generated/**", "target/**", "build/**"},
+	"Kotlin":     {"**/generated/**", "target/**", "build/**"},
+	"Scala":      {"**/generated/**", "target/**"},
+	"C++":        {"build/**", "third_party/**", "external/**"},
+	"Elixir":     {"deps/**", "_build/**"},
+	"Dart":       {"**/*.g.dart", "**/*.freezed.dart", "**/*.mocks.dart"},
+	"Swift":      {".build/**", "Pods/**"},
+}
+
+// branchCoverageLanguages support -branch-coverage
+var branchCoverageLanguages = map[string]bool{"Python": true, "Java": true, "Kotlin": true}
+
+// runInit inspects a project and writes a starter config file, and
+// optionally a GitHub Actions workflow that runs the agent
+func runInit(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	projectPath := fs.String("project", ".", "Path to the project to set up")
+	target := fs.Float64("target", 80.0, "Target code coverage percentage for the config")
+	workflow := fs.Bool("workflow", false, "Also write a GitHub Actions workflow to "+workflowFile)
+	force := fs.Bool("force", false, "Overwrite an existing config file or workflow")
+	printOnly := fs.Bool("print", false, "Print the proposed config instead of writing it")
+	fs.Parse(args)
+
+	if *target < 0 || *target > 100 {
+		fmt.Fprintf(os.Stderr, "Error: target coverage must be between 0 and 100\n")
+		return 1
+	}
+
+	info, err := inspectProject(*projectPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	info.print(*projectPath)
+
+	data, err := json.MarshalIndent(info.config(*target), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	data = append(data, '\n')
+
+	if *printOnly {
+		fmt.Printf("\n%s", data)
+		return 0
+	}
+
+	configPath := filepath.Join(*projectPath, config.DefaultConfigFile)
+	if err := writeNewFile(configPath, data, *force); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("\nWrote %s\n", configPath)
+
+	if *workflow {
+		path := filepath.Join(*projectPath, filepath.FromSlash(workflowFile))
+		if err := writeNewFile(path, []byte(info.workflow()), *force); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Wrote %s (add the ANTHROPIC_API_KEY repository secret before running it)\n", path)
+	}
+
+	return 0
+}
+
+// inspectProject detects a project's language, test framework, layout and
+// CI providers, and the exclude patterns that apply to it
+func inspectProject(projectPath string) (*projectInfo, error) {
+	analyzer, err := coverage.DetectProjectLanguage(projectPath, coverage.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("%w (see \"Other Languages\" in the README for custom analyzers)", err)
+	}
+
+	info := &projectInfo{Language: analyzer.GetLanguageName()}
+	info.Framework = testFramework(projectPath, info.Language)
+
+	for _, runner := range []struct{ file, name string }{{"nx.json", "Nx"}, {"turbo.json", "Turborepo"}, {"pants.toml", "Pants"}} {
+		if fileExists(filepath.Join(projectPath, runner.file)) {
+			info.TaskRunner = runner.name
+			break
+		}
+	}
+
+	for _, dir := range []string{"src", "lib", "internal", "pkg", "cmd", "app", "packages", "apps", "libs"} {
+		if stat, err := os.Stat(filepath.Join(projectPath, dir)); err == nil && stat.IsDir() {
+			info.SourceDirs = append(info.SourceDirs, dir)
+		}
+	}
+
+	ciFiles := []struct{ path, name string }{
+		{".github/workflows", "GitHub Actions"},
+		{".gitlab-ci.yml", "GitLab CI"},
+		{".circleci/config.yml", "CircleCI"},
+		{"Jenkinsfile", "Jenkins"},
+		{"azure-pipelines.yml", "Azure Pipelines"},
+		{"bitbucket-pipelines.yml", "Bitbucket Pipelines"},
+		{".buildkite", "Buildkite"},
+	}
+	for _, ci := range ciFiles {
+		if _, err := os.Stat(filepath.Join(projectPath, filepath.FromSlash(ci.path))); err == nil {
+			info.CI = append(info.CI, ci.name)
+		}
+	}
+
+	info.Excludes = matchingExcludes(projectPath, excludeCandidates[info.Language])
+	return info, nil
+}
+
+// testFramework names the test framework a project uses, judging by its
+// build and dependency files
+func testFramework(projectPath, language string) string {
+	contains := func(text string, files ...string) bool {
+		for _, file := range files {
+			data, err := os.ReadFile(filepath.Join(projectPath, file))
+			if err == nil && strings.Contains(string(data), text) {
+				return true
+			}
+		}
+		return false
+	}
+	jvmBuild := []string{"pom.xml", "build.gradle", "build.gradle.kts"}
+
+	switch language {
+	case "Go":
+		if contains("github.com/stretchr/testify", "go.mod") {
+			return "go test with testify"
+		}
+		return "go test"
+	case "Python":
+		if contains("pytest", "pyproject.toml", "requirements.txt", "requirements-dev.txt", "setup.cfg", "tox.ini", "pytest.ini") {
+			return "pytest"
+		}
+		return "unittest"
+	case "TypeScript":
+		for _, framework := range []struct{ pkg, name string }{{"vitest", "Vitest"}, {"jest", "Jest"}, {"mocha", "Mocha"}} {
+			if contains(`"`+framework.pkg+`"`, "package.json") {
+				return framework.name
+			}
+		}
+		return "Jest"
+	case "Java", "Kotlin":
+		switch {
+		case contains("kotest", jvmBuild...):
+			return "Kotest"
+		case contains("junit-jupiter", jvmBuild...) || contains("junit.jupiter", jvmBuild...):
+			return "JUnit 5"
+		case contains("junit", jvmBuild...):
+			return "JUnit 4"
+		}
+		return "JUnit"
+	case "Scala":
+		if contains("munit", "build.sbt") {
+			return "MUnit"
+		}
+		return "ScalaTest"
+	case "C++":
+		if contains("gtest", "CMakeLists.txt") || contains("GTest", "CMakeLists.txt") {
+			return "GoogleTest"
+		}
+		return "CTest"
+	case "Elixir":
+		return "ExUnit"
+	case "Dart":
+		if contains("flutter_test", "pubspec.yaml") {
+			return "flutter_test"
+		}
+		return "package:test"
+	case "Swift":
+		return "XCTest"
+	}
+	return "unknown"
+}
+
+// matchingExcludes returns the patterns that match at least one file of the
+// project, so the config only lists excludes that do something
+func matchingExcludes(projectPath string, patterns []string) []string {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	matched := make(map[string]bool)
+	count := 0
+	filepath.WalkDir(projectPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			switch d.Name() {
+			case ".git", "node_modules", cache.DirName:
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if count++; count > maxInitFiles {
+			return filepath.SkipAll
+		}
+
+		rel, err := filepath.Rel(projectPath, path)
+		if err != nil {
+			return nil
+		}
+		for _, pattern := range patterns {
+			if !matched[pattern] && coverage.MatchGlob(pattern, filepath.ToSlash(rel)) {
+				matched[pattern] = true
+			}
+		}
+		return nil
+	})
+
+	var excludes []string
+	for _, pattern := range patterns {
+		if matched[pattern] {
+			excludes = append(excludes, pattern)
+		}
+	}
+	return excludes
+}
+
+// config returns the proposed config file contents
+func (info *projectInfo) config(target float64) map[string]any {
+	cfg := map[string]any{
+		"target_coverage": target,
+		"max_files":       20, // Keeps the first pull requests reviewable
+	}
+	if len(info.Excludes) > 0 {
+		cfg["exclude"] = info.Excludes
+	}
+	if branchCoverageLanguages[info.Language] {
+		cfg["analyzer"] = map[string]any{"branch_coverage": true}
+	}
+	return cfg
+}
+
+// print shows what init found
+func (info *projectInfo) print(projectPath string) {
+	orNone := func(values []string) string {
+		if len(values) == 0 {
+			return "none detected"
+		}
+		return strings.Join(values, ", ")
+	}
+
+	fmt.Printf("Project:     %s\n", projectPath)
+	fmt.Printf("Language:    %s (%s)\n", info.Language, info.Framework)
+	layout := "source in " + orNone(info.SourceDirs)
+	if info.TaskRunner != "" {
+		layout += ", " + info.TaskRunner + " workspace"
+	}
+	fmt.Printf("Layout:      %s\n", layout)
+	fmt.Printf("CI:          %s\n", orNone(info.CI))
+	fmt.Printf("Excludes:    %s\n", orNone(info.Excludes))
+}
+
+// setupSteps are the GitHub Actions steps that install a language's
+// toolchain and test dependencies
+var setupSteps = map[string]string{
+	"Go": `      - uses: actions/setup-go@v5
+        with:
+          go-version-file: go.mod
+`,
+	"Python": `      - uses: actions/setup-python@v5
+        with:
+          python-version: '3.x'
+      - uses: actions/setup-go@v5
+        with:
+          go-version: stable
+      - name: Install test dependencies
+        run: |
+          if [ -f requirements.txt ]; then pip install -r requirements.txt; fi
+          pip install pytest pytest-cov
+`,
+	"TypeScript": `      - uses: actions/setup-node@v4
+        with:
+          node-version: 'lts/*'
+      - uses: actions/setup-go@v5
+        with:
+          go-version: stable
+      - name: Install dependencies
+        run: npm ci
+`,
+	"Java": `      - uses: actions/setup-java@v4
+        with:
+          distribution: temurin
+          java-version: '17'
+      - uses: actions/setup-go@v5
+        with:
+          go-version: stable
+`,
+}
+
+// workflow returns a GitHub Actions workflow that runs the agent weekly or
+// on demand and pushes its branch for review
+func (info *projectInfo) workflow() string {
+	setup, ok := setupSteps[info.Language]
+	if info.Language == "Kotlin" || info.Language == "Scala" {
+		setup, ok = setupSteps["Java"], true
+	}
+	if !ok {
+		setup = fmt.Sprintf(`      # Set up the %s toolchain and test dependencies here
+      - uses: actions/setup-go@v5
+        with:
+          go-version: stable
+`, info.Language)
+	}
+
+	return `name: Test Coverage Agent
+
+on:
+  workflow_dispatch:
+  schedule:
+    - cron: '0 3 * * 1'
+
+permissions:
+  contents: write
+
+jobs:
+  coverage:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+
+` + setup + `
+      - name: Install test-coverage-agent
+        run: |
+          git clone https://github.com/jucevic/test-coverage-agent.git /tmp/tca
+          cd /tmp/tca && go build -o test-coverage-agent
+          sudo mv test-coverage-agent /usr/local/bin/
+
+      - name: Generate tests
+        env:
+          ANTHROPIC_API_KEY: ${{ secrets.ANTHROPIC_API_KEY }}
+        run: |
+          git config user.name "github-actions[bot]"
+          git config user.email "github-actions[bot]@users.noreply.github.com"
+          test-coverage-agent -project . -max-iterations 10
+
+      - name: Push the agent's branch
+        run: git push origin HEAD
+`
+}
+
+// writeNewFile writes a file, creating its directory, unless it already
+// exists and force is false
+func writeNewFile(path string, data []byte, force bool) error {
+	if fileExists(path) && !force {
+		return fmt.Errorf("%s already exists (use -force to overwrite)", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// fileExists reports whether a path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
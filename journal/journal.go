@@ -0,0 +1,223 @@
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/cache"
+)
+
+const journalFile = "journal.json"
+
+// Entry records a single file touched by the agent
+type Entry struct {
+	File      string    `json:"file"`               // Absolute path of the touched file
+	Original  string    `json:"original,omitempty"` // Backup of the original contents (empty if the file was created)
+	Created   bool      `json:"created"`            // True if the file did not exist before the agent touched it
+	Snapshots []string  `json:"snapshots,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Journal is a filesystem-based change log used as a safety net for
+// projects that are not under git. It keeps originals and snapshots of
+// generated files so every change can be audited and undone.
+type Journal struct {
+	store   *cache.Cache
+	dir     string
+	Entries []Entry `json:"entries"`
+}
+
+// Open loads the journal for a project, creating an empty one if none exists
+func Open(projectPath string) (*Journal, error) {
+	store := cache.New(projectPath)
+	j := &Journal{
+		store:   store,
+		dir:     store.Path(cache.Journal, ""),
+		Entries: []Entry{},
+	}
+
+	data, err := os.ReadFile(filepath.Join(j.dir, journalFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal journal: %w", err)
+	}
+
+	return j, nil
+}
+
+// Record must be called before the agent writes to a file. The first time a
+// file is recorded its original contents (if any) are backed up.
+func (j *Journal) Record(file string) error {
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path %s: %w", file, err)
+	}
+
+	if j.find(absPath) != nil {
+		return nil
+	}
+
+	entry := Entry{
+		File:      absPath,
+		Created:   true,
+		Timestamp: time.Now(),
+	}
+
+	if _, err := os.Stat(absPath); err == nil {
+		backup := filepath.Join(j.dir, fmt.Sprintf("%04d.orig", len(j.Entries)+1))
+		if err := copyFile(absPath, backup); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", file, err)
+		}
+		entry.Original = backup
+		entry.Created = false
+	}
+
+	j.Entries = append(j.Entries, entry)
+	return j.save()
+}
+
+// RecordCreated records a file that an external tool has just created, so
+// undo removes it
+func (j *Journal) RecordCreated(file string) error {
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path %s: %w", file, err)
+	}
+
+	if j.find(absPath) != nil {
+		return nil
+	}
+
+	j.Entries = append(j.Entries, Entry{
+		File:      absPath,
+		Created:   true,
+		Timestamp: time.Now(),
+	})
+	return j.save()
+}
+
+// Snapshot stores a copy of the current contents of a recorded file,
+// giving an audit trail of what the agent wrote
+func (j *Journal) Snapshot(file string) error {
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path %s: %w", file, err)
+	}
+
+	entry := j.find(absPath)
+	if entry == nil {
+		return fmt.Errorf("file %s is not recorded in the journal", file)
+	}
+
+	snapshot := filepath.Join(j.dir, fmt.Sprintf("%04d.%d.snap", j.index(absPath)+1, len(entry.Snapshots)+1))
+	if err := copyFile(absPath, snapshot); err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w", file, err)
+	}
+	entry.Snapshots = append(entry.Snapshots, snapshot)
+
+	return j.save()
+}
+
+// Undo restores every recorded file to its original state (removing files
+// the agent created) and clears the journal. It returns the restored paths.
+func (j *Journal) Undo() ([]string, error) {
+	var restored []string
+
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		entry := j.Entries[i]
+
+		if entry.Created {
+			if err := os.Remove(entry.File); err != nil && !os.IsNotExist(err) {
+				return restored, fmt.Errorf("failed to remove %s: %w", entry.File, err)
+			}
+		} else {
+			if err := copyFile(entry.Original, entry.File); err != nil {
+				return restored, fmt.Errorf("failed to restore %s: %w", entry.File, err)
+			}
+		}
+
+		restored = append(restored, entry.File)
+	}
+
+	return restored, j.Clean()
+}
+
+// Clean discards the journal and its backups, keeping the agent's changes
+func (j *Journal) Clean() error {
+	j.Entries = []Entry{}
+	if err := os.RemoveAll(j.dir); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// find returns the entry for an absolute path, or nil
+func (j *Journal) find(absPath string) *Entry {
+	if i := j.index(absPath); i >= 0 {
+		return &j.Entries[i]
+	}
+	return nil
+}
+
+// index returns the position of the entry for an absolute path, or -1
+func (j *Journal) index(absPath string) int {
+	for i := range j.Entries {
+		if j.Entries[i].File == absPath {
+			return i
+		}
+	}
+	return -1
+}
+
+// save writes the journal index to disk
+func (j *Journal) save() error {
+	if _, err := j.store.Dir(cache.Journal); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(j.dir, journalFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	return nil
+}
+
+// copyFile copies src to dst, creating parent directories as needed
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,87 @@
+// Package llm defines the interface the test generator uses to talk to a
+// model, so the generator doesn't depend on a provider. The Claude API,
+// Ollama and Vertex AI clients are adapted to it by New; tests can replace
+// it with a fake, and decorators such as a response cache can wrap it.
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/claude"
+)
+
+// Providers of the models that write tests
+const (
+	ProviderAnthropic = "anthropic" // The Claude API
+	ProviderOllama    = "ollama"    // A local Ollama server
+	ProviderVertex    = "vertex"    // Gemini models on Google Cloud Vertex AI
+)
+
+// Request is a prompt for a model
+type Request struct {
+	System string // Instructions shared by every request
+	Prompt string // The user message
+
+	// Prefix starts the user message with content later requests share,
+	// which providers that support it cache
+	Prefix string
+
+	// Attachments are files sent along with the user message, by title
+	Attachments map[string]string
+
+	// Model overrides the client's default model; empty uses the default
+	Model string
+}
+
+// Text returns the user message as one string: the attachments, the
+// prefix and the prompt, for providers without separate blocks for them
+func (r Request) Text() string {
+	titles := make([]string, 0, len(r.Attachments))
+	for title := range r.Attachments {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	var b strings.Builder
+	for _, title := range titles {
+		fmt.Fprintf(&b, "<document title=%q>\n%s\n</document>\n\n", title, r.Attachments[title])
+	}
+	if r.Prefix != "" {
+		b.WriteString(r.Prefix + "\n\n")
+	}
+	b.WriteString(r.Prompt)
+	return b.String()
+}
+
+// Client sends prompts to the models of a provider
+type Client interface {
+	// SendMessage sends a request and returns the model's response
+	SendMessage(req Request) (string, error)
+
+	// CountTokens returns the number of input tokens a request would use
+	CountTokens(req Request) (int, error)
+
+	// Model returns the model of requests that don't name one
+	Model() string
+}
+
+// AccessChecker is implemented by clients that can verify up front that
+// requests to a model will be accepted
+type AccessChecker interface {
+	CheckModelAccess(model string) error
+}
+
+// UsageTracker is implemented by clients that count the tokens they use
+type UsageTracker interface {
+	// TakeUsage returns the tokens used and their cost in USD since the
+	// last call
+	TakeUsage() (claude.Usage, float64)
+}
+
+// Uploader is implemented by clients that upload attachments and should
+// delete them at the end of a session
+type Uploader interface {
+	DeleteUploads() error
+}
@@ -0,0 +1,104 @@
+package llm
+
+import (
+	"github.com/tablev/test-coverage-agent/claude"
+	"github.com/tablev/test-coverage-agent/ollama"
+	"github.com/tablev/test-coverage-agent/vertex"
+)
+
+// Options selects and configures a provider's client
+type Options struct {
+	// Provider is ProviderAnthropic (default), ProviderOllama or ProviderVertex
+	Provider string
+
+	// APIKey authenticates Claude API requests
+	APIKey string
+
+	// OllamaHost is the address of the Ollama server; empty uses
+	// OLLAMA_HOST or the default local address
+	OllamaHost string
+
+	// VertexProject and VertexLocation are the Google Cloud project and
+	// location of Vertex AI requests; empty uses the environment
+	VertexProject  string
+	VertexLocation string
+
+	// Model overrides the provider's default model
+	Model string
+
+	// MaxTokens overrides the provider's output token limit (0 = default)
+	MaxTokens int
+
+	// RequestsPerMinute and TokensPerMinute pace Claude API requests to
+	// stay under the account's rate limits (0 = unlimited)
+	RequestsPerMinute int
+	TokensPerMinute   int
+}
+
+// provider is the API the clients of every provider offer
+type provider interface {
+	Model() string
+	SetModel(model string)
+	SetMaxTokens(maxTokens int)
+	SendMessageWithPrefix(system, prefix, prompt, model string) (string, error)
+	SendMessageWithAttachments(system, prompt, model string, attachments map[string]string) (string, error)
+	CountTokens(system, prompt, model string) (int, error)
+	CheckModelAccess(model string) error
+	TakeUsage() (claude.Usage, float64)
+	DeleteUploads() error
+}
+
+// New returns the client of the configured provider
+func New(options Options) Client {
+	var p provider
+	switch options.Provider {
+	case ProviderOllama:
+		p = ollama.NewClient(options.OllamaHost)
+	case ProviderVertex:
+		p = vertex.NewClient(options.VertexProject, options.VertexLocation)
+	default:
+		claudeClient := claude.NewClient(options.APIKey)
+		if options.RequestsPerMinute > 0 || options.TokensPerMinute > 0 {
+			claudeClient.SetRateLimits(options.RequestsPerMinute, options.TokensPerMinute)
+		}
+		p = claudeClient
+	}
+
+	if options.Model != "" {
+		p.SetModel(options.Model)
+	}
+	if options.MaxTokens > 0 {
+		p.SetMaxTokens(options.MaxTokens)
+	}
+	return &client{p}
+}
+
+// client adapts a provider's client to Client and the optional interfaces
+type client struct {
+	provider
+}
+
+// SendMessage sends the attachments as documents if there are any, and
+// else the prefix as a block of its own, so the provider can cache them
+func (c *client) SendMessage(req Request) (string, error) {
+	if req.Model == "" {
+		req.Model = c.Model()
+	}
+	if len(req.Attachments) > 0 {
+		prompt := req.Prompt
+		if req.Prefix != "" {
+			prompt = req.Prefix + "\n\n" + prompt
+		}
+		return c.SendMessageWithAttachments(req.System, prompt, req.Model, req.Attachments)
+	}
+	return c.SendMessageWithPrefix(req.System, req.Prefix, req.Prompt, req.Model)
+}
+
+// CountTokens counts the tokens of the request with its attachments
+// included as text
+func (c *client) CountTokens(req Request) (int, error) {
+	if req.Model == "" {
+		req.Model = c.Model()
+	}
+	return c.provider.CountTokens(req.System, req.Text(), req.Model)
+}
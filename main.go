@@ -2,54 +2,280 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 
+	"github.com/tablev/test-coverage-agent/attest"
+	"github.com/tablev/test-coverage-agent/cache"
+	"github.com/tablev/test-coverage-agent/ci"
+	"github.com/tablev/test-coverage-agent/claude"
 	"github.com/tablev/test-coverage-agent/config"
+	"github.com/tablev/test-coverage-agent/coverage"
+	"github.com/tablev/test-coverage-agent/errdefs"
+	"github.com/tablev/test-coverage-agent/git"
+	"github.com/tablev/test-coverage-agent/llm"
+	"github.com/tablev/test-coverage-agent/ollama"
 	"github.com/tablev/test-coverage-agent/orchestrator"
+	"github.com/tablev/test-coverage-agent/testgen"
+	"github.com/tablev/test-coverage-agent/vertex"
+	"github.com/tablev/test-coverage-agent/workplan"
 )
 
+// exitSpendLimit is the exit code of a session stopped by -max-cost or
+// -max-tokens-total
+const exitSpendLimit = 3
+
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		if code, ok := runCommand(os.Args[1], os.Args[2:]); ok {
+			os.Exit(code)
+		}
+	}
+
+	cfg := &config.Config{}
+
 	// CLI flags
+	flag.StringVar(&cfg.ProjectPath, "project", ".", "Path to the project to analyze")
+	flag.Float64Var(&cfg.TargetCoverage, "target", 80.0, "Target code coverage percentage (0-100)")
+	flag.Float64Var(&cfg.Gain, "gain", 0, "Coverage goal in percentage points to gain over the starting coverage, instead of -target (0 = use -target)")
+	flag.StringVar(&cfg.CoverageReport, "coverage-report", "", "Start from an existing coverage report (Go coverprofile, lcov, Cobertura, jacoco.xml, coverage.json) instead of running the test suite")
+	flag.StringVar(&cfg.CoverageOut, "coverage-out", "", "Export every coverage measurement as format:path, e.g. lcov:coverage.lcov, for genhtml, editors or Codecov")
+	flag.StringVar(&cfg.CICoverage, "ci-coverage", "", "Warn if the starting coverage differs from what CI reported for the same commit: codecov, or a URL returning JSON ({commit} is replaced by the commit hash)")
+	flag.Float64Var(&cfg.CITolerance, "ci-tolerance", 1.0, "Allowed difference in percentage points between the local and the CI coverage")
+	flag.BoolVar(&cfg.PRLabels, "pr-labels", false, "In CI, adjust the session to the pull request's labels: "+ci.LabelSkip+", "+ci.LabelStrict+" or "+ci.LabelTargetPrefix+"N")
+	flag.Float64Var(&cfg.StrictTarget, "strict-target", 90, "Target coverage of pull requests labeled "+ci.LabelStrict)
+	flag.StringVar(&cfg.StateFile, "state", "", "State file for pause/resume (default: <project>/.coverage-agent/state.json, or a .coverage-agent-state.json of an earlier version)")
+	flag.StringVar(&cfg.HTMLReport, "html-report", "", "HTML session report written at the end of the run (default: <project>/.coverage-agent/report.html)")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Preview actions without making changes")
+	flag.IntVar(&cfg.MaxIterations, "max-iterations", 100, "Maximum number of test generation iterations")
+	flag.IntVar(&cfg.MaxFiles, "max-files", 0, "Maximum number of test files to create or modify per run, counting only validated changes (0 = unlimited)")
+	flag.IntVar(&cfg.FileBudgetMin, "file-budget", 0, "Minutes to spend generating, fixing and validating one file before marking it failed and moving on (0 = unlimited)")
+	flag.IntVar(&cfg.SuiteInterval, "suite-interval", 0, "Minimum minutes between full-suite coverage runs; iterations in between only validate the new test (0 = no limit)")
+	flag.IntVar(&cfg.SuiteEvery, "suite-every", 0, "Run the full-suite coverage analysis every N iterations and only validate the new test in between (0 = every iteration)")
+	flag.BoolVar(&cfg.FreshCoverage, "fresh-coverage", false, "Always run the test suite for coverage instead of reusing the cached report of an unchanged source tree")
+	flag.BoolVar(&cfg.Incremental, "incremental", false, "Go: after a test change, re-measure only the changed packages and merge them into the last coverage profile instead of running go test ./...")
+	flag.IntVar(&cfg.ChunkFiles, "chunk-files", 0, "Start a new branch every N committed test files (0 = single branch)")
+	flag.Float64Var(&cfg.ChunkGain, "chunk-gain", 0, "Start a new branch every X% of coverage gained (0 = single branch)")
+	flag.BoolVar(&cfg.AnnotateTests, "annotate-tests", false, "Add a comment above each generated test naming the lines it targets")
+	flag.BoolVar(&cfg.Testcontainers, "testcontainers", false, "Test repository/DAO code against a real database started with testcontainers when the project depends on a database driver (Go, Java, Kotlin, Python, TypeScript; needs Docker)")
+	flag.BoolVar(&cfg.Harness, "integration-harness", false, "Test main packages and entrypoint scripts through an integration harness (Go, Python)")
+	flag.IntVar(&cfg.AttachKB, "attach-kb", 0, "Upload source files larger than this many KB through the Files API and attach them to the prompt instead of inlining them (0 = never)")
+	flag.BoolVar(&cfg.PackageContext, "package-context", false, "Attach the other source files of each file's directory as documents, so tests can use the package's types and helpers")
+	flag.BoolVar(&cfg.GoMocks, "go-mocks", false, "Generate mockgen/mockery mocks for the interfaces declared in a Go file's package that the file depends on, and use them in its tests (imported interfaces such as io.Reader get no mocks)")
+	flag.StringVar(&cfg.Analyzer.Python.Runner, "python-runner", "", "Python: test runner, one of "+strings.Join(coverage.PythonRunners, ", ")+" (default: detected)")
+	flag.Var(&listFlag{&cfg.Analyzer.Go.Tags}, "go-tags", "Go: build tag for every go build and go test, e.g. integration (repeatable)")
+	flag.StringVar(&cfg.Analyzer.CoverPkg, "coverpkg", "", "Go: packages to measure coverage in, passed to go test -coverpkg (e.g. ./... to credit tests in cmd/ for the internal/ code they exercise)")
+	flag.BoolVar(&cfg.Analyzer.BranchCoverage, "branch-coverage", false, "Collect uncovered branch arms and target them in prompts (Python, Java)")
+	flag.BoolVar(&cfg.DropGenerated, "drop-generated", false, "Leave generated files (\"Code generated ... DO NOT EDIT\", @generated, minified JavaScript) out of total coverage too; they are always left out of the work plan")
+	flag.BoolVar(&cfg.SkipDeadCode, "skip-dead-code", false, "Skip untested files with code that staticcheck, vulture or ts-prune report as unused, suggesting deletion instead, and work on files with some unused code last")
+	flag.Var(&listFlag{&cfg.Exclude}, "exclude", "Leave files matching a project-relative path pattern out of the work plan, e.g. 'vendor/**' or '**/*.pb.go' (repeatable)")
+	flag.Var(&listFlag{&cfg.MergeCoverage}, "merge-coverage", "Merge an extra coverage report (Go coverprofile, lcov, ...), e.g. from integration tests, into every coverage measurement (repeatable)")
+	flag.IntVar(&cfg.Analyzer.StallMinutes, "stall-timeout", 0, "Minutes a coverage, test or build command may run without printing anything before it is killed and its file marked failed (0 = never)")
+	flag.Var(&envFlag{&cfg.Analyzer.Env}, "env", "Set an environment variable for every coverage, test and validation command, as KEY=VALUE (repeatable)")
+	flag.BoolVar(&cfg.CoverageNotes, "coverage-notes", false, "Attach the coverage snapshot as a git note (refs/notes/coverage) to each safety commit")
+	flag.BoolVar(&cfg.RevertDrops, "revert-regressions", false, "Revert the safety commit of a test change after which a file's coverage dropped (the file is marked for rework either way)")
+	flag.BoolVar(&cfg.NoModify, "no-modify", false, "Only create new test files; files whose tests already exist are skipped instead of improved")
+	flag.BoolVar(&cfg.ImproveOnly, "improve-only", false, "Only improve existing test files with gaps; source files without any tests are skipped")
+	flag.BoolVar(&cfg.SafeImprove, "safe-improve", false, "Validate improved tests in a temporary copy of the project before replacing the real file")
+	flag.IntVar(&cfg.FlakyRuns, "flaky-runs", 0, "Re-run each validated test N times and quarantine it if any run fails (0 = off)")
+	flag.BoolVar(&cfg.RefactorHints, "suggest-refactorings", false, "After the run, ask the model for refactorings that would make the files it failed to test testable, and add them to the summary and report")
+	flag.IntVar(&cfg.ReviewBelow, "review-below", testgen.DefaultReviewBelow, "Flag validated tests with a lower confidence score (0-100) for review in the summary, report and activity log (0 = never)")
+	flag.IntVar(&cfg.FailureLogs, "failure-logs", 50, "Number of failed validation outputs to keep in .coverage-agent/logs (0 = none)")
+	flag.IntVar(&cfg.FailureLogKB, "failure-log-kb", 512, "Size limit in KB for each kept validation output (0 = unlimited)")
+	flag.Int64Var(&cfg.CacheMaxSizeMB, "cache-max-size", cache.DefaultMaxSize/(1024*1024), "Size limit in MB for the .coverage-agent cache")
+	flag.BoolVar(&cfg.ReuseResponses, "reuse-responses", false, "Reuse cached responses to prompts identical to an earlier session's instead of calling the API; responses whose tests failed are never reused")
+	flag.StringVar(&cfg.ReportLanguage, "report-language", "", "Translate the final summary into this language, e.g. German or ja (default: English)")
+	flag.StringVar(&cfg.Attestation, "attestation", "", "Write a signed JSON attestation of the final coverage, commit and tool versions to this file at the end of a successful session")
+	flag.StringVar(&cfg.AttestKeyEnv, "attestation-key-env", attest.DefaultKeyEnv, "Environment variable holding the HMAC key that signs the attestation")
+	flag.StringVar(&cfg.ActivityLog, "activity-log", "", "Append an entry on coverage and the tests written to this Markdown file in the project, e.g. COVERAGE_AGENT_LOG.md or CHANGELOG.md, and commit it on the session branch")
+	flag.BoolVar(&cfg.KeepFailed, "keep-failed-tests", false, "Leave test files that failed validation on disk for inspection; by default a new file is removed and an existing one restored")
+	flag.BoolVar(&cfg.BlameContext, "blame-context", false, "Include the messages of the commits that last changed the uncovered lines in prompts, so tests target the intended behavior")
+	flag.StringVar(&cfg.Partition, "partition", "", "Only work on a partition of the files, path:PREFIX[,PREFIX...] or hash:K/N, so agents running on the same repository take disjoint files")
+	flag.StringVar(&cfg.Provider, "provider", llm.ProviderAnthropic, "Where the models run: anthropic for the Claude API, ollama for a local Ollama server, or vertex for Gemini on Google Cloud Vertex AI")
+	flag.StringVar(&cfg.OllamaHost, "ollama-host", "", "Address of the Ollama server with -provider ollama (default: OLLAMA_HOST or "+ollama.DefaultHost+")")
+	flag.StringVar(&cfg.VertexProject, "vertex-project", "", "Google Cloud project with -provider vertex (default: GOOGLE_CLOUD_PROJECT or the credentials' project)")
+	flag.StringVar(&cfg.VertexLocation, "vertex-location", "", "Vertex AI location with -provider vertex (default: GOOGLE_CLOUD_LOCATION or "+vertex.DefaultLocation+")")
+	flag.StringVar(&cfg.Model, "model", "", "Claude model or alias (opus, sonnet, haiku) for files not matched by the config's models patterns (default: "+claude.DefaultModel+", "+ollama.DefaultModel+" with -provider ollama, "+vertex.DefaultModel+" with -provider vertex)")
+	flag.IntVar(&cfg.MaxTokens, "max-tokens", claude.DefaultMaxTokens, "Output token limit of test generation requests; raise it if long test files come back truncated")
+	flag.IntVar(&cfg.RequestsPerMin, "requests-per-minute", 0, "Pace Claude API requests to at most this many per minute, instead of running into rate limits (0 = unlimited)")
+	flag.IntVar(&cfg.TokensPerMin, "tokens-per-minute", 0, "Pace Claude API requests to at most this many input and output tokens per minute (0 = unlimited)")
+	flag.IntVar(&cfg.MaxTokensTotal, "max-tokens-total", 0, "Stop the session once it used this many API tokens, input and output (0 = unlimited)")
+	flag.Float64Var(&cfg.MaxCost, "max-cost", 0, "Stop the session once its estimated API cost reaches this many USD (0 = unlimited)")
+	flag.StringVar(&cfg.ClaudeAPIKey, "api-key", "", "Claude API key (or set ANTHROPIC_API_KEY env var)")
+
 	var (
-		projectPath    = flag.String("project", ".", "Path to the project to analyze")
-		targetCoverage = flag.Float64("target", 80.0, "Target code coverage percentage (0-100)")
-		stateFile      = flag.String("state", ".coverage-agent-state.json", "State file for pause/resume")
-		dryRun         = flag.Bool("dry-run", false, "Preview actions without making changes")
-		resume         = flag.Bool("resume", false, "Resume from previous state")
-		maxIterations  = flag.Int("max-iterations", 100, "Maximum number of test generation iterations")
-		claudeAPIKey   = flag.String("api-key", "", "Claude API key (or set ANTHROPIC_API_KEY env var)")
+		configFile = flag.String("config", "", "Configuration file (default: <project>/"+config.DefaultConfigFile+" if present)")
+		resume     = flag.Bool("resume", false, "Resume from previous state")
+		workspace  = flag.String("workspace", "", "Directory for clones of projects given as a git URL (default: <user cache dir>/test-coverage-agent/workspaces)")
+		noPush     = flag.Bool("no-push", false, "Keep the session branch in the workspace instead of pushing it back when the project is a git URL")
 	)
 
 	flag.Parse()
 
+	// A git URL as -project runs the session in a managed clone. Setting the
+	// flag rather than the field keeps the clone when flags are re-applied
+	// over the config file. The session runs inside the clone, since work
+	// items are relative to the project; files named on the command line
+	// stay relative to where the agent was started.
+	remoteURL := ""
+	if git.IsRemoteURL(cfg.ProjectPath) {
+		remoteURL = cfg.ProjectPath
+		dir, err := prepareWorkspace(*workspace, remoteURL, *resume)
+		if err == nil {
+			err = absoluteFileFlags("config", "state", "html-report", "coverage-report", "attestation")
+		}
+		if err == nil {
+			err = os.Chdir(dir)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		flag.Set("project", dir)
+	}
+
+	// Load the config file; flags given on the command line take precedence
+	if err := applyConfigFile(cfg, *configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Validate inputs
-	if *targetCoverage < 0 || *targetCoverage > 100 {
+	if cfg.TargetCoverage < 0 || cfg.TargetCoverage > 100 {
 		fmt.Fprintf(os.Stderr, "Error: target coverage must be between 0 and 100\n")
 		os.Exit(1)
 	}
 
-	// Get API key from flag or environment
-	apiKey := *claudeAPIKey
-	if apiKey == "" {
-		apiKey = os.Getenv("ANTHROPIC_API_KEY")
+	if cfg.Gain < 0 || cfg.Gain > 100 {
+		fmt.Fprintf(os.Stderr, "Error: gain must be between 0 and 100 percentage points\n")
+		os.Exit(1)
+	}
+
+	if cfg.MaxFiles < 0 {
+		fmt.Fprintf(os.Stderr, "Error: max-files must not be negative\n")
+		os.Exit(1)
+	}
+
+	if cfg.FileBudgetMin < 0 {
+		fmt.Fprintf(os.Stderr, "Error: file-budget must not be negative\n")
+		os.Exit(1)
+	}
+
+	if cfg.SuiteInterval < 0 || cfg.SuiteEvery < 0 {
+		fmt.Fprintf(os.Stderr, "Error: suite-interval and suite-every must not be negative\n")
+		os.Exit(1)
+	}
+
+	if err := coverage.CheckPythonRunner(cfg.Analyzer.Python.Runner); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg.CoverageOut != "" {
+		if _, _, err := coverage.ParseExport(cfg.CoverageOut); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Fail before the session rather than after it if the key is missing
+	if cfg.Attestation != "" {
+		if _, err := attest.Key(cfg.AttestKeyEnv); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.CICoverage != "" {
+		if err := ci.CheckSource(cfg.CICoverage); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
-	if apiKey == "" {
+	if cfg.CITolerance < 0 {
+		fmt.Fprintf(os.Stderr, "Error: ci-tolerance must not be negative\n")
+		os.Exit(1)
+	}
+
+	if cfg.StrictTarget < 0 || cfg.StrictTarget > 100 {
+		fmt.Fprintf(os.Stderr, "Error: strict-target must be between 0 and 100\n")
+		os.Exit(1)
+	}
+
+	// Let the pull request's labels opt it out or ask for a different target
+	if cfg.PRLabels {
+		if skip := applyPRLabels(cfg); skip {
+			return
+		}
+	}
+
+	if cfg.NoModify && cfg.ImproveOnly {
+		fmt.Fprintf(os.Stderr, "Error: -no-modify and -improve-only exclude each other\n")
+		os.Exit(1)
+	}
+
+	if cfg.ReviewBelow < 0 || cfg.ReviewBelow > 100 {
+		fmt.Fprintf(os.Stderr, "Error: review-below must be between 0 and 100\n")
+		os.Exit(1)
+	}
+
+	if cfg.FlakyRuns < 0 {
+		fmt.Fprintf(os.Stderr, "Error: flaky-runs must not be negative\n")
+		os.Exit(1)
+	}
+
+	if cfg.FailureLogs < 0 || cfg.FailureLogKB < 0 {
+		fmt.Fprintf(os.Stderr, "Error: failure-logs and failure-log-kb must not be negative\n")
+		os.Exit(1)
+	}
+
+	if cfg.MaxTokens < 0 || cfg.MaxTokensTotal < 0 || cfg.MaxCost < 0 {
+		fmt.Fprintf(os.Stderr, "Error: max-tokens, max-tokens-total and max-cost must not be negative\n")
+		os.Exit(1)
+	}
+
+	if cfg.RequestsPerMin < 0 || cfg.TokensPerMin < 0 {
+		fmt.Fprintf(os.Stderr, "Error: requests-per-minute and tokens-per-minute must not be negative\n")
+		os.Exit(1)
+	}
+
+	if cfg.ChunkFiles < 0 || cfg.ChunkGain < 0 {
+		fmt.Fprintf(os.Stderr, "Error: chunk-files and chunk-gain must not be negative\n")
+		os.Exit(1)
+	}
+
+	if _, err := workplan.ParsePartition(cfg.Partition); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch cfg.Provider {
+	case llm.ProviderAnthropic, llm.ProviderOllama, llm.ProviderVertex:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown provider %q (use anthropic, ollama or vertex)\n", cfg.Provider)
+		os.Exit(1)
+	}
+
+	// Get API key from flag or environment; other providers need none
+	if cfg.ClaudeAPIKey == "" {
+		cfg.ClaudeAPIKey = os.Getenv("ANTHROPIC_API_KEY")
+	}
+	if cfg.ClaudeAPIKey == "" && cfg.Provider == llm.ProviderAnthropic {
 		fmt.Fprintf(os.Stderr, "Error: Claude API key required (use -api-key flag or ANTHROPIC_API_KEY env var)\n")
 		os.Exit(1)
 	}
 
-	// Load or create configuration
-	cfg := &config.Config{
-		ProjectPath:    *projectPath,
-		TargetCoverage: *targetCoverage,
-		StateFile:      *stateFile,
-		DryRun:         *dryRun,
-		MaxIterations:  *maxIterations,
-		ClaudeAPIKey:   apiKey,
+	// Keep the state with the rest of the agent's artifacts by default
+	if cfg.StateFile == "" {
+		cfg.StateFile = cache.New(cfg.ProjectPath).StateFile()
 	}
 
 	// Create orchestrator
@@ -84,15 +310,88 @@ func main() {
 	// Run the orchestrator
 	fmt.Printf("Starting Test Coverage Agent\n")
 	fmt.Printf("Project: %s\n", cfg.ProjectPath)
-	fmt.Printf("Target Coverage: %.2f%%\n", cfg.TargetCoverage)
+	if cfg.Gain > 0 {
+		fmt.Printf("Coverage Goal: +%.2f points over the starting coverage\n", cfg.Gain)
+	} else {
+		fmt.Printf("Target Coverage: %.2f%%\n", cfg.TargetCoverage)
+	}
 	fmt.Printf("Max Iterations: %d\n", cfg.MaxIterations)
+	if cfg.MaxFiles > 0 {
+		fmt.Printf("Max Files: %d\n", cfg.MaxFiles)
+	}
+	if cfg.FileBudgetMin > 0 {
+		fmt.Printf("File Budget: %d minutes\n", cfg.FileBudgetMin)
+	}
+	if cfg.Partition != "" {
+		fmt.Printf("Partition: %s\n", cfg.Partition)
+	}
 	if cfg.DryRun {
 		fmt.Println("DRY RUN MODE - No changes will be made")
 	}
+	if cfg.NoModify {
+		fmt.Println("No-modify mode: only new test files will be created")
+	}
+	if cfg.ImproveOnly {
+		fmt.Println("Improve-only mode: only existing test files will be improved")
+	}
 	fmt.Println("Press Ctrl+C to pause and save state")
-	fmt.Println("=====================================\n")
+	fmt.Println("=====================================")
+	fmt.Println()
+
+	err = orch.Run(ctx)
+	if err == nil {
+		if attestation, attestErr := orch.WriteAttestation(); attestErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not write coverage attestation: %v\n", attestErr)
+		} else if attestation != "" {
+			fmt.Printf("Coverage attestation: %s\n", attestation)
+		}
+	}
+
+	if logFile, logErr := orch.WriteActivityLog(); logErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not update activity log: %v\n", logErr)
+	} else if logFile != "" {
+		fmt.Printf("Activity log: %s\n", logFile)
+	}
+
+	if mayCallAPI(ctx, err) {
+		orch.SuggestRefactorings()
+	}
+
+	orch.PrintSummary()
+
+	if reportFile, reportErr := orch.WriteReport(); reportErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write HTML report: %v\n", reportErr)
+	} else if reportFile != "" {
+		fmt.Printf("HTML report: %s\n", reportFile)
+	}
+
+	// Only a completed session is pushed; a failed one can be resumed
+	if remoteURL != "" && !*noPush && err == nil {
+		if branches, pushErr := orch.PushBranches("origin"); pushErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not push to %s: %v\n", remoteURL, pushErr)
+		} else {
+			for _, branch := range branches {
+				fmt.Printf("Pushed branch %s to %s\n", branch, remoteURL)
+			}
+		}
+	}
 
-	if err := orch.Run(ctx); err != nil {
+	if finishErr := orch.Finish(err); finishErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", finishErr)
+	}
+
+	if gcErr := orch.CollectGarbage(); gcErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cache cleanup failed: %v\n", gcErr)
+	}
+
+	// A used-up budget is a clean stop, told apart from a failure by its exit code
+	if errors.Is(err, errdefs.ErrSpendLimit) {
+		fmt.Printf("\nBudget exhausted: %v\n", err)
+		fmt.Println("Resume with -resume and a higher -max-cost or -max-tokens-total to continue")
+		os.Exit(exitSpendLimit)
+	}
+
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "\nError during execution: %v\n", err)
 
 		// Save state before exiting
@@ -106,3 +405,170 @@ func main() {
 	fmt.Println("\n=====================================")
 	fmt.Println("Test Coverage Agent completed successfully!")
 }
+
+// mayCallAPI reports whether the end of a run may still send API requests
+// of its own, such as refactoring suggestions. It may not once the user
+// interrupted the run or its budget is used up, or after an API failure
+// that every further request would repeat.
+func mayCallAPI(ctx context.Context, err error) bool {
+	var circuitErr *claude.CircuitOpenError
+	var apiErr *claude.APIError
+	switch {
+	case ctx.Err() != nil, errors.Is(err, errdefs.ErrSpendLimit), errors.As(err, &circuitErr):
+		return false
+	case errors.As(err, &apiErr) && apiErr.Fatal():
+		return false
+	}
+	return true
+}
+
+// applyPRLabels adjusts the target to the labels of the pull request the CI
+// job runs for and reports whether the session should be skipped. Outside of
+// a pull request, or if the labels can't be read, nothing changes.
+func applyPRLabels(cfg *config.Config) bool {
+	labels, ok, err := ci.PullRequestLabels()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not read pull request labels, keeping the configured target: %v\n", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	policy, err := ci.Policy(labels, cfg.StrictTarget)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case policy.Skip:
+		fmt.Printf("Pull request is labeled %s, skipping the session\n", policy.Label)
+		return true
+	case policy.Label == "":
+		return false // No label adjusts the session
+	case policy.AtLeast && cfg.Gain == 0 && cfg.TargetCoverage >= policy.Target:
+		fmt.Printf("Pull request is labeled %s; the target of %.2f%% is already stricter\n", policy.Label, cfg.TargetCoverage)
+		return false
+	}
+
+	fmt.Printf("Pull request is labeled %s, target coverage %.2f%%\n", policy.Label, policy.Target)
+	cfg.TargetCoverage = policy.Target
+	cfg.Gain = 0
+	return false
+}
+
+// absoluteFileFlags makes the paths given on the command line for the
+// named flags absolute, so they still refer to the same files after the
+// working directory changes
+func absoluteFileFlags(names ...string) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for _, name := range names {
+		value := flag.Lookup(name).Value.String()
+		if !set[name] || value == "" || filepath.IsAbs(value) {
+			continue
+		}
+		abs, err := filepath.Abs(value)
+		if err != nil {
+			return fmt.Errorf("failed to resolve -%s: %w", name, err)
+		}
+		flag.Set(name, abs)
+	}
+	return nil
+}
+
+// applyConfigFile loads a JSON config file into cfg. Without an explicit
+// path, the project's default config file is used if it exists. Flags set
+// on the command line are re-applied afterwards so they win over the file.
+func applyConfigFile(cfg *config.Config, path string) error {
+	explicit := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if path == "" {
+		path = filepath.Join(cfg.ProjectPath, config.DefaultConfigFile)
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+	}
+
+	if err := config.LoadConfig(path, cfg); err != nil {
+		return err
+	}
+
+	for name, value := range explicit {
+		if err := flag.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// envFlag collects repeated -env KEY=VALUE flags into the analyzer
+// environment. String joins the pairs with newlines and Set accepts that
+// form too, so applyConfigFile can re-apply the flag after the config file.
+type envFlag struct {
+	env *map[string]string
+}
+
+func (f *envFlag) String() string {
+	if f == nil || f.env == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*f.env))
+	for name, value := range *f.env {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\n")
+}
+
+func (f *envFlag) Set(value string) error {
+	if *f.env == nil {
+		*f.env = make(map[string]string)
+	}
+	for _, pair := range strings.Split(value, "\n") {
+		name, val, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("expected KEY=VALUE, got %q", pair)
+		}
+		(*f.env)[name] = val
+	}
+	return nil
+}
+
+// listFlag collects repeated string flags. Like envFlag, String joins the
+// values with newlines so applyConfigFile can re-apply the flag; values
+// already in the list are skipped.
+type listFlag struct {
+	values *[]string
+}
+
+func (f *listFlag) String() string {
+	if f == nil || f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, "\n")
+}
+
+func (f *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, "\n") {
+		if v == "" {
+			return fmt.Errorf("expected a value")
+		}
+		known := false
+		for _, existing := range *f.values {
+			known = known || existing == v
+		}
+		if !known {
+			*f.values = append(*f.values, v)
+		}
+	}
+	return nil
+}
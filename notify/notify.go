@@ -0,0 +1,169 @@
+// Package notify sends session events to reporters: output channels such as
+// the console, a JSON lines file, the GitHub job summary or Slack. Reporters
+// are configured by type and new types can be registered, so a new channel
+// needs no change to the orchestrator.
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Event types
+const (
+	EventStarted   = "session_started"   // Initial coverage measured
+	EventCoverage  = "coverage_measured" // Coverage measured in an iteration
+	EventValidated = "test_validated"    // A generated or improved test passed validation
+	EventFailed    = "file_failed"       // A file was given up on
+	EventFinished  = "session_finished"  // The session ended, with its summary
+)
+
+// Event is something that happened during a session
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Project   string    `json:"project"`
+	Iteration int       `json:"iteration,omitempty"`
+	Coverage  float64   `json:"coverage"` // Latest total coverage
+	Target    float64   `json:"target"`
+	File      string    `json:"file,omitempty"`      // Source file of file events
+	TestFile  string    `json:"test_file,omitempty"` // Test file of EventValidated
+	Message   string    `json:"message,omitempty"`   // Failure message of EventFailed
+	Summary   *Summary  `json:"summary,omitempty"`   // Set for EventFinished
+}
+
+// Summary is the outcome of a session
+type Summary struct {
+	StartCoverage  float64 `json:"start_coverage"`
+	Iterations     int     `json:"iterations"`
+	TestsGenerated int     `json:"tests_generated"`
+	TestsFixed     int     `json:"tests_fixed"`
+	FailedFiles    int     `json:"failed_files"`
+	TargetMet      bool    `json:"target_met"`
+	Error          string  `json:"error,omitempty"` // Why the session stopped early, if it did
+}
+
+// Reporter receives session events and writes them to its output channel
+type Reporter interface {
+	// Report handles an event. Errors are warnings, the session goes on.
+	Report(event Event) error
+
+	// Close flushes and releases the reporter's output at the end of the
+	// session
+	Close() error
+}
+
+// Spec configures a reporter
+type Spec struct {
+	Type   string   `json:"type"`    // console, json, github, slack or a registered type
+	Path   string   `json:"path"`    // Output file of file-based reporters
+	URLEnv string   `json:"url_env"` // Environment variable holding the webhook URL of webhook reporters
+	Events []string `json:"events"`  // Event types to report; the type's default if empty
+}
+
+// Factory creates a reporter from its configuration
+type Factory func(spec Spec) (Reporter, error)
+
+// reporterType is a registered reporter type
+type reporterType struct {
+	factory Factory
+	events  []string // Default events, all if empty
+}
+
+var reporterTypes = map[string]reporterType{
+	"console": {factory: newConsole},
+	"json":    {factory: newJSONFile},
+	"github":  {factory: newGitHub},
+	"slack":   {factory: newSlack, events: []string{EventFailed, EventFinished}},
+}
+
+// Register adds a reporter type, or replaces the one of the same name.
+// events are the types it reports unless configured otherwise; nil means
+// all.
+func Register(name string, factory Factory, events ...string) {
+	reporterTypes[name] = reporterType{factory: factory, events: events}
+}
+
+// Types returns the names of the registered reporter types
+func Types() []string {
+	names := make([]string, 0, len(reporterTypes))
+	for name := range reporterTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Notifier sends events to every configured reporter
+type Notifier struct {
+	reporters []filtered
+}
+
+// filtered is a reporter with the events it takes
+type filtered struct {
+	name     string
+	reporter Reporter
+	events   map[string]bool // All events if nil
+}
+
+// New creates the reporters of specs. Without specs, the notifier drops
+// every event.
+func New(specs []Spec) (*Notifier, error) {
+	n := &Notifier{}
+	for _, spec := range specs {
+		rt, ok := reporterTypes[spec.Type]
+		if !ok {
+			n.Close()
+			return nil, fmt.Errorf("unknown reporter type %q (known: %v)", spec.Type, Types())
+		}
+
+		reporter, err := rt.factory(spec)
+		if err != nil {
+			n.Close()
+			return nil, fmt.Errorf("failed to create %s reporter: %w", spec.Type, err)
+		}
+
+		events := spec.Events
+		if len(events) == 0 {
+			events = rt.events
+		}
+		f := filtered{name: spec.Type, reporter: reporter}
+		if len(events) > 0 {
+			f.events = make(map[string]bool, len(events))
+			for _, event := range events {
+				f.events[event] = true
+			}
+		}
+		n.reporters = append(n.reporters, f)
+	}
+	return n, nil
+}
+
+// Notify sends an event to the reporters that take it. A failing reporter
+// is reported and doesn't stop the others.
+func (n *Notifier) Notify(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, f := range n.reporters {
+		if f.events != nil && !f.events[event.Type] {
+			continue
+		}
+		if err := f.reporter.Report(event); err != nil {
+			fmt.Printf("Warning: %s reporter failed: %v\n", f.name, err)
+		}
+	}
+}
+
+// Close closes every reporter
+func (n *Notifier) Close() error {
+	var lastErr error
+	for _, f := range n.reporters {
+		if err := f.reporter.Close(); err != nil {
+			lastErr = fmt.Errorf("failed to close %s reporter: %w", f.name, err)
+		}
+	}
+	n.reporters = nil
+	return lastErr
+}
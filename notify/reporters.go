@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/schema"
+)
+
+// DefaultSlackURLEnv names the environment variable holding the Slack
+// webhook URL unless configured otherwise
+const DefaultSlackURLEnv = "SLACK_WEBHOOK_URL"
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// describe returns a one-line description of an event
+func describe(event Event) string {
+	switch event.Type {
+	case EventStarted:
+		return fmt.Sprintf("Session started at %.2f%% coverage (target %.2f%%)", event.Coverage, event.Target)
+	case EventCoverage:
+		return fmt.Sprintf("Iteration %d: %.2f%% coverage (target %.2f%%)", event.Iteration, event.Coverage, event.Target)
+	case EventValidated:
+		return fmt.Sprintf("Iteration %d: %s passed validation for %s", event.Iteration, event.TestFile, event.File)
+	case EventFailed:
+		message, _, _ := strings.Cut(event.Message, "\n")
+		return fmt.Sprintf("Iteration %d: gave up on %s: %s", event.Iteration, event.File, message)
+	case EventFinished:
+		if event.Summary == nil {
+			return fmt.Sprintf("Session finished at %.2f%% coverage", event.Coverage)
+		}
+		s := event.Summary
+		text := fmt.Sprintf("Session finished: %.2f%% → %.2f%% coverage (target %.2f%%) after %d iterations, %d tests generated, %d fixed, %d files failed",
+			s.StartCoverage, event.Coverage, event.Target, s.Iterations, s.TestsGenerated, s.TestsFixed, s.FailedFiles)
+		if s.Error != "" {
+			text += "; stopped early: " + s.Error
+		}
+		return text
+	}
+	return event.Type
+}
+
+// console prints a line per event
+type console struct {
+	out io.Writer
+}
+
+func newConsole(spec Spec) (Reporter, error) {
+	return &console{out: os.Stdout}, nil
+}
+
+func (c *console) Report(event Event) error {
+	_, err := fmt.Fprintf(c.out, "[%s] %s\n", event.Type, describe(event))
+	return err
+}
+
+func (c *console) Close() error { return nil }
+
+// jsonFile appends events to a file as JSON lines, each checked against
+// the published events schema
+type jsonFile struct {
+	file *os.File
+}
+
+func newJSONFile(spec Spec) (Reporter, error) {
+	if spec.Path == "" {
+		return nil, fmt.Errorf("json reporter needs a path")
+	}
+	file, err := os.OpenFile(spec.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+	return &jsonFile{file: file}, nil
+}
+
+func (j *jsonFile) Report(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := schema.Validate(schema.Events, line); err != nil {
+		return fmt.Errorf("event %w", err)
+	}
+	_, err = j.file.Write(append(line, '\n'))
+	return err
+}
+
+func (j *jsonFile) Close() error {
+	return j.file.Close()
+}
+
+// gitHub writes a Markdown summary of the session to the GitHub Actions job
+// summary when the session finishes
+type gitHub struct {
+	path      string
+	validated []Event
+	failed    []Event
+}
+
+func newGitHub(spec Spec) (Reporter, error) {
+	path := spec.Path
+	if path == "" {
+		path = os.Getenv("GITHUB_STEP_SUMMARY")
+	}
+	if path == "" {
+		return nil, fmt.Errorf("GITHUB_STEP_SUMMARY is not set and no path is configured")
+	}
+	return &gitHub{path: path}, nil
+}
+
+func (g *gitHub) Report(event Event) error {
+	switch event.Type {
+	case EventValidated:
+		g.validated = append(g.validated, event)
+	case EventFailed:
+		g.failed = append(g.failed, event)
+	case EventFinished:
+		file, err := os.OpenFile(g.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open job summary: %w", err)
+		}
+		_, err = file.WriteString(g.markdown(event))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write job summary: %w", err)
+		}
+	}
+	return nil
+}
+
+// markdown renders the job summary of a finished session
+func (g *gitHub) markdown(event Event) string {
+	var b strings.Builder
+	b.WriteString("## Test Coverage Agent\n\n")
+	b.WriteString("| Start | Final | Target | Iterations |\n|---|---|---|---|\n")
+	start, iterations := event.Coverage, event.Iteration
+	if s := event.Summary; s != nil {
+		start, iterations = s.StartCoverage, s.Iterations
+	}
+	fmt.Fprintf(&b, "| %.2f%% | %.2f%% | %.2f%% | %d |\n\n", start, event.Coverage, event.Target, iterations)
+
+	if s := event.Summary; s != nil && s.Error != "" {
+		fmt.Fprintf(&b, "Stopped early: `%s`\n\n", s.Error)
+	}
+
+	if len(g.validated) > 0 {
+		fmt.Fprintf(&b, "### Tests written (%d)\n\n", len(g.validated))
+		for _, e := range g.validated {
+			fmt.Fprintf(&b, "- `%s` for `%s`\n", e.TestFile, e.File)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(g.failed) > 0 {
+		failed := append([]Event(nil), g.failed...)
+		sort.SliceStable(failed, func(i, j int) bool { return failed[i].File < failed[j].File })
+		fmt.Fprintf(&b, "### Failed files (%d)\n\n", len(failed))
+		for _, e := range failed {
+			message, _, _ := strings.Cut(e.Message, "\n")
+			fmt.Fprintf(&b, "- `%s`: %s\n", e.File, message)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (g *gitHub) Close() error { return nil }
+
+// slack posts events to a Slack incoming webhook
+type slack struct {
+	url string
+}
+
+func newSlack(spec Spec) (Reporter, error) {
+	name := spec.URLEnv
+	if name == "" {
+		name = DefaultSlackURLEnv
+	}
+	url := os.Getenv(name)
+	if url == "" {
+		return nil, fmt.Errorf("no webhook URL: %s is not set", name)
+	}
+	return &slack{url: url}, nil
+}
+
+func (s *slack) Report(event Event) error {
+	text := describe(event)
+	if event.Project != "" {
+		text = fmt.Sprintf("*%s*: %s", event.Project, text)
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	resp, err := httpClient.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post to Slack: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Slack returned %s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+func (s *slack) Close() error { return nil }
@@ -0,0 +1,255 @@
+// Package ollama is a client for a local Ollama server, so the agent can
+// write tests offline with local models such as Qwen-Coder. It offers the
+// same methods as the claude client that the test generator calls.
+package ollama
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/claude"
+)
+
+const (
+	DefaultHost      = "http://localhost:11434"
+	DefaultModel     = "qwen2.5-coder:14b"
+	DefaultMaxTokens = 8000
+
+	// DefaultContextTokens is the context window requested for each
+	// prompt. Ollama's own default is a few thousand tokens and it drops
+	// the start of longer prompts silently, which loses the source file.
+	DefaultContextTokens = 32768
+
+	// HostEnv is the environment variable the ollama CLI reads the server
+	// address from
+	HostEnv = "OLLAMA_HOST"
+)
+
+// Client handles communication with an Ollama server
+type Client struct {
+	host       string
+	httpClient *http.Client
+	model      string
+	maxTokens  int
+
+	usage claude.Usage // Tokens used since the last TakeUsage
+}
+
+// NewClient creates a client for the Ollama server at host, or at
+// OLLAMA_HOST or DefaultHost if empty
+func NewClient(host string) *Client {
+	if host == "" {
+		host = os.Getenv(HostEnv)
+	}
+	if host == "" {
+		host = DefaultHost
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+
+	return &Client{
+		host: strings.TrimSuffix(host, "/"),
+		httpClient: &http.Client{
+			// Local models on modest hardware take minutes for a test file
+			Timeout: 15 * time.Minute,
+		},
+		model:     DefaultModel,
+		maxTokens: DefaultMaxTokens,
+	}
+}
+
+// Model returns the default model of requests
+func (c *Client) Model() string {
+	return c.model
+}
+
+// SetModel sets the default model of requests
+func (c *Client) SetModel(model string) {
+	c.model = model
+}
+
+// SetMaxTokens sets the output token limit of requests
+func (c *Client) SetMaxTokens(maxTokens int) {
+	c.maxTokens = maxTokens
+}
+
+// message is a chat message
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatRequest is the body of a /api/chat request
+type chatRequest struct {
+	Model    string         `json:"model"`
+	Messages []message      `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Options  map[string]any `json:"options,omitempty"`
+}
+
+// chatResponse is the body of a non-streaming /api/chat response
+type chatResponse struct {
+	Message         message `json:"message"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+}
+
+// errorResponse is the body of an error response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// SendMessageWithSystem sends a message with a system prompt and returns
+// the response
+func (c *Client) SendMessageWithSystem(system, prompt, model string) (string, error) {
+	if model == "" {
+		model = c.model
+	}
+
+	var messages []message
+	if system != "" {
+		messages = append(messages, message{Role: "system", Content: system})
+	}
+	messages = append(messages, message{Role: "user", Content: prompt})
+
+	req := chatRequest{
+		Model:    model,
+		Messages: messages,
+		Options: map[string]any{
+			"num_predict": c.maxTokens,
+			"num_ctx":     DefaultContextTokens,
+		},
+	}
+
+	body, err := c.post("/api/chat", req)
+	if err != nil {
+		return "", err
+	}
+
+	var response chatResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	c.usage.InputTokens += response.PromptEvalCount
+	c.usage.OutputTokens += response.EvalCount
+
+	if response.Message.Content == "" {
+		return "", fmt.Errorf("empty response from Ollama")
+	}
+	return response.Message.Content, nil
+}
+
+// SendMessageWithPrefix is SendMessageWithSystem with a prefix before the
+// prompt. Ollama reuses the evaluated prompt of the previous request on its
+// own, so the prefix is simply prepended.
+func (c *Client) SendMessageWithPrefix(system, prefix, prompt, model string) (string, error) {
+	if prefix != "" {
+		prompt = prefix + "\n\n" + prompt
+	}
+	return c.SendMessageWithSystem(system, prompt, model)
+}
+
+// SendMessageWithAttachments is SendMessageWithSystem with files included
+// before the prompt, by title, since Ollama has no document attachments
+func (c *Client) SendMessageWithAttachments(system, prompt, model string, attachments map[string]string) (string, error) {
+	titles := make([]string, 0, len(attachments))
+	for title := range attachments {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	var b strings.Builder
+	for _, title := range titles {
+		fmt.Fprintf(&b, "<document title=%q>\n%s\n</document>\n\n", title, attachments[title])
+	}
+	b.WriteString(prompt)
+
+	return c.SendMessageWithSystem(system, b.String(), model)
+}
+
+// CheckAccess verifies that the server is reachable and has the default
+// model
+func (c *Client) CheckAccess() error {
+	return c.CheckModelAccess(c.model)
+}
+
+// CheckModelAccess verifies that the server has a model pulled
+func (c *Client) CheckModelAccess(model string) error {
+	_, err := c.post("/api/show", map[string]string{"model": model})
+	return err
+}
+
+// charsPerToken is the average length of a token of source code and
+// English text, for estimating token counts
+const charsPerToken = 4
+
+// CountTokens estimates the number of input tokens of a message with a
+// system prompt. Ollama has no endpoint that counts tokens without running
+// the model, so the count is estimated from the length of the text.
+func (c *Client) CountTokens(system, prompt, model string) (int, error) {
+	return (len(system) + len(prompt) + charsPerToken - 1) / charsPerToken, nil
+}
+
+// TakeUsage returns the tokens used since the last call, and starts
+// counting again. Local models cost nothing.
+func (c *Client) TakeUsage() (claude.Usage, float64) {
+	usage := c.usage
+	c.usage = claude.Usage{}
+	return usage, 0
+}
+
+// DeleteUploads does nothing; attachments are never uploaded
+func (c *Client) DeleteUploads() error {
+	return nil
+}
+
+// post sends a request to the server and returns the body of a successful
+// response. Error responses are returned as a claude.APIError, so callers
+// handle a missing model like a model the Claude API doesn't know.
+func (c *Client) post(path string, body any) ([]byte, error) {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(c.host+path, "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Ollama at %s (is `ollama serve` running?): %w", c.host, err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		msg := string(bodyBytes)
+		if json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Error != "" {
+			msg = errResp.Error
+		}
+		return nil, &claude.APIError{StatusCode: resp.StatusCode, Type: "ollama_error", Message: msg, Hint: hint(resp.StatusCode)}
+	}
+
+	return bodyBytes, nil
+}
+
+// hint explains how to fix an error response that fails every request
+func hint(statusCode int) string {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "Ollama takes no API key, so the request was rejected by whatever runs at -ollama-host or OLLAMA_HOST, e.g. a proxy"
+	case http.StatusNotFound:
+		return "the model was not found, pull it with `ollama pull` or check -model"
+	}
+	return ""
+}
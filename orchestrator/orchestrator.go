@@ -1,18 +1,33 @@
 package orchestrator
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/tablev/test-coverage-agent/attest"
+	"github.com/tablev/test-coverage-agent/cache"
+	"github.com/tablev/test-coverage-agent/ci"
 	"github.com/tablev/test-coverage-agent/claude"
 	"github.com/tablev/test-coverage-agent/config"
 	"github.com/tablev/test-coverage-agent/coverage"
+	"github.com/tablev/test-coverage-agent/errdefs"
 	"github.com/tablev/test-coverage-agent/git"
+	"github.com/tablev/test-coverage-agent/journal"
+	"github.com/tablev/test-coverage-agent/llm"
+	"github.com/tablev/test-coverage-agent/notify"
+	"github.com/tablev/test-coverage-agent/prompts"
+	"github.com/tablev/test-coverage-agent/reporting"
 	"github.com/tablev/test-coverage-agent/testgen"
+	"github.com/tablev/test-coverage-agent/workplan"
 )
 
 // Orchestrator manages the test generation workflow
@@ -23,12 +38,49 @@ type Orchestrator struct {
 	generator *testgen.Generator
 	validator *testgen.Validator
 	gitMgr    *git.Manager
+	journal   *journal.Journal
+	notifier  *notify.Notifier
+
+	// branch is the session branch created by Run, or "" if there is none
+	branch string
+
+	// initialReport is the coverage at the start of the run, for the HTML report
+	initialReport *coverage.CoverageReport
+
+	// created and improved are the validated test changes of this run, for
+	// the activity log
+	created  []config.TestChange
+	improved []config.TestChange
+
+	// lastReport is the latest coverage report, reused by validation-only
+	// iterations while the full-suite run limits apply
+	lastReport   *coverage.CoverageReport
+	lastSuiteRun time.Time
+	reusedRuns   int // Iterations that reused lastReport since the last suite run
+
+	// staleProfile is set when test files changed outside the pending test
+	// changes, e.g. by a revert, so the next -incremental run measures the
+	// whole project
+	staleProfile bool
+
+	// deadCode holds the unused code found at the start of the run with
+	// -skip-dead-code; nil until it was looked for
+	deadCode coverage.DeadCode
+
+	// partition is the subset of files this run works on, nil for all
+	partition *workplan.Partition
 }
 
+// notesRef is the git notes ref holding coverage snapshots
+const notesRef = "coverage"
+
+// maxParseErrors is the number of skipped coverage report entries listed
+const maxParseErrors = 5
+
 // New creates a new orchestrator
 func New(cfg *config.Config) (*Orchestrator, error) {
 	// Detect project language
-	analyzer, err := coverage.DetectProjectLanguage(cfg.ProjectPath)
+	analyzer, err := coverage.DetectProjectLanguage(cfg.ProjectPath, cfg.Analyzer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to detect project language: %w", err)
 	}
@@ -37,12 +89,68 @@ func New(cfg *config.Config) (*Orchestrator, error) {
 
 	// Create state
 	state := config.NewState(cfg.ProjectPath, cfg.TargetCoverage, analyzer.GetLanguageName())
+	state.Exclude = cfg.Exclude
+	state.FileBudgetMin = cfg.FileBudgetMin
 
 	// Create components
-	generator := testgen.NewGenerator(cfg.ClaudeAPIKey, analyzer)
+	store := cache.New(cfg.ProjectPath)
+	if err := store.Init(); err != nil {
+		return nil, err
+	}
+
+	// Database tests need Docker to start their containers
+	if cfg.Testcontainers && !testgen.DockerAvailable() {
+		fmt.Println("Warning: Docker is not available, database code gets plain unit tests instead of testcontainers-based tests")
+		cfg.Testcontainers = false
+	}
+
+	templates, err := loadPromptTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	generator := testgen.NewGenerator(cfg.ClaudeAPIKey, analyzer, testgen.Options{
+		Annotate:       cfg.AnnotateTests,
+		Cache:          store,
+		ReuseResponses: cfg.ReuseResponses,
+		Harness:        cfg.Harness,
+		Testcontainers: cfg.Testcontainers,
+		Provider:       cfg.Provider,
+		OllamaHost:     cfg.OllamaHost,
+		VertexProject:  cfg.VertexProject,
+		VertexLocation: cfg.VertexLocation,
+		Model:          cfg.Model,
+		Models:         cfg.Models,
+		MaxTokens:      cfg.MaxTokens,
+		AttachKB:       cfg.AttachKB,
+		PackageContext: cfg.PackageContext,
+		Templates:      templates,
+
+		RequestsPerMinute: cfg.RequestsPerMin,
+		TokensPerMinute:   cfg.TokensPerMin,
+	})
 	validator := testgen.NewValidator(analyzer)
 	gitMgr := git.NewManager(cfg.ProjectPath)
 
+	// Without git, fall back to a filesystem journal for undo and audit
+	var changeJournal *journal.Journal
+	if !gitMgr.IsEnabled() {
+		changeJournal, err = journal.Open(cfg.ProjectPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open change journal: %w", err)
+		}
+	}
+
+	notifier, err := notify.New(cfg.Reporters)
+	if err != nil {
+		return nil, err
+	}
+
+	partition, err := workplan.ParsePartition(cfg.Partition)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Orchestrator{
 		config:    cfg,
 		state:     state,
@@ -50,6 +158,9 @@ func New(cfg *config.Config) (*Orchestrator, error) {
 		generator: generator,
 		validator: validator,
 		gitMgr:    gitMgr,
+		journal:   changeJournal,
+		notifier:  notifier,
+		partition: partition,
 	}, nil
 }
 
@@ -61,36 +172,184 @@ func (o *Orchestrator) LoadState() error {
 	}
 
 	o.state = state
+	o.state.DropMissingFailureLogs() // Garbage collected since the last run
+	o.reconcileConfig()
 	return nil
 }
 
+// reconcileConfig applies config changes made since the state was saved
+// and logs them: a raised target revisits processed files still below it,
+// a raised file budget retries files that ran out of it, and changed
+// exclude patterns take effect with the next coverage report
+func (o *Orchestrator) reconcileConfig() {
+	target := o.config.TargetCoverage
+	if o.config.Gain > 0 && len(o.state.CoverageHistory) > 0 {
+		target = math.Min(o.state.StartingCoverage()+o.config.Gain, 100)
+	}
+	if target != o.state.TargetCoverage {
+		fmt.Printf("Config changed since the last run: target coverage %.2f%% -> %.2f%%\n", o.state.TargetCoverage, target)
+		if target > o.state.TargetCoverage {
+			if requeued := o.state.RequeueBelow(target); len(requeued) > 0 {
+				fmt.Printf("  Revisiting %d processed file(s) below the new target\n", len(requeued))
+			}
+		}
+		o.state.TargetCoverage = target
+	}
+
+	budget := o.config.FileBudgetMin
+	if budget != o.state.FileBudgetMin {
+		fmt.Printf("Config changed since the last run: file budget %s -> %s\n",
+			budgetString(o.state.FileBudgetMin), budgetString(budget))
+		if raised := o.state.FileBudgetMin > 0 && (budget == 0 || budget > o.state.FileBudgetMin); raised {
+			if requeued := o.state.RequeueFailed(config.FailureBudget); len(requeued) > 0 {
+				fmt.Printf("  Retrying %d file(s) that ran out of the old budget\n", len(requeued))
+			}
+		}
+		o.state.FileBudgetMin = budget
+	}
+
+	added, removed := patternChanges(o.state.Exclude, o.config.Exclude)
+	if len(added) > 0 {
+		fmt.Printf("Config changed since the last run: now excluding %s\n", strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		fmt.Printf("Config changed since the last run: no longer excluding %s; matching files are planned again\n",
+			strings.Join(removed, ", "))
+	}
+	o.state.Exclude = o.config.Exclude
+}
+
+// budgetString formats a file budget in minutes
+func budgetString(minutes int) string {
+	if minutes == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// patternChanges returns the patterns added to and removed from a list
+func patternChanges(before, after []string) (added, removed []string) {
+	old := make(map[string]bool, len(before))
+	for _, pattern := range before {
+		old[pattern] = true
+	}
+	current := make(map[string]bool, len(after))
+	for _, pattern := range after {
+		current[pattern] = true
+		if !old[pattern] {
+			added = append(added, pattern)
+		}
+	}
+	for _, pattern := range before {
+		if !current[pattern] {
+			removed = append(removed, pattern)
+		}
+	}
+	return added, removed
+}
+
 // SaveState saves the current state
 func (o *Orchestrator) SaveState() error {
 	return o.state.SaveState(o.config.StateFile)
 }
 
+// CollectGarbage trims the project cache to the configured size limit,
+// forgetting the failure logs it removed
+func (o *Orchestrator) CollectGarbage() error {
+	_, err := cache.New(o.config.ProjectPath).GC(o.config.CacheMaxSizeMB*1024*1024, 0)
+	if o.state != nil {
+		o.state.DropMissingFailureLogs()
+	}
+	return err
+}
+
 // Run executes the main orchestration loop
 func (o *Orchestrator) Run(ctx context.Context) error {
-	// Create a git branch for this session if git is available
+	// Create a git branch for this session if git is available. The first
+	// chunk of a chunked session is recorded once the starting coverage is
+	// known, which its gain is measured from.
+	firstChunk := ""
 	if o.gitMgr.IsEnabled() {
 		branchName := fmt.Sprintf("test-coverage-agent-%s", time.Now().Format("20060102-150405"))
+		if o.partition != nil {
+			// Runs on other partitions of the same repository get their own branches
+			branchName = fmt.Sprintf("test-coverage-agent-%s-%s", o.partition.Slug(), time.Now().Format("20060102-150405"))
+		}
+		if o.chunkingEnabled() {
+			// Continue the last chunk when resuming a chunked session
+			if chunk := o.state.CurrentChunk(); chunk != nil {
+				branchName = chunk.Branch
+			} else {
+				o.state.SessionBranch = branchName
+				branchName = o.chunkBranchName(1)
+			}
+		}
 		if err := o.gitMgr.CreateBranchForSession(branchName); err != nil {
 			fmt.Printf("Warning: Could not create git branch: %v\n", err)
 		} else {
 			fmt.Printf("Created git branch: %s\n", branchName)
+			o.branch = branchName
+			if o.chunkingEnabled() && o.state.CurrentChunk() == nil {
+				firstChunk = branchName
+			}
+		}
+	}
+
+	o.recordToolVersions()
+
+	// Catch a bad key or model before the potentially long coverage run
+	if !o.config.DryRun {
+		if err := o.checkAPI(); err != nil {
+			return err
 		}
 	}
 
+	// Uploaded attachments are only referenced by this session's requests
+	defer func() {
+		if err := o.generator.DeleteUploads(); err != nil {
+			fmt.Printf("Warning: Could not delete uploaded files: %v\n", err)
+		}
+	}()
+
 	// Run initial coverage analysis to show starting point
-	fmt.Println("\nAnalyzing current test coverage...")
-	initialReport, err := o.analyzer.RunCoverage(o.config.ProjectPath)
+	initialReport, err := o.initialCoverage()
 	if err != nil {
-		return fmt.Errorf("failed to run initial coverage analysis: %w", err)
+		return err
+	}
+
+	// A loaded report stands in for the first iteration's coverage run
+	reuseReport := o.config.CoverageReport != ""
+	o.initialReport = initialReport
+	o.lastReport = initialReport
+	if !reuseReport {
+		o.lastSuiteRun = time.Now()
 	}
 
 	o.state.AddCoverageSnapshot(initialReport.TotalCoverage)
+	if firstChunk != "" {
+		o.state.StartChunk(firstChunk)
+	}
 	fmt.Printf("\n✓ Initial Coverage: %.2f%%\n", initialReport.TotalCoverage)
+	o.printDirectories(initialReport)
+	o.writeCoverageNotes()
+	o.checkRegressions(initialReport)
+	if o.config.CoverageNotes {
+		defer o.flushCoverageNotes(ctx)
+	}
+	if o.config.CICoverage != "" {
+		o.checkCICoverage(initialReport.TotalCoverage)
+	}
+
+	// A gain goal becomes an absolute target once the starting point is known.
+	// Resumed sessions keep measuring from the first run's starting point.
+	if o.config.Gain > 0 {
+		start := o.state.StartingCoverage()
+		o.config.TargetCoverage = math.Min(start+o.config.Gain, 100)
+		o.state.TargetCoverage = o.config.TargetCoverage
+		fmt.Printf("  Coverage Goal:    +%.2f points from %.2f%%\n", o.config.Gain, start)
+	}
 	fmt.Printf("  Target Coverage:  %.2f%%\n", o.config.TargetCoverage)
+	o.notify(notify.Event{Type: notify.EventStarted})
 
 	coverageGap := o.config.TargetCoverage - initialReport.TotalCoverage
 	if coverageGap > 0 {
@@ -122,6 +381,15 @@ func (o *Orchestrator) Run(ctx context.Context) error {
 		default:
 		}
 
+		// Stop cleanly once the token or cost budget is used up
+		o.recordUsage()
+		if err := o.checkSpend(); err != nil {
+			if saveErr := o.SaveState(); saveErr != nil {
+				return fmt.Errorf("failed to save state: %w", saveErr)
+			}
+			return err
+		}
+
 		// Check for rate limiting
 		if shouldWait, waitDuration := o.state.ShouldWaitForRateLimit(); shouldWait {
 			fmt.Printf("\nRate limit reached. Waiting until %v (%v)...\n",
@@ -144,18 +412,45 @@ func (o *Orchestrator) Run(ctx context.Context) error {
 			}
 		}
 
+		// Stop once the per-session file budget is used up
+		if o.config.MaxFiles > 0 && o.keptTestFiles() >= o.config.MaxFiles {
+			fmt.Printf("\nReached maximum files modified (%d)\n", o.config.MaxFiles)
+			fmt.Printf("Final coverage: %.2f%% / Target: %.2f%%\n",
+				o.state.CurrentCoverage, o.config.TargetCoverage)
+			return o.SaveState()
+		}
+
 		o.state.CurrentIteration++
 		fmt.Printf("\n=== Iteration %d ===\n", o.state.CurrentIteration)
 
-		// Run coverage analysis
-		report, err := o.analyzer.RunCoverage(o.config.ProjectPath)
-		if err != nil {
-			return fmt.Errorf("failed to run coverage analysis: %w", err)
-		}
+		// Run coverage analysis, unless the suite is too heavy to run again yet
+		report := o.lastReport
+		if reuseReport || o.suiteLimited() {
+			if !reuseReport {
+				fmt.Println("Validation-only iteration: reusing the last coverage report")
+			}
+			reuseReport = false
+			o.reusedRuns++
+		} else {
+			report, err = o.measureCoverage()
+			if err != nil {
+				return fmt.Errorf("failed to run coverage analysis: %w", err)
+			}
+			if report, err = o.completeReport(report); err != nil {
+				return err
+			}
+			o.lastReport = report
+			o.lastSuiteRun = time.Now()
+			o.reusedRuns = 0
 
-		o.state.AddCoverageSnapshot(report.TotalCoverage)
+			o.state.AddCoverageSnapshot(report.TotalCoverage)
+			o.writeCoverageNotes()
+			o.checkRegressions(report)
+			o.notify(notify.Event{Type: notify.EventCoverage})
+		}
 		fmt.Printf("Current Coverage: %.2f%% / Target: %.2f%%\n",
 			report.TotalCoverage, o.config.TargetCoverage)
+		o.printDirectories(report)
 
 		// Check if we've reached the target
 		if report.TotalCoverage >= o.config.TargetCoverage {
@@ -178,10 +473,23 @@ func (o *Orchestrator) Run(ctx context.Context) error {
 		fmt.Printf("\nProcessing: %s (current coverage: %.2f%%)\n",
 			workItem.SourceFile, workItem.CurrentCoverage)
 
-		// Process the file
-		if err := o.processFile(ctx, workItem, report); err != nil {
+		// Process the file within its time budget
+		fileCtx, cancel := o.fileContext(ctx, workItem)
+		err = o.processFile(fileCtx, workItem, report)
+		if err != nil && errors.Is(context.Cause(fileCtx), errdefs.ErrBudgetExceeded) {
+			err = fmt.Errorf("%w: %w", errdefs.ErrBudgetExceeded, err)
+		}
+		cancel()
+		if err != nil {
+			// Interrupted mid-file: leave it to be retried on resume
+			if ctx.Err() != nil {
+				fmt.Println("\nStopping and saving state...")
+				return o.SaveState()
+			}
+
 			// Check if it's a rate limit error
-			if rateLimitErr, ok := err.(*claude.RateLimitError); ok {
+			var rateLimitErr *claude.RateLimitError
+			if errors.As(err, &rateLimitErr) {
 				fmt.Printf("Rate limit hit: %v\n", rateLimitErr)
 				o.state.SetRateLimitReset(rateLimitErr.ResetTime)
 
@@ -192,12 +500,40 @@ func (o *Orchestrator) Run(ctx context.Context) error {
 				continue
 			}
 
-			// Other errors
-			fmt.Printf("Error processing file: %v\n", err)
-			o.state.MarkFileFailed(workItem.SourceFile, err.Error())
+			// A misconfigured or persistently failing API, or a missing tool,
+			// won't recover on the next file
+			var circuitErr *claude.CircuitOpenError
+			var apiErr *claude.APIError
+			if errors.As(err, &circuitErr) || (errors.As(err, &apiErr) && apiErr.Fatal()) ||
+				errors.Is(err, errdefs.ErrToolMissing) {
+				if saveErr := o.SaveState(); saveErr != nil {
+					return fmt.Errorf("failed to save state: %w", saveErr)
+				}
+				return fmt.Errorf("stopping session: %w", err)
+			}
+
+			switch {
+			case errors.Is(err, errdefs.ErrBudgetExceeded):
+				fmt.Printf("  ⏱️  Time budget of %d minutes exceeded, moving on\n", o.config.FileBudgetMin)
+				o.markFailed(workItem.SourceFile, "budget exceeded")
+			case errors.Is(err, errdefs.ErrOutsideProject):
+				fmt.Printf("  Rejected test file outside the project: %v\n", err)
+				o.markFailed(workItem.SourceFile, err.Error())
+			case errors.Is(err, errdefs.ErrStalled):
+				fmt.Printf("  ⏳ %v, moving on\n", err)
+				o.markFailed(workItem.SourceFile, "Stalled: "+err.Error())
+			case errors.Is(err, errdefs.ErrContextTooLarge):
+				fmt.Printf("  Prompt too large for the model, skipping file: %v\n", err)
+				o.markFailed(workItem.SourceFile, err.Error())
+			default:
+				// Other errors
+				fmt.Printf("Error processing file: %v\n", err)
+				o.markFailed(workItem.SourceFile, err.Error())
+			}
 		}
 
 		// Save state after each iteration
+		o.recordUsage()
 		if err := o.SaveState(); err != nil {
 			return fmt.Errorf("failed to save state: %w", err)
 		}
@@ -213,59 +549,318 @@ func (o *Orchestrator) Run(ctx context.Context) error {
 	return o.SaveState()
 }
 
-// WorkItem represents a file that needs test coverage
-type WorkItem struct {
-	SourceFile      string
-	TestFile        string
-	CurrentCoverage float64
-	UncoveredLines  []int
-	Priority        int
-	Exists          bool
+// loadPromptTemplates loads the prompt templates the config replaces, from
+// files relative to the project. Without any, the built-in templates are
+// used.
+func loadPromptTemplates(cfg *config.Config) (*prompts.Templates, error) {
+	if len(cfg.PromptTemplates) == 0 {
+		return nil, nil
+	}
+
+	files := make(map[string]string, len(cfg.PromptTemplates))
+	for name, path := range cfg.PromptTemplates {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cfg.ProjectPath, path)
+		}
+		files[name] = path
+	}
+	templates, err := prompts.LoadTemplates(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load prompt templates: %w", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("Using custom prompt templates: %s\n", strings.Join(names, ", "))
+	return templates, nil
 }
 
-// prioritizeWorkItems creates a prioritized list of files to work on
-func (o *Orchestrator) prioritizeWorkItems(report *coverage.CoverageReport) []WorkItem {
-	var items []WorkItem
+// recordUsage adds the API usage since the last call to the session's usage
+func (o *Orchestrator) recordUsage() {
+	usage, cost := o.generator.TakeUsage()
+	o.state.RecordUsage(usage.Total(), cost)
+}
 
-	for _, sourceFile := range report.UncoveredFiles {
-		// Skip if already processed
-		if o.state.IsFileProcessed(sourceFile) {
-			continue
+// checkSpend returns an errdefs.ErrSpendLimit error once the session has
+// used its token or cost budget
+func (o *Orchestrator) checkSpend() error {
+	if o.config.MaxTokensTotal > 0 && o.state.TokensUsed >= o.config.MaxTokensTotal {
+		return fmt.Errorf("%w: %d of %d tokens used", errdefs.ErrSpendLimit, o.state.TokensUsed, o.config.MaxTokensTotal)
+	}
+	if o.config.MaxCost > 0 && o.state.CostUSD >= o.config.MaxCost {
+		return fmt.Errorf("%w: ~$%.2f of $%.2f spent", errdefs.ErrSpendLimit, o.state.CostUSD, o.config.MaxCost)
+	}
+	return nil
+}
+
+// suiteLimited reports whether the next full-suite coverage run must wait,
+// either for the minimum interval between runs or for the configured number
+// of validation-only iterations
+func (o *Orchestrator) suiteLimited() bool {
+	if o.config.SuiteEvery > 1 && o.reusedRuns < o.config.SuiteEvery-1 {
+		return true
+	}
+	interval := time.Duration(o.config.SuiteInterval) * time.Minute
+	return interval > 0 && time.Since(o.lastSuiteRun) < interval
+}
+
+// initialCoverage measures the starting coverage. With a configured report
+// file, it loads the report instead of running the test suite.
+func (o *Orchestrator) initialCoverage() (*coverage.CoverageReport, error) {
+	if o.config.CoverageReport == "" {
+		fmt.Println("\nAnalyzing current test coverage...")
+		report, err := o.runCoverage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to run initial coverage analysis: %w", err)
 		}
+		return o.completeReport(report)
+	}
 
-		// Skip if in failed files
-		if _, failed := o.state.FailedFiles[sourceFile]; failed {
-			continue
+	fmt.Printf("\nLoading current test coverage from %s...\n", o.config.CoverageReport)
+	report, err := coverage.LoadReport(o.config.ProjectPath, o.config.CoverageReport, o.analyzer, o.config.Analyzer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load coverage report: %w", err)
+	}
+	return o.completeReport(report)
+}
+
+// runCoverage runs the test suite with coverage, unless a report of an
+// earlier run on the same source tree is cached, e.g. after a dry-run
+// iteration or a failed generation left the tree unchanged
+func (o *Orchestrator) runCoverage() (*coverage.CoverageReport, error) {
+	var key string
+	if !o.config.FreshCoverage {
+		var err error
+		if key, err = coverage.ReportKey(o.config.ProjectPath, o.analyzer, o.config.Analyzer); err != nil {
+			fmt.Printf("Warning: Could not hash the source tree, running the test suite: %v\n", err)
+		} else if report, ok := coverage.CachedReport(o.config.ProjectPath, key); ok {
+			fmt.Println("Source tree unchanged since an earlier run: reusing its coverage report")
+			return report, nil
+		}
+	}
+
+	report, err := o.analyzer.RunCoverage(o.config.ProjectPath)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		if err := coverage.CacheReport(o.config.ProjectPath, key, report); err != nil {
+			fmt.Printf("Warning: Could not cache coverage report: %v\n", err)
 		}
+	}
+	return report, nil
+}
+
+// measureCoverage measures coverage for an iteration. With -incremental,
+// analyzers that support it only re-measure the code of the test files
+// changed since the last measurement.
+func (o *Orchestrator) measureCoverage() (*coverage.CoverageReport, error) {
+	incremental, ok := o.analyzer.(coverage.IncrementalAnalyzer)
+	if !o.config.Incremental || !ok || o.staleProfile || len(o.state.PendingChanges) == 0 {
+		o.staleProfile = false
+		return o.runCoverage()
+	}
 
-		testFile := o.analyzer.GetTestFilePath(sourceFile)
-		uncoveredLines := report.UncoveredLines[sourceFile]
-		currentCoverage := report.FileCoverage[sourceFile]
+	testFiles := make([]string, 0, len(o.state.PendingChanges))
+	for _, change := range o.state.PendingChanges {
+		testFiles = append(testFiles, change.TestFile)
+	}
+	fmt.Printf("Incremental coverage run for %d changed test file(s)\n", len(testFiles))
+	return incremental.RunCoverageFor(o.config.ProjectPath, testFiles)
+}
 
-		// Calculate priority (lower coverage = higher priority)
-		priority := int(100 - currentCoverage)
+// completeReport merges the configured extra coverage reports into a fresh
+// coverage report, drops test files, removes excluded paths, generated
+// files and code marked with ignore directives and keeps the report's
+// exclusions for the summary
+func (o *Orchestrator) completeReport(report *coverage.CoverageReport) (*coverage.CoverageReport, error) {
+	if len(o.config.MergeCoverage) > 0 {
+		extra := make([]*coverage.CoverageReport, 0, len(o.config.MergeCoverage))
+		for _, file := range o.config.MergeCoverage {
+			r, err := coverage.LoadReport(o.config.ProjectPath, file, o.analyzer, o.config.Analyzer)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load coverage to merge: %w", err)
+			}
+			extra = append(extra, r)
+		}
+		report = coverage.MergeReports(report, extra...)
+	}
 
-		// Check if test file exists
-		testExists := fileExists(testFile)
+	coverage.DropTestFiles(o.config.ProjectPath, o.analyzer.GetLanguageName(), report)
 
-		items = append(items, WorkItem{
-			SourceFile:      sourceFile,
-			TestFile:        testFile,
-			CurrentCoverage: currentCoverage,
-			UncoveredLines:  uncoveredLines,
-			Priority:        priority,
-			Exists:          testExists,
-		})
+	if len(report.ParseErrors) > 0 {
+		fmt.Printf("Warning: skipped %d coverage report entries that could not be parsed\n", len(report.ParseErrors))
+		for i, e := range report.ParseErrors {
+			if i == maxParseErrors {
+				fmt.Printf("  ... and %d more\n", len(report.ParseErrors)-i)
+				break
+			}
+			fmt.Printf("  %s: %s\n", e.Entry, e.Error)
+		}
+		if kept := report.ParseErrors[0].Report; kept != "" {
+			fmt.Printf("  Raw report kept at %s\n", kept)
+		}
+	}
+
+	// Export the tool's view before excludes and ignore directives edit the report
+	if o.config.CoverageOut != "" {
+		if err := coverage.Export(o.config.ProjectPath, report, o.config.CoverageOut); err != nil {
+			fmt.Printf("Warning: Could not export coverage: %v\n", err)
+		}
+	}
+
+	coverage.ExcludePaths(o.config.ProjectPath, report, o.config.Exclude)
+	coverage.ExcludeGenerated(o.config.ProjectPath, report, o.config.DropGenerated)
+	coverage.ApplyIgnoreDirectives(o.config.ProjectPath, report)
+	if o.config.SkipDeadCode {
+		coverage.ApplyDeadCode(o.config.ProjectPath, report, o.findDeadCode())
+	}
+	o.state.Exclusions = report.Exclusions
+	return report, nil
+}
+
+// findDeadCode runs the dead-code tool once per run. Tests generated later
+// use the code they test, so the tool's later view would hide dead code.
+func (o *Orchestrator) findDeadCode() coverage.DeadCode {
+	if o.deadCode != nil {
+		return o.deadCode
+	}
+
+	fmt.Println("Looking for dead code...")
+	dead, err := coverage.FindDeadCode(o.config.ProjectPath, o.analyzer, o.config.Analyzer)
+	if err != nil {
+		fmt.Printf("Warning: Could not look for dead code: %v\n", err)
+	}
+	if dead == nil {
+		dead = make(coverage.DeadCode)
 	}
+	o.deadCode = dead
+	return dead
+}
+
+// WorkItem represents a file that needs test coverage
+type WorkItem = workplan.Item
 
-	// Sort by priority (higher priority first)
-	sort.Slice(items, func(i, j int) bool {
-		return items[i].Priority > items[j].Priority
+// prioritizeWorkItems creates a prioritized list of files to work on,
+// leaving out files that were already processed or failed
+func (o *Orchestrator) prioritizeWorkItems(report *coverage.CoverageReport) []WorkItem {
+	items := workplan.Prioritize(report, o.analyzer, func(sourceFile string) bool {
+		if o.state.IsFileProcessed(sourceFile) {
+			return true
+		}
+		_, failed := o.state.FailedFiles[sourceFile]
+		return failed
 	})
 
+	// Files of other partitions are left to the runs working on them
+	if o.partition != nil {
+		kept := items[:0]
+		for _, item := range items {
+			if o.inPartition(item.SourceFile) {
+				kept = append(kept, item)
+			}
+		}
+		if skipped := len(items) - len(kept); skipped > 0 {
+			fmt.Printf("Skipping %d file(s) outside partition %s\n", skipped, o.partition)
+		}
+		items = kept
+	}
+
+	// Test files the agent didn't write are off limits with -no-modify
+	if o.config.NoModify {
+		kept := items[:0]
+		for _, item := range items {
+			if !item.Exists || o.wroteTest(item.TestFile) {
+				kept = append(kept, item)
+			}
+		}
+		if skipped := len(items) - len(kept); skipped > 0 {
+			fmt.Printf("Skipping %d file(s) whose tests already exist (-no-modify)\n", skipped)
+		}
+		items = kept
+	}
+
+	// Files without tests are left to people with -improve-only
+	if o.config.ImproveOnly {
+		kept := items[:0]
+		for _, item := range items {
+			if item.Exists {
+				kept = append(kept, item)
+			}
+		}
+		if skipped := len(items) - len(kept); skipped > 0 {
+			fmt.Printf("Skipping %d file(s) without tests (-improve-only)\n", skipped)
+		}
+		items = kept
+	}
+
+	// Files with unused code are worked on last
+	if len(o.deadCode) > 0 {
+		sort.SliceStable(items, func(i, j int) bool {
+			return len(o.deadCode.Symbols(o.config.ProjectPath, items[j].SourceFile)) > 0 &&
+				len(o.deadCode.Symbols(o.config.ProjectPath, items[i].SourceFile)) == 0
+		})
+	}
 	return items
 }
 
+// inPartition reports whether a source file belongs to this run's
+// partition, by its project-relative path
+func (o *Orchestrator) inPartition(sourceFile string) bool {
+	relPath := sourceFile
+	if rel, err := filepath.Rel(o.config.ProjectPath, sourceFile); err == nil && filepath.IsAbs(sourceFile) {
+		relPath = rel
+	}
+	return o.partition.Contains(filepath.ToSlash(relPath))
+}
+
+// maxHistoryCommits is the number of commits behind a file's uncovered
+// lines included in prompts with -blame-context
+const maxHistoryCommits = 3
+
+// lineHistory returns the most recent commits that last changed a work
+// item's uncovered lines. Failing to blame the file only loses the context.
+func (o *Orchestrator) lineHistory(item WorkItem) []prompts.Commit {
+	commits, err := o.gitMgr.LineHistory(item.SourceFile, item.UncoveredLines, maxHistoryCommits)
+	if err != nil {
+		fmt.Printf("  Warning: Could not read the history of the uncovered lines: %v\n", err)
+		return nil
+	}
+
+	history := make([]prompts.Commit, 0, len(commits))
+	for _, commit := range commits {
+		history = append(history, prompts.Commit{Hash: commit.Hash, Message: commit.Message, Lines: commit.Lines})
+	}
+	return history
+}
+
+// keptTestFiles returns the number of distinct test files this run created
+// or changed and kept, i.e. whose change was validated. Abandoned attempts
+// and earlier runs of a resumed session don't count.
+func (o *Orchestrator) keptTestFiles() int {
+	files := make(map[string]bool)
+	for _, change := range o.created {
+		files[change.TestFile] = true
+	}
+	for _, change := range o.improved {
+		files[change.TestFile] = true
+	}
+	return len(files)
+}
+
+// wroteTest reports whether the agent created a test file in this session
+func (o *Orchestrator) wroteTest(testFile string) bool {
+	for _, generated := range o.state.GeneratedTests {
+		if filepath.Clean(generated) == filepath.Clean(testFile) {
+			return true
+		}
+	}
+	return false
+}
+
 // processFile processes a single file (generate or improve tests)
 func (o *Orchestrator) processFile(ctx context.Context, item WorkItem, report *coverage.CoverageReport) error {
 	// Check for cancellation
@@ -277,8 +872,74 @@ func (o *Orchestrator) processFile(ctx context.Context, item WorkItem, report *c
 
 	var testFile string
 	var err error
+	var result *testgen.ValidationResult // Set if the test was already validated in a sandbox
+
+	// Never touch files outside the project, whatever the analyzer returned
+	if err := testgen.CheckPath(o.config.ProjectPath, item.TestFile); err != nil {
+		return err
+	}
+
+	// Back up the test file before touching it when git is not available
+	if o.journal != nil && !o.config.DryRun {
+		if err := o.journal.Record(item.TestFile); err != nil {
+			return fmt.Errorf("failed to record change: %w", err)
+		}
+	}
+
+	// Validation runs only the tests added or changed from here on
+	validated := false
+	var mocks *testgen.Mocks
+	var buildFile string // Changed to register a new test file
+	var buildOriginal []byte
+	if !o.config.DryRun {
+		defer func() {
+			o.generator.FinishAttempt(validated)
+		}()
+
+		original, readErr := os.ReadFile(item.TestFile) // Empty for a new test file
+		o.validator.SetBaseline(item.TestFile, string(original))
+
+		// A broken test file left behind breaks later coverage runs of its
+		// package, so an abandoned attempt is undone
+		if !o.config.KeepFailed {
+			defer func() {
+				if !validated {
+					o.discardAttempt(item.TestFile, original, readErr == nil)
+					o.discardMocks(mocks)
+					if buildFile != "" {
+						o.discardAttempt(buildFile, buildOriginal, true)
+					}
+				}
+			}()
+		}
+	}
+
+	o.generator.SetUntestedMethods(o.config.ProjectPath, item.SourceFile, item.UntestedMethods)
+	if o.config.BlameContext {
+		o.generator.SetLineHistory(o.config.ProjectPath, item.SourceFile, o.lineHistory(item))
+	}
+
+	if unused := o.deadCode.Symbols(o.config.ProjectPath, item.SourceFile); len(unused) > 0 {
+		fmt.Printf("  Note: %d unused symbol(s), e.g. line %d: %s; consider deleting them instead of testing them\n",
+			len(unused), unused[0].Line, unused[0].Symbol)
+	}
+
+	// Generate or reuse mocks for the file's interface dependencies. Every
+	// mock the test uses is committed with it, since a reused mock may have
+	// been left uncommitted by an earlier file's attempt.
+	var extraFiles []string
+	if o.config.GoMocks && !o.config.DryRun {
+		mocks = o.prepareMocks(item.SourceFile)
+		if mocks != nil {
+			extraFiles = mocks.Files
+		}
+	}
 
 	if !item.Exists {
+		if o.config.ImproveOnly {
+			return fmt.Errorf("refusing to create a test file for %s, which has none (-improve-only)", item.SourceFile)
+		}
+
 		// Generate new test
 		fmt.Println("  Generating new test file...")
 		if !o.config.DryRun {
@@ -287,30 +948,53 @@ func (o *Orchestrator) processFile(ctx context.Context, item WorkItem, report *c
 				o.config.ProjectPath,
 				item.SourceFile,
 				item.UncoveredLines,
+				item.UncoveredBranches,
 			)
 			if err != nil {
 				return fmt.Errorf("failed to generate test: %w", err)
 			}
 			o.state.AddGeneratedTest(testFile)
+			o.state.RecordTestModel(testFile, o.generator.ModelFor(o.config.ProjectPath, item.SourceFile))
+
+			// A build that lists its test files only runs the new one once it's added
+			buildFile, buildOriginal = o.registerTestFile(testFile)
+			if buildFile != "" {
+				extraFiles = append(extraFiles, buildFile)
+			}
 		} else {
 			fmt.Println("  [DRY RUN] Would generate test file")
 			testFile = item.TestFile
 		}
 	} else {
+		if o.config.NoModify && !o.wroteTest(item.TestFile) {
+			return fmt.Errorf("refusing to change %s, which the agent didn't write (-no-modify)", item.TestFile)
+		}
+
 		// Improve existing test
 		fmt.Println("  Improving existing test file...")
-		if !o.config.DryRun {
+		if !o.config.DryRun && o.config.SafeImprove {
+			o.state.RecordAPICall()
+			result, err = o.improveInSandbox(ctx, item)
+			if err != nil {
+				return err
+			}
+			testFile = item.TestFile
+			o.state.AddFixedTest(testFile)
+			o.state.RecordTestModel(testFile, o.generator.ModelFor(o.config.ProjectPath, item.SourceFile))
+		} else if !o.config.DryRun {
 			o.state.RecordAPICall()
 			testFile, err = o.generator.ImproveExistingTest(
 				o.config.ProjectPath,
 				item.SourceFile,
 				item.TestFile,
 				item.UncoveredLines,
+				item.UncoveredBranches,
 			)
 			if err != nil {
 				return fmt.Errorf("failed to improve test: %w", err)
 			}
 			o.state.AddFixedTest(testFile)
+			o.state.RecordTestModel(testFile, o.generator.ModelFor(o.config.ProjectPath, item.SourceFile))
 		} else {
 			fmt.Println("  [DRY RUN] Would improve test file")
 			testFile = item.TestFile
@@ -319,34 +1003,75 @@ func (o *Orchestrator) processFile(ctx context.Context, item WorkItem, report *c
 
 	// Validate the test
 	if !o.config.DryRun {
-		fmt.Println("  Validating test...")
-		result, err := o.validator.ValidateAndRetry(
-			o.config.ProjectPath,
-			testFile,
-			o.generator,
-			2, // max 2 retries
-		)
+		// Generation may have used up the file's time budget
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		if err != nil {
-			return fmt.Errorf("validation error: %w", err)
+		if result == nil {
+			fmt.Println("  Validating test...")
+			result, err = o.validator.ValidateAndRetry(
+				ctx,
+				o.config.ProjectPath,
+				testFile,
+				o.generator,
+				2, // max 2 retries
+			)
+
+			if err != nil {
+				return fmt.Errorf("validation error: %w", err)
+			}
 		}
 
 		if !result.Success {
 			fmt.Printf("  ❌ Test validation failed: %s\n", result.ErrorMessage)
-			o.state.MarkFileFailed(item.SourceFile, result.ErrorMessage)
+			o.markFailed(item.SourceFile, result.ErrorMessage)
+			output := result.Output
+			if attempt, err := os.ReadFile(testFile); err == nil && !o.config.KeepFailed {
+				output += fmt.Sprintf("\n\n--- Abandoned %s ---\n%s", testFile, attempt)
+			}
+			if logFile := o.saveFailureLog(item.SourceFile, output); logFile != "" {
+				o.state.RecordFailureLog(item.SourceFile, logFile)
+				fmt.Printf("  Full output: %s\n", logFile)
+			}
 			return nil
 		}
 
 		fmt.Println("  ✅ Test validation successful")
+		validated = true
+		o.recordTestQuality(item.SourceFile, testFile)
+		o.recordConfidence(testFile, result.Retries)
 
 		// Commit to git if enabled
+		change := config.TestChange{SourceFile: item.SourceFile, TestFile: testFile}
 		if o.gitMgr.IsEnabled() {
 			fmt.Println("  Committing to git...")
 			coverageGain := 0.0 // We'd need to re-run coverage to know this
-			if err := o.gitMgr.CreateSafetyCommit(testFile, coverageGain); err != nil {
+			if err := o.gitMgr.CreateSafetyCommit(testFile, coverageGain, extraFiles...); err != nil {
 				fmt.Printf("  Warning: Failed to commit: %v\n", err)
+			} else {
+				change.Commit, _ = o.gitMgr.GetLastCommitHash()
+				if o.chunkingEnabled() {
+					o.addToChunk(testFile)
+				}
+			}
+		} else if o.journal != nil {
+			if err := o.journal.Snapshot(testFile); err != nil {
+				fmt.Printf("  Warning: Failed to snapshot: %v\n", err)
 			}
 		}
+
+		o.state.RecordTestChange(change)
+		if item.Exists {
+			o.improved = append(o.improved, change)
+		} else {
+			o.created = append(o.created, change)
+		}
+		o.notify(notify.Event{Type: notify.EventValidated, File: item.SourceFile, TestFile: testFile})
+
+		if o.config.FlakyRuns > 0 {
+			o.checkFlaky(testFile)
+		}
 	}
 
 	// Mark file as processed
@@ -355,16 +1080,980 @@ func (o *Orchestrator) processFile(ctx context.Context, item WorkItem, report *c
 	return nil
 }
 
-// fileExists checks if a file exists
-func fileExists(path string) bool {
-	absPath := path
-	if !filepath.IsAbs(path) {
-		var err error
-		absPath, err = filepath.Abs(path)
-		if err != nil {
-			return false
+// discardAttempt undoes an abandoned attempt at a test file: an existing
+// file gets its original content back, and a new one is removed
+func (o *Orchestrator) discardAttempt(testFile string, original []byte, existed bool) {
+	current, err := os.ReadFile(testFile)
+	if err != nil && !existed {
+		return // Never written
+	}
+	if existed && err == nil && bytes.Equal(current, original) {
+		return // Unchanged, e.g. improved in a sandbox
+	}
+
+	if existed {
+		if err := os.WriteFile(testFile, original, 0644); err != nil {
+			fmt.Printf("  Warning: Could not restore %s: %v\n", testFile, err)
+			return
 		}
+		fmt.Printf("  Restored %s to its original content\n", testFile)
+		return
+	}
+	if err := os.Remove(testFile); err != nil {
+		fmt.Printf("  Warning: Could not remove broken test file %s: %v\n", testFile, err)
+		return
+	}
+	fmt.Printf("  Removed broken test file %s\n", testFile)
+}
+
+// registerTestFile adds a new test file to the build if the analyzer's
+// build lists its test files, returning the build file it changed and its
+// original content, or "" if it changed none
+func (o *Orchestrator) registerTestFile(testFile string) (string, []byte) {
+	registrar, ok := o.analyzer.(coverage.TestRegistrar)
+	if !ok {
+		return "", nil
+	}
+	buildFile := registrar.TestBuildFile(o.config.ProjectPath, testFile)
+	if buildFile == "" {
+		return "", nil
+	}
+
+	original, err := os.ReadFile(buildFile)
+	if err != nil {
+		fmt.Printf("  Warning: Could not read %s: %v\n", buildFile, err)
+		return "", nil
+	}
+	if o.journal != nil {
+		if err := o.journal.Record(buildFile); err != nil {
+			fmt.Printf("  Warning: Could not record %s: %v\n", buildFile, err)
+			return "", nil
+		}
+	}
+
+	changed, err := registrar.RegisterTestFile(o.config.ProjectPath, testFile)
+	if err != nil {
+		fmt.Printf("  Warning: Could not register %s in the build: %v\n", testFile, err)
+		return "", nil
+	}
+	if !changed {
+		return "", nil
+	}
+	fmt.Printf("  Registered %s in %s\n", testFile, buildFile)
+	return buildFile, original
+}
+
+// discardMocks removes the mocks generated for an abandoned attempt, so
+// the next file of the package generates and commits them itself
+func (o *Orchestrator) discardMocks(mocks *testgen.Mocks) {
+	if mocks == nil {
+		return
+	}
+	for _, file := range mocks.Created {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("  Warning: Could not remove mock file %s: %v\n", file, err)
+		}
+	}
+}
+
+// containerBudgetFactor extends the time budget of files tested against a
+// database container, since starting containers slows every validation run
+const containerBudgetFactor = 3
+
+// fileContext bounds the work on a single file by the configured time
+// budget. The budget is checked between steps, so a running API call or
+// test run finishes first.
+func (o *Orchestrator) fileContext(ctx context.Context, item WorkItem) (context.Context, context.CancelFunc) {
+	if o.config.FileBudgetMin <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	budget := time.Duration(o.config.FileBudgetMin) * time.Minute
+	if o.config.Testcontainers {
+		sourceCode, _ := os.ReadFile(item.SourceFile)
+		if database := testgen.DatabaseFor(o.analyzer.GetLanguageName(), o.config.ProjectPath, string(sourceCode)); database != "" {
+			fmt.Printf("  Testing against %s in a container, time budget extended to %d minutes\n",
+				database, o.config.FileBudgetMin*containerBudgetFactor)
+			budget *= containerBudgetFactor
+		}
+	}
+	return context.WithTimeoutCause(ctx, budget, errdefs.ErrBudgetExceeded)
+}
+
+// improveInSandbox improves and validates an existing test in a temporary
+// copy of the project, and only replaces the real test file once the
+// improved version passes. A bad rewrite never touches the checkout.
+func (o *Orchestrator) improveInSandbox(ctx context.Context, item WorkItem) (*testgen.ValidationResult, error) {
+	sandbox, err := testgen.NewSandbox(o.config.ProjectPath)
+	if err != nil {
+		return nil, err
+	}
+	defer sandbox.Remove()
+
+	sandboxTest := sandbox.Path(item.TestFile)
+	if original, err := os.ReadFile(sandboxTest); err == nil {
+		o.validator.SetBaseline(sandboxTest, string(original))
+	}
+	_, err = o.generator.ImproveExistingTest(
+		sandbox.Root(),
+		sandbox.Path(item.SourceFile),
+		sandboxTest,
+		item.UncoveredLines,
+		item.UncoveredBranches,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to improve test: %w", err)
+	}
+
+	fmt.Println("  Validating test in a temporary copy of the project...")
+	result, err := o.validator.ValidateAndRetry(ctx, sandbox.Root(), sandboxTest, o.generator, 2)
+	if err != nil {
+		return nil, fmt.Errorf("validation error: %w", err)
+	}
+	if !result.Success {
+		return result, nil
+	}
+
+	improved, err := os.ReadFile(sandboxTest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read improved test: %w", err)
+	}
+	if err := os.WriteFile(item.TestFile, improved, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write improved test file: %w", err)
+	}
+
+	return result, nil
+}
+
+// prepareMocks generates mocks for a source file's interface dependencies
+// and returns them, or nil if there are none or they couldn't be generated
+func (o *Orchestrator) prepareMocks(sourceFile string) *testgen.Mocks {
+	mocks, err := o.generator.PrepareMocks(o.config.ProjectPath, sourceFile)
+	if err != nil {
+		fmt.Printf("  Warning: Could not prepare mocks: %v\n", err)
+		return nil
+	}
+	if mocks == nil {
+		return nil
+	}
+
+	fmt.Printf("  Using %s mocks for %s\n", mocks.Tool, strings.Join(mocks.Interfaces, ", "))
+	if o.journal != nil {
+		for _, file := range mocks.Created {
+			if err := o.journal.RecordCreated(file); err != nil {
+				fmt.Printf("  Warning: Failed to record change: %v\n", err)
+			}
+		}
+	}
+
+	return mocks
+}
+
+// PrintSummary prints the end-of-run summary
+func (o *Orchestrator) PrintSummary() {
+	o.recordUsage()
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "%s\n", o.state.GetProgress())
+	fmt.Fprintln(&summary, o.state.GetQualitySummary())
+
+	if len(o.state.Quarantined) > 0 {
+		files := make([]string, 0, len(o.state.Quarantined))
+		for file := range o.state.Quarantined {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		fmt.Fprintf(&summary, "Quarantined flaky tests (%d):\n", len(files))
+		for _, file := range files {
+			fmt.Fprintf(&summary, "  %s: %s\n", file, o.state.Quarantined[file])
+		}
+	}
+
+	if review := o.state.NeedsReview(); len(review) > 0 {
+		fmt.Fprintf(&summary, "Low-confidence tests to review first (%d):\n", len(review))
+		for _, file := range review {
+			fmt.Fprintf(&summary, "  %s: %s\n", file, o.state.Confidence[file])
+		}
+	}
+
+	if len(o.state.Refactorings) > 0 {
+		files := make([]string, 0, len(o.state.Refactorings))
+		for file := range o.state.Refactorings {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		fmt.Fprintf(&summary, "Refactorings that would make untestable files testable (%d):\n", len(files))
+		for _, file := range files {
+			fmt.Fprintf(&summary, "  %s:\n", file)
+			for _, suggestion := range o.state.Refactorings[file] {
+				fmt.Fprintf(&summary, "    - %s\n", suggestion)
+			}
+		}
+	}
+
+	if len(o.state.Exclusions) > 0 {
+		exclusions := append([]coverage.Exclusion(nil), o.state.Exclusions...)
+		sort.Slice(exclusions, func(i, j int) bool {
+			if exclusions[i].File != exclusions[j].File {
+				return exclusions[i].File < exclusions[j].File
+			}
+			return exclusions[i].Reason < exclusions[j].Reason
+		})
+
+		fmt.Fprintf(&summary, "Excluded from coverage work (%d):\n", len(exclusions))
+		for _, e := range exclusions {
+			scope := "whole file"
+			if e.Lines > 0 {
+				scope = fmt.Sprintf("%d uncovered lines", e.Lines)
+			}
+			counted := "not counted in total coverage"
+			if e.InTotal {
+				counted = "still counted in total coverage"
+			}
+			fmt.Fprintf(&summary, "  %s: %s (%s, %s)\n", e.File, e.Reason, scope, counted)
+		}
+	}
+
+	if len(o.state.Regressions) > 0 {
+		fmt.Fprintf(&summary, "Coverage regressions (%d):\n", len(o.state.Regressions))
+		for _, r := range o.state.Regressions {
+			cause := "cause unknown"
+			if r.TestFile != "" {
+				cause = "after changes to " + r.TestFile
+				if r.Reverted {
+					cause += ", reverted"
+				}
+			}
+			fmt.Fprintf(&summary, "  %s: %.2f%% -> %.2f%% in iteration %d (%s)\n", r.File, r.Before, r.After, r.Iteration, cause)
+		}
+	}
+
+	// Translating is another API call, which a used-up budget doesn't allow
+	text := summary.String()
+	if o.config.ReportLanguage != "" && !o.config.DryRun && o.checkSpend() == nil {
+		translated, err := o.generator.TranslateSummary(text, o.config.ReportLanguage)
+		if err != nil {
+			fmt.Printf("\nWarning: Could not translate summary: %v\n", err)
+		} else {
+			text = translated + "\n"
+		}
+	}
+
+	fmt.Printf("\n%s", text)
+}
+
+// Finish reports the end of the session to the configured reporters and
+// closes them. runErr is the error Run returned, if any.
+func (o *Orchestrator) Finish(runErr error) error {
+	summary := &notify.Summary{
+		StartCoverage:  o.state.StartingCoverage(),
+		Iterations:     o.state.CurrentIteration,
+		TestsGenerated: len(o.state.GeneratedTests),
+		TestsFixed:     len(o.state.FixedTests),
+		FailedFiles:    len(o.state.FailedFiles),
+		TargetMet:      o.state.CurrentCoverage >= o.config.TargetCoverage,
+	}
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+
+	o.notify(notify.Event{Type: notify.EventFinished, Summary: summary})
+	return o.notifier.Close()
+}
+
+// notify sends an event to the configured reporters, filling in the
+// session's progress
+func (o *Orchestrator) notify(event notify.Event) {
+	event.Project = o.config.ProjectPath
+	event.Iteration = o.state.CurrentIteration
+	event.Coverage = o.state.CurrentCoverage
+	event.Target = o.config.TargetCoverage
+	o.notifier.Notify(event)
+}
+
+// maxRefactorFiles caps the failed files analyzed for refactorings per run
+const maxRefactorFiles = 10
+
+// SuggestRefactorings asks for refactorings that would make the files the
+// agent failed to test testable, for files whose generated tests didn't
+// compile or pass even after fixing. Files with suggestions from an
+// earlier run are skipped.
+func (o *Orchestrator) SuggestRefactorings() {
+	if !o.config.RefactorHints || o.config.DryRun {
+		return
+	}
+
+	var files []string
+	for file, message := range o.state.FailedFiles {
+		class := config.FailureClass(message)
+		if _, done := o.state.Refactorings[file]; !done && (class == config.FailureCompile || class == config.FailureTest) {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+	sort.Strings(files)
+	if len(files) > maxRefactorFiles {
+		fmt.Printf("\nSuggesting refactorings for %d of %d untestable files\n", maxRefactorFiles, len(files))
+		files = files[:maxRefactorFiles]
+	} else {
+		fmt.Printf("\nSuggesting refactorings for %d untestable file(s)\n", len(files))
+	}
+
+	for _, file := range files {
+		o.state.RecordAPICall()
+		suggestions, err := o.generator.SuggestRefactorings(o.config.ProjectPath, file, o.state.FailedFiles[file])
+		if err != nil {
+			fmt.Printf("  Warning: %s: %v\n", file, err)
+			if errors.Is(err, errdefs.ErrRateLimited) {
+				break
+			}
+			continue
+		}
+		o.state.RecordRefactorings(file, suggestions)
+	}
+	o.recordUsage()
+
+	if err := o.SaveState(); err != nil {
+		fmt.Printf("  Warning: %v\n", err)
+	}
+}
+
+// markFailed gives up on a file and reports it
+func (o *Orchestrator) markFailed(sourceFile, message string) {
+	o.state.MarkFileFailed(sourceFile, message)
+	o.notify(notify.Event{Type: notify.EventFailed, File: sourceFile, Message: message})
+}
+
+// WriteReport writes the HTML session report and returns its path. It does
+// nothing and returns "" if the run never measured coverage.
+func (o *Orchestrator) WriteReport() (string, error) {
+	if o.initialReport == nil {
+		return "", nil
+	}
+
+	path := o.config.HTMLReport
+	if path == "" {
+		path = cache.New(o.config.ProjectPath).ReportFile()
+	}
+
+	// Link each source file of the report to the test the agent touched
+	touched := make(map[string]bool)
+	for _, testFile := range append(append([]string(nil), o.state.GeneratedTests...), o.state.FixedTests...) {
+		touched[filepath.Clean(testFile)] = true
+	}
+	testFor := make(map[string]string)
+	for file := range o.lastReport.FileCoverage {
+		if testFile := o.analyzer.GetTestFilePath(file); touched[filepath.Clean(testFile)] {
+			testFor[file] = testFile
+		}
+	}
+
+	err := reporting.WriteHTML(path, reporting.Session{
+		State:   o.state,
+		Before:  o.initialReport,
+		After:   o.lastReport,
+		TestFor: testFor,
+	})
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// reviewNotes lists this run's test files flagged for review, least
+// confident first, with their confidence
+func (o *Orchestrator) reviewNotes(relPath func(string) string) []string {
+	written := make(map[string]bool)
+	for _, change := range append(append([]config.TestChange(nil), o.created...), o.improved...) {
+		written[change.TestFile] = true
+	}
+
+	var notes []string
+	for _, file := range o.state.NeedsReview() {
+		if written[file] {
+			notes = append(notes, fmt.Sprintf("`%s`: %s", relPath(file), o.state.Confidence[file]))
+		}
+	}
+	return notes
+}
+
+// WriteActivityLog appends this run's entry to the configured activity log
+// and commits it on the session branch, returning the log's path. It does
+// nothing and returns "" without an activity log, in dry runs or if the run
+// validated no tests.
+func (o *Orchestrator) WriteActivityLog() (string, error) {
+	if o.config.ActivityLog == "" || o.config.DryRun || o.initialReport == nil {
+		return "", nil
+	}
+	if len(o.created) == 0 && len(o.improved) == 0 {
+		return "", nil
+	}
+
+	path := o.config.ActivityLog
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(o.config.ProjectPath, path)
+	}
+	if err := testgen.CheckPath(o.config.ProjectPath, path); err != nil {
+		return "", err
+	}
+
+	relPath := func(file string) string {
+		if rel, err := filepath.Rel(o.config.ProjectPath, file); err == nil {
+			return filepath.ToSlash(rel)
+		}
+		return file
+	}
+	relChanges := func(changes []config.TestChange) []config.TestChange {
+		rel := make([]config.TestChange, len(changes))
+		for i, change := range changes {
+			rel[i] = config.TestChange{SourceFile: relPath(change.SourceFile), TestFile: relPath(change.TestFile), Commit: change.Commit}
+		}
+		return rel
+	}
+
+	err := reporting.AppendActivity(path, reporting.Activity{
+		Date:       time.Now(),
+		Branch:     o.branch,
+		Before:     o.initialReport.TotalCoverage,
+		After:      o.state.CurrentCoverage,
+		Target:     o.config.TargetCoverage,
+		Iterations: o.state.CurrentIteration,
+		Created:    relChanges(o.created),
+		Improved:   relChanges(o.improved),
+		Failed:     len(o.state.FailedFiles),
+		Review:     o.reviewNotes(relPath),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if o.branch != "" {
+		message := fmt.Sprintf("docs: Log test-coverage-agent session (%.2f%% -> %.2f%% coverage)",
+			o.initialReport.TotalCoverage, o.state.CurrentCoverage)
+		if err := o.gitMgr.CreateCommit([]string{path}, message); err != nil {
+			return path, err
+		}
+	}
+	return path, nil
+}
+
+// WriteAttestation signs the session's final coverage and writes it to the
+// configured attestation file, returning its path. Test changes made since
+// the last measurement are measured first, so the attestation holds for the
+// commit it names. It does nothing without an attestation file, in dry runs
+// or if the run never measured coverage.
+func (o *Orchestrator) WriteAttestation() (string, error) {
+	if o.config.Attestation == "" || o.config.DryRun || o.lastReport == nil {
+		return "", nil
+	}
+
+	key, err := attest.Key(o.config.AttestKeyEnv)
+	if err != nil {
+		return "", err
+	}
+
+	// A loaded report wasn't measured by this session
+	if len(o.state.PendingChanges) > 0 || (o.config.CoverageReport != "" && o.lastReport == o.initialReport) {
+		fmt.Println("\nMeasuring final coverage for the attestation...")
+		report, err := o.runCoverage()
+		if err != nil {
+			return "", fmt.Errorf("failed to measure final coverage: %w", err)
+		}
+		if o.lastReport, err = o.completeReport(report); err != nil {
+			return "", err
+		}
+	}
+
+	commit, err := o.gitMgr.GetLastCommitHash()
+	if err != nil {
+		return "", err
+	}
+	dirty, err := o.gitMgr.HasUncommittedChanges()
+	if err != nil {
+		return "", err
+	}
+	repository, _ := o.gitMgr.GetRemoteURL("origin")
+	branch, _ := o.gitMgr.GetCurrentBranch()
+
+	err = attest.Write(o.config.Attestation, attest.Attestation{
+		Repository:   repository,
+		Commit:       commit,
+		Branch:       branch,
+		Dirty:        dirty,
+		Language:     o.analyzer.GetLanguageName(),
+		Coverage:     o.lastReport.TotalCoverage,
+		Target:       o.config.TargetCoverage,
+		TargetMet:    o.lastReport.TotalCoverage >= o.config.TargetCoverage,
+		AgentVersion: attest.AgentVersion(),
+		ToolVersions: o.state.ToolVersions,
+		IssuedAt:     time.Now().UTC(),
+	}, key)
+	if err != nil {
+		return "", err
+	}
+	return o.config.Attestation, nil
+}
+
+// PushBranches pushes the session's branches, every chunk branch when the
+// work was chunked, to a remote. It returns the pushed branches and does
+// nothing if no test file was committed.
+func (o *Orchestrator) PushBranches(remote string) ([]string, error) {
+	if !o.gitMgr.IsEnabled() || o.config.DryRun || o.state.ModifiedFileCount() == 0 {
+		return nil, nil
+	}
+
+	var branches []string
+	for _, chunk := range o.state.Chunks {
+		if len(chunk.Files) > 0 {
+			branches = append(branches, chunk.Branch)
+		}
+	}
+	if len(o.state.Chunks) == 0 && o.branch != "" {
+		branches = append(branches, o.branch)
+	}
+
+	for _, branch := range branches {
+		if err := o.gitMgr.Push(remote, branch); err != nil {
+			return nil, err
+		}
+	}
+	return branches, nil
+}
+
+// checkFlaky re-runs a validated test and quarantines it if any run fails.
+// The quarantine is a separate commit so it can be reverted on its own.
+func (o *Orchestrator) checkFlaky(testFile string) {
+	fmt.Printf("  Re-running test %d time(s) to check for flakiness...\n", o.config.FlakyRuns)
+	flaky, _, err := o.validator.CheckFlaky(o.config.ProjectPath, testFile, o.config.FlakyRuns)
+	if err != nil {
+		fmt.Printf("  Warning: Flakiness check failed: %v\n", err)
+		return
+	}
+	if !flaky {
+		return
+	}
+
+	reason := fmt.Sprintf("passed validation but failed a re-run (%d re-runs)", o.config.FlakyRuns)
+	if err := testgen.Quarantine(o.analyzer.GetLanguageName(), testFile, reason); err != nil {
+		fmt.Printf("  Warning: Test is flaky but could not be quarantined: %v\n", err)
+		return
+	}
+	o.state.QuarantineTest(testFile, reason)
+	fmt.Println("  ⚠️  Flaky test quarantined")
+
+	if o.gitMgr.IsEnabled() {
+		if err := o.gitMgr.CreateQuarantineCommit(testFile, reason); err != nil {
+			fmt.Printf("  Warning: Failed to commit quarantine: %v\n", err)
+		}
+	} else if o.journal != nil {
+		if err := o.journal.Snapshot(testFile); err != nil {
+			fmt.Printf("  Warning: Failed to snapshot: %v\n", err)
+		}
+	}
+}
+
+// checkAPI verifies API access, failing on errors that would affect every
+// request and only warning about transient ones
+func (o *Orchestrator) checkAPI() error {
+	name := "Claude API"
+	switch o.config.Provider {
+	case llm.ProviderOllama:
+		name = "Ollama"
+	case llm.ProviderVertex:
+		name = "Vertex AI"
+	}
+
+	fmt.Printf("Checking %s access...\n", name)
+	err := o.generator.CheckAPI()
+	if err == nil {
+		return nil
+	}
+
+	// A local server that can't be reached won't come up by itself, and
+	// missing Google Cloud credentials won't appear
+	var apiErr *claude.APIError
+	if errors.As(err, &apiErr) && !apiErr.Retryable() || o.config.Provider != llm.ProviderAnthropic {
+		return fmt.Errorf("%s check failed: %w", name, err)
+	}
+
+	fmt.Printf("Warning: Could not verify %s access: %v\n", name, err)
+	return nil
+}
+
+// checkCICoverage compares the starting coverage with the coverage CI
+// reported for the same commit and warns if they diverge, which usually
+// means the local coverage command is set up differently from CI
+func (o *Orchestrator) checkCICoverage(local float64) {
+	if !o.gitMgr.IsEnabled() {
+		fmt.Println("  Warning: Skipping CI coverage check, the project is not a git repository")
+		return
+	}
+
+	commit, err := o.gitMgr.GetLastCommitHash()
+	if err != nil {
+		fmt.Printf("  Warning: Skipping CI coverage check: %v\n", err)
+		return
+	}
+	remote := ""
+	if o.config.CICoverage == ci.Codecov {
+		if remote, err = o.gitMgr.GetRemoteURL("origin"); err != nil {
+			fmt.Printf("  Warning: Skipping CI coverage check: %v\n", err)
+			return
+		}
+	}
+
+	reported, err := ci.FetchCoverage(o.config.CICoverage, remote, commit)
+	if err != nil {
+		fmt.Printf("  Warning: Could not fetch CI coverage: %v\n", err)
+		return
+	}
+
+	diff := local - reported
+	if math.Abs(diff) <= o.config.CITolerance {
+		fmt.Printf("  CI Coverage:      %.2f%% (matches within %.2f points)\n", reported, o.config.CITolerance)
+		return
+	}
+
+	fmt.Printf("\n⚠️  Local coverage (%.2f%%) differs from CI coverage (%.2f%%) for commit %.12s by %+.2f points\n",
+		local, reported, commit, diff)
+	fmt.Println("  The local coverage command may include or exclude different files or tests than CI;")
+	fmt.Println("  check it before relying on the agent's numbers.")
+	if dirty, _ := o.gitMgr.HasUncommittedChanges(); dirty {
+		fmt.Println("  Note: the working tree has uncommitted changes, which CI didn't see.")
+	}
+}
+
+// writeCoverageNotes attaches the latest coverage snapshot as a JSON git
+// note to the safety commits of the test changes since the previous
+// measurement, which the snapshot is the first to include. The changes are
+// kept in the state, so commits made before a pause get their notes when
+// the session resumes.
+func (o *Orchestrator) writeCoverageNotes() {
+	if !o.config.CoverageNotes || len(o.state.CoverageHistory) == 0 {
+		return
+	}
+
+	snapshot := o.state.CoverageHistory[len(o.state.CoverageHistory)-1]
+	for _, change := range o.state.PendingChanges {
+		if change.Commit == "" {
+			continue
+		}
+
+		note := struct {
+			config.CoverageSnapshot
+			TestFile string `json:"test_file"`
+		}{
+			CoverageSnapshot: snapshot,
+			TestFile:         change.TestFile,
+		}
+
+		data, err := json.Marshal(note)
+		if err != nil {
+			fmt.Printf("Warning: Could not encode coverage note: %v\n", err)
+			continue
+		}
+		if err := o.gitMgr.AddNote(notesRef, change.Commit, string(data)); err != nil {
+			fmt.Printf("Warning: Could not write coverage note: %v\n", err)
+		}
+	}
+}
+
+// flushCoverageNotes measures coverage once more at the end of a run whose
+// last safety commits haven't been measured yet, however the run ended, and
+// writes their notes. An interrupted run leaves them to the resumed session.
+func (o *Orchestrator) flushCoverageNotes(ctx context.Context) {
+	pending := false
+	for _, change := range o.state.PendingChanges {
+		pending = pending || change.Commit != ""
+	}
+	if !pending || ctx.Err() != nil {
+		return
+	}
+
+	fmt.Println("\nMeasuring final coverage for the coverage notes...")
+	report, err := o.runCoverage()
+	if err == nil {
+		report, err = o.completeReport(report)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Could not measure coverage for the coverage notes: %v\n", err)
+		return
+	}
+
+	o.lastReport = report
+	o.state.AddCoverageSnapshot(report.TotalCoverage)
+	o.writeCoverageNotes()
+	o.checkRegressions(report)
+	if err := o.SaveState(); err != nil {
+		fmt.Printf("Warning: Could not save state: %v\n", err)
+	}
+}
+
+// progressDirectories is the number of directories listed in progress
+// output as dragging coverage down
+const progressDirectories = 5
+
+// printDirectories lists the subsystems with the most uncovered lines and
+// their coverage, so it's clear where the remaining gap is
+func (o *Orchestrator) printDirectories(report *coverage.CoverageReport) {
+	dirs := report.ByDirectory().Subsystems()
+	if len(dirs) < 2 {
+		return
+	}
+
+	dirs = append([]*coverage.DirectoryCoverage(nil), dirs...)
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return dirs[i].Uncovered > dirs[j].Uncovered
+	})
+	if len(dirs) > progressDirectories {
+		dirs = dirs[:progressDirectories]
+	}
+
+	fmt.Println("  Least covered directories:")
+	for _, dir := range dirs {
+		name := dir.Path
+		if rel, err := filepath.Rel(o.config.ProjectPath, name); err == nil && filepath.IsAbs(name) {
+			name = filepath.ToSlash(rel)
+		}
+		fmt.Printf("    %-40s %6.2f%%  %d uncovered lines in %d files\n", name+"/", dir.Coverage, dir.Uncovered, dir.Files)
+	}
+}
+
+// regressionThreshold is the drop in a file's coverage, in points, that
+// counts as a regression rather than measurement noise
+const regressionThreshold = 0.01
+
+// checkRegressions compares per-file coverage with the previous measurement.
+// A drop is blamed on the pending test change for that file, or on the only
+// pending change; the blamed file is marked for rework and, with
+// -revert-regressions, its safety commit is reverted.
+func (o *Orchestrator) checkRegressions(report *coverage.CoverageReport) {
+	previous := o.state.FileCoverage
+	changes := o.state.PendingChanges
+
+	current := make(map[string]float64, len(report.FileCoverage))
+	for file, cov := range report.FileCoverage {
+		current[file] = cov
+	}
+	o.state.FileCoverage = current
+	o.state.PendingChanges = nil
+
+	// Without test changes, a drop comes from edits outside the agent
+	if previous == nil || len(changes) == 0 {
+		return
+	}
+
+	files := make([]string, 0, len(previous))
+	for file := range previous {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	reverted := make(map[string]bool)
+	for _, file := range files {
+		after, ok := current[file]
+		before := previous[file]
+		if !ok || before-after < regressionThreshold {
+			continue
+		}
+
+		regression := config.Regression{
+			File:      file,
+			Before:    before,
+			After:     after,
+			Iteration: o.state.CurrentIteration,
+		}
+		blamed := blameChange(changes, file)
+		fmt.Printf("\n⚠️  Coverage regression: %s dropped from %.2f%% to %.2f%%\n", file, before, after)
+		if blamed == nil {
+			fmt.Printf("  Could not tell which of %d test changes caused it\n", len(changes))
+			o.state.RecordRegression(regression)
+			continue
+		}
+
+		regression.TestFile = blamed.TestFile
+		fmt.Printf("  Likely caused by changes to %s\n", blamed.TestFile)
+		if o.config.RevertDrops && blamed.Commit != "" {
+			if reverted[blamed.Commit] {
+				regression.Reverted = true
+			} else if err := o.gitMgr.RevertCommit(blamed.Commit); err != nil {
+				fmt.Printf("  Warning: Could not revert: %v\n", err)
+			} else {
+				fmt.Printf("  Reverted commit %.12s\n", blamed.Commit)
+				reverted[blamed.Commit] = true
+				regression.Reverted = true
+				o.staleProfile = true
+			}
+		}
+		if regression.Reverted {
+			// The revert restores the earlier coverage
+			o.state.FileCoverage[file] = before
+		}
+		o.state.RecordRegression(regression)
+
+		delete(o.state.ProcessedFiles, blamed.SourceFile)
+		o.markFailed(blamed.SourceFile, fmt.Sprintf(
+			"Coverage regression: %s dropped from %.2f%% to %.2f%% after changes to %s",
+			file, before, after, blamed.TestFile))
+	}
+}
+
+// blameChange picks the test change responsible for a drop in a file's
+// coverage: the change made for that file, or the only change made
+func blameChange(changes []config.TestChange, file string) *config.TestChange {
+	for i := range changes {
+		if changes[i].SourceFile == file {
+			return &changes[i]
+		}
+	}
+	if len(changes) == 1 {
+		return &changes[0]
+	}
+	return nil
+}
+
+// failureLogPrefix names the validation output files in the logs cache
+const failureLogPrefix = "validation-"
+
+// saveFailureLog keeps the full output of a failed validation in the cache
+// for post-mortem debugging, pruning the oldest logs beyond the configured
+// count and dropping them from the state. It returns the log path, or "" if
+// nothing was kept.
+func (o *Orchestrator) saveFailureLog(sourceFile, output string) string {
+	if o.config.FailureLogs <= 0 {
+		return ""
+	}
+
+	relPath, err := filepath.Rel(o.config.ProjectPath, sourceFile)
+	if err != nil {
+		relPath = filepath.Base(sourceFile)
+	}
+	name := fmt.Sprintf("%s%s-%s.log",
+		failureLogPrefix,
+		strings.ReplaceAll(filepath.ToSlash(relPath), "/", "_"),
+		time.Now().Format("20060102-150405"))
+
+	store := cache.New(o.config.ProjectPath)
+	data := truncateOutput(output, o.config.FailureLogKB*1024)
+	if err := store.Put(cache.Logs, name, []byte(data)); err != nil {
+		fmt.Printf("  Warning: Could not save validation output: %v\n", err)
+		return ""
+	}
+	if err := store.Prune(cache.Logs, failureLogPrefix, o.config.FailureLogs); err != nil {
+		fmt.Printf("  Warning: Could not prune validation logs: %v\n", err)
+	}
+	o.state.DropMissingFailureLogs()
+
+	return store.Path(cache.Logs, name)
+}
+
+// truncateOutput limits output to maxBytes (if positive), keeping the start
+// and the end where build and test failures are usually reported
+func truncateOutput(output string, maxBytes int) string {
+	if maxBytes <= 0 || len(output) <= maxBytes {
+		return output
+	}
+
+	half := maxBytes / 2
+	return fmt.Sprintf("%s\n\n... [%d bytes truncated] ...\n\n%s",
+		output[:half], len(output)-2*half, output[len(output)-half:])
+}
+
+// recordTestQuality measures a validated test file and stores the metrics
+func (o *Orchestrator) recordTestQuality(sourceFile, testFile string) {
+	testCode, err := os.ReadFile(testFile)
+	if err != nil {
+		return
+	}
+	sourceCode, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return
+	}
+
+	quality := testgen.MeasureQuality(o.analyzer.GetLanguageName(), string(testCode), string(sourceCode))
+	o.state.RecordTestQuality(testFile, quality)
+}
+
+// recordConfidence scores a validated test file from the model's
+// self-assessment and the validation signals, and flags it for review if
+// the score is low
+func (o *Orchestrator) recordConfidence(testFile string, retries int) {
+	relativeTestFile, err := filepath.Rel(o.config.ProjectPath, testFile)
+	if err != nil {
+		return
+	}
+	assessment, assessed := o.generator.SelfAssessment(relativeTestFile)
+	confidence := testgen.ScoreConfidence(assessment, assessed, retries, o.state.TestQuality[testFile], o.config.ReviewBelow)
+	o.state.RecordConfidence(testFile, confidence)
+
+	if confidence.Review {
+		fmt.Printf("  ⚠️  Low confidence, flagged for review: %s\n", confidence)
+	} else {
+		fmt.Printf("  Confidence: %d/100\n", confidence.Score)
+	}
+}
+
+// recordToolVersions detects the coverage tool versions, warns if they
+// changed since the state was saved, and stores them in the state
+func (o *Orchestrator) recordToolVersions() {
+	versions := o.analyzer.ToolVersions(o.config.ProjectPath)
+
+	tools := make([]string, 0, len(versions))
+	for tool := range versions {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+
+	fmt.Println("Tool versions:")
+	for _, tool := range tools {
+		fmt.Printf("  %s: %s\n", tool, versions[tool])
+		if previous, ok := o.state.ToolVersions[tool]; ok && previous != versions[tool] {
+			fmt.Printf("  Warning: %s changed since the previous run (was: %s)\n", tool, previous)
+		}
+	}
+
+	o.state.ToolVersions = versions
+}
+
+// chunkingEnabled reports whether work should be split across several branches
+func (o *Orchestrator) chunkingEnabled() bool {
+	return o.gitMgr.IsEnabled() && (o.config.ChunkFiles > 0 || o.config.ChunkGain > 0)
+}
+
+// chunkBranchName returns the branch name for the chunk with the given index
+func (o *Orchestrator) chunkBranchName(index int) string {
+	return fmt.Sprintf("%s-part-%d", o.state.SessionBranch, index)
+}
+
+// addToChunk records a committed file in the current chunk and rolls over to
+// a new branch once the chunk is full. Each chunk branch starts from the
+// previous one, so the resulting PRs can be reviewed and merged in order.
+func (o *Orchestrator) addToChunk(testFile string) {
+	chunk := o.state.CurrentChunk()
+	if chunk == nil {
+		return
+	}
+	chunk.Files = append(chunk.Files, testFile)
+
+	full := o.config.ChunkFiles > 0 && len(chunk.Files) >= o.config.ChunkFiles
+	if o.config.ChunkGain > 0 {
+		// Coverage is re-measured at the start of each iteration, so this is
+		// the gain up to the previous iteration
+		full = full || o.state.CurrentCoverage-chunk.StartCoverage >= o.config.ChunkGain
+	}
+	if !full {
+		return
+	}
+
+	branchName := o.chunkBranchName(chunk.Index + 1)
+	if err := o.gitMgr.CreateBranchForSession(branchName); err != nil {
+		fmt.Printf("  Warning: Could not create chunk branch: %v\n", err)
+		return
 	}
-	_, err := os.Stat(absPath)
-	return err == nil
+	fmt.Printf("  Chunk %d complete (%d files). Continuing on branch: %s\n",
+		chunk.Index, len(chunk.Files), branchName)
+	o.state.StartChunk(branchName)
 }
@@ -0,0 +1,111 @@
+package prompts
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Names of the prompt templates a project can replace
+const (
+	TemplateSystem   = "system"   // System prompt shared by every test-writing prompt
+	TemplateGenerate = "generate" // Writing a new test file
+	TemplateImprove  = "improve"  // Improving an existing test file
+	TemplateFix      = "fix"      // Fixing a failing test file
+)
+
+// TemplateData is what prompt templates are rendered with. Each template
+// gets the fields of its prompt; the others are empty.
+type TemplateData struct {
+	Language       string
+	SourceFile     string
+	SourceCode     string // Or where to find it, such as the start of the message or an attached document
+	UncoveredLines string // Formatted uncovered lines; the coverage gaps of improve
+	ExistingTests  string
+	TestFile       string
+	TestCode       string
+	ErrorOutput    string
+}
+
+// Templates renders prompts from text/templates: the built-in ones, some
+// of them replaced by a project's own. A nil *Templates renders the
+// built-in ones.
+type Templates struct {
+	templates map[string]*template.Template
+}
+
+// Default renders the built-in templates
+var Default = mustParse()
+
+// TemplateNames returns the names of the templates that can be replaced
+func TemplateNames() []string {
+	names := make([]string, 0, len(defaultTemplates))
+	for name := range defaultTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadTemplates reads templates replacing built-in ones from files, by
+// template name, e.g. to add a project's test conventions or banned APIs
+// to the system prompt. Every template is rendered once with empty data,
+// so one referring to an unknown field fails here rather than mid-session.
+func LoadTemplates(files map[string]string) (*Templates, error) {
+	overrides := make(map[string]string, len(files))
+	for name, path := range files {
+		if _, ok := defaultTemplates[name]; !ok {
+			return nil, fmt.Errorf("unknown prompt template %q (known: %s)", name, strings.Join(TemplateNames(), ", "))
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s prompt template: %w", name, err)
+		}
+		overrides[name] = string(data)
+	}
+	return parse(overrides)
+}
+
+// parse parses the built-in templates, replacing those in overrides
+func parse(overrides map[string]string) (*Templates, error) {
+	t := &Templates{templates: make(map[string]*template.Template, len(defaultTemplates))}
+	for name, text := range defaultTemplates {
+		if override, ok := overrides[name]; ok {
+			text = override
+		}
+		tmpl, err := template.New(name).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s prompt template: %w", name, err)
+		}
+		if err := tmpl.Execute(io.Discard, TemplateData{}); err != nil {
+			return nil, fmt.Errorf("invalid %s prompt template: %w", name, err)
+		}
+		t.templates[name] = tmpl
+	}
+	return t, nil
+}
+
+// mustParse parses the built-in templates
+func mustParse() *Templates {
+	t, err := parse(nil)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// render renders a template. A project's template that fails to render
+// falls back to the built-in one.
+func (t *Templates) render(name string, data TemplateData) string {
+	if t == nil {
+		t = Default
+	}
+	var b strings.Builder
+	if err := t.templates[name].Execute(&b, data); err != nil && t != Default {
+		return Default.render(name, data)
+	}
+	return b.String()
+}
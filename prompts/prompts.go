@@ -0,0 +1,329 @@
+// Package prompts builds the prompts the agent sends to the model. It has no
+// dependency on the API client or the orchestrator, so other tools can build
+// the same prompts from their own coverage data.
+package prompts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/coverage"
+)
+
+// Request describes a source file and its coverage gaps
+type Request struct {
+	Language          string
+	SourceFile        string // Path shown in the prompt, usually relative to the project root
+	SourceCode        string
+	ExistingTests     string // Current test file contents, used by ForExistingTest
+	UncoveredLines    []int
+	UncoveredBranches []coverage.Branch
+	UntestedMethods   []coverage.Method
+
+	Annotate bool   // Ask for a comment above each test naming the lines it targets
+	Harness  bool   // Ask for an integration harness, for main packages and entrypoint scripts
+	MockTool string // Tool that generated Mocks, "mockgen" or "mockery"
+	Mocks    string // Description of the mocks available to the tests; empty if none
+	Database string // Database the code talks to, tested through testcontainers; empty if none
+
+	// TakenNames are declared by other test files of the package and must not be redeclared
+	TakenNames []string
+
+	// History holds the commits that last changed the uncovered lines, for
+	// the intent behind the code
+	History []Commit
+
+	// Attachments are sent as documents instead of inline, by title. If the
+	// source file is among them, the prompt points to it instead of
+	// including SourceCode; the others are package context.
+	Attachments map[string]string
+}
+
+// Commit is a commit that last changed some of the uncovered lines
+type Commit struct {
+	Hash    string
+	Message string
+	Lines   []int // The uncovered lines it last changed, sorted
+}
+
+// Prompt is a prompt split by role: the system prompt holds the
+// instructions shared by every file, the user message the file itself
+type Prompt struct {
+	System string
+	User   string
+
+	// Prefix starts the user message with the content every prompt about a
+	// file shares, its source code, so the API can cache it across the
+	// requests that write, fix and improve the file's tests
+	Prefix string
+
+	// Attachments are files sent along with the user message as documents, by title
+	Attachments map[string]string
+}
+
+// String renders the prompt for logs and debugging
+func (p Prompt) String() string {
+	prompt := p.Message()
+	if p.System != "" {
+		prompt = "SYSTEM:\n" + p.System + "\n\nUSER:\n" + p.Message()
+	}
+	for _, title := range p.attachmentTitles() {
+		prompt += "\n\nATTACHMENT " + title + ":\n" + p.Attachments[title]
+	}
+	return prompt
+}
+
+// Message returns the whole user message, the prefix included
+func (p Prompt) Message() string {
+	if p.Prefix == "" {
+		return p.User
+	}
+	return p.Prefix + "\n\n" + p.User
+}
+
+// attachmentTitles returns the titles of the attachments, sorted
+func (p Prompt) attachmentTitles() []string {
+	titles := make([]string, 0, len(p.Attachments))
+	for title := range p.Attachments {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// Key returns the parts of the prompt that identify it, for caching
+func (p Prompt) Key() []string {
+	parts := []string{p.System, p.Prefix, p.User}
+	for _, title := range p.attachmentTitles() {
+		parts = append(parts, title, p.Attachments[title])
+	}
+	return parts
+}
+
+// ForNewTest builds the prompt for writing a new test file from the
+// built-in templates
+func ForNewTest(req Request) Prompt {
+	return Default.ForNewTest(req)
+}
+
+// ForExistingTest builds the prompt for improving an existing test file
+// from the built-in templates
+func ForExistingTest(req Request) Prompt {
+	return Default.ForExistingTest(req)
+}
+
+// ForBrokenTest builds the prompt for fixing a failing test file from the
+// built-in templates
+func ForBrokenTest(language, testFile, testCode, errorOutput string, annotate bool) Prompt {
+	return Default.ForBrokenTest(language, testFile, testCode, errorOutput, annotate)
+}
+
+// ForFailingTest is ForBrokenTest with the source file under test
+func ForFailingTest(req Request, testFile, testCode, errorOutput string) Prompt {
+	return Default.ForFailingTest(req, testFile, testCode, errorOutput)
+}
+
+// ForNewTest builds the prompt for writing a new test file
+func (t *Templates) ForNewTest(req Request) Prompt {
+	prompt := t.render(TemplateGenerate, TemplateData{
+		Language:       req.Language,
+		SourceFile:     req.SourceFile,
+		SourceCode:     req.source(),
+		UncoveredLines: FormatLines(req.UncoveredLines),
+	})
+	return Prompt{System: t.system(req.Language, req.Annotate), User: req.extend(prompt), Prefix: req.prefix(), Attachments: req.Attachments}
+}
+
+// ForExistingTest builds the prompt for improving an existing test file
+func (t *Templates) ForExistingTest(req Request) Prompt {
+	prompt := t.render(TemplateImprove, TemplateData{
+		Language:       req.Language,
+		SourceFile:     req.SourceFile,
+		SourceCode:     req.source(),
+		ExistingTests:  req.ExistingTests,
+		UncoveredLines: FormatLines(req.UncoveredLines),
+	})
+	return Prompt{System: t.system(req.Language, req.Annotate), User: req.extend(prompt), Prefix: req.prefix(), Attachments: req.Attachments}
+}
+
+// ForBrokenTest builds the prompt for fixing a failing test file
+func (t *Templates) ForBrokenTest(language, testFile, testCode, errorOutput string, annotate bool) Prompt {
+	return Prompt{
+		System: t.system(language, annotate),
+		User: t.render(TemplateFix, TemplateData{
+			Language:    language,
+			TestFile:    testFile,
+			TestCode:    testCode,
+			ErrorOutput: errorOutput,
+		}),
+	}
+}
+
+// ForFailingTest is ForBrokenTest with the source file under test, which
+// starts the prompt the same way as the prompt that wrote the test, so
+// every retry reuses its cached prefix
+func (t *Templates) ForFailingTest(req Request, testFile, testCode, errorOutput string) Prompt {
+	prompt := t.ForBrokenTest(req.Language, testFile, testCode, errorOutput, req.Annotate)
+	prompt.Prefix = req.prefix()
+	prompt.Attachments = req.Attachments
+	return prompt
+}
+
+// system builds the system prompt for a language. Annotations are
+// requested for the whole session, so they belong here too, and so does
+// the self-assessment, which the generator depends on.
+func (t *Templates) system(language string, annotate bool) string {
+	prompt := WithSelfAssessment(t.render(TemplateSystem, TemplateData{Language: language}))
+	if annotate {
+		prompt = WithReviewerAnnotations(prompt)
+	}
+	return prompt
+}
+
+// source returns a pointer to the source code, which is in the prompt's
+// prefix or in the attached document if the source file is attached
+func (req Request) source() string {
+	if _, ok := req.Attachments[req.SourceFile]; ok {
+		return fmt.Sprintf("(attached as the document titled %q)", req.SourceFile)
+	}
+	return "(shown at the start of this message)"
+}
+
+// prefix returns the prompt prefix presenting the source code, or "" if
+// the source file is attached
+func (req Request) prefix() string {
+	if _, ok := req.Attachments[req.SourceFile]; ok {
+		return ""
+	}
+	return SourcePrefix(req.SourceFile, req.SourceCode)
+}
+
+// contextFiles returns the attached files other than the source file, sorted
+func (req Request) contextFiles() []string {
+	var files []string
+	for title := range req.Attachments {
+		if title != req.SourceFile {
+			files = append(files, title)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// extend applies the file-specific prompt extensions requested
+func (req Request) extend(prompt string) string {
+	if len(req.UncoveredBranches) > 0 {
+		prompt = WithUncoveredBranches(prompt, FormatBranches(req.SourceCode, req.UncoveredBranches))
+	}
+	if len(req.UntestedMethods) > 0 {
+		prompt = WithUntestedMethods(prompt, FormatMethods(req.UntestedMethods))
+	}
+	if req.Harness {
+		prompt = WithIntegrationHarness(prompt, req.Language)
+	}
+	if req.Mocks != "" {
+		prompt = WithMocks(prompt, req.MockTool, req.Mocks)
+	}
+	if req.Database != "" {
+		prompt = WithTestcontainers(prompt, req.Language, req.Database)
+	}
+	if len(req.TakenNames) > 0 {
+		prompt = WithTakenNames(prompt, req.TakenNames)
+	}
+	if len(req.History) > 0 {
+		prompt = WithLineHistory(prompt, FormatHistory(req.History))
+	}
+	if files := req.contextFiles(); len(files) > 0 {
+		prompt = WithContextFiles(prompt, files)
+	}
+	return prompt
+}
+
+// FormatBranches lists branch arms with the condition text from the source
+func FormatBranches(sourceCode string, branches []coverage.Branch) string {
+	sourceLines := strings.Split(sourceCode, "\n")
+
+	var formatted []string
+	for _, branch := range branches {
+		condition := ""
+		if branch.Line > 0 && branch.Line <= len(sourceLines) {
+			condition = strings.TrimSpace(sourceLines[branch.Line-1])
+		}
+		formatted = append(formatted, fmt.Sprintf("- Line %d: `%s` (%s)", branch.Line, condition, branch.Detail))
+	}
+
+	return strings.Join(formatted, "\n")
+}
+
+// FormatMethods lists methods with their class and first line. Constructors
+// are shown by class name.
+func FormatMethods(methods []coverage.Method) string {
+	var formatted []string
+	for _, method := range methods {
+		name := method.Class + "." + method.Name
+		if method.Name == "<init>" {
+			name = method.Class + " constructor"
+		}
+		if method.Line > 0 {
+			name += fmt.Sprintf(" (line %d)", method.Line)
+		}
+		formatted = append(formatted, "- "+name)
+	}
+	return strings.Join(formatted, "\n")
+}
+
+// FormatHistory lists commits with the lines they last changed and their
+// messages, the body indented below the subject
+func FormatHistory(commits []Commit) string {
+	var formatted []string
+	for _, commit := range commits {
+		hash := commit.Hash
+		if len(hash) > 7 {
+			hash = hash[:7]
+		}
+		subject, body, _ := strings.Cut(commit.Message, "\n")
+		entry := fmt.Sprintf("- %s (%s): %s", hash, FormatLines(commit.Lines), subject)
+		if body = strings.TrimSpace(body); body != "" {
+			entry += "\n  " + strings.ReplaceAll(body, "\n", "\n  ")
+		}
+		formatted = append(formatted, entry)
+	}
+	return strings.Join(formatted, "\n")
+}
+
+// FormatLines formats sorted line numbers for a prompt, grouping consecutive
+// lines into ranges
+func FormatLines(lines []int) string {
+	if len(lines) == 0 {
+		return "None"
+	}
+
+	// Group consecutive lines into ranges
+	var ranges []string
+	start := lines[0]
+	end := lines[0]
+
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == end+1 {
+			end = lines[i]
+		} else {
+			if start == end {
+				ranges = append(ranges, fmt.Sprintf("Line %d", start))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("Lines %d-%d", start, end))
+			}
+			start = lines[i]
+			end = lines[i]
+		}
+	}
+
+	// Add last range
+	if start == end {
+		ranges = append(ranges, fmt.Sprintf("Line %d", start))
+	} else {
+		ranges = append(ranges, fmt.Sprintf("Lines %d-%d", start, end))
+	}
+
+	return strings.Join(ranges, ", ")
+}
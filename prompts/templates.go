@@ -0,0 +1,370 @@
+package prompts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultTemplates are the built-in prompt templates, by name. Projects
+// can replace them with their own; see LoadTemplates.
+var defaultTemplates = map[string]string{
+	// The system prompt shared by every test-writing prompt: the role, the
+	// quality bar and the output format. It is the same for every file, so
+	// the API can cache it.
+	TemplateSystem: `You are an expert {{.Language}} test engineer. You write, improve and fix unit tests to raise the code coverage of a project.
+
+Every test file you write:
+1. Follows {{.Language}} best practices and idioms
+2. Uses appropriate testing frameworks for {{.Language}}
+3. Includes edge cases and error conditions
+4. Is properly structured and well-documented
+
+OUTPUT FORMAT:
+Provide ONLY the complete test file code, without any explanations or markdown formatting.
+The test file should be ready to save and run immediately.`,
+
+	TemplateGenerate: `I need you to write comprehensive unit tests for the following source code.
+
+Language: {{.Language}}
+Source File: {{.SourceFile}}
+
+SOURCE CODE:
+{{.SourceCode}}
+
+UNCOVERED LINES (need tests):
+{{.UncoveredLines}}
+
+Please generate a complete, runnable test file that covers all the uncovered lines mentioned above.`,
+
+	TemplateImprove: `I have existing tests that need to be improved to cover more code.
+
+Language: {{.Language}}
+Source File: {{.SourceFile}}
+
+SOURCE CODE:
+{{.SourceCode}}
+
+EXISTING TESTS:
+{{.ExistingTests}}
+
+COVERAGE GAPS (uncovered code):
+{{.UncoveredLines}}
+
+Please enhance the existing tests to:
+1. Cover all the gaps mentioned above
+2. Maintain all existing test functionality
+3. Add new test cases for uncovered scenarios
+
+Reply with the complete enhanced test file.`,
+
+	TemplateFix: `The following test file is failing and needs to be fixed.
+
+Language: {{.Language}}
+Test File: {{.TestFile}}
+
+CURRENT TEST CODE:
+{{.TestCode}}
+
+TEST FAILURE OUTPUT:
+{{.ErrorOutput}}
+
+Please fix the test code so that:
+1. All tests pass successfully
+2. The tests still provide meaningful coverage
+3. The fixes address the root cause, not just symptoms`,
+}
+
+// TestEngineer creates the system prompt shared by every test-writing
+// prompt from the built-in template
+func TestEngineer(language string) string {
+	return Default.render(TemplateSystem, TemplateData{Language: language})
+}
+
+// GenerateTest creates a prompt for generating tests for uncovered code
+// from the built-in template
+func GenerateTest(language, sourceFile, sourceCode, uncoveredLines string) string {
+	return Default.render(TemplateGenerate, TemplateData{
+		Language:       language,
+		SourceFile:     sourceFile,
+		SourceCode:     sourceCode,
+		UncoveredLines: uncoveredLines,
+	})
+}
+
+// FixBrokenTest creates a prompt for fixing broken tests from the built-in
+// template
+func FixBrokenTest(language, testFile, testCode, errorOutput string) string {
+	return Default.render(TemplateFix, TemplateData{
+		Language:    language,
+		TestFile:    testFile,
+		TestCode:    testCode,
+		ErrorOutput: errorOutput,
+	})
+}
+
+// ImproveTestCoverage creates a prompt for improving existing tests from
+// the built-in template
+func ImproveTestCoverage(language, sourceFile, sourceCode, existingTests, coverageGaps string) string {
+	return Default.render(TemplateImprove, TemplateData{
+		Language:       language,
+		SourceFile:     sourceFile,
+		SourceCode:     sourceCode,
+		ExistingTests:  existingTests,
+		UncoveredLines: coverageGaps,
+	})
+}
+
+// SourcePrefix presents a source file at the start of a user message,
+// ahead of the request itself
+func SourcePrefix(sourceFile, sourceCode string) string {
+	return fmt.Sprintf(`SOURCE FILE UNDER TEST: %s
+
+%s`, sourceFile, sourceCode)
+}
+
+// AnalyzeUncoveredCode creates a prompt for understanding what tests are needed
+func AnalyzeUncoveredCode(language, sourceFile, sourceCode, coverageReport string) string {
+	return fmt.Sprintf(`You are an expert %s code coverage analyst. Please analyze the following source code and coverage report.
+
+Language: %s
+Source File: %s
+
+SOURCE CODE:
+%s
+
+COVERAGE REPORT:
+%s
+
+Please provide a concise analysis in this format:
+1. List the specific functions/methods that need test coverage
+2. For each function, briefly describe what test scenarios are needed
+3. Identify any edge cases or error conditions that should be tested
+
+Keep your response focused and actionable. Format as a numbered list.`,
+		language, language, sourceFile, sourceCode, coverageReport)
+}
+
+// SuggestRefactorings creates a prompt for refactorings that would make a
+// source file testable, after writing tests for it failed
+func SuggestRefactorings(language, sourceFile, sourceCode, failure string) string {
+	return fmt.Sprintf(`Writing unit tests for the following %s source file failed repeatedly.
+
+Source File: %s
+
+SOURCE CODE:
+%s
+
+LAST FAILURE:
+%s
+
+Identify what makes this code hard to unit test, such as constructors that create their own
+dependencies, global or package-level state, network, file system or clock access inside pure
+logic, or functions that do too many things.
+
+Reply with 1 to 5 concrete refactoring suggestions, one per line, each starting with "- ".
+Each suggestion names the change and the code it applies to, for example
+"- Inject a clock: pass a now func() time.Time to NewScheduler instead of calling time.Now in Next"
+or "- Extract interface Store from the *sql.DB methods Sync uses, so tests can pass a fake".
+Don't write code, and don't suggest writing tests.`,
+		language, sourceFile, sourceCode, failure)
+}
+
+// TranslateSummary creates a prompt for translating the end-of-run summary
+// for readers of another language
+func TranslateSummary(summary, language string) string {
+	return fmt.Sprintf(`Translate the following test coverage run summary into %s.
+
+SUMMARY:
+%s
+
+Keep the line structure, numbers, percentages, file paths and test names exactly as they are. Translate only the human-readable words.
+Provide ONLY the translated summary, without any explanations or markdown formatting.`,
+		language, summary)
+}
+
+// WithSelfAssessment extends the system prompt with a request for the
+// model's confidence in each test file it writes, which decides with the
+// validation results which tests are flagged for human review
+func WithSelfAssessment(prompt string) string {
+	return prompt + `
+
+SELF-ASSESSMENT:
+After the test file, on a line of its own, rate how confident you are that the tests are correct
+and check meaningful behavior rather than just executing code:
+CONFIDENCE: <0-100> - <one sentence naming what you had to guess, if anything>
+This line is removed before the test file is saved.`
+}
+
+// WithReviewerAnnotations extends a test-writing prompt with instructions to
+// annotate each test function with the source lines or branches it targets
+func WithReviewerAnnotations(prompt string) string {
+	return prompt + `
+
+REVIEWER ANNOTATIONS:
+Directly above each test function, add a short comment (one or two lines) stating which
+uncovered lines or branches of the source file the test targets, for example
+"Covers lines 42-48: error path when the config file is missing".
+Keep existing annotation comments intact and update them if the test changes.`
+}
+
+// WithUncoveredBranches extends a test-writing prompt with the branch arms
+// that were never taken, so tests target the missing paths specifically
+func WithUncoveredBranches(prompt, uncoveredBranches string) string {
+	return prompt + fmt.Sprintf(`
+
+UNCOVERED BRANCHES (condition and the arm that was never taken):
+%s
+
+For each branch above, write a test whose inputs make execution take the missing arm.
+Do not simply re-test the path that is already covered.`, uncoveredBranches)
+}
+
+// WithUntestedMethods extends a test-writing prompt with the methods no test
+// executes at all, so each of them gets at least one test
+func WithUntestedMethods(prompt, untestedMethods string) string {
+	return prompt + fmt.Sprintf(`
+
+UNTESTED METHODS (never called by any test):
+%s
+
+Write at least one test that calls each of these methods.`, untestedMethods)
+}
+
+// harnessInstructions describes how to test a program entrypoint per language
+// so that the coverage tool still sees the executed lines
+var harnessInstructions = map[string]string{
+	"Go": `This file is a main package. Write an integration harness in package main instead of unit tests:
+1. Drive main() in-process: set os.Args to the flags under test, redirect os.Stdout/os.Stderr
+   through os.Pipe, call main(), and assert on the captured output
+2. For paths that call os.Exit or log.Fatal, re-exec the test binary: when an environment
+   variable such as GO_WANT_HELPER_PROCESS is set, a helper test calls main() with the given
+   arguments; the parent test starts os.Args[0] with -test.run=<helper> via os/exec and asserts
+   on the exit code and output
+3. Use t.TempDir() for any files the program reads or writes
+4. Do not refactor or modify the source file`,
+	"Python": `This file is a program entrypoint. Write an integration harness instead of unit tests:
+1. Run the script in-process with runpy.run_path (or runpy.run_module) using run_name="__main__",
+   so that coverage records the executed lines
+2. Set command line arguments with monkeypatch.setattr(sys, "argv", [...])
+3. Capture output with capsys and expect exits with pytest.raises(SystemExit), asserting on the exit code
+4. Use tmp_path for any files the program reads or writes
+5. Do not refactor or modify the source file`,
+}
+
+// WithIntegrationHarness extends a test-writing prompt with instructions to
+// exercise a main package or entrypoint script end to end
+func WithIntegrationHarness(prompt, language string) string {
+	instructions, ok := harnessInstructions[language]
+	if !ok {
+		return prompt
+	}
+	return prompt + fmt.Sprintf(`
+
+INTEGRATION HARNESS:
+%s`, instructions)
+}
+
+// testcontainersInstructions describe how to test database code against a
+// real database started with testcontainers, by language
+var testcontainersInstructions = map[string]string{
+	"Go": `1. Use testcontainers-go (github.com/testcontainers/testcontainers-go) and its module for the
+   database under github.com/testcontainers/testcontainers-go/modules/
+2. Start the container once per package in TestMain, or once per test with t.Cleanup terminating it;
+   wait for the database to accept connections before running tests
+3. Create the schema the code expects before the tests run, and isolate tests from each other
+   (separate tables, transactions or cleanup between tests)
+4. Call testing.Short() and skip the tests in short mode`,
+	"Java": `1. Use Testcontainers for JUnit 5: annotate the class with @Testcontainers and declare a static
+   @Container field for the database (org.testcontainers modules such as PostgreSQLContainer)
+2. Build the data source or client from the container's JDBC URL, host and mapped port
+3. Create the schema the code expects before the tests run, and isolate tests from each other
+4. Don't mock the database driver or the repository under test`,
+	"Kotlin": `1. Use Testcontainers for JUnit 5: annotate the class with @Testcontainers and declare the
+   database container (org.testcontainers modules such as PostgreSQLContainer) in a companion
+   object with @JvmStatic @Container
+2. Build the data source or client from the container's JDBC URL, host and mapped port
+3. Create the schema the code expects before the tests run, and isolate tests from each other
+4. Don't mock the database driver or the repository under test`,
+	"Python": `1. Use testcontainers-python (e.g. from testcontainers.postgres import PostgresContainer)
+2. Start the container in a module-scoped pytest fixture that yields a connection URL and stops
+   the container afterwards
+3. Create the schema the code expects in the fixture, and isolate tests from each other
+   (transactions rolled back or tables truncated between tests)
+4. Don't mock the database driver or the repository under test`,
+	"TypeScript": `1. Use the testcontainers npm package and its module for the database (e.g.
+   PostgreSqlContainer from @testcontainers/postgresql)
+2. Start the container in beforeAll and stop it in afterAll; pass a timeout of 120000 ms to both,
+   since pulling and starting a container takes far longer than the default test timeout
+3. Create the schema the code expects before the tests run, and isolate tests from each other
+4. Don't mock the database driver or the repository under test`,
+}
+
+// WithTestcontainers extends a test-writing prompt with instructions to test
+// database code against a real database started by testcontainers, since
+// unit tests with a mocked driver don't exercise the queries
+func WithTestcontainers(prompt, language, database string) string {
+	instructions, ok := testcontainersInstructions[language]
+	if !ok {
+		return prompt
+	}
+	return prompt + fmt.Sprintf(`
+
+DATABASE TESTS (%s):
+This code talks to %s. Test it against a real %s started with testcontainers; Docker is available.
+%s`, database, database, database, instructions)
+}
+
+// mockUsage describes how tests use mocks from each mock generator
+var mockUsage = map[string]string{
+	"mockgen": "Create a controller with gomock.NewController(t), build mocks with their NewMock... constructors, and set expectations with mock.EXPECT().",
+	"mockery": "Build mocks with their New... constructors passing t, and set expectations with mock.On(...).Return(...) or mock.EXPECT().",
+}
+
+// WithMocks extends a test-writing prompt with the generated mocks for the
+// source file's interface dependencies, so tests don't hand-roll fakes
+func WithMocks(prompt, tool, mocks string) string {
+	return prompt + fmt.Sprintf(`
+
+GENERATED MOCKS (%s, in the same package, already on disk):
+%s
+
+Use these mocks for every interface dependency. %s
+Do not define your own fakes or stubs for these interfaces, and do not redeclare the mock types.`, tool, mocks, mockUsage[tool])
+}
+
+// WithTakenNames extends a test-writing prompt with the names other test
+// files of the package already declare, since redeclaring them doesn't compile
+func WithTakenNames(prompt string, names []string) string {
+	return prompt + fmt.Sprintf(`
+
+NAMES ALREADY TAKEN:
+Other test files in the same package already declare these names:
+%s
+
+Do not declare tests, helpers or types with any of these names; choose distinct names instead.`, strings.Join(names, ", "))
+}
+
+// WithLineHistory extends a test-writing prompt with the commits that last
+// changed the uncovered lines, whose messages tell what the code is for
+func WithLineHistory(prompt, history string) string {
+	return prompt + fmt.Sprintf(`
+
+RECENT CHANGES TO THE UNCOVERED CODE:
+These commits last changed the uncovered lines:
+%s
+
+Their messages tell why the code exists. Write tests for the behavior they describe, e.g. that a fixed bug stays fixed, rather than tests that restate the implementation.`, history)
+}
+
+// WithContextFiles extends a test-writing prompt with the other files of
+// the source file's package, attached as documents, so tests can use its
+// types and helpers without guessing their signatures
+func WithContextFiles(prompt string, files []string) string {
+	return prompt + fmt.Sprintf(`
+
+PACKAGE CONTEXT:
+These other files of the same package are attached as documents:
+%s
+
+Use them to understand the types, constructors and helpers the source code depends on. Write tests only for the source file above.`, "- "+strings.Join(files, "\n- "))
+}
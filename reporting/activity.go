@@ -0,0 +1,90 @@
+package reporting
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/config"
+)
+
+// activityHeader starts a new activity log
+const activityHeader = "# Coverage Agent Log\n\nTests written and improved by test-coverage-agent, one entry per session.\n"
+
+// Activity is a session's entry in the activity log
+type Activity struct {
+	Date       time.Time
+	Branch     string // Session branch, if any
+	Before     float64
+	After      float64
+	Target     float64
+	Iterations int
+	Created    []config.TestChange // New test files
+	Improved   []config.TestChange // Existing test files the session changed
+	Failed     int                 // Files given up on
+	Review     []string            // Tests flagged for review, with their confidence
+}
+
+// AppendActivity appends a session's entry to a Markdown activity log,
+// creating the log if it doesn't exist. An existing file, such as the
+// project's changelog, is appended to as it is.
+func AppendActivity(path string, activity Activity) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read activity log: %w", err)
+	}
+
+	var b strings.Builder
+	if len(existing) == 0 {
+		b.WriteString(activityHeader)
+	} else if !strings.HasSuffix(string(existing), "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString(activity.markdown())
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open activity log: %w", err)
+	}
+	if _, err := file.WriteString(b.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write activity log: %w", err)
+	}
+	return file.Close()
+}
+
+// markdown renders the entry
+func (a Activity) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n## %s", a.Date.Format("2006-01-02"))
+	if a.Branch != "" {
+		fmt.Fprintf(&b, " (`%s`)", a.Branch)
+	}
+	fmt.Fprintf(&b, "\n\nCoverage %.2f%% → %.2f%% (%+.2f points, target %.2f%%) in %d iterations.\n",
+		a.Before, a.After, a.After-a.Before, a.Target, a.Iterations)
+
+	list := func(title string, changes []config.TestChange) {
+		if len(changes) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s:\n\n", title)
+		for _, change := range changes {
+			fmt.Fprintf(&b, "- `%s` for `%s`\n", change.TestFile, change.SourceFile)
+		}
+	}
+	list("New tests", a.Created)
+	list("Improved tests", a.Improved)
+
+	if len(a.Review) > 0 {
+		b.WriteString("\nLow-confidence tests to review first:\n\n")
+		for _, note := range a.Review {
+			fmt.Fprintf(&b, "- %s\n", note)
+		}
+	}
+
+	if a.Failed > 0 {
+		fmt.Fprintf(&b, "\n%d file(s) could not be tested.\n", a.Failed)
+	}
+	return b.String()
+}
@@ -0,0 +1,466 @@
+// Package reporting renders human-readable reports of an agent session for
+// reviewers who don't want to read the JSON state.
+package reporting
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/config"
+	"github.com/tablev/test-coverage-agent/coverage"
+)
+
+// Session is the data shown in a session report
+type Session struct {
+	State  *config.State
+	Before *coverage.CoverageReport // Coverage at the start of the run
+	After  *coverage.CoverageReport // Latest coverage of the run
+
+	// TestFor maps source files to the test files the agent wrote or fixed
+	// for them, keyed like the coverage reports' FileCoverage
+	TestFor map[string]string
+}
+
+// fileRow is a row of the coverage by file table
+type fileRow struct {
+	File   string
+	Before float64
+	After  float64
+	Delta  float64
+	Test   string // Link to the test file, relative to the report
+}
+
+// packageRow is a row of the coverage by package table, aggregating the
+// files of a directory
+type packageRow struct {
+	Package   string
+	Files     int
+	Before    float64
+	After     float64
+	Delta     float64
+	Uncovered int  // Uncovered lines after the run
+	Fixed     int  // Uncovered lines the run covered
+	Risky     bool // Still below the target coverage
+}
+
+// directoryRow is a row of the directory tree, the latest coverage of a
+// directory and everything below it
+type directoryRow struct {
+	Path      string
+	Depth     int
+	Files     int
+	Coverage  float64
+	Uncovered int
+	Risky     bool // Below the target coverage
+}
+
+// testRow is a row of the test files table
+type testRow struct {
+	File    string
+	Link    string
+	Action  string
+	Model   string // Model that wrote the test, if recorded
+	Quality *config.TestQuality
+
+	Confidence *config.Confidence
+}
+
+// chart is the coverage history drawn as an SVG polyline
+type chart struct {
+	Width, Height int
+	Points        string
+	Target        float64 // y coordinate of the target line
+	Labels        []chartLabel
+}
+
+// chartLabel is a labeled point of the history chart
+type chartLabel struct {
+	X, Y     float64
+	Coverage float64
+}
+
+// WriteHTML writes the session report to path, creating its directory
+func WriteHTML(path string, session Session) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create HTML report: %w", err)
+	}
+	defer f.Close()
+
+	if err := htmlTemplate.Execute(f, session.view(filepath.Dir(path))); err != nil {
+		return fmt.Errorf("failed to render HTML report: %w", err)
+	}
+	return nil
+}
+
+// view prepares the template data; links are made relative to reportDir
+func (s Session) view(reportDir string) map[string]any {
+	state := s.State
+	before, after := state.StartingCoverage(), state.CurrentCoverage
+	if s.Before != nil {
+		before = s.Before.TotalCoverage
+	}
+	if s.After != nil {
+		after = s.After.TotalCoverage
+	}
+
+	return map[string]any{
+		"State":     state,
+		"Before":    before,
+		"After":     after,
+		"Delta":     after - before,
+		"Generated": time.Now().Format(time.RFC1123),
+		"Files":     s.fileRows(reportDir),
+		"Packages":  s.packageRows(state.TargetCoverage),
+		"Tree":      s.directoryRows(state.TargetCoverage),
+		"Tests":     s.testRows(reportDir),
+		"Review":    s.reviewRows(reportDir),
+		"Failed":    state.FailedFiles,
+		"Refactor":  state.Refactorings,
+		"Chart":     historyChart(state.CoverageHistory, state.TargetCoverage),
+	}
+}
+
+// fileRows lists every file of either report, files whose coverage changed
+// the most first
+func (s Session) fileRows(reportDir string) []fileRow {
+	files := make(map[string]bool)
+	for _, report := range []*coverage.CoverageReport{s.Before, s.After} {
+		if report == nil {
+			continue
+		}
+		for file := range report.FileCoverage {
+			files[file] = true
+		}
+	}
+
+	rows := make([]fileRow, 0, len(files))
+	for file := range files {
+		row := fileRow{File: file}
+		if s.Before != nil {
+			row.Before = s.Before.FileCoverage[file]
+		}
+		row.After = row.Before
+		if s.After != nil {
+			row.After = s.After.FileCoverage[file]
+		}
+		row.Delta = row.After - row.Before
+		if test, ok := s.TestFor[file]; ok {
+			row.Test = link(reportDir, test)
+		}
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Delta != rows[j].Delta {
+			return rows[i].Delta > rows[j].Delta
+		}
+		return rows[i].File < rows[j].File
+	})
+	return rows
+}
+
+// packageRows aggregates coverage by directory, the packages with the most
+// uncovered lines first
+func (s Session) packageRows(target float64) []packageRow {
+	packages := make(map[string][]string)
+	for _, report := range []*coverage.CoverageReport{s.Before, s.After} {
+		if report == nil {
+			continue
+		}
+		for file := range report.FileCoverage {
+			dir := filepath.ToSlash(filepath.Dir(file))
+			if !contains(packages[dir], file) {
+				packages[dir] = append(packages[dir], file)
+			}
+		}
+	}
+
+	rows := make([]packageRow, 0, len(packages))
+	for dir, files := range packages {
+		row := packageRow{Package: dir, Files: len(files)}
+		before, uncoveredBefore := packageCoverage(s.Before, files)
+		row.Before = before
+		row.After, row.Uncovered = before, uncoveredBefore
+		if s.After != nil {
+			row.After, row.Uncovered = packageCoverage(s.After, files)
+		}
+		row.Delta = row.After - row.Before
+		if uncoveredBefore > row.Uncovered {
+			row.Fixed = uncoveredBefore - row.Uncovered
+		}
+		row.Risky = row.After < target
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Uncovered != rows[j].Uncovered {
+			return rows[i].Uncovered > rows[j].Uncovered
+		}
+		return rows[i].Package < rows[j].Package
+	})
+	return rows
+}
+
+// directoryRows lays out the latest report's directory tree, parents
+// before their children
+func (s Session) directoryRows(target float64) []directoryRow {
+	report := s.After
+	if report == nil {
+		report = s.Before
+	}
+	if report == nil || len(report.FileCoverage) == 0 {
+		return nil
+	}
+
+	var rows []directoryRow
+	report.ByDirectory().Walk(func(dir *coverage.DirectoryCoverage, depth int) {
+		rows = append(rows, directoryRow{
+			Path:      dir.Path,
+			Depth:     depth,
+			Files:     dir.Files,
+			Coverage:  dir.Coverage,
+			Uncovered: dir.Uncovered,
+			Risky:     dir.Coverage < target,
+		})
+	})
+	return rows
+}
+
+// packageCoverage returns the coverage of a set of files and their number
+// of uncovered lines. Coverage is weighted by line counts where the report
+// has them for every file, and the mean of the files' coverage otherwise.
+func packageCoverage(report *coverage.CoverageReport, files []string) (float64, int) {
+	if report == nil {
+		return 0, 0
+	}
+
+	var sum float64
+	var total, uncovered int
+	weighted := true
+	for _, file := range files {
+		sum += report.FileCoverage[file]
+		uncovered += len(report.UncoveredLines[file])
+		lines, ok := report.TotalLines[file]
+		total += lines
+		weighted = weighted && ok
+	}
+
+	if weighted && total > 0 {
+		return float64(total-uncovered) / float64(total) * 100, uncovered
+	}
+	return sum / float64(len(files)), uncovered
+}
+
+// contains reports whether a list holds a string
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// testRows lists the test files the agent wrote or fixed
+func (s Session) testRows(reportDir string) []testRow {
+	var rows []testRow
+	add := func(files []string, action string) {
+		for _, file := range files {
+			row := testRow{File: file, Link: link(reportDir, file), Action: action, Model: s.State.TestModels[file]}
+			if quality, ok := s.State.TestQuality[file]; ok {
+				row.Quality = &quality
+			}
+			if confidence, ok := s.State.Confidence[file]; ok {
+				row.Confidence = &confidence
+			}
+			if reason, ok := s.State.Quarantined[file]; ok {
+				row.Action += " (quarantined: " + reason + ")"
+			}
+			rows = append(rows, row)
+		}
+	}
+	add(s.State.GeneratedTests, "generated")
+	add(s.State.FixedTests, "fixed or improved")
+	return rows
+}
+
+// reviewRows lists the test files flagged for review, least confident first
+func (s Session) reviewRows(reportDir string) []testRow {
+	var rows []testRow
+	for _, file := range s.State.NeedsReview() {
+		confidence := s.State.Confidence[file]
+		rows = append(rows, testRow{File: file, Link: link(reportDir, file), Confidence: &confidence})
+	}
+	return rows
+}
+
+// historyChart scales the coverage history to an SVG of fixed size. It
+// returns nil with fewer than two snapshots.
+func historyChart(history []config.CoverageSnapshot, target float64) *chart {
+	if len(history) < 2 {
+		return nil
+	}
+
+	const width, height, pad = 640, 240, 24
+	// Coordinates are rounded to a tenth of a pixel to keep the markup short
+	x := func(i int) float64 {
+		return math.Round((pad+float64(i)*float64(width-2*pad)/float64(len(history)-1))*10) / 10
+	}
+	y := func(coverage float64) float64 {
+		return math.Round((float64(height-pad)-coverage/100*float64(height-2*pad))*10) / 10
+	}
+
+	c := &chart{Width: width, Height: height, Target: y(target)}
+	points := make([]string, 0, len(history))
+	for i, snapshot := range history {
+		points = append(points, fmt.Sprintf("%g,%g", x(i), y(snapshot.Coverage)))
+		c.Labels = append(c.Labels, chartLabel{X: x(i), Y: y(snapshot.Coverage), Coverage: snapshot.Coverage})
+	}
+	c.Points = strings.Join(points, " ")
+	return c
+}
+
+// link returns a file's path relative to the report directory, or the
+// absolute path if there is no relative one
+func link(reportDir, file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	rel, err := filepath.Rel(reportDir, abs)
+	if err != nil {
+		return filepath.ToSlash(abs)
+	}
+	return filepath.ToSlash(rel)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct": func(v float64) string { return fmt.Sprintf("%.2f%%", v) },
+	"delta": func(v float64) string {
+		if v == 0 {
+			return "–"
+		}
+		return fmt.Sprintf("%+.2f", v)
+	},
+	"trend": func(v float64) string {
+		switch {
+		case v > 0:
+			return "▲"
+		case v < 0:
+			return "▼"
+		}
+		return "▶"
+	},
+	"time":   func(t time.Time) string { return t.Format(time.RFC1123) },
+	"indent": func(depth int) float64 { return 0.75 + 1.5*float64(depth) },
+	"join":   strings.Join,
+	"firstLine": func(s string) string {
+		line, _, _ := strings.Cut(s, "\n")
+		return line
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Test Coverage Agent Report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 12px; text-align: left; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.up { color: #1a7f37; }
+.down { color: #cf222e; }
+tr.risky td:first-child { border-left: 3px solid #cf222e; }
+.summary td { border: none; }
+</style>
+</head>
+<body>
+<h1>Test Coverage Agent Report</h1>
+<table class="summary">
+<tr><td>Project</td><td>{{.State.ProjectPath}}</td></tr>
+<tr><td>Language</td><td>{{.State.Language}}</td></tr>
+<tr><td>Started</td><td>{{time .State.StartedAt}}</td></tr>
+<tr><td>Report generated</td><td>{{.Generated}}</td></tr>
+<tr><td>Iterations</td><td>{{.State.CurrentIteration}}</td></tr>
+<tr><td>Coverage</td><td>{{pct .Before}} → {{pct .After}} ({{delta .Delta}} points, target {{pct .State.TargetCoverage}})</td></tr>
+</table>
+
+{{with .Chart}}
+<h2>Coverage History</h2>
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+<line x1="0" y1="{{.Target}}" x2="{{.Width}}" y2="{{.Target}}" stroke="#d29922" stroke-dasharray="4 4"/>
+<polyline points="{{.Points}}" fill="none" stroke="#0969da" stroke-width="2"/>
+{{range .Labels}}<circle cx="{{.X}}" cy="{{.Y}}" r="3" fill="#0969da"><title>{{pct .Coverage}}</title></circle>
+{{end}}</svg>
+{{end}}
+
+{{if .Review}}
+<h2>Needs Review ({{len .Review}})</h2>
+<p>Tests with a low confidence score, from the model's self-assessment, the fix attempts they needed and their assertions; review these first.</p>
+<table>
+<tr><th>Test file</th><th>Confidence</th><th>Concerns</th><th>Model's assessment</th></tr>
+{{range .Review}}<tr class="risky"><td><a href="{{.Link}}">{{.File}}</a></td><td class="num">{{.Confidence.Score}}</td><td>{{join .Confidence.Concerns "; "}}</td><td>{{.Confidence.Reason}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Tests}}
+<h2>Test Files ({{len .Tests}})</h2>
+<table>
+<tr><th>Test file</th><th>Action</th><th>Model</th><th>Tests</th><th>Assertions</th><th>Functions exercised</th><th>Confidence</th></tr>
+{{range .Tests}}<tr><td><a href="{{.Link}}">{{.File}}</a></td><td>{{.Action}}</td><td>{{.Model}}</td>{{with .Quality}}<td class="num">{{.Tests}}</td><td class="num">{{.Assertions}}</td><td class="num">{{.FunctionsExercised}}/{{.FunctionsTotal}}</td>{{else}}<td></td><td></td><td></td>{{end}}{{with .Confidence}}<td class="num{{if .Review}} down{{end}}">{{.Score}}</td>{{else}}<td></td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+
+{{if .Failed}}
+<h2>Failed Files ({{len .Failed}})</h2>
+<ul>
+{{range $file, $msg := .Failed}}<li>{{$file}}: {{firstLine $msg}}</li>
+{{end}}</ul>
+{{end}}
+
+{{if .Refactor}}
+<h2>Refactoring Suggestions ({{len .Refactor}})</h2>
+<p>Changes that would make the files the agent failed to test testable.</p>
+{{range $file, $suggestions := .Refactor}}<h3>{{$file}}</h3>
+<ul>
+{{range $suggestions}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{end}}
+
+{{if .Packages}}
+<h2>Coverage by Package</h2>
+<p>Packages with the most uncovered lines first; marked packages are still below the target.</p>
+<table>
+<tr><th>Package</th><th>Files</th><th>Before</th><th>After</th><th>Trend</th><th>Uncovered lines</th><th>Newly covered</th></tr>
+{{range .Packages}}<tr{{if .Risky}} class="risky"{{end}}><td>{{.Package}}</td><td class="num">{{.Files}}</td><td class="num">{{pct .Before}}</td><td class="num">{{pct .After}}</td><td class="num{{if gt .Delta 0.0}} up{{else if lt .Delta 0.0}} down{{end}}">{{trend .Delta}} {{delta .Delta}}</td><td class="num">{{.Uncovered}}</td><td class="num">{{.Fixed}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Tree}}
+<h2>Coverage by Directory</h2>
+<p>Each directory includes everything below it; marked directories are below the target.</p>
+<table>
+<tr><th>Directory</th><th>Files</th><th>Coverage</th><th>Uncovered lines</th></tr>
+{{range .Tree}}<tr{{if .Risky}} class="risky"{{end}}><td style="padding-left: {{indent .Depth}}em">{{.Path}}</td><td class="num">{{.Files}}</td><td class="num">{{pct .Coverage}}</td><td class="num">{{.Uncovered}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Coverage by File</h2>
+<table>
+<tr><th>File</th><th>Before</th><th>After</th><th>Change</th><th>Test</th></tr>
+{{range .Files}}<tr><td>{{.File}}</td><td class="num">{{pct .Before}}</td><td class="num">{{pct .After}}</td><td class="num{{if gt .Delta 0.0}} up{{end}}">{{delta .Delta}}</td><td>{{if .Test}}<a href="{{.Test}}">test</a>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,124 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema, limited to the keywords Generate produces
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Type                 any                `json:"type,omitempty"` // A type name, or a list of them
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"` // false, or the schema of map values
+	Items                *Schema            `json:"items,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// Generate derives the schema of a Go value's JSON encoding from its type.
+// Fields without omitempty are required, and objects admit no fields
+// besides their struct's. Nil slices and maps encode as null, so null is
+// allowed for them.
+func Generate(v any) *Schema {
+	g := &generator{defs: make(map[string]*Schema)}
+	root := g.schemaOf(reflect.TypeOf(v), true)
+	root.Schema = Draft
+	if len(g.defs) > 0 {
+		root.Defs = g.defs
+	}
+	return root
+}
+
+// generator collects the definitions of the struct types below the root
+type generator struct {
+	defs map[string]*Schema
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf returns the schema of a type. Struct types other than the root
+// are defined once in $defs and referenced.
+func (g *generator) schemaOf(t reflect.Type, root bool) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawJSONType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		elem := g.schemaOf(t.Elem(), false)
+		if name, ok := elem.Type.(string); ok {
+			elem.Type = []string{name, "null"}
+			return elem
+		}
+		return &Schema{AnyOf: []*Schema{elem, {Type: "null"}}}
+	case reflect.Struct:
+		if root {
+			return g.object(t)
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = &Schema{} // Placeholder for recursive types
+			g.defs[t.Name()] = g.object(t)
+		}
+		return &Schema{Ref: "#/$defs/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: []string{"array", "null"}, Items: g.schemaOf(t.Elem(), false)}
+	case reflect.Map:
+		return &Schema{Type: []string{"object", "null"}, AdditionalProperties: g.schemaOf(t.Elem(), false)}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	}
+	return &Schema{}
+}
+
+// object returns the schema of a struct's JSON object
+func (g *generator) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema), AdditionalProperties: false}
+	g.addFields(s, t)
+	return s
+}
+
+// addFields adds the JSON fields of a struct to an object schema,
+// flattening embedded structs like encoding/json does
+func (g *generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			g.addFields(s, field.Type)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		s.Properties[name] = g.schemaOf(field.Type, false)
+		if !strings.Contains(","+options+",", ",omitempty,") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
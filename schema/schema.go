@@ -0,0 +1,88 @@
+// Package schema publishes versioned JSON Schemas of the files the agent
+// writes for other programs: the state file, the event log and the coverage
+// attestation. The agent validates these files against the published
+// schemas when it writes them, so a field change that would break the
+// dashboards and scripts built on them fails during development instead of
+// shipping silently.
+package schema
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Published schemas, by file name. The version in the name changes
+// whenever a field is removed, renamed or changes its type; new fields are
+// added to the current version.
+const (
+	State       = "state.v1.json"       // .coverage-agent/state.json
+	Events      = "events.v1.json"      // A line of the json reporter's event log
+	Attestation = "attestation.v1.json" // Coverage attestation files
+)
+
+// BaseURL is where the schemas are published, the base of their $id
+const BaseURL = "https://raw.githubusercontent.com/tablev/test-coverage-agent/main/schema/"
+
+// Draft is the JSON Schema dialect of the schemas
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+//go:embed *.json
+var published embed.FS
+
+var (
+	mu     sync.Mutex
+	loaded = make(map[string]map[string]any) // Decoded schemas by file name
+)
+
+// Names returns the file names of the published schemas
+func Names() []string {
+	return []string{State, Events, Attestation}
+}
+
+// Published returns a published schema by file name
+func Published(name string) ([]byte, error) {
+	data, err := published.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown schema %q", name)
+	}
+	return data, nil
+}
+
+// Validate checks a JSON document against a published schema
+func Validate(name string, data []byte) error {
+	root, err := load(name)
+	if err != nil {
+		return err
+	}
+
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := validate(root, root, doc, "$"); err != nil {
+		return fmt.Errorf("does not match %s: %w", name, err)
+	}
+	return nil
+}
+
+// load decodes a published schema once
+func load(name string) (map[string]any, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if root, ok := loaded[name]; ok {
+		return root, nil
+	}
+	data, err := Published(name)
+	if err != nil {
+		return nil, err
+	}
+	var root map[string]any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", name, err)
+	}
+	loaded[name] = root
+	return root, nil
+}
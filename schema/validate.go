@@ -0,0 +1,167 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// validate checks a decoded JSON value against a schema. It supports the
+// keywords Generate produces: type, properties, required,
+// additionalProperties, items, anyOf and $ref to $defs of the root.
+func validate(s, root map[string]any, v any, path string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		def, err := resolve(root, ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := validate(def, root, v, path); err != nil {
+			return err
+		}
+	}
+
+	if anyOf, ok := s["anyOf"].([]any); ok {
+		var firstErr error
+		matched := false
+		for _, option := range anyOf {
+			sub, _ := option.(map[string]any)
+			err := validate(sub, root, v, path)
+			if err == nil {
+				matched = true
+				break
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if !matched {
+			return firstErr
+		}
+	}
+
+	if t, ok := s["type"]; ok && !hasType(t, v) {
+		return fmt.Errorf("%s: got %s, want %v", path, typeOf(v), t)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		return validateObject(s, root, v, path)
+	case []any:
+		if items, ok := s["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validate(items, root, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateObject checks the members of an object
+func validateObject(s, root map[string]any, v map[string]any, path string) error {
+	if required, ok := s["required"].([]any); ok {
+		for _, name := range required {
+			if key, _ := name.(string); key != "" {
+				if _, ok := v[key]; !ok {
+					return fmt.Errorf("%s: missing required field %q", path, key)
+				}
+			}
+		}
+	}
+
+	properties, _ := s["properties"].(map[string]any)
+	additional := s["additionalProperties"]
+
+	// Sorted, so the same document always reports the same error
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		member := path + "." + key
+		if property, ok := properties[key].(map[string]any); ok {
+			if err := validate(property, root, v[key], member); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := additional.(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: field not in the schema", member)
+			}
+		case map[string]any:
+			if err := validate(additional, root, v[key], member); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve finds the definition a $ref points to
+func resolve(root map[string]any, ref string) (map[string]any, error) {
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	defs, _ := root["$defs"].(map[string]any)
+	def, ok := defs[name].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("undefined $ref %q", ref)
+	}
+	return def, nil
+}
+
+// hasType reports whether a value is of a type, or one of a list of types
+func hasType(t any, v any) bool {
+	switch t := t.(type) {
+	case string:
+		return isType(t, v)
+	case []any:
+		for _, name := range t {
+			if name, ok := name.(string); ok && isType(name, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isType reports whether a value is of a JSON Schema type
+func isType(name string, v any) bool {
+	actual := typeOf(v)
+	switch {
+	case name == actual:
+		return true
+	case name == "number" && actual == "integer":
+		return true
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded value
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/cache"
+	"github.com/tablev/test-coverage-agent/config"
+	"github.com/tablev/test-coverage-agent/git"
+)
+
+// Entries of a state bundle
+const (
+	bundleManifest = "manifest.json"
+	bundleState    = "state.json"
+	bundleGit      = "branches.bundle"
+	bundleCache    = "cache/"
+)
+
+// stateBundleVersion is the format version written to bundle manifests
+const stateBundleVersion = 1
+
+// stateManifest describes an exported session
+type stateManifest struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Branch     string    `json:"branch,omitempty"`   // Branch checked out at export
+	Branches   []string  `json:"branches,omitempty"` // Session branches in the git bundle
+	Head       string    `json:"head,omitempty"`     // Commit checked out at export
+}
+
+// runState handles the state export and import subcommands
+func runState(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "export":
+			return runStateExport(args[1:])
+		case "import":
+			return runStateImport(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: test-coverage-agent state export|import [-project path] [-state file] bundle.tar.gz\n")
+	return 1
+}
+
+// runStateExport bundles a paused session, its cache and its branches so
+// it can be resumed on another machine
+func runStateExport(args []string) int {
+	fs := flag.NewFlagSet("state export", flag.ExitOnError)
+	projectPath := fs.String("project", ".", "Path to the project of the session")
+	stateFile := fs.String("state", "", "State file of the session (default: <project>/.coverage-agent/state.json)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: test-coverage-agent state export [-project path] [-state file] bundle.tar.gz\n")
+		return 1
+	}
+	if err := exportState(*projectPath, *stateFile, fs.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Exported session to %s\n", fs.Arg(0))
+	return 0
+}
+
+// runStateImport unpacks an exported session into a checkout of the same
+// repository and switches to its branch, ready for -resume
+func runStateImport(args []string) int {
+	fs := flag.NewFlagSet("state import", flag.ExitOnError)
+	projectPath := fs.String("project", ".", "Path to the project to resume the session in")
+	stateFile := fs.String("state", "", "State file to write (default: <project>/.coverage-agent/state.json)")
+	force := fs.Bool("force", false, "Replace an existing state file and import even if the exported branch has moved")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: test-coverage-agent state import [-project path] [-state file] [-force] bundle.tar.gz\n")
+		return 1
+	}
+	manifest, err := importState(*projectPath, *stateFile, fs.Arg(0), *force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if manifest.Branch != "" {
+		fmt.Printf("Checked out %s at %.12s\n", manifest.Branch, manifest.Head)
+	}
+	fmt.Printf("Imported session exported at %s; run with -resume to continue\n", manifest.ExportedAt.Format(time.RFC3339))
+	return 0
+}
+
+// exportState writes the session's state, cache and branches to a gzipped
+// tar bundle. Isolated toolchains are machine-specific and left out.
+func exportState(projectPath, stateFile, bundlePath string) error {
+	c := cache.New(projectPath)
+	if stateFile == "" {
+		stateFile = c.StateFile()
+	}
+	state, err := config.LoadState(stateFile)
+	if err != nil {
+		return err
+	}
+
+	manifest := stateManifest{Version: stateBundleVersion, ExportedAt: time.Now()}
+	gitMgr := git.NewManager(projectPath)
+	var gitBundle string
+	if gitMgr.IsEnabled() {
+		if dirty, _ := gitMgr.HasUncommittedChanges(); dirty {
+			fmt.Println("Warning: uncommitted changes are not exported")
+		}
+		if manifest.Branch, err = gitMgr.GetCurrentBranch(); err != nil {
+			return err
+		}
+		if manifest.Head, err = gitMgr.GetLastCommitHash(); err != nil {
+			return err
+		}
+		manifest.Branches = sessionBranches(state, manifest.Branch)
+
+		tmp, err := os.MkdirTemp("", "coverage-agent-export-")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(tmp)
+		gitBundle = filepath.Join(tmp, bundleGit)
+		if err := gitMgr.CreateBundle(gitBundle, manifest.Branches); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := addTarData(tw, bundleManifest, manifestData); err != nil {
+		return err
+	}
+	if err := addTarFile(tw, bundleState, stateFile); err != nil {
+		return err
+	}
+	if gitBundle != "" {
+		if err := addTarFile(tw, bundleGit, gitBundle); err != nil {
+			return err
+		}
+	}
+
+	absState, _ := filepath.Abs(stateFile)
+	toolchain := filepath.Join(c.Root(), string(cache.Toolchain))
+	err = filepath.Walk(c.Root(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == toolchain {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if abs, _ := filepath.Abs(path); abs == absState || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(c.Root(), path)
+		if err != nil {
+			return err
+		}
+		return addTarFile(tw, bundleCache+filepath.ToSlash(rel), path)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to bundle cache: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// sessionBranches returns the branches holding the session's commits: its
+// chunk branches, or the current branch
+func sessionBranches(state *config.State, current string) []string {
+	var branches []string
+	seen := make(map[string]bool)
+	for _, chunk := range state.Chunks {
+		if !seen[chunk.Branch] {
+			seen[chunk.Branch] = true
+			branches = append(branches, chunk.Branch)
+		}
+	}
+	if current != "" && current != "HEAD" && !seen[current] {
+		branches = append(branches, current)
+	}
+	return branches
+}
+
+// importState unpacks a bundle into a project. The import fails unless the
+// exported branch holds the exported commit, or HEAD does for a detached
+// session, and unless every local branch of the session can be
+// fast-forwarded to the bundle's. The branches are then updated and the
+// exported one checked out; the cache and state are installed last.
+func importState(projectPath, stateFile, bundlePath string, force bool) (*stateManifest, error) {
+	c := cache.New(projectPath)
+	if stateFile == "" {
+		stateFile = c.StateFile()
+	}
+	if _, err := os.Stat(stateFile); err == nil && !force {
+		return nil, fmt.Errorf("state file %s already exists (use -force to replace it)", stateFile)
+	}
+	if err := c.Init(); err != nil {
+		return nil, err
+	}
+
+	// Extract inside the cache so the entries can be moved into place, which
+	// happens only once the session is checked out
+	tmp, err := os.MkdirTemp(c.Root(), "import-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	manifest, err := extractBundle(bundlePath, tmp)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Version > stateBundleVersion {
+		return nil, fmt.Errorf("bundle format version %d is newer than this agent supports (%d)", manifest.Version, stateBundleVersion)
+	}
+
+	// Check out the session before the state is installed, so a failed
+	// import doesn't leave a state that doesn't match the tree
+	gitMgr := git.NewManager(projectPath)
+	if manifest.Head != "" {
+		if !gitMgr.IsEnabled() {
+			return nil, fmt.Errorf("the session was exported from a git repository, but %s is not one", projectPath)
+		}
+		commits, err := gitMgr.FetchBundle(filepath.Join(tmp, bundleGit), manifest.Branches)
+		defer gitMgr.DropBundleRefs()
+		if err != nil {
+			return nil, err
+		}
+
+		branch := manifest.Branch
+		if branch == "HEAD" {
+			branch = ""
+		}
+		head := commits[branch]
+		if branch == "" {
+			// A detached session is resumed from whatever is checked out
+			if head, err = gitMgr.GetLastCommitHash(); err != nil {
+				return nil, err
+			}
+		}
+		if head != manifest.Head && !force {
+			name := branch
+			if name == "" {
+				name = "HEAD"
+			}
+			return nil, fmt.Errorf("%s is at %.12s but the session was exported at %.12s (use -force to import anyway)", name, head, manifest.Head)
+		}
+
+		if err := gitMgr.UpdateBranches(commits); err != nil {
+			return nil, err
+		}
+		if branch != "" {
+			if err := gitMgr.Checkout(branch); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := installCache(filepath.Join(tmp, strings.TrimSuffix(bundleCache, "/")), c.Root()); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(tmp, bundleState))
+	if err != nil {
+		return nil, fmt.Errorf("bundle has no state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(stateFile, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write state: %w", err)
+	}
+	return manifest, nil
+}
+
+// extractBundle unpacks a bundle into dir. Entries that would land outside
+// it are rejected.
+func extractBundle(bundlePath, dir string) (*stateManifest, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if rel, err := filepath.Rel(dir, target); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("invalid bundle entry %q", header.Name)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, bundleManifest))
+	if err != nil {
+		return nil, fmt.Errorf("bundle has no manifest: %w", err)
+	}
+	var manifest stateManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// addTarFile adds a file to a tar archive under the given name
+func addTarFile(tw *tar.Writer, name, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return addTarData(tw, name, data)
+}
+
+// addTarData adds a regular file with the given contents to a tar archive
+func addTarData(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write bundle entry %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write bundle entry %s: %w", name, err)
+	}
+	return nil
+}
+
+// installCache moves extracted cache entries into the cache, replacing
+// entries with the same key
+func installCache(src, root string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == src {
+			return nil // The bundle has no cache entries
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+		}
+		if err := os.Rename(path, target); err != nil {
+			return fmt.Errorf("failed to install cache entry %s: %w", rel, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,269 @@
+package testgen
+
+import (
+	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/astcache"
+)
+
+// packageNames holds the top-level names declared by the other files of a
+// test file's package, which generated tests must not declare again
+type packageNames struct {
+	byPackage map[string]map[string]bool // Go package name ("" for Java) -> names
+	inTests   []string                   // Names declared in test files, sorted, for the prompt
+}
+
+// javaTypePattern finds top-level Java type declarations, assuming they
+// start at the beginning of a line as formatted code does
+var javaTypePattern = regexp.MustCompile(`(?m)^(?:(?:public|final|abstract|sealed|non-sealed|strictfp)\s+)*(?:class|interface|enum|record|@interface)\s+(\w+)`)
+
+// existingNames collects the names declared next to a test file: the other
+// Go files of its directory, or the other Java files of its directory and
+// of the source file's directory, which belong to the same package. Other
+// languages have no collision check.
+func existingNames(language, testFile, sourceFile string) packageNames {
+	names := packageNames{byPackage: make(map[string]map[string]bool)}
+	inTests := make(map[string]bool)
+
+	switch language {
+	case "Go":
+		matches, _ := filepath.Glob(filepath.Join(filepath.Dir(testFile), "*.go"))
+		for _, file := range matches {
+			if sameFile(file, testFile) {
+				continue
+			}
+			pkg, declared, err := goTopLevelNames(file)
+			if err != nil {
+				continue
+			}
+			for _, name := range declared {
+				names.add(pkg, name)
+				if strings.HasSuffix(file, "_test.go") {
+					inTests[name] = true
+				}
+			}
+		}
+
+	case "Java":
+		dirs := []string{filepath.Dir(testFile)}
+		if sourceFile != "" && filepath.Dir(sourceFile) != dirs[0] {
+			dirs = append(dirs, filepath.Dir(sourceFile))
+		}
+		for i, dir := range dirs {
+			matches, _ := filepath.Glob(filepath.Join(dir, "*.java"))
+			for _, file := range matches {
+				if sameFile(file, testFile) {
+					continue
+				}
+				data, err := os.ReadFile(file)
+				if err != nil {
+					continue
+				}
+				for _, m := range javaTypePattern.FindAllStringSubmatch(string(data), -1) {
+					names.add("", m[1])
+					if i == 0 {
+						inTests[m[1]] = true
+					}
+				}
+			}
+		}
+	}
+
+	for name := range inTests {
+		names.inTests = append(names.inTests, name)
+	}
+	sort.Strings(names.inTests)
+	return names
+}
+
+// add records a name declared in a package
+func (n packageNames) add(pkg, name string) {
+	if n.byPackage[pkg] == nil {
+		n.byPackage[pkg] = make(map[string]bool)
+	}
+	n.byPackage[pkg][name] = true
+}
+
+// renameCollisions renames top-level declarations in generated test code
+// that are already declared elsewhere in the package, which would not
+// compile. References in the code are renamed too. It returns the new code
+// and the renamed names; code that can't be parsed is returned unchanged.
+func renameCollisions(language, testFile, code string, names packageNames) (string, []string) {
+	switch language {
+	case "Go":
+		return renameGoCollisions(code, names)
+	case "Java":
+		return renameJavaCollisions(strings.TrimSuffix(filepath.Base(testFile), ".java"), code, names)
+	}
+	return code, nil
+}
+
+// renameGoCollisions renames colliding functions, types, variables and
+// constants, using the parser's resolution so that fields and methods with
+// the same name are left alone
+func renameGoCollisions(code string, names packageNames) (string, []string) {
+	parsed, err := astcache.Shared.ParseSource("", []byte(code))
+	if err != nil {
+		return code, nil
+	}
+	fset, file := parsed.Fset, parsed.AST
+	reserved := names.byPackage[file.Name.Name]
+	if len(reserved) == 0 {
+		return code, nil
+	}
+
+	taken := make(map[string]bool)
+	for _, ident := range goDeclIdents(file) {
+		taken[ident.Name] = true
+	}
+
+	// Map each colliding declaration's object to its new name
+	renames := make(map[*ast.Object]string)
+	var renamed []string
+	for _, ident := range goDeclIdents(file) {
+		if !reserved[ident.Name] || ident.Obj == nil {
+			continue
+		}
+		newName := uniqueName(ident.Name, reserved, taken)
+		taken[newName] = true
+		renames[ident.Obj] = newName
+		renamed = append(renamed, ident.Name)
+	}
+	if len(renames) == 0 {
+		return code, nil
+	}
+
+	type edit struct {
+		offset int
+		old    string
+		new    string
+	}
+	var edits []edit
+	fieldKeys := make(map[*ast.Ident]bool)
+	ast.Inspect(file, func(node ast.Node) bool {
+		// The parser resolves struct literal field keys like variables
+		if lit, ok := node.(*ast.CompositeLit); ok {
+			switch lit.Type.(type) {
+			case *ast.MapType, *ast.ArrayType:
+			default:
+				for _, elt := range lit.Elts {
+					if kv, ok := elt.(*ast.KeyValueExpr); ok {
+						if key, ok := kv.Key.(*ast.Ident); ok {
+							fieldKeys[key] = true
+						}
+					}
+				}
+			}
+		}
+
+		if ident, ok := node.(*ast.Ident); ok && ident.Obj != nil && !fieldKeys[ident] {
+			if newName, ok := renames[ident.Obj]; ok {
+				edits = append(edits, edit{fset.Position(ident.Pos()).Offset, ident.Name, newName})
+			}
+		}
+		return true
+	})
+
+	// Apply from the end so earlier offsets stay valid
+	sort.Slice(edits, func(i, j int) bool { return edits[i].offset > edits[j].offset })
+	for _, e := range edits {
+		code = code[:e.offset] + e.new + code[e.offset+len(e.old):]
+	}
+	return code, renamed
+}
+
+// renameJavaCollisions renames colliding top-level helper classes. The
+// class named after the file can't be renamed and is left alone.
+func renameJavaCollisions(className, code string, names packageNames) (string, []string) {
+	reserved := names.byPackage[""]
+	if len(reserved) == 0 {
+		return code, nil
+	}
+
+	taken := make(map[string]bool)
+	var declared []string
+	for _, m := range javaTypePattern.FindAllStringSubmatch(code, -1) {
+		taken[m[1]] = true
+		declared = append(declared, m[1])
+	}
+
+	var renamed []string
+	for _, name := range declared {
+		if !reserved[name] || name == className {
+			continue
+		}
+		newName := uniqueName(name, reserved, taken)
+		taken[newName] = true
+		code = regexp.MustCompile(`\b`+regexp.QuoteMeta(name)+`\b`).ReplaceAllString(code, newName)
+		renamed = append(renamed, name)
+	}
+	return code, renamed
+}
+
+// uniqueName appends the lowest number to a name that makes it unique
+func uniqueName(name string, reserved, taken map[string]bool) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s%d", name, i)
+		if !reserved[candidate] && !taken[candidate] {
+			return candidate
+		}
+	}
+}
+
+// goTopLevelNames returns the package name and the package-level names
+// declared in a Go file
+func goTopLevelNames(path string) (string, []string, error) {
+	parsed, err := astcache.Shared.ParseFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	file := parsed.AST
+
+	var names []string
+	for _, ident := range goDeclIdents(file) {
+		names = append(names, ident.Name)
+	}
+	return file.Name.Name, names, nil
+}
+
+// goDeclIdents returns the identifiers of a file's package-level
+// declarations, leaving out methods, init functions and blank names, which
+// can't collide
+func goDeclIdents(file *ast.File) []*ast.Ident {
+	var idents []*ast.Ident
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil && d.Name.Name != "init" {
+				idents = append(idents, d.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					idents = append(idents, s.Name)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						if name.Name != "_" {
+							idents = append(idents, name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return idents
+}
+
+// sameFile reports whether two paths name the same file
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,82 @@
+package testgen
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/tablev/test-coverage-agent/claude"
+	"github.com/tablev/test-coverage-agent/config"
+)
+
+// Score penalties for validation signals that make a test less trustworthy
+const (
+	retryPenalty         = 15 // Per fix attempt the test needed to pass
+	fewAssertionsPenalty = 20 // Fewer assertions than tests
+	fewFunctionsPenalty  = 10 // Less than half of the source functions exercised
+	unassessedConfidence = 50 // Score to start from without a self-assessment
+)
+
+// DefaultReviewBelow is the confidence below which tests are flagged for
+// human review
+const DefaultReviewBelow = 60
+
+// SelfAssessment is the model's rating of a test file it wrote
+type SelfAssessment struct {
+	Score  int // 0-100
+	Reason string
+}
+
+// SelfAssessment returns the model's rating of the test file at a
+// project-relative path, from the last response that wrote it and had one
+func (g *Generator) SelfAssessment(relativeTestFile string) (SelfAssessment, bool) {
+	assessment, ok := g.assessments[filepath.Clean(relativeTestFile)]
+	return assessment, ok
+}
+
+// takeAssessment removes the self-assessment line from a response and
+// stores it for the test file. Responses without one keep the earlier
+// assessment, so a fix that omits it doesn't lose the rating.
+func (g *Generator) takeAssessment(projectPath, testFile, response string) string {
+	response, score, reason, ok := claude.ExtractConfidence(response)
+	if !ok {
+		return response
+	}
+	if relativeTestFile, err := filepath.Rel(projectPath, testFile); err == nil {
+		g.assessments[relativeTestFile] = SelfAssessment{Score: score, Reason: reason}
+	}
+	return response
+}
+
+// ScoreConfidence combines the model's self-assessment with the validation
+// signals of a test file: the fix attempts it needed and its assertion
+// density. Tests scoring below reviewBelow are flagged for review; 0
+// flags none.
+func ScoreConfidence(assessment SelfAssessment, assessed bool, retries int, quality config.TestQuality, reviewBelow int) config.Confidence {
+	confidence := config.Confidence{Score: unassessedConfidence, SelfScore: -1, Retries: retries}
+	if assessed {
+		confidence.Score = assessment.Score
+		confidence.SelfScore = assessment.Score
+		confidence.Reason = assessment.Reason
+	} else {
+		confidence.Concerns = append(confidence.Concerns, "no self-assessment")
+	}
+
+	if retries > 0 {
+		confidence.Score -= retries * retryPenalty
+		confidence.Concerns = append(confidence.Concerns, fmt.Sprintf("needed %d fix attempt(s)", retries))
+	}
+	if quality.Tests > 0 && quality.Assertions < quality.Tests {
+		confidence.Score -= fewAssertionsPenalty
+		confidence.Concerns = append(confidence.Concerns, fmt.Sprintf("%d assertion(s) in %d test(s)", quality.Assertions, quality.Tests))
+	}
+	if quality.FunctionsTotal > 0 && quality.FunctionsExercised*2 < quality.FunctionsTotal {
+		confidence.Score -= fewFunctionsPenalty
+		confidence.Concerns = append(confidence.Concerns, fmt.Sprintf("exercises %d of %d functions", quality.FunctionsExercised, quality.FunctionsTotal))
+	}
+
+	if confidence.Score < 0 {
+		confidence.Score = 0
+	}
+	confidence.Review = reviewBelow > 0 && confidence.Score < reviewBelow
+	return confidence
+}
@@ -0,0 +1,118 @@
+package testgen
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// databaseDriver is a dependency that connects to a database
+type databaseDriver struct {
+	dependency string
+	database   string
+}
+
+// databaseDrivers lists the database drivers and clients recognized in each
+// language's dependency manifests, most specific first
+var databaseDrivers = map[string][]databaseDriver{
+	"Go": {
+		{"github.com/lib/pq", "PostgreSQL"},
+		{"github.com/jackc/pgx", "PostgreSQL"},
+		{"github.com/go-sql-driver/mysql", "MySQL"},
+		{"go.mongodb.org/mongo-driver", "MongoDB"},
+		{"github.com/redis/go-redis", "Redis"},
+		{"github.com/go-redis/redis", "Redis"},
+	},
+	"Java": {
+		{"org.postgresql", "PostgreSQL"},
+		{"mysql-connector", "MySQL"},
+		{"mariadb-java-client", "MariaDB"},
+		{"mongodb-driver", "MongoDB"},
+		{"spring-boot-starter-data-mongodb", "MongoDB"},
+		{"jedis", "Redis"},
+		{"lettuce-core", "Redis"},
+	},
+	"Python": {
+		{"psycopg", "PostgreSQL"},
+		{"asyncpg", "PostgreSQL"},
+		{"pymysql", "MySQL"},
+		{"mysqlclient", "MySQL"},
+		{"mysql-connector-python", "MySQL"},
+		{"pymongo", "MongoDB"},
+		{"motor", "MongoDB"},
+		{"redis", "Redis"},
+	},
+	"TypeScript": {
+		{`"pg"`, "PostgreSQL"},
+		{`"postgres"`, "PostgreSQL"},
+		{`"mysql"`, "MySQL"},
+		{`"mysql2"`, "MySQL"},
+		{`"mongodb"`, "MongoDB"},
+		{`"mongoose"`, "MongoDB"},
+		{`"ioredis"`, "Redis"},
+		{`"redis"`, "Redis"},
+	},
+}
+
+// dependencyManifests lists the files declaring each language's dependencies
+var dependencyManifests = map[string][]string{
+	"Go":         {"go.mod"},
+	"Java":       {"pom.xml", "build.gradle", "build.gradle.kts"},
+	"Python":     {"requirements.txt", "requirements-dev.txt", "pyproject.toml", "setup.py", "setup.cfg", "Pipfile"},
+	"TypeScript": {"package.json"},
+}
+
+// dataAccessPatterns match source code that talks to a database, such as a
+// repository or DAO, as opposed to code that merely lives in a project with
+// a database dependency
+var dataAccessPatterns = map[string]*regexp.Regexp{
+	"Go":         regexp.MustCompile(`"database/sql"|"gorm\.io/|"github\.com/jmoiron/sqlx"|"github\.com/jackc/pgx|"github\.com/lib/pq"|"go\.mongodb\.org/mongo-driver|"github\.com/(redis|go-redis)/`),
+	"Java":       regexp.MustCompile(`import (java|javax)\.sql\.|JdbcTemplate|EntityManager|@Repository\b|JpaRepository|MongoTemplate|MongoCollection|import org\.jooq\.|Jedis\b`),
+	"Python":     regexp.MustCompile(`(?m)^\s*(import|from) (psycopg2?|asyncpg|pymysql|MySQLdb|mysql\.connector|sqlalchemy|pymongo|motor|redis|django\.db)\b`),
+	"TypeScript": regexp.MustCompile(`(from|require\()\s*['"](pg|postgres|mysql2?|mongodb|mongoose|ioredis|redis|typeorm|knex|sequelize|@prisma/client|drizzle-orm)(/[^'"]*)?['"]`),
+}
+
+// DatabaseFor returns the database a source file talks to, such as
+// "PostgreSQL", or "" if the file doesn't access a database or the project
+// has no recognized driver. Such files can't be unit tested without a real
+// database, so they are candidates for testcontainers-based tests.
+func DatabaseFor(language, projectPath, sourceCode string) string {
+	// Kotlin projects use the JVM drivers
+	if language == "Kotlin" {
+		language = "Java"
+	}
+
+	pattern, ok := dataAccessPatterns[language]
+	if !ok || !pattern.MatchString(sourceCode) {
+		return ""
+	}
+
+	var manifests strings.Builder
+	for _, file := range dependencyManifests[language] {
+		if data, err := os.ReadFile(filepath.Join(projectPath, file)); err == nil {
+			manifests.Write(data)
+			manifests.WriteString("\n")
+		}
+	}
+
+	text := manifests.String()
+	for _, driver := range databaseDrivers[language] {
+		if strings.Contains(text, driver.dependency) {
+			return driver.database
+		}
+	}
+	return ""
+}
+
+// DockerAvailable reports whether a Docker daemon is reachable, which
+// testcontainers needs to start databases
+func DockerAvailable() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	return exec.CommandContext(ctx, "docker", "info").Run() == nil
+}
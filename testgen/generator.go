@@ -5,52 +5,215 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/tablev/test-coverage-agent/cache"
 	"github.com/tablev/test-coverage-agent/claude"
 	"github.com/tablev/test-coverage-agent/coverage"
+	"github.com/tablev/test-coverage-agent/llm"
+	"github.com/tablev/test-coverage-agent/prompts"
 )
 
-// Generator handles test generation using Claude API
+// Generator handles test generation using Claude API, Vertex AI or a local model
 type Generator struct {
-	claudeClient *claude.Client
-	analyzer     coverage.Analyzer
+	client      llm.Client
+	analyzer    coverage.Analyzer
+	options     Options
+	mocks       map[string]*Mocks            // Prepared mocks by source file key
+	methods     map[string][]coverage.Method // Untested methods by source file key
+	history     map[string][]prompts.Commit  // Commits behind the uncovered lines by source file key
+	assessments map[string]SelfAssessment    // Self-assessments by project-relative test file
+	sent        map[string]bool              // Keys of the prompts sent in this session
+	attempt     []string                     // Keys of the responses used by the current attempt
+	unminimized bool                         // Whether skipped diff minimization was reported
 }
 
+// Options controls optional generator behavior
+type Options struct {
+	// Annotate asks for a comment above each test naming the lines it targets
+	Annotate bool
+
+	// Cache stores prompts and responses; nil disables caching
+	Cache *cache.Cache
+
+	// ReuseResponses returns cached responses for prompts identical to
+	// those of an earlier session. Within a session, and for responses
+	// whose tests failed validation, the API is always called.
+	ReuseResponses bool
+
+	// Harness asks for integration harnesses for main packages and entrypoint scripts
+	Harness bool
+
+	// Testcontainers asks for tests against a real database, started with
+	// testcontainers, for code that talks to one
+	Testcontainers bool
+
+	// Provider is where the models run: llm.ProviderAnthropic (default),
+	// llm.ProviderOllama or llm.ProviderVertex
+	Provider string
+
+	// OllamaHost is the address of the Ollama server; empty uses
+	// OLLAMA_HOST or the default local address
+	OllamaHost string
+
+	// VertexProject and VertexLocation are the Google Cloud project and
+	// location of Vertex AI requests; empty uses the environment
+	VertexProject  string
+	VertexLocation string
+
+	// Model overrides the client's default model; aliases like "opus" are accepted
+	Model string
+
+	// Models maps project-relative path patterns ("internal/crypto/**") to
+	// models; the longest matching pattern wins, other files use Model
+	Models map[string]string
+
+	// MaxTokens overrides the client's output token limit (0 = default)
+	MaxTokens int
+
+	// RequestsPerMinute and TokensPerMinute pace Claude API requests to
+	// stay under the account's rate limits (0 = unlimited)
+	RequestsPerMinute int
+	TokensPerMinute   int
+
+	// AttachKB is the size in KB above which a source file is uploaded and
+	// attached as a document instead of included in the prompt (0 = never)
+	AttachKB int
+
+	// Templates renders the prompts; nil uses the built-in templates
+	Templates *prompts.Templates
+
+	// PackageContext attaches the other source files of the file's
+	// directory as documents, so tests can use the package's types and helpers
+	PackageContext bool
+}
+
+// maxContextFiles caps the package files attached with PackageContext
+const maxContextFiles = 20
+
 // NewGenerator creates a new test generator
-func NewGenerator(apiKey string, analyzer coverage.Analyzer) *Generator {
+func NewGenerator(apiKey string, analyzer coverage.Analyzer, options Options) *Generator {
+	client := llm.New(llm.Options{
+		Provider:       options.Provider,
+		APIKey:         apiKey,
+		OllamaHost:     options.OllamaHost,
+		VertexProject:  options.VertexProject,
+		VertexLocation: options.VertexLocation,
+		Model:          options.Model,
+		MaxTokens:      options.MaxTokens,
+
+		RequestsPerMinute: options.RequestsPerMinute,
+		TokensPerMinute:   options.TokensPerMinute,
+	})
+	return NewGeneratorWithClient(client, analyzer, options)
+}
+
+// NewGeneratorWithClient creates a test generator that sends its prompts
+// through client, e.g. a fake in tests or a decorated client. The
+// provider, model and token limit options are left to the client.
+func NewGeneratorWithClient(client llm.Client, analyzer coverage.Analyzer, options Options) *Generator {
 	return &Generator{
-		claudeClient: claude.NewClient(apiKey),
-		analyzer:     analyzer,
+		client:      client,
+		analyzer:    analyzer,
+		options:     options,
+		mocks:       make(map[string]*Mocks),
+		methods:     make(map[string][]coverage.Method),
+		history:     make(map[string][]prompts.Commit),
+		assessments: make(map[string]SelfAssessment),
+		sent:        make(map[string]bool),
+	}
+}
+
+// FinishAttempt ends the attempt at a test file. If its tests failed
+// validation, the cached responses it used are evicted, so a retry, e.g.
+// after rerun-failed, asks the model again instead of replaying them.
+func (g *Generator) FinishAttempt(validated bool) {
+	if !validated && g.options.Cache != nil {
+		for _, key := range g.attempt {
+			_ = g.options.Cache.Delete(cache.Responses, key)
+		}
+	}
+	g.attempt = nil
+}
+
+// SetUntestedMethods records the methods of a source file that no test
+// executes; prompts for the file then ask for a test of each
+func (g *Generator) SetUntestedMethods(projectPath, sourceFile string, methods []coverage.Method) {
+	g.methods[sourceKey(projectPath, sourceFile)] = methods
+}
+
+// SetLineHistory records the commits that last changed the uncovered lines
+// of a source file; prompts for the file then include their messages
+func (g *Generator) SetLineHistory(projectPath, sourceFile string, commits []prompts.Commit) {
+	g.history[sourceKey(projectPath, sourceFile)] = commits
+}
+
+// sourceKey identifies a source file by its project-relative path, so the
+// context recorded for it is also found for its copy in a sandbox
+func sourceKey(projectPath, sourceFile string) string {
+	root, err := filepath.Abs(projectPath)
+	if err != nil {
+		return sourceFile
+	}
+	file, err := filepath.Abs(sourceFile)
+	if err != nil {
+		return sourceFile
+	}
+	if rel, err := filepath.Rel(root, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return sourceFile
+}
+
+// CheckAPI verifies that the provider accepts the configured key and
+// every configured model. Clients that can't check access pass.
+func (g *Generator) CheckAPI() error {
+	checker, ok := g.client.(llm.AccessChecker)
+	if !ok {
+		return nil
+	}
+
+	if err := checker.CheckModelAccess(g.client.Model()); err != nil {
+		return err
 	}
+	for _, model := range g.models() {
+		if err := checker.CheckModelAccess(model); err != nil {
+			return fmt.Errorf("model %s: %w", model, err)
+		}
+	}
+	return nil
 }
 
 // GenerateTestForFile generates a test file for an uncovered source file
-func (g *Generator) GenerateTestForFile(projectPath, sourceFile string, uncoveredLines []int) (string, error) {
+func (g *Generator) GenerateTestForFile(projectPath, sourceFile string, uncoveredLines []int, uncoveredBranches []coverage.Branch) (string, error) {
 	// Read source file
 	sourceCode, err := os.ReadFile(sourceFile)
 	if err != nil {
 		return "", fmt.Errorf("failed to read source file: %w", err)
 	}
 
-	// Format uncovered lines
-	uncoveredLinesStr := g.formatUncoveredLines(uncoveredLines)
+	// Get test file path
+	testFilePath := g.analyzer.GetTestFilePath(sourceFile)
+	if err := CheckPath(projectPath, testFilePath); err != nil {
+		return "", err
+	}
+	language := g.analyzer.GetLanguageName()
+	names := existingNames(language, testFilePath, sourceFile)
 
 	// Generate prompt
-	language := g.analyzer.GetLanguageName()
-	relativeSourceFile, _ := filepath.Rel(projectPath, sourceFile)
-	prompt := claude.GenerateTestPrompt(language, relativeSourceFile, string(sourceCode), uncoveredLinesStr)
+	req := g.promptRequest(projectPath, sourceFile, string(sourceCode), uncoveredLines, uncoveredBranches)
+	req.TakenNames = names.inTests
+	prompt := g.options.Templates.ForNewTest(req)
 
 	// Call Claude API
-	response, err := g.claudeClient.SendMessage(prompt)
+	response, err := g.send(prompt, g.modelFor(projectPath, sourceFile))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate test: %w", err)
 	}
 
-	// Extract code from response
-	testCode := claude.ExtractCodeFromResponse(response)
-
-	// Get test file path
-	testFilePath := g.analyzer.GetTestFilePath(sourceFile)
+	// Extract code from response, renaming anything the package already declares
+	testCode := claude.ExtractCodeFromResponse(g.takeAssessment(projectPath, testFilePath, response))
+	testCode, _ = renameCollisions(language, testFilePath, testCode, names)
 
 	// Ensure directory exists
 	testDir := filepath.Dir(testFilePath)
@@ -68,25 +231,37 @@ func (g *Generator) GenerateTestForFile(projectPath, sourceFile string, uncovere
 
 // FixBrokenTest attempts to fix a failing test file
 func (g *Generator) FixBrokenTest(projectPath, testFile, errorOutput string) (string, error) {
+	if err := CheckPath(projectPath, testFile); err != nil {
+		return "", err
+	}
+
 	// Read test file
 	testCode, err := os.ReadFile(testFile)
 	if err != nil {
 		return "", fmt.Errorf("failed to read test file: %w", err)
 	}
 
-	// Generate prompt
+	// Generate prompt, starting with the source file like the prompt that
+	// wrote the test so the cached prefix is reused
 	language := g.analyzer.GetLanguageName()
 	relativeTestFile, _ := filepath.Rel(projectPath, testFile)
-	prompt := claude.FixBrokenTestPrompt(language, relativeTestFile, string(testCode), errorOutput)
+	sourceFile := g.analyzer.GetSourceFileForTest(testFile)
+	prompt := g.options.Templates.ForBrokenTest(language, relativeTestFile, string(testCode), errorOutput, g.options.Annotate)
+	if sourceCode, err := os.ReadFile(sourceFile); err == nil {
+		req := g.promptRequest(projectPath, sourceFile, string(sourceCode), nil, nil)
+		prompt = g.options.Templates.ForFailingTest(req, relativeTestFile, string(testCode), errorOutput)
+	}
 
-	// Call Claude API
-	response, err := g.claudeClient.SendMessage(prompt)
+	// Call Claude API with the model chosen for the file under test
+	response, err := g.send(prompt, g.modelFor(projectPath, sourceFile))
 	if err != nil {
 		return "", fmt.Errorf("failed to fix test: %w", err)
 	}
 
-	// Extract code from response
-	fixedTestCode := claude.ExtractCodeFromResponse(response)
+	// Extract code from response, keeping unchanged tests as they were
+	fixedTestCode := claude.ExtractCodeFromResponse(g.takeAssessment(projectPath, testFile, response))
+	fixedTestCode = g.minimize(language, testFile, string(testCode), fixedTestCode)
+	fixedTestCode, _ = renameCollisions(language, testFile, fixedTestCode, existingNames(language, testFile, sourceFile))
 
 	// Write fixed test file
 	if err := os.WriteFile(testFile, []byte(fixedTestCode), 0644); err != nil {
@@ -97,7 +272,11 @@ func (g *Generator) FixBrokenTest(projectPath, testFile, errorOutput string) (st
 }
 
 // ImproveExistingTest enhances an existing test to cover more code
-func (g *Generator) ImproveExistingTest(projectPath, sourceFile, testFile string, uncoveredLines []int) (string, error) {
+func (g *Generator) ImproveExistingTest(projectPath, sourceFile, testFile string, uncoveredLines []int, uncoveredBranches []coverage.Branch) (string, error) {
+	if err := CheckPath(projectPath, testFile); err != nil {
+		return "", err
+	}
+
 	// Read source and test files
 	sourceCode, err := os.ReadFile(sourceFile)
 	if err != nil {
@@ -109,28 +288,24 @@ func (g *Generator) ImproveExistingTest(projectPath, sourceFile, testFile string
 		return "", fmt.Errorf("failed to read test file: %w", err)
 	}
 
-	// Format uncovered lines
-	uncoveredLinesStr := g.formatUncoveredLines(uncoveredLines)
-
 	// Generate prompt
 	language := g.analyzer.GetLanguageName()
-	relativeSourceFile, _ := filepath.Rel(projectPath, sourceFile)
-	prompt := claude.ImproveTestCoveragePrompt(
-		language,
-		relativeSourceFile,
-		string(sourceCode),
-		string(existingTests),
-		uncoveredLinesStr,
-	)
+	names := existingNames(language, testFile, sourceFile)
+	req := g.promptRequest(projectPath, sourceFile, string(sourceCode), uncoveredLines, uncoveredBranches)
+	req.ExistingTests = string(existingTests)
+	req.TakenNames = names.inTests
+	prompt := g.options.Templates.ForExistingTest(req)
 
 	// Call Claude API
-	response, err := g.claudeClient.SendMessage(prompt)
+	response, err := g.send(prompt, g.modelFor(projectPath, sourceFile))
 	if err != nil {
 		return "", fmt.Errorf("failed to improve test: %w", err)
 	}
 
-	// Extract code from response
-	improvedTestCode := claude.ExtractCodeFromResponse(response)
+	// Extract code from response, keeping unchanged tests as they were
+	improvedTestCode := claude.ExtractCodeFromResponse(g.takeAssessment(projectPath, testFile, response))
+	improvedTestCode = g.minimize(language, testFile, string(existingTests), improvedTestCode)
+	improvedTestCode, _ = renameCollisions(language, testFile, improvedTestCode, names)
 
 	// Write improved test file
 	if err := os.WriteFile(testFile, []byte(improvedTestCode), 0644); err != nil {
@@ -140,37 +315,188 @@ func (g *Generator) ImproveExistingTest(projectPath, sourceFile, testFile string
 	return testFile, nil
 }
 
-// formatUncoveredLines formats line numbers for the prompt
-func (g *Generator) formatUncoveredLines(lines []int) string {
-	if len(lines) == 0 {
-		return "None"
+// maxFailureChars caps the failure output included in refactoring prompts
+const maxFailureChars = 4000
+
+// SuggestRefactorings asks for refactorings that would make a source file
+// testable, given why writing tests for it failed, and returns them
+func (g *Generator) SuggestRefactorings(projectPath, sourceFile, failure string) ([]string, error) {
+	sourceCode, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source file: %w", err)
+	}
+	if len(failure) > maxFailureChars {
+		failure = failure[:maxFailureChars] + "\n[truncated]"
+	}
+
+	relativeSourceFile, _ := filepath.Rel(projectPath, sourceFile)
+	prompt := prompts.SuggestRefactorings(g.analyzer.GetLanguageName(), relativeSourceFile, string(sourceCode), failure)
+	response, err := g.send(prompts.Prompt{User: prompt}, g.modelFor(projectPath, sourceFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest refactorings: %w", err)
+	}
+
+	var suggestions []string
+	for _, line := range strings.Split(response, "\n") {
+		if suggestion, ok := strings.CutPrefix(strings.TrimSpace(line), "- "); ok && suggestion != "" {
+			suggestions = append(suggestions, strings.TrimSpace(suggestion))
+		}
+	}
+	if len(suggestions) == 0 {
+		return nil, fmt.Errorf("no refactoring suggestions in the response")
+	}
+	return suggestions, nil
+}
+
+// TranslateSummary translates a human-readable run summary into the given
+// language. Only the summary is translated, never generated code.
+func (g *Generator) TranslateSummary(summary, language string) (string, error) {
+	response, err := g.send(prompts.Prompt{User: prompts.TranslateSummary(summary, language)}, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to translate summary: %w", err)
+	}
+	return strings.TrimSpace(response), nil
+}
+
+// send sends a prompt to the model with the given model, or the default model
+// if empty, going through the response cache if configured
+func (g *Generator) send(prompt prompts.Prompt, model string) (string, error) {
+	if model == "" {
+		model = g.client.Model()
+	}
+	model = claude.ResolveModel(model)
+
+	if g.options.Cache == nil {
+		return g.sendPrompt(prompt, model)
+	}
+
+	// A prompt repeated within the session, like an identical fix retry,
+	// needs a new response rather than the one that led to it
+	key := cache.Key(append([]string{model}, prompt.Key()...)...)
+	if g.options.ReuseResponses && !g.sent[key] {
+		if cached, ok := g.options.Cache.Get(cache.Responses, key); ok {
+			g.sent[key] = true
+			g.attempt = append(g.attempt, key)
+			return string(cached), nil
+		}
+	}
+
+	// Keep the prompt around for debugging; failures here are not fatal
+	promptName := fmt.Sprintf("%s-%s.txt", time.Now().Format("20060102-150405"), key[:12])
+	_ = g.options.Cache.Put(cache.Prompts, promptName, []byte(prompt.String()))
+
+	response, err := g.sendPrompt(prompt, model)
+	if err != nil {
+		return "", err
+	}
+
+	g.sent[key] = true
+	g.attempt = append(g.attempt, key)
+	_ = g.options.Cache.Put(cache.Responses, key, []byte(response))
+	return response, nil
+}
+
+// sendPrompt sends a prompt to the provider, with its attachments if it has any.
+// The attachments, or else the prompt's prefix, are cached for later
+// prompts about the same file.
+func (g *Generator) sendPrompt(prompt prompts.Prompt, model string) (string, error) {
+	return g.client.SendMessage(llm.Request{
+		System:      prompt.System,
+		Prefix:      prompt.Prefix,
+		Prompt:      prompt.User,
+		Attachments: prompt.Attachments,
+		Model:       model,
+	})
+}
+
+// TakeUsage returns the API tokens used and their cost in USD since the
+// last call, or nothing if the client doesn't count them
+func (g *Generator) TakeUsage() (claude.Usage, float64) {
+	if tracker, ok := g.client.(llm.UsageTracker); ok {
+		return tracker.TakeUsage()
+	}
+	return claude.Usage{}, 0
+}
+
+// DeleteUploads deletes the files uploaded as attachments during the session
+func (g *Generator) DeleteUploads() error {
+	if uploader, ok := g.client.(llm.Uploader); ok {
+		return uploader.DeleteUploads()
+	}
+	return nil
+}
+
+// attachments returns the files to attach to a prompt for a source file:
+// the source itself if it's larger than AttachKB, the project headers a
+// C/C++ source includes, and with PackageContext the other source files of
+// its directory
+func (g *Generator) attachments(projectPath, sourceFile, sourceCode string) map[string]string {
+	attachments := make(map[string]string)
+	relativeSourceFile, _ := filepath.Rel(projectPath, sourceFile)
+	if g.options.AttachKB > 0 && len(sourceCode) > g.options.AttachKB*1024 {
+		attachments[relativeSourceFile] = sourceCode
 	}
 
-	// Group consecutive lines into ranges
-	var ranges []string
-	start := lines[0]
-	end := lines[0]
+	if g.analyzer.GetLanguageName() == "C++" {
+		for rel, content := range includedHeaders(projectPath, sourceFile, sourceCode) {
+			attachments[rel] = content
+		}
+	}
 
-	for i := 1; i < len(lines); i++ {
-		if lines[i] == end+1 {
-			end = lines[i]
-		} else {
-			if start == end {
-				ranges = append(ranges, fmt.Sprintf("Line %d", start))
-			} else {
-				ranges = append(ranges, fmt.Sprintf("Lines %d-%d", start, end))
+	if g.options.PackageContext {
+		language := g.analyzer.GetLanguageName()
+		matches, _ := filepath.Glob(filepath.Join(filepath.Dir(sourceFile), "*"+filepath.Ext(sourceFile)))
+		added := 0
+		for _, file := range matches {
+			rel, err := filepath.Rel(projectPath, file)
+			if err != nil || rel == relativeSourceFile || coverage.IsTestFile(language, rel) {
+				continue
 			}
-			start = lines[i]
-			end = lines[i]
+			if added == maxContextFiles {
+				fmt.Printf("  Attaching only %d files of %s as package context\n", maxContextFiles, filepath.Dir(rel))
+				break
+			}
+			content, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			attachments[rel] = string(content)
+			added++
 		}
 	}
 
-	// Add last range
-	if start == end {
-		ranges = append(ranges, fmt.Sprintf("Line %d", start))
-	} else {
-		ranges = append(ranges, fmt.Sprintf("Lines %d-%d", start, end))
+	if len(attachments) == 0 {
+		return nil
+	}
+	return attachments
+}
+
+// promptRequest describes a source file for the prompts package, enabling
+// the prompt extensions from the generator options
+func (g *Generator) promptRequest(projectPath, sourceFile, sourceCode string, uncoveredLines []int, uncoveredBranches []coverage.Branch) prompts.Request {
+	language := g.analyzer.GetLanguageName()
+	relativeSourceFile, _ := filepath.Rel(projectPath, sourceFile)
+
+	req := prompts.Request{
+		Language:          language,
+		SourceFile:        relativeSourceFile,
+		SourceCode:        sourceCode,
+		UncoveredLines:    uncoveredLines,
+		UncoveredBranches: uncoveredBranches,
+		Annotate:          g.options.Annotate,
+		Harness:           g.options.Harness && IsEntrypoint(language, sourceFile, sourceCode),
+		Attachments:       g.attachments(projectPath, sourceFile, sourceCode),
+	}
+	key := sourceKey(projectPath, sourceFile)
+	req.UntestedMethods = g.methods[key]
+	req.History = g.history[key]
+	if mocks, ok := g.mocks[key]; ok {
+		req.MockTool = mocks.Tool
+		req.Mocks = mocks.describe()
+	}
+	if g.options.Testcontainers {
+		req.Database = DatabaseFor(language, projectPath, sourceCode)
 	}
 
-	return strings.Join(ranges, ", ")
+	return req
 }
@@ -0,0 +1,25 @@
+package testgen
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+var (
+	goMainPackagePattern = regexp.MustCompile(`(?m)^package main\b`)
+	goMainFuncPattern    = regexp.MustCompile(`(?m)^func main\(\)`)
+	pythonMainPattern    = regexp.MustCompile(`(?m)^if __name__ == ['"]__main__['"]\s*:`)
+)
+
+// IsEntrypoint reports whether a source file is a program entrypoint (a Go
+// main package's main function, or a Python script run as __main__). Unit
+// tests rarely reach these, so they are candidates for an integration harness.
+func IsEntrypoint(language, sourceFile, sourceCode string) bool {
+	switch language {
+	case "Go":
+		return goMainPackagePattern.MatchString(sourceCode) && goMainFuncPattern.MatchString(sourceCode)
+	case "Python":
+		return filepath.Base(sourceFile) == "__main__.py" || pythonMainPattern.MatchString(sourceCode)
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package testgen
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// includePattern matches quoted includes, which name project headers;
+// system headers use angle brackets
+var includePattern = regexp.MustCompile(`(?m)^\s*#\s*include\s*"([^"]+)"`)
+
+// includeDirs are the project directories searched for a quoted include
+// that isn't next to the including file
+var includeDirs = []string{".", "include", "src"}
+
+// includedHeaders returns the project headers a C/C++ source includes
+// directly, by project-relative path, so a test is written against their
+// declarations rather than guesses. Headers that can't be found, e.g.
+// generated ones, are skipped.
+func includedHeaders(projectPath, sourceFile, sourceCode string) map[string]string {
+	headers := make(map[string]string)
+	for _, match := range includePattern.FindAllStringSubmatch(sourceCode, -1) {
+		if len(headers) == maxContextFiles {
+			break
+		}
+
+		name := filepath.FromSlash(match[1])
+		candidates := []string{filepath.Join(filepath.Dir(sourceFile), name)}
+		for _, dir := range includeDirs {
+			candidates = append(candidates, filepath.Join(projectPath, dir, name))
+		}
+		for _, candidate := range candidates {
+			rel, err := filepath.Rel(projectPath, candidate)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			if content, err := os.ReadFile(candidate); err == nil {
+				headers[rel] = string(content)
+				break
+			}
+		}
+	}
+	return headers
+}
@@ -0,0 +1,252 @@
+package testgen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/astcache"
+)
+
+// errNoMinimizer is returned by minimizeDiff for languages without a parser here
+var errNoMinimizer = errors.New("diff minimization supports only Go, Java and Kotlin")
+
+// minimizeDiff post-processes a rewritten test file so that declarations the
+// model left unchanged keep their original text and position. New
+// declarations are appended at the end, which keeps git diffs limited to the
+// genuinely new or modified tests. Go files are merged by top-level
+// declaration and Java and Kotlin files by member of the test class. It fails
+// for other languages and for input that fails to parse.
+func minimizeDiff(language, original, rewritten string) (string, error) {
+	switch language {
+	case "Go":
+		return minimizeGoDiff(original, rewritten)
+	case "Java", "Kotlin":
+		return minimizeJVMDiff(language == "Kotlin", original, rewritten)
+	}
+	return "", errNoMinimizer
+}
+
+// minimize returns a rewritten test file with the unchanged declarations of
+// the original kept as they were, or the whole rewrite if it can't, saying
+// why: once per session for an unsupported language, and for each file that
+// fails to parse
+func (g *Generator) minimize(language, testFile, original, rewritten string) string {
+	merged, err := minimizeDiff(language, original, rewritten)
+	switch {
+	case err == nil:
+		return merged
+	case errors.Is(err, errNoMinimizer):
+		if !g.unminimized {
+			fmt.Printf("  Note: Rewritten %s test files are kept whole, since %v\n", language, err)
+			g.unminimized = true
+		}
+	default:
+		fmt.Printf("  Note: Keeping the whole rewrite of %s, which could not be merged: %v\n", testFile, err)
+	}
+	return rewritten
+}
+
+// goDecl is a top-level declaration with its source text
+type goDecl struct {
+	key        string
+	text       string
+	start, end int
+}
+
+// minimizeGoDiff merges a rewritten Go file into the original one declaration by declaration
+func minimizeGoDiff(original, rewritten string) (string, error) {
+	origFile, origDecls, err := parseGoDecls(original)
+	if err != nil {
+		return "", err
+	}
+	newFile, newDecls, err := parseGoDecls(rewritten)
+	if err != nil {
+		return "", err
+	}
+
+	if origFile.Name.Name != newFile.Name.Name {
+		return "", fmt.Errorf("package name changed")
+	}
+
+	newByKey := make(map[string]goDecl)
+	for _, d := range newDecls {
+		newByKey[d.key] = d
+	}
+	origKeys := make(map[string]bool)
+	for _, d := range origDecls {
+		origKeys[d.key] = true
+	}
+
+	type replacement struct {
+		start, end int
+		text       string
+	}
+	var replacements []replacement
+	importsReplaced := false
+
+	for _, d := range origDecls {
+		if d.key == extraImportKey {
+			continue
+		}
+		updated, ok := newByKey[d.key]
+		switch {
+		case !ok:
+			// Removed by the model
+			replacements = append(replacements, replacement{d.start, d.end, ""})
+			importsReplaced = importsReplaced || d.key == importKey
+		case normalizeGo(updated.text) != normalizeGo(d.text):
+			replacements = append(replacements, replacement{d.start, d.end, updated.text})
+			importsReplaced = importsReplaced || d.key == importKey
+		}
+	}
+
+	// Additional import blocks were folded into the first one
+	if importsReplaced {
+		for _, d := range origDecls {
+			if d.key == extraImportKey {
+				replacements = append(replacements, replacement{d.start, d.end, ""})
+			}
+		}
+	}
+
+	// Imports that did not exist before go right after the package clause
+	if imports, ok := newByKey[importKey]; ok && !origKeys[importKey] {
+		pkgEnd := origFile.Name.End() - origFile.FileStart
+		replacements = append(replacements, replacement{int(pkgEnd), int(pkgEnd), "\n\n" + imports.text})
+	}
+
+	// Apply replacements back to front so offsets stay valid
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].start > replacements[j].start
+	})
+	merged := original
+	for _, r := range replacements {
+		merged = merged[:r.start] + r.text + merged[r.end:]
+	}
+
+	// Append new declarations, including any free-floating comments before them
+	var added []string
+	prevEnd := int(newFile.Name.End() - newFile.FileStart)
+	for _, d := range newDecls {
+		if !origKeys[d.key] && d.key != importKey && d.key != extraImportKey {
+			added = append(added, strings.TrimSpace(rewritten[prevEnd:d.end]))
+		}
+		prevEnd = d.end
+	}
+	if len(added) > 0 {
+		merged = strings.TrimRight(merged, "\n") + "\n\n" + strings.Join(added, "\n\n") + "\n"
+	}
+
+	formatted, err := format.Source([]byte(merged))
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
+
+const (
+	importKey      = "import"
+	extraImportKey = "import#extra"
+)
+
+// parseGoDecls parses Go source and returns its top-level declarations keyed
+// by name. All import declarations are folded into a single entry.
+func parseGoDecls(src string) (*ast.File, []goDecl, error) {
+	parsed, err := astcache.Shared.ParseSource("", []byte(src))
+	if err != nil {
+		return nil, nil, err
+	}
+	fset, file := parsed.Fset, parsed.AST
+
+	var decls []goDecl
+	seen := make(map[string]int)
+	importIdx := -1
+
+	for _, decl := range file.Decls {
+		start := decl.Pos()
+		var key string
+
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			key = "func " + d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				key = fmt.Sprintf("method %s.%s", exprString(d.Recv.List[0].Type), d.Name.Name)
+			}
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			if d.Tok == token.IMPORT {
+				key = importKey
+			} else {
+				var names []string
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						names = append(names, s.Name.Name)
+					case *ast.ValueSpec:
+						for _, n := range s.Names {
+							names = append(names, n.Name)
+						}
+					}
+				}
+				key = d.Tok.String() + " " + strings.Join(names, ",")
+			}
+		}
+
+		startOff := fset.Position(start).Offset
+		endOff := fset.Position(decl.End()).Offset
+
+		if key == importKey && importIdx >= 0 {
+			decls[importIdx].text += "\n" + src[startOff:endOff]
+			decls = append(decls, goDecl{key: extraImportKey, start: startOff, end: endOff})
+			continue
+		}
+
+		// Disambiguate repeated keys such as multiple init funcs or blank vars
+		seen[key]++
+		if seen[key] > 1 {
+			key = fmt.Sprintf("%s#%d", key, seen[key])
+		}
+
+		decls = append(decls, goDecl{
+			key:   key,
+			text:  src[startOff:endOff],
+			start: startOff,
+			end:   endOff,
+		})
+		if key == importKey {
+			importIdx = len(decls) - 1
+		}
+	}
+
+	return file, decls, nil
+}
+
+// exprString renders a receiver type expression as a key component
+func exprString(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.StarExpr:
+		return "*" + exprString(e.X)
+	case *ast.IndexExpr:
+		return exprString(e.X)
+	case *ast.IndexListExpr:
+		return exprString(e.X)
+	}
+	return fmt.Sprintf("%T", expr)
+}
+
+// normalizeGo reduces Go source to a whitespace-insensitive form for comparison
+func normalizeGo(src string) string {
+	return strings.Join(strings.Fields(src), " ")
+}
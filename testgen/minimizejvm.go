@@ -0,0 +1,293 @@
+package testgen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// jvmMember is a member of a Java or Kotlin test class: a method, field,
+// nested class or initializer block with the comments and annotations
+// before it
+type jvmMember struct {
+	key  string
+	text string
+}
+
+// jvmClass is a source file split around its test class
+type jvmClass struct {
+	head    string // Package, imports and the class header up to its opening brace
+	members []jvmMember
+	tail    string // Whitespace and comments before the class's closing brace
+	rest    string // The closing brace and anything after it
+}
+
+var (
+	jvmTypePattern       = regexp.MustCompile(`\b(class|interface|enum|object|record)\s+(\w+)`)
+	jvmCompanionPattern  = regexp.MustCompile(`\bcompanion\s+object\b`)
+	jvmMethodPattern     = regexp.MustCompile(`(\w+)\s*\(`)
+	jvmPropertyPattern   = regexp.MustCompile(`\b(?:val|var)\s+(\w+)`)
+	jvmLastIdentPattern  = regexp.MustCompile(`(\w+)\s*$`)
+	jvmAnnotationPattern = regexp.MustCompile(`@[\w.]+(\s*\([^()]*\))?`)
+
+	// kotlinDeclStart matches the start of a line that begins a new Kotlin
+	// class member, which ends the one before it
+	kotlinDeclStart = regexp.MustCompile(`^\s*(@|(private|protected|internal|public|override|open|abstract|final|lateinit|inline|suspend|const|inner|data|companion|fun|val|var|class|object|init)\b)`)
+)
+
+// minimizeJVMDiff merges a rewritten Java or Kotlin test file into the
+// original one member of its test class at a time, the way minimizeGoDiff
+// does with top-level declarations
+func minimizeJVMDiff(kotlin bool, original, rewritten string) (string, error) {
+	orig, err := parseJVMClass(original, kotlin)
+	if err != nil {
+		return "", err
+	}
+	updated, err := parseJVMClass(rewritten, kotlin)
+	if err != nil {
+		return "", err
+	}
+
+	newByKey := make(map[string]jvmMember)
+	for _, m := range updated.members {
+		newByKey[m.key] = m
+	}
+	origKeys := make(map[string]bool)
+	for _, m := range orig.members {
+		origKeys[m.key] = true
+	}
+
+	var merged strings.Builder
+	head, rest := orig.head, orig.rest
+	if normalizeGo(updated.head) != normalizeGo(orig.head) {
+		head = updated.head // Imports or the class header changed
+	}
+	if normalizeGo(updated.rest) != normalizeGo(orig.rest) {
+		rest = updated.rest
+	}
+
+	merged.WriteString(head)
+	for _, m := range orig.members {
+		n, ok := newByKey[m.key]
+		switch {
+		case !ok:
+			// Removed by the model
+		case normalizeGo(n.text) != normalizeGo(m.text):
+			merged.WriteString(n.text)
+		default:
+			merged.WriteString(m.text)
+		}
+	}
+	for _, m := range updated.members {
+		if !origKeys[m.key] {
+			merged.WriteString(m.text)
+		}
+	}
+	merged.WriteString(orig.tail)
+	merged.WriteString(rest)
+
+	// The merge must still split into the rewritten file's members
+	check, err := parseJVMClass(merged.String(), kotlin)
+	if err != nil || len(check.members) != len(updated.members) {
+		return "", fmt.Errorf("merged test class does not match the rewritten one")
+	}
+	for _, m := range check.members {
+		if _, ok := newByKey[m.key]; !ok {
+			return "", fmt.Errorf("merged test class does not match the rewritten one")
+		}
+	}
+	return merged.String(), nil
+}
+
+// parseJVMClass splits a Java or Kotlin file around the members of its
+// first class. Braces are matched outside of comments, strings and
+// character literals; Java members end with a semicolon or a closing brace,
+// and Kotlin members also at the end of a line followed by another member.
+func parseJVMClass(src string, kotlin bool) (*jvmClass, error) {
+	mask := maskJVM(src)
+
+	// Find the opening brace of the first class, skipping other blocks
+	open, depth, segment := -1, 0, 0
+	for i := 0; i < len(mask) && open < 0; i++ {
+		switch mask[i] {
+		case '{':
+			if depth == 0 && jvmTypePattern.MatchString(mask[segment:i]) {
+				open = i
+			}
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				segment = i + 1
+			}
+		case ';':
+			if depth == 0 {
+				segment = i + 1
+			}
+		}
+	}
+	if open < 0 {
+		return nil, fmt.Errorf("no class found")
+	}
+
+	class := &jvmClass{head: src[:open+1]}
+	seen := make(map[string]int)
+	addMember := func(start, end int) {
+		key := jvmMemberKey(mask[start:end])
+		seen[key]++
+		if seen[key] > 1 {
+			key = fmt.Sprintf("%s#%d", key, seen[key])
+		}
+		class.members = append(class.members, jvmMember{key: key, text: src[start:end]})
+	}
+
+	start, depth, parens := open+1, 0, 0
+	for i := open + 1; i < len(mask); i++ {
+		switch c := mask[i]; {
+		case c == '(':
+			parens++
+		case c == ')':
+			parens--
+		case c == '{':
+			depth++
+		case c == '}' && depth == 0:
+			if strings.TrimSpace(mask[start:i]) != "" {
+				addMember(start, i) // A Kotlin member without a terminator
+				start = i
+			}
+			class.tail = src[start:i]
+			class.rest = src[i:]
+			return class, nil
+		case c == '}':
+			// Braces in annotation arguments don't end a member
+			if depth--; depth == 0 && parens == 0 {
+				end := i + 1
+				// Anonymous classes and array initializers end with "};"
+				if j := end + len(mask[end:]) - len(strings.TrimLeft(mask[end:], " \t\r\n")); j < len(mask) && mask[j] == ';' {
+					end = j + 1
+				}
+				addMember(start, end)
+				start, i = end, end-1
+			}
+		case c == ';' && depth == 0 && parens == 0:
+			addMember(start, i+1)
+			start = i + 1
+		case c == '\n' && kotlin && depth == 0 && parens == 0:
+			declared := strings.TrimSpace(jvmAnnotationPattern.ReplaceAllString(mask[start:i], ""))
+			if declared != "" && kotlinDeclStart.MatchString(nextLine(mask[i+1:])) {
+				addMember(start, i)
+				start = i
+			}
+		}
+	}
+	return nil, fmt.Errorf("unbalanced braces")
+}
+
+// jvmMemberKey identifies a class member by its kind and name, and methods
+// also by their parameters, so overloads are told apart
+func jvmMemberKey(mask string) string {
+	decl := strings.TrimSpace(jvmAnnotationPattern.ReplaceAllString(mask, ""))
+	header := decl
+	if i := strings.IndexByte(header, '{'); i >= 0 {
+		header = header[:i]
+	}
+
+	if m := jvmTypePattern.FindStringSubmatch(header); m != nil {
+		return "type " + m[2]
+	}
+	if jvmCompanionPattern.MatchString(header) {
+		return "type companion"
+	}
+
+	// A method's parameters come before any "=" of an expression body
+	if m := jvmMethodPattern.FindStringSubmatchIndex(header); m != nil && !strings.Contains(header[:m[0]], "=") {
+		params, depth := header[m[1]:], 0
+		for i := m[1] - 1; i < len(header); i++ {
+			if header[i] == '(' {
+				depth++
+			} else if header[i] == ')' {
+				if depth--; depth == 0 {
+					params = header[m[1]:i]
+					break
+				}
+			}
+		}
+		return fmt.Sprintf("method %s(%s)", header[m[2]:m[3]], normalizeGo(params))
+	}
+
+	if i := strings.IndexAny(header, "=;"); i >= 0 {
+		header = header[:i]
+	}
+	if m := jvmPropertyPattern.FindStringSubmatch(header); m != nil {
+		return "field " + m[1]
+	}
+	if m := jvmLastIdentPattern.FindStringSubmatch(strings.TrimSpace(header)); m != nil {
+		if m[1] == "static" || m[1] == "init" {
+			return "block " + m[1]
+		}
+		return "field " + m[1]
+	}
+	return "block"
+}
+
+// maskJVM blanks out the comments, string and character literals of Java
+// or Kotlin source, keeping offsets and line breaks, so braces and
+// semicolons in them don't count
+func maskJVM(src string) string {
+	mask := []byte(src)
+	blank := func(from, to int) {
+		for i := from; i < to && i < len(mask); i++ {
+			if mask[i] != '\n' {
+				mask[i] = ' '
+			}
+		}
+	}
+
+	for i := 0; i < len(src); i++ {
+		switch {
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			blank(i, i+end)
+			i += end - 1
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				end = len(src) - i - 4
+			}
+			blank(i, i+end+4)
+			i += end + 3
+		case strings.HasPrefix(src[i:], `"""`):
+			end := strings.Index(src[i+3:], `"""`)
+			if end < 0 {
+				end = len(src) - i - 6
+			}
+			blank(i, i+end+6)
+			i += end + 5
+		case src[i] == '"' || src[i] == '\'':
+			quote := src[i]
+			j := i + 1
+			for j < len(src) && src[j] != quote && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			blank(i, j+1)
+			i = j
+		}
+	}
+	return string(mask)
+}
+
+// nextLine returns the first line of s that isn't blank
+func nextLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) != "" {
+			return line
+		}
+	}
+	return ""
+}
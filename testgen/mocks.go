@@ -0,0 +1,230 @@
+package testgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/astcache"
+)
+
+// Mocks describes the generated mocks for a source file's interface dependencies
+type Mocks struct {
+	Tool       string   // "mockgen" or "mockery"
+	Interfaces []string // Interfaces the source file depends on
+	Files      []string // Mock files, created or reused
+	Created    []string // Subset of Files created in this call
+
+	root string // Project the mocks were prepared in
+}
+
+var mockConstructorPattern = regexp.MustCompile(`(?m)^func (New\w+)\(`)
+
+// PrepareMocks finds the interfaces declared in a Go source file's package
+// that its functions and structs depend on, and generates mocks for them
+// with mockgen (preferred) or mockery. Existing mock files are reused. It
+// returns nil if the file has no interface dependencies.
+func (g *Generator) PrepareMocks(projectPath, sourceFile string) (*Mocks, error) {
+	if g.analyzer.GetLanguageName() != "Go" {
+		return nil, nil
+	}
+
+	parsed, err := astcache.Shared.ParseFile(sourceFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", sourceFile, err)
+	}
+	file := parsed.AST
+
+	pkgDir := filepath.Dir(sourceFile)
+	pkgName := file.Name.Name
+	declaredIn, err := packageInterfaces(pkgDir, pkgName)
+	if err != nil {
+		return nil, err
+	}
+
+	deps := interfaceDeps(file, declaredIn)
+	if len(deps) == 0 {
+		return nil, nil
+	}
+
+	mocks := &Mocks{Interfaces: deps, root: projectPath}
+	if _, err := exec.LookPath("mockgen"); err == nil {
+		mocks.Tool = "mockgen"
+	} else if _, err := exec.LookPath("mockery"); err == nil {
+		mocks.Tool = "mockery"
+	} else {
+		return nil, fmt.Errorf("interfaces %s need mocks, but neither mockgen nor mockery is installed", strings.Join(deps, ", "))
+	}
+
+	// mockgen works per declaring file, mockery per interface
+	done := make(map[string]bool)
+	for _, iface := range deps {
+		var mockFile string
+		var cmd *exec.Cmd
+
+		switch mocks.Tool {
+		case "mockgen":
+			source := declaredIn[iface]
+			if done[source] {
+				continue
+			}
+			done[source] = true
+
+			base := strings.TrimSuffix(filepath.Base(source), ".go")
+			mockFile = filepath.Join(pkgDir, "mock_"+base+"_test.go")
+			args := []string{"-source=" + filepath.Base(source), "-destination=" + filepath.Base(mockFile), "-package=" + pkgName}
+			if importPath := goImportPath(pkgDir); importPath != "" {
+				// Mocks live in the package itself, so it must not import itself
+				args = append(args, "-self_package="+importPath)
+			}
+			cmd = exec.Command("mockgen", args...)
+
+		case "mockery":
+			mockFile = filepath.Join(pkgDir, "mock_"+iface+"_test.go")
+			cmd = exec.Command("mockery", "--name="+iface, "--inpackage", "--testonly", "--output=.", "--dir=.")
+		}
+
+		mocks.Files = append(mocks.Files, mockFile)
+		if fileExists(mockFile) {
+			continue
+		}
+
+		cmd.Dir = pkgDir
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("failed to generate mocks with %s: %s", mocks.Tool, stderr.String())
+		}
+		if !fileExists(mockFile) {
+			return nil, fmt.Errorf("%s did not create the expected mock file %s", mocks.Tool, mockFile)
+		}
+		mocks.Created = append(mocks.Created, mockFile)
+	}
+
+	g.mocks[sourceKey(projectPath, sourceFile)] = mocks
+	return mocks, nil
+}
+
+// describe summarizes the mocks for a prompt: the files and the constructors they provide
+func (m *Mocks) describe() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Interfaces: %s", strings.Join(m.Interfaces, ", ")))
+
+	for _, file := range m.Files {
+		relPath, _ := filepath.Rel(m.root, file)
+		lines = append(lines, fmt.Sprintf("Mock file: %s", relPath))
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, match := range mockConstructorPattern.FindAllStringSubmatch(string(content), -1) {
+			lines = append(lines, fmt.Sprintf("  constructor: %s", match[1]))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// packageInterfaces parses the non-test files of a package and returns the
+// file declaring each interface type
+func packageInterfaces(pkgDir, pkgName string) (map[string]string, error) {
+	files, err := filepath.Glob(filepath.Join(pkgDir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	declaredIn := make(map[string]string)
+	for _, filename := range files {
+		if strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+
+		parsed, err := astcache.Shared.ParseFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+		}
+		file := parsed.AST
+		if file.Name.Name != pkgName {
+			continue
+		}
+
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				if _, ok := typeSpec.Type.(*ast.InterfaceType); ok {
+					declaredIn[typeSpec.Name.Name] = filename
+				}
+			}
+		}
+	}
+
+	return declaredIn, nil
+}
+
+// interfaceDeps returns the package interfaces used in function signatures
+// and struct fields of a parsed source file
+func interfaceDeps(file *ast.File, declaredIn map[string]string) []string {
+	found := make(map[string]bool)
+	collect := func(fields *ast.FieldList) {
+		if fields == nil {
+			return
+		}
+		for _, field := range fields.List {
+			ast.Inspect(field.Type, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Ident); ok {
+					if _, isInterface := declaredIn[ident.Name]; isInterface {
+						found[ident.Name] = true
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncType:
+			collect(node.Params)
+		case *ast.StructType:
+			collect(node.Fields)
+		}
+		return true
+	})
+
+	deps := make([]string, 0, len(found))
+	for name := range found {
+		deps = append(deps, name)
+	}
+	sort.Strings(deps)
+
+	return deps
+}
+
+// goImportPath returns the import path of the package in dir, or "" if it can't be determined
+func goImportPath(dir string) string {
+	cmd := exec.Command("go", "list", "-f", "{{.ImportPath}}", ".")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// fileExists checks if a file exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
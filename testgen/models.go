@@ -0,0 +1,61 @@
+package testgen
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/claude"
+	"github.com/tablev/test-coverage-agent/coverage"
+)
+
+// modelFor returns the model for a source file: the model of the most
+// specific (longest) matching pattern in Options.Models, or "" for the
+// client's default model
+func (g *Generator) modelFor(projectPath, sourceFile string) string {
+	if len(g.options.Models) == 0 {
+		return ""
+	}
+
+	relPath := sourceFile
+	if rel, err := filepath.Rel(projectPath, sourceFile); err == nil && !strings.HasPrefix(rel, "..") {
+		relPath = rel
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	best := ""
+	for pattern := range g.options.Models {
+		if !coverage.MatchGlob(pattern, relPath) {
+			continue
+		}
+		if len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return g.options.Models[best]
+}
+
+// ModelFor returns the model ID that writes the tests of a source file
+func (g *Generator) ModelFor(projectPath, sourceFile string) string {
+	if model := g.modelFor(projectPath, sourceFile); model != "" {
+		return claude.ResolveModel(model)
+	}
+	return g.client.Model()
+}
+
+// models returns the distinct models configured for files other than the
+// default model, for checking access up front
+func (g *Generator) models() []string {
+	seen := map[string]bool{g.client.Model(): true}
+	var models []string
+	for _, model := range g.options.Models {
+		model = claude.ResolveModel(model)
+		if !seen[model] {
+			seen[model] = true
+			models = append(models, model)
+		}
+	}
+	return models
+}
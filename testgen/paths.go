@@ -0,0 +1,60 @@
+package testgen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/errdefs"
+)
+
+// CheckPath returns an ErrOutsideProject error unless a file to be written
+// resolves inside the project directory. Paths are resolved the way writing
+// them would: relative paths against the working directory, through ".."
+// elements and symlinked directories. Test file paths come from analyzers,
+// including external plugins, so they aren't trusted.
+func CheckPath(projectPath, path string) error {
+	root, err := resolvePath(projectPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve project directory: %w", err)
+	}
+	target, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return fmt.Errorf("%w: %s resolves to %s", errdefs.ErrOutsideProject, path, target)
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path of a file with the symlinks of its
+// existing ancestors evaluated; the file itself need not exist
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	existing, missing := abs, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return abs, nil
+		}
+		missing = filepath.Join(filepath.Base(existing), missing)
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, missing), nil
+}
@@ -0,0 +1,126 @@
+package testgen
+
+import (
+	"regexp"
+
+	"github.com/tablev/test-coverage-agent/config"
+)
+
+// qualityPatterns holds the regexes used to measure a language's tests
+type qualityPatterns struct {
+	tests      *regexp.Regexp
+	assertions *regexp.Regexp
+	mocks      *regexp.Regexp
+	functions  *regexp.Regexp // Function declarations in source code; the last non-empty group is the name
+}
+
+var languageQualityPatterns = map[string]qualityPatterns{
+	"Go": {
+		tests:      regexp.MustCompile(`(?m)^func Test\w*\(`),
+		assertions: regexp.MustCompile(`\bt\.(Error|Errorf|Fatal|Fatalf)\(|\b(assert|require)\.\w+\(`),
+		mocks:      regexp.MustCompile(`\.EXPECT\(\)|\.On\("|\bgomock\.NewController\(|\bMock[A-Z]\w*\{`),
+		functions:  regexp.MustCompile(`(?m)^func\s+(?:\([^)]*\)\s*)?(\w+)\s*[\[(]`),
+	},
+	"Python": {
+		tests:      regexp.MustCompile(`(?m)^\s*def test_\w*\(`),
+		assertions: regexp.MustCompile(`(?m)^\s*assert\b|self\.assert\w+\(|pytest\.raises\(`),
+		mocks:      regexp.MustCompile(`\b(Mock|MagicMock|AsyncMock)\(|\bpatch(\.object)?\(|\bmocker\.`),
+		functions:  regexp.MustCompile(`(?m)^\s*(?:async\s+)?def\s+(\w+)\s*\(`),
+	},
+	"TypeScript": {
+		tests:      regexp.MustCompile(`\b(it|test)\(`),
+		assertions: regexp.MustCompile(`\bexpect\(|\bassert\.\w+\(`),
+		mocks:      regexp.MustCompile(`\b(jest|vi)\.(fn|mock|spyOn)\(|\bsinon\.`),
+		functions:  regexp.MustCompile(`(?m)function\s+(\w+)|(\w+)\s*=\s*(?:async\s*)?\([^)]*\)\s*=>|^\s+(?:async\s+)?(\w+)\s*\([^)]*\)\s*(?::\s*[^{]+)?\{`),
+	},
+	"Java": {
+		tests:      regexp.MustCompile(`@Test\b`),
+		assertions: regexp.MustCompile(`\bassert\w*\(|\bverify\(`),
+		mocks:      regexp.MustCompile(`\bmock\(|@Mock\b|\bwhen\(`),
+		functions:  regexp.MustCompile(`(?m)^\s*(?:(?:public|protected|private|static|final|synchronized|abstract)\s+)+[\w<>\[\], ?]+\s+(\w+)\s*\(`),
+	},
+	"Kotlin": {
+		tests:      regexp.MustCompile(`@Test\b`),
+		assertions: regexp.MustCompile(`\bassert\w*\(|\bverify\s*[({]|\bshould\w+\b`),
+		mocks:      regexp.MustCompile(`\bmockk\b|@MockK\b|\bevery\s*\{|\bmock<|@Mock\b`),
+		functions:  regexp.MustCompile(`\bfun\s+(?:<[^>]*>\s*)?(?:[\w.<>?, ]+\.)?(\w+)\s*\(`),
+	},
+	"Scala": {
+		tests:      regexp.MustCompile(`\btest\(\s*"|"\s+in\s*\{`),
+		assertions: regexp.MustCompile(`\bassert\w*[(\[]|\bshould(Be|Equal)?\b|\bmust(Be|Equal)?\b|\bintercept\[`),
+		mocks:      regexp.MustCompile(`\bmock\[|\bstub\[|\bwhen\(|\bMockito\.`),
+		functions:  regexp.MustCompile(`\bdef\s+(\w+)`),
+	},
+	"C++": {
+		tests:      regexp.MustCompile(`\b(TEST|TEST_F|TEST_P|TEST_CASE)\(`),
+		assertions: regexp.MustCompile(`\b(EXPECT|ASSERT)_\w+\(|\b(REQUIRE|CHECK)(_\w+)?\(`),
+		mocks:      regexp.MustCompile(`\bMOCK_(CONST_)?METHOD\w*\(|\bEXPECT_CALL\(`),
+		functions:  regexp.MustCompile(`(?m)^(?:[\w:<>,*&]+\s+)+[*&]?(?:\w+::)*(\w+)\s*\([^;{)]*\)\s*(?:const\s*)?(?:noexcept\s*)?\{`),
+	},
+	"Elixir": {
+		tests:      regexp.MustCompile(`(?m)^\s*test\s+"`),
+		assertions: regexp.MustCompile(`\b(assert|refute)(_\w+)?\b`),
+		mocks:      regexp.MustCompile(`\bMox\.|\bexpect\(|\bstub\(`),
+		functions:  regexp.MustCompile(`(?m)^\s*defp?\s+(\w+[?!]?)`),
+	},
+	"Dart": {
+		tests:      regexp.MustCompile(`(?m)^\s*(test|testWidgets)\(`),
+		assertions: regexp.MustCompile(`\bexpect(Later|Async)?\(|\bfail\(`),
+		mocks:      regexp.MustCompile(`\bwhen\(|\bverify(Never)?\(|\bMock[A-Z]\w*\(|\bextends\s+Mock\b`),
+		functions:  regexp.MustCompile(`(?m)^\s*(?:static\s+)?(?:Future<[^>]*>|Stream<[^>]*>|[\w<>?,\s]+?)\s+(\w+)\s*\([^;]*\)\s*(?:async\s*)?(?:\{|=>)`),
+	},
+	"Swift": {
+		tests:      regexp.MustCompile(`\bfunc test\w*\(`),
+		assertions: regexp.MustCompile(`\bXCTAssert\w*\(|\bXCTFail\(`),
+		mocks:      regexp.MustCompile(`\bMock[A-Z]\w*\(`),
+		functions:  regexp.MustCompile(`\bfunc\s+(\w+)\s*[<(]`),
+	},
+}
+
+// Names that look like function declarations to the regexes but aren't
+var notFunctions = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "catch": true,
+	"return": true, "main": true, "init": true, "constructor": true,
+}
+
+// MeasureQuality computes simple quality metrics for a test file: how many
+// tests and assertions it has, how much it relies on mocks, and how many of
+// the source file's functions it calls
+func MeasureQuality(language, testCode, sourceCode string) config.TestQuality {
+	if language == "JavaScript" {
+		language = "TypeScript"
+	}
+
+	patterns, ok := languageQualityPatterns[language]
+	if !ok {
+		return config.TestQuality{}
+	}
+
+	quality := config.TestQuality{
+		Tests:      len(patterns.tests.FindAllStringIndex(testCode, -1)),
+		Assertions: len(patterns.assertions.FindAllStringIndex(testCode, -1)),
+		Mocks:      len(patterns.mocks.FindAllStringIndex(testCode, -1)),
+	}
+
+	seen := make(map[string]bool)
+	for _, match := range patterns.functions.FindAllStringSubmatch(sourceCode, -1) {
+		name := ""
+		for _, group := range match[1:] {
+			if group != "" {
+				name = group
+			}
+		}
+		if name == "" || notFunctions[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		called := regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\s*[\[(<]`)
+		if called.MatchString(testCode) {
+			quality.FunctionsExercised++
+		}
+	}
+	quality.FunctionsTotal = len(seen)
+
+	return quality
+}
@@ -0,0 +1,128 @@
+package testgen
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// quarantineMarker is included in every skip annotation so quarantined
+// tests are easy to find and are never marked twice
+const quarantineMarker = "quarantined by test-coverage-agent"
+
+var (
+	goTestFuncPattern   = regexp.MustCompile(`(?m)^func (Test\w*)\((\w+) \*testing\.T\) \{\n`)
+	javaClassPattern    = regexp.MustCompile(`(?m)^(public\s+|internal\s+)?(final\s+|open\s+)?class\s+\w+`)
+	javaPackagePattern  = regexp.MustCompile(`(?m)^package\s+[\w.]+;?\n`)
+	tsTopLevelPattern   = regexp.MustCompile(`(?m)^(describe|it|test)\(`)
+	pythonFuturePattern = regexp.MustCompile(`(?m)^from __future__ import .*\n`)
+	gtestPattern        = regexp.MustCompile(`(?m)^(\s*TEST(?:_F|_P)?\(\s*\w+\s*,\s*)(\w+\s*\))`)
+	dartLibraryPattern  = regexp.MustCompile(`(?m)^library\b[^;]*;`)
+	exUnitCasePattern   = regexp.MustCompile(`(?m)^(\s*)use ExUnit\.Case\b.*\n`)
+	junitImportPattern  = regexp.MustCompile(`(?m)^import\s+(static\s+)?org\.junit\.`)
+	jupiterPattern      = regexp.MustCompile(`(?m)^import\s+(static\s+)?org\.junit\.jupiter\.`)
+)
+
+// Quarantine marks every test in a test file as skipped (Go, Java, Kotlin,
+// Scala, TypeScript, Elixir, Dart), disabled (GoogleTest) or expected-to-fail (Python), leaving the tests in place for
+// a human to investigate. It returns an error for unsupported languages and
+// when it finds no tests to mark, so nothing is recorded as quarantined that
+// still runs.
+func Quarantine(language, testFile, reason string) error {
+	content, err := os.ReadFile(testFile)
+	if err != nil {
+		return fmt.Errorf("failed to read test file: %w", err)
+	}
+
+	code := string(content)
+	if strings.Contains(code, quarantineMarker) {
+		return nil
+	}
+
+	note := fmt.Sprintf("%s: %s", quarantineMarker, reason)
+
+	var marked string
+	switch language {
+	case "Go":
+		if !goTestFuncPattern.MatchString(code) {
+			return fmt.Errorf("no test functions found in %s", testFile)
+		}
+		marked = goTestFuncPattern.ReplaceAllString(code, fmt.Sprintf("${0}\t${2}.Skip(%q)\n", note))
+
+	case "Python":
+		header := fmt.Sprintf("import pytest\n\npytestmark = pytest.mark.xfail(reason=%q, strict=False)\n\n", note)
+		if loc := pythonFuturePattern.FindStringIndex(code); loc != nil {
+			marked = code[:loc[1]] + header + code[loc[1]:]
+		} else {
+			marked = header + code
+		}
+
+	case "Java", "Kotlin", "Scala":
+		loc := javaClassPattern.FindStringIndex(code)
+		if loc == nil {
+			return fmt.Errorf("no test class found in %s", testFile)
+		}
+		// JUnit 4 ignores tests with @Ignore, JUnit 5 with @Disabled
+		annotation := fmt.Sprintf("@Disabled(%q)\n", note)
+		importLine := "import org.junit.jupiter.api.Disabled;\n"
+		if junitImportPattern.MatchString(code) && !jupiterPattern.MatchString(code) {
+			annotation = fmt.Sprintf("@Ignore(%q)\n", note)
+			importLine = "import org.junit.Ignore;\n"
+		}
+		switch language {
+		case "Kotlin":
+			importLine = strings.TrimSuffix(importLine, ";\n") + "\n"
+		case "Scala":
+			// ScalaTest's @Ignore takes no reason
+			annotation = fmt.Sprintf("// %s\n@Ignore\n", note)
+			importLine = "import org.scalatest.Ignore\n"
+		}
+		marked = code[:loc[0]] + annotation + code[loc[0]:]
+		if pkg := javaPackagePattern.FindStringIndex(marked); pkg != nil {
+			marked = marked[:pkg[1]] + "\n" + importLine + marked[pkg[1]:]
+		} else {
+			marked = importLine + marked
+		}
+
+	case "C++":
+		// GoogleTest skips tests whose name starts with DISABLED_
+		if !gtestPattern.MatchString(code) {
+			return fmt.Errorf("no GoogleTest tests found in %s", testFile)
+		}
+		marked = fmt.Sprintf("// %s\n", note) + gtestPattern.ReplaceAllString(code, "${1}DISABLED_${2}")
+
+	case "Elixir":
+		// @moduletag :skip skips every test in the module
+		loc := exUnitCasePattern.FindStringSubmatchIndex(code)
+		if loc == nil {
+			return fmt.Errorf("no ExUnit test module found in %s", testFile)
+		}
+		indent := code[loc[2]:loc[3]]
+		marked = code[:loc[1]] + fmt.Sprintf("%s# %s\n%s@moduletag :skip\n", indent, note, indent) + code[loc[1]:]
+
+	case "Dart":
+		// A @Skip annotation on the library skips every test in the file
+		annotation := fmt.Sprintf("@Skip(%q)\n", note)
+		if loc := dartLibraryPattern.FindStringIndex(code); loc != nil {
+			marked = code[:loc[0]] + annotation + code[loc[0]:]
+		} else {
+			marked = annotation + "library;\n\n" + code
+		}
+
+	case "TypeScript", "JavaScript":
+		if !tsTopLevelPattern.MatchString(code) {
+			return fmt.Errorf("no top-level describe, it or test found in %s", testFile)
+		}
+		marked = fmt.Sprintf("// %s\n", note) + tsTopLevelPattern.ReplaceAllString(code, "$1.skip(")
+
+	default:
+		return fmt.Errorf("quarantine is not supported for %s", language)
+	}
+
+	if err := os.WriteFile(testFile, []byte(marked), 0644); err != nil {
+		return fmt.Errorf("failed to write quarantined test file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package testgen
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tablev/test-coverage-agent/cache"
+)
+
+// Directories that are not copied into a sandbox wherever they are:
+// version control, agent artifacts and tool caches that never hold sources
+var sandboxSkipDirs = map[string]bool{
+	".git": true, cache.DirName: true, ".gradle": true, "__pycache__": true,
+}
+
+// Build output directories that the sandbox regenerates on its own. They
+// are only skipped at the project root, since packages deeper in the tree
+// may have the same names, e.g. internal/build.
+var sandboxOutputDirs = map[string]bool{
+	"target": true, "build": true, "dist": true, ".build": true,
+}
+
+// Dependency directories that are only read during test runs, so a sandbox
+// links to them instead of copying them
+var sandboxLinkDirs = map[string]bool{
+	"node_modules": true, "vendor": true, ".venv": true, "venv": true,
+}
+
+// Sandbox is a temporary copy of a project used to validate a risky change
+// before it touches the real checkout. Each sandbox has its own directory,
+// so several can be used at once.
+type Sandbox struct {
+	projectPath string
+	root        string
+}
+
+// NewSandbox copies a project into a new temporary directory
+func NewSandbox(projectPath string) (*Sandbox, error) {
+	absProject, err := filepath.Abs(projectPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve project path: %w", err)
+	}
+
+	root, err := os.MkdirTemp("", "coverage-agent-sandbox-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox: %w", err)
+	}
+
+	sb := &Sandbox{projectPath: absProject, root: root}
+	if err := sb.copyProject(); err != nil {
+		sb.Remove()
+		return nil, fmt.Errorf("failed to copy project into sandbox: %w", err)
+	}
+
+	return sb, nil
+}
+
+// Root returns the sandbox copy of the project root
+func (s *Sandbox) Root() string {
+	return s.root
+}
+
+// Path maps a project file (absolute, or relative to the project root) to
+// its absolute path in the sandbox
+func (s *Sandbox) Path(file string) string {
+	if filepath.IsAbs(file) {
+		if rel, err := filepath.Rel(s.projectPath, file); err == nil {
+			file = rel
+		}
+	}
+	return filepath.Join(s.root, file)
+}
+
+// Remove deletes the sandbox
+func (s *Sandbox) Remove() error {
+	return os.RemoveAll(s.root)
+}
+
+// copyProject copies the project tree into the sandbox, linking dependency
+// directories and skipping build output
+func (s *Sandbox) copyProject() error {
+	return filepath.WalkDir(s.projectPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.projectPath, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(s.root, rel)
+
+		if d.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			// rel only equals an output directory's name at the root
+			if sandboxSkipDirs[d.Name()] || sandboxOutputDirs[rel] {
+				return filepath.SkipDir
+			}
+			if sandboxLinkDirs[d.Name()] {
+				if err := os.Symlink(path, target); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyRegularFile(path, target)
+	})
+}
+
+// copyRegularFile copies src to dst, keeping the file mode
+func copyRegularFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,78 @@
+package testgen
+
+import (
+	"regexp"
+	"strings"
+)
+
+// testDeclPatterns find test declarations in languages whose analyzers can
+// run individual tests; the first group is the test name
+var testDeclPatterns = map[string]*regexp.Regexp{
+	"Go":     regexp.MustCompile(`(?m)^func (Test\w*)\(\w+ \*testing\.T\)`),
+	"Java":   regexp.MustCompile(`(?s)@Test\b.*?\bvoid\s+(\w+)\s*\(`),
+	"Kotlin": regexp.MustCompile(`(?s)@Test\b.*?\bfun\s+(\w+)\s*\(`),
+}
+
+// ChangedTests returns the tests in a test file that are new or changed
+// compared to its earlier content. It returns nil, meaning all tests should
+// run, if the language isn't supported, no tests were found, or code outside
+// the tests (imports, setup) changed.
+func ChangedTests(language, before, after string) []string {
+	pattern, ok := testDeclPatterns[language]
+	if !ok {
+		return nil
+	}
+
+	beforePreamble, beforeTests := splitTests(pattern, before)
+	afterPreamble, afterTests := splitTests(pattern, after)
+	if len(afterTests) == 0 {
+		return nil
+	}
+	if len(beforeTests) > 0 && strings.TrimSpace(beforePreamble) != strings.TrimSpace(afterPreamble) {
+		return nil
+	}
+
+	previous := make(map[string]string, len(beforeTests))
+	for _, test := range beforeTests {
+		previous[test.name] = test.body
+	}
+
+	var changed []string
+	for _, test := range afterTests {
+		if body, ok := previous[test.name]; !ok || body != test.body {
+			changed = append(changed, test.name)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return changed
+}
+
+// testSegment is a test and the code that follows it up to the next test
+type testSegment struct {
+	name string
+	body string
+}
+
+// splitTests splits test code into the preamble before the first test and
+// one segment per test, in order
+func splitTests(pattern *regexp.Regexp, code string) (string, []testSegment) {
+	matches := pattern.FindAllStringSubmatchIndex(code, -1)
+	if len(matches) == 0 {
+		return code, nil
+	}
+
+	segments := make([]testSegment, 0, len(matches))
+	for i, m := range matches {
+		end := len(code)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		segments = append(segments, testSegment{
+			name: code[m[2]:m[3]],
+			body: strings.TrimSpace(code[m[0]:end]),
+		})
+	}
+	return code[:matches[0][0]], segments
+}
@@ -1,25 +1,39 @@
 package testgen
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/tablev/test-coverage-agent/coverage"
+	"github.com/tablev/test-coverage-agent/errdefs"
 )
 
 // Validator validates generated tests
 type Validator struct {
 	analyzer coverage.Analyzer
+
+	// baselines holds test file contents from before generation, so
+	// validation can run only new and changed tests
+	baselines map[string]string
 }
 
 // NewValidator creates a new test validator
 func NewValidator(analyzer coverage.Analyzer) *Validator {
 	return &Validator{
-		analyzer: analyzer,
+		analyzer:  analyzer,
+		baselines: make(map[string]string),
 	}
 }
 
+// SetBaseline records a test file's content before it is generated or
+// improved ("" for a new file). Later validations of the file run only the
+// tests that were added or changed since, where the analyzer supports it.
+func (v *Validator) SetBaseline(testFile, content string) {
+	v.baselines[testFile] = content
+}
+
 // ValidationResult represents the result of test validation
 type ValidationResult struct {
 	Success        bool
@@ -29,6 +43,16 @@ type ValidationResult struct {
 	ErrorMessage   string
 	FailedTests    []string
 	CoverageGained float64
+	Retries        int // Fix attempts made by ValidateAndRetry
+}
+
+// Err returns nil for a successful validation, or an error matching
+// errdefs.ErrValidationFailed with the reason the test failed
+func (r *ValidationResult) Err() error {
+	if r.Success {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errdefs.ErrValidationFailed, r.ErrorMessage)
 }
 
 // ValidateTest validates a test file
@@ -39,8 +63,22 @@ func (v *Validator) ValidateTest(projectPath, testFile string) (*ValidationResul
 		TestsPassed:   false,
 	}
 
+	// A cheap compile check catches syntax and type errors without paying
+	// for a test run; its output goes straight to the fix prompt
+	if compiler, ok := v.analyzer.(coverage.Compiler); ok {
+		compiled, output, err := compiler.CompileTestFile(projectPath, testFile)
+		if err != nil {
+			return nil, fmt.Errorf("compile check error: %w", err)
+		}
+		if !compiled {
+			result.Output = output
+			result.ErrorMessage = "Compilation failed"
+			return result, nil
+		}
+	}
+
 	// Validate the test file (compile and run)
-	success, output, err := v.analyzer.ValidateTestFile(projectPath, testFile)
+	success, output, err := v.runTests(projectPath, testFile)
 	if err != nil {
 		return nil, fmt.Errorf("validation error: %w", err)
 	}
@@ -71,20 +109,48 @@ func (v *Validator) ValidateTest(projectPath, testFile string) (*ValidationResul
 	return result, nil
 }
 
-// ValidateAndRetry validates a test and retries if it fails
-func (v *Validator) ValidateAndRetry(projectPath, testFile string, generator *Generator, maxRetries int) (*ValidationResult, error) {
+// runTests validates a test file, running only its new and changed tests if
+// a baseline is known and the analyzer can select tests
+func (v *Validator) runTests(projectPath, testFile string) (bool, string, error) {
+	selector, ok := v.analyzer.(coverage.TestSelector)
+	baseline, known := v.baselines[testFile]
+	if !ok || !known {
+		return v.analyzer.ValidateTestFile(projectPath, testFile)
+	}
+
+	current, err := v.readFile(testFile)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read test file: %w", err)
+	}
+
+	tests := ChangedTests(v.analyzer.GetLanguageName(), baseline, current)
+	if len(tests) == 0 {
+		return v.analyzer.ValidateTestFile(projectPath, testFile)
+	}
+
+	fmt.Printf("  Running %d new or changed test(s)\n", len(tests))
+	return selector.RunSelectedTests(projectPath, testFile, tests)
+}
+
+// ValidateAndRetry validates a test and retries if it fails. It stops
+// before the next fix attempt once ctx is done and returns ctx's error.
+func (v *Validator) ValidateAndRetry(ctx context.Context, projectPath, testFile string, generator *Generator, maxRetries int) (*ValidationResult, error) {
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		result, err := v.ValidateTest(projectPath, testFile)
 		if err != nil {
 			return nil, err
 		}
 
+		result.Retries = attempt
 		if result.Success {
 			return result, nil
 		}
 
 		// If not the last attempt, try to fix
 		if attempt < maxRetries {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
 			fmt.Printf("  Test validation failed (attempt %d/%d), attempting to fix...\n", attempt+1, maxRetries+1)
 
 			// Try to fix the test
@@ -97,9 +163,27 @@ func (v *Validator) ValidateAndRetry(projectPath, testFile string, generator *Ge
 
 	// Return last result if all retries failed
 	result, err := v.ValidateTest(projectPath, testFile)
+	if result != nil {
+		result.Retries = maxRetries
+	}
 	return result, err
 }
 
+// CheckFlaky re-runs a passing test file several times. It reports the test
+// as flaky if any run fails, along with the output of the failing run.
+func (v *Validator) CheckFlaky(projectPath, testFile string, runs int) (bool, string, error) {
+	for i := 0; i < runs; i++ {
+		success, output, err := v.analyzer.RunTests(projectPath, testFile)
+		if err != nil {
+			return false, "", fmt.Errorf("flakiness check error: %w", err)
+		}
+		if !success {
+			return true, output, nil
+		}
+	}
+	return false, "", nil
+}
+
 // extractFailedTests extracts names of failed tests from output
 func (v *Validator) extractFailedTests(output string) []string {
 	var failed []string
@@ -158,11 +242,31 @@ func (v *Validator) IsTestFileValid(testFile string, language string) bool {
 			(strings.Contains(content, "expect(") ||
 				strings.Contains(content, "assert"))
 
-	case "Java":
+	case "Java", "Kotlin":
 		return strings.Contains(content, "@Test") &&
 			(strings.Contains(content, "import org.junit") ||
 				strings.Contains(content, "import org.testng"))
 
+	case "Scala":
+		return strings.Contains(content, "org.scalatest") ||
+			strings.Contains(content, "munit") ||
+			strings.Contains(content, "org.specs2")
+
+	case "C++":
+		return strings.Contains(content, "TEST(") ||
+			strings.Contains(content, "TEST_F(") ||
+			strings.Contains(content, "TEST_CASE(")
+
+	case "Elixir":
+		return strings.Contains(content, "use ExUnit.Case") &&
+			strings.Contains(content, "test \"")
+
+	case "Dart":
+		return (strings.Contains(content, "package:test/") ||
+			strings.Contains(content, "package:flutter_test/")) &&
+			(strings.Contains(content, "test(") ||
+				strings.Contains(content, "testWidgets("))
+
 	case "Swift":
 		return strings.Contains(content, "XCTestCase") &&
 			strings.Contains(content, "func test")
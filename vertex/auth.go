@@ -0,0 +1,256 @@
+package vertex
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CredentialsEnv names a credentials file, taking precedence over the
+	// gcloud application default credentials
+	CredentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+
+	tokenURL    = "https://oauth2.googleapis.com/token"
+	metadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	scope       = "https://www.googleapis.com/auth/cloud-platform"
+
+	// tokenSlack renews tokens this long before they expire
+	tokenSlack = time.Minute
+)
+
+// credentials is a Google credentials file: a service account key, or the
+// user credentials written by `gcloud auth application-default login`
+type credentials struct {
+	Type string `json:"type"`
+
+	// Service accounts
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	ProjectID    string `json:"project_id"`
+
+	// Authorized users
+	ClientID       string `json:"client_id"`
+	ClientSecret   string `json:"client_secret"`
+	RefreshToken   string `json:"refresh_token"`
+	QuotaProjectID string `json:"quota_project_id"`
+}
+
+// tokenResponse is an OAuth2 access token response
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// tokenSource fetches access tokens through Application Default
+// Credentials: the file named by GOOGLE_APPLICATION_CREDENTIALS, else the
+// gcloud credentials file, else the metadata server of the GCE, GKE or
+// Cloud Run instance the agent runs on
+type tokenSource struct {
+	httpClient *http.Client
+	creds      *credentials // nil on the metadata server
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newTokenSource finds the application default credentials
+func newTokenSource(httpClient *http.Client) (*tokenSource, error) {
+	path := os.Getenv(CredentialsEnv)
+	if path == "" {
+		path = gcloudCredentialsFile()
+		if _, err := os.Stat(path); err != nil {
+			return &tokenSource{httpClient: httpClient}, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	var creds credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials %s: %w", path, err)
+	}
+	if creds.Type != "service_account" && creds.Type != "authorized_user" {
+		return nil, fmt.Errorf("unsupported credentials type %q in %s", creds.Type, path)
+	}
+	return &tokenSource{httpClient: httpClient, creds: &creds}, nil
+}
+
+// gcloudCredentialsFile returns the path of the credentials written by
+// `gcloud auth application-default login`
+func gcloudCredentialsFile() string {
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return filepath.Join(dir, "application_default_credentials.json")
+	}
+	if appData := os.Getenv("APPDATA"); appData != "" {
+		return filepath.Join(appData, "gcloud", "application_default_credentials.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// project returns the project of the credentials, if they name one
+func (s *tokenSource) project() string {
+	if s.creds == nil {
+		return ""
+	}
+	if s.creds.ProjectID != "" {
+		return s.creds.ProjectID
+	}
+	return s.creds.QuotaProjectID
+}
+
+// Token returns a valid access token, fetching a new one when the last one
+// is about to expire
+func (s *tokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(tokenSlack).Before(s.expires) {
+		return s.token, nil
+	}
+
+	var resp *tokenResponse
+	var err error
+	switch {
+	case s.creds == nil:
+		resp, err = s.metadataToken()
+	case s.creds.Type == "service_account":
+		resp, err = s.serviceAccountToken()
+	default:
+		resp, err = s.refreshToken()
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get Google Cloud access token: %w", err)
+	}
+
+	s.token = resp.AccessToken
+	s.expires = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+// metadataToken asks the instance's metadata server for a token
+func (s *tokenSource) metadataToken() (*tokenResponse, error) {
+	req, err := http.NewRequest("GET", metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("no credentials found: set %s or run `gcloud auth application-default login` (%w)", CredentialsEnv, err)
+	}
+	return readToken(resp)
+}
+
+// refreshToken exchanges a user's refresh token for an access token
+func (s *tokenSource) refreshToken() (*tokenResponse, error) {
+	resp, err := s.httpClient.PostForm(tokenURL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {s.creds.ClientID},
+		"client_secret": {s.creds.ClientSecret},
+		"refresh_token": {s.creds.RefreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readToken(resp)
+}
+
+// serviceAccountToken exchanges a JWT signed with a service account's key
+// for an access token
+func (s *tokenSource) serviceAccountToken() (*tokenResponse, error) {
+	audience := s.creds.TokenURI
+	if audience == "" {
+		audience = tokenURL
+	}
+
+	assertion, err := s.signJWT(audience)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.PostForm(audience, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readToken(resp)
+}
+
+// signJWT returns an RS256-signed JWT asserting the service account's
+// identity for the cloud-platform scope
+func (s *tokenSource) signJWT(audience string) (string, error) {
+	block, _ := pem.Decode([]byte(s.creds.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid private key of service account %s", s.creds.ClientEmail)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("private key of service account %s is not an RSA key", s.creds.ClientEmail)
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.creds.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   s.creds.ClientEmail,
+		"scope": scope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// readToken reads an access token response
+func readToken(resp *http.Response) (*tokenResponse, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token response (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return nil, fmt.Errorf("token request failed (status %d): %s %s", resp.StatusCode, token.Error, token.Description)
+	}
+	return &token, nil
+}
@@ -0,0 +1,375 @@
+// Package vertex is a client for Gemini models on Google Cloud Vertex AI,
+// for organizations whose model access goes through GCP. It authenticates
+// with Application Default Credentials and offers the same methods as the
+// claude client that the test generator calls.
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tablev/test-coverage-agent/claude"
+)
+
+const (
+	DefaultModel     = "gemini-2.5-pro"
+	DefaultLocation  = "us-central1"
+	DefaultMaxTokens = 8000
+
+	// ProjectEnv and LocationEnv are the environment variables the Google
+	// Cloud SDKs read the project and location from
+	ProjectEnv  = "GOOGLE_CLOUD_PROJECT"
+	LocationEnv = "GOOGLE_CLOUD_LOCATION"
+)
+
+// Client handles communication with the Vertex AI API
+type Client struct {
+	project    string
+	location   string
+	httpClient *http.Client
+	tokens     *tokenSource
+	model      string
+	maxTokens  int
+
+	setupErr error // Set if the credentials or project are missing
+
+	usage claude.Usage // Tokens used since the last TakeUsage
+	cost  float64      // Their cost in USD
+}
+
+// NewClient creates a client for a Google Cloud project and location. An
+// empty project or location is taken from GOOGLE_CLOUD_PROJECT and
+// GOOGLE_CLOUD_LOCATION, and else from the credentials and DefaultLocation.
+// Missing credentials or project fail every request, starting with
+// CheckAccess.
+func NewClient(project, location string) *Client {
+	c := &Client{
+		httpClient: &http.Client{
+			Timeout: 120 * time.Second,
+		},
+		model:     DefaultModel,
+		maxTokens: DefaultMaxTokens,
+	}
+
+	c.tokens, c.setupErr = newTokenSource(c.httpClient)
+	if c.setupErr != nil {
+		return c
+	}
+
+	if project == "" {
+		project = os.Getenv(ProjectEnv)
+	}
+	if project == "" {
+		project = c.tokens.project()
+	}
+	if project == "" {
+		c.setupErr = fmt.Errorf("no Google Cloud project: use -vertex-project or set %s", ProjectEnv)
+	}
+	if location == "" {
+		location = os.Getenv(LocationEnv)
+	}
+	if location == "" {
+		location = DefaultLocation
+	}
+
+	c.project = project
+	c.location = location
+	return c
+}
+
+// Model returns the default model of requests
+func (c *Client) Model() string {
+	return c.model
+}
+
+// SetModel sets the default model of requests
+func (c *Client) SetModel(model string) {
+	c.model = model
+}
+
+// SetMaxTokens sets the output token limit of requests
+func (c *Client) SetMaxTokens(maxTokens int) {
+	c.maxTokens = maxTokens
+}
+
+// part is the text of a content
+type part struct {
+	Text string `json:"text"`
+}
+
+// content is a turn of the conversation, or the system instruction
+type content struct {
+	Role  string `json:"role,omitempty"`
+	Parts []part `json:"parts"`
+}
+
+// generateRequest is the body of a generateContent or countTokens request
+type generateRequest struct {
+	Contents          []content       `json:"contents"`
+	SystemInstruction *content        `json:"systemInstruction,omitempty"`
+	GenerationConfig  *generateConfig `json:"generationConfig,omitempty"`
+}
+
+// generateConfig limits the response
+type generateConfig struct {
+	MaxOutputTokens int `json:"maxOutputTokens"`
+}
+
+// generateResponse is the body of a generateContent response
+type generateResponse struct {
+	Candidates []struct {
+		Content      content `json:"content"`
+		FinishReason string  `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// errorResponse is the body of an error response
+type errorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// SendMessageWithSystem sends a message with a system prompt and returns
+// the response
+func (c *Client) SendMessageWithSystem(system, prompt, model string) (string, error) {
+	if model == "" {
+		model = c.model
+	}
+
+	req := generateRequest{
+		Contents:         []content{{Role: "user", Parts: []part{{Text: prompt}}}},
+		GenerationConfig: &generateConfig{MaxOutputTokens: c.maxTokens},
+	}
+	if system != "" {
+		req.SystemInstruction = &content{Parts: []part{{Text: system}}}
+	}
+
+	body, err := c.post(model, "generateContent", req)
+	if err != nil {
+		return "", err
+	}
+
+	var response generateResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	c.recordUsage(model, response)
+
+	if len(response.Candidates) == 0 {
+		return "", fmt.Errorf("empty response from Vertex AI")
+	}
+	var text strings.Builder
+	for _, p := range response.Candidates[0].Content.Parts {
+		text.WriteString(p.Text)
+	}
+	if text.Len() == 0 {
+		return "", fmt.Errorf("empty response from Vertex AI (finish reason %s)", response.Candidates[0].FinishReason)
+	}
+	return text.String(), nil
+}
+
+// SendMessageWithPrefix is SendMessageWithSystem with a prefix before the
+// prompt. Gemini caches repeated prompt prefixes implicitly, so the prefix
+// only has to come first.
+func (c *Client) SendMessageWithPrefix(system, prefix, prompt, model string) (string, error) {
+	if prefix != "" {
+		prompt = prefix + "\n\n" + prompt
+	}
+	return c.SendMessageWithSystem(system, prompt, model)
+}
+
+// SendMessageWithAttachments is SendMessageWithSystem with files included
+// before the prompt, by title
+func (c *Client) SendMessageWithAttachments(system, prompt, model string, attachments map[string]string) (string, error) {
+	titles := make([]string, 0, len(attachments))
+	for title := range attachments {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	var b strings.Builder
+	for _, title := range titles {
+		fmt.Fprintf(&b, "<document title=%q>\n%s\n</document>\n\n", title, attachments[title])
+	}
+	b.WriteString(prompt)
+
+	return c.SendMessageWithSystem(system, b.String(), model)
+}
+
+// CheckAccess verifies the credentials, project and default model
+func (c *Client) CheckAccess() error {
+	return c.CheckModelAccess(c.model)
+}
+
+// CheckModelAccess verifies access to a model by counting the tokens of a
+// minimal prompt, which costs nothing
+func (c *Client) CheckModelAccess(model string) error {
+	req := generateRequest{Contents: []content{{Role: "user", Parts: []part{{Text: "ping"}}}}}
+	_, err := c.post(model, "countTokens", req)
+	if _, ok := err.(*claude.RateLimitError); ok {
+		return nil
+	}
+	return err
+}
+
+// CountTokens returns the number of input tokens a message with a system
+// prompt would use with a model, or the default model if empty
+func (c *Client) CountTokens(system, prompt, model string) (int, error) {
+	if model == "" {
+		model = c.model
+	}
+
+	req := generateRequest{Contents: []content{{Role: "user", Parts: []part{{Text: prompt}}}}}
+	if system != "" {
+		req.SystemInstruction = &content{Parts: []part{{Text: system}}}
+	}
+	body, err := c.post(model, "countTokens", req)
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		TotalTokens int `json:"totalTokens"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return response.TotalTokens, nil
+}
+
+// TakeUsage returns the tokens used and their cost in USD since the last
+// call, and starts counting again
+func (c *Client) TakeUsage() (claude.Usage, float64) {
+	usage, cost := c.usage, c.cost
+	c.usage, c.cost = claude.Usage{}, 0
+	return usage, cost
+}
+
+// DeleteUploads does nothing; attachments are never uploaded
+func (c *Client) DeleteUploads() error {
+	return nil
+}
+
+// price is the cost of a model's tokens in USD per million
+type price struct {
+	input, output, cacheRead float64
+}
+
+// prices by model family, matched against the model name
+var prices = map[string]price{
+	"pro":   {input: 1.25, output: 10, cacheRead: 0.31},
+	"flash": {input: 0.30, output: 2.50, cacheRead: 0.075},
+}
+
+// recordUsage counts the tokens of a response. Cached tokens are part of
+// the prompt count and priced lower.
+func (c *Client) recordUsage(model string, response generateResponse) {
+	meta := response.UsageMetadata
+	usage := claude.Usage{
+		InputTokens:          meta.PromptTokenCount - meta.CachedContentTokenCount,
+		OutputTokens:         meta.CandidatesTokenCount,
+		CacheReadInputTokens: meta.CachedContentTokenCount,
+	}
+	c.usage.InputTokens += usage.InputTokens
+	c.usage.OutputTokens += usage.OutputTokens
+	c.usage.CacheReadInputTokens += usage.CacheReadInputTokens
+
+	// Unknown models are priced like Pro, so spending limits err on the
+	// safe side
+	p := prices["pro"]
+	for family, familyPrice := range prices {
+		if strings.Contains(model, family) {
+			p = familyPrice
+		}
+	}
+	c.cost += (float64(usage.InputTokens)*p.input +
+		float64(usage.OutputTokens)*p.output +
+		float64(usage.CacheReadInputTokens)*p.cacheRead) / 1e6
+}
+
+// post sends a request for a model's method and returns the body of a
+// successful response. Rate limits are returned as a claude.RateLimitError
+// and other error responses as a claude.APIError, so callers handle them
+// like the Claude API's.
+func (c *Client) post(model, method string, body any) ([]byte, error) {
+	if c.setupErr != nil {
+		return nil, c.setupErr
+	}
+
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	token, err := c.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:%s",
+		c.location, c.project, c.location, model, method)
+	if c.location == "global" {
+		endpoint = strings.Replace(endpoint, "global-aiplatform", "aiplatform", 1)
+	}
+	httpReq, err := http.NewRequest("POST", endpoint, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// Vertex AI sends no retry-after header; quotas are per minute
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &claude.RateLimitError{ResetTime: time.Now().Add(60 * time.Second), RetryAfter: 60}
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		apiErr := &claude.APIError{StatusCode: resp.StatusCode, Message: string(bodyBytes), Hint: hint(resp.StatusCode)}
+		if json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Error.Message != "" {
+			apiErr.Type = errResp.Error.Status
+			apiErr.Message = errResp.Error.Message
+		}
+		return nil, apiErr
+	}
+
+	return bodyBytes, nil
+}
+
+// hint explains how to fix an error response that fails every request
+func hint(statusCode int) string {
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return "the credentials were rejected, check " + CredentialsEnv + " or run `gcloud auth application-default login`"
+	case http.StatusForbidden:
+		return "the credentials lack Vertex AI access, check -vertex-project and that the Vertex AI API is enabled in it"
+	case http.StatusNotFound:
+		return "the model was not found, check -model and -vertex-location"
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package workplan
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Partition selects a deterministic subset of a project's files, so several
+// agents working on the same repository take disjoint files. A partition is
+// either a set of directory prefixes ("path:internal/api,cmd") or a hash
+// bucket of the files' directories ("hash:2/3", the second of three
+// buckets). Hashing directories rather than files keeps the tests of a
+// package in one run, so two runs don't add clashing helpers to it.
+type Partition struct {
+	Prefixes []string // Directory or file prefixes, for path partitions
+	Bucket   int      // 1-based bucket, for hash partitions
+	Buckets  int      // Number of buckets, 0 for path partitions
+}
+
+// ParsePartition parses a partition spec; an empty spec is the whole
+// project and returns nil
+func ParsePartition(spec string) (*Partition, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	kind, value, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "path":
+		p := &Partition{}
+		for _, prefix := range strings.Split(value, ",") {
+			prefix = strings.Trim(path.Clean("/"+strings.TrimSpace(prefix)), "/")
+			if prefix != "" {
+				p.Prefixes = append(p.Prefixes, prefix)
+			}
+		}
+		if len(p.Prefixes) == 0 {
+			return nil, fmt.Errorf("partition %q has no path prefixes", spec)
+		}
+		return p, nil
+	case "hash":
+		bucket, buckets, ok := strings.Cut(value, "/")
+		k, err1 := strconv.Atoi(bucket)
+		n, err2 := strconv.Atoi(buckets)
+		if !ok || err1 != nil || err2 != nil || n < 1 || k < 1 || k > n {
+			return nil, fmt.Errorf("partition %q must be hash:K/N with 1 <= K <= N", spec)
+		}
+		return &Partition{Bucket: k, Buckets: n}, nil
+	}
+	return nil, fmt.Errorf("unknown partition %q (use path:PREFIX[,PREFIX...] or hash:K/N)", spec)
+}
+
+// Contains reports whether a project-relative, slash-separated file path
+// belongs to the partition. A nil partition contains every file.
+func (p *Partition) Contains(relPath string) bool {
+	if p == nil {
+		return true
+	}
+
+	if p.Buckets > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(path.Dir(relPath)))
+		return int(h.Sum32()%uint32(p.Buckets)) == p.Bucket-1
+	}
+	for _, prefix := range p.Prefixes {
+		if relPath == prefix || strings.HasPrefix(relPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the partition spec
+func (p *Partition) String() string {
+	if p == nil {
+		return ""
+	}
+	if p.Buckets > 0 {
+		return fmt.Sprintf("hash:%d/%d", p.Bucket, p.Buckets)
+	}
+	return "path:" + strings.Join(p.Prefixes, ",")
+}
+
+// Slug returns a name for the partition that is safe in a git branch name,
+// e.g. "hash-2-of-3" or "path-internal-api"
+func (p *Partition) Slug() string {
+	if p == nil {
+		return ""
+	}
+	if p.Buckets > 0 {
+		return fmt.Sprintf("hash-%d-of-%d", p.Bucket, p.Buckets)
+	}
+
+	var b strings.Builder
+	b.WriteString("path")
+	for _, prefix := range p.Prefixes {
+		b.WriteByte('-')
+		for _, r := range prefix {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('-')
+			}
+		}
+	}
+	return b.String()
+}
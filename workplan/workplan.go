@@ -0,0 +1,70 @@
+// Package workplan turns a coverage report into a prioritized list of files
+// to write tests for. It only needs a coverage.Analyzer, so other tools can
+// plan work without running the orchestrator.
+package workplan
+
+import (
+	"os"
+	"sort"
+
+	"github.com/tablev/test-coverage-agent/coverage"
+)
+
+// Item represents a file that needs test coverage
+type Item struct {
+	SourceFile        string
+	TestFile          string
+	CurrentCoverage   float64
+	UncoveredLines    []int
+	UncoveredBranches []coverage.Branch
+	UntestedMethods   []coverage.Method // Methods no test executes, for tools that report methods
+	Priority          int
+	Exists            bool // True if TestFile already exists
+}
+
+// SkipFunc reports whether a source file should be left out of the plan,
+// e.g. because it was already processed
+type SkipFunc func(sourceFile string) bool
+
+// Prioritize creates a list of the report's uncovered files, highest
+// priority first. Files for which skip returns true are left out; skip may
+// be nil.
+func Prioritize(report *coverage.CoverageReport, analyzer coverage.Analyzer, skip SkipFunc) []Item {
+	var items []Item
+
+	for _, sourceFile := range report.UncoveredFiles {
+		if skip != nil && skip(sourceFile) {
+			continue
+		}
+
+		testFile := analyzer.GetTestFilePath(sourceFile)
+		currentCoverage := report.FileCoverage[sourceFile]
+
+		// Check if test file exists
+		_, err := os.Stat(testFile)
+
+		items = append(items, Item{
+			SourceFile:        sourceFile,
+			TestFile:          testFile,
+			CurrentCoverage:   currentCoverage,
+			UncoveredLines:    report.UncoveredLines[sourceFile],
+			UncoveredBranches: report.UncoveredBranches[sourceFile],
+			UntestedMethods:   report.UntestedMethods(sourceFile),
+			Priority:          Priority(currentCoverage),
+			Exists:            err == nil,
+		})
+	}
+
+	// Sort by priority (higher priority first)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Priority > items[j].Priority
+	})
+
+	return items
+}
+
+// Priority ranks a file by its coverage percentage: lower coverage means
+// higher priority
+func Priority(fileCoverage float64) int {
+	return int(100 - fileCoverage)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tablev/test-coverage-agent/cache"
+	"github.com/tablev/test-coverage-agent/git"
+)
+
+// defaultWorkspaceRoot returns where clones of remote projects are kept
+// unless -workspace says otherwise
+func defaultWorkspaceRoot() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "test-coverage-agent", "workspaces")
+}
+
+// workspaceDir returns the clone directory for a repository URL. The name
+// keeps the repository name readable and adds a hash of the URL, so forks
+// with the same name don't share a clone.
+func workspaceDir(root, url string) string {
+	name := strings.TrimSuffix(path.Base(strings.TrimRight(url, "/")), ".git")
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" || name == "." {
+		name = "repo"
+	}
+	return filepath.Join(root, name+"-"+cache.Key(url)[:8])
+}
+
+// prepareWorkspace returns a checkout of a remote repository to run the
+// session in. A fresh run replaces any earlier clone with a shallow clone of
+// the default branch; a resumed run continues in the existing clone, where
+// the state and session branch live, after checking that it is a clone of
+// the same repository and fetching it.
+func prepareWorkspace(root, url string, resume bool) (string, error) {
+	if root == "" {
+		root = defaultWorkspaceRoot()
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve workspace directory: %w", err)
+	}
+	dir := workspaceDir(root, url)
+
+	if resume {
+		if _, err := os.Stat(dir); err != nil {
+			return "", fmt.Errorf("no workspace to resume for %s (expected %s)", url, dir)
+		}
+		origin, err := git.RemoteURL(dir, "origin")
+		if err != nil {
+			return "", err
+		}
+		if origin != url {
+			return "", fmt.Errorf("workspace %s is a clone of %s, not %s", dir, origin, url)
+		}
+		if err := git.Fetch(dir, "origin"); err != nil {
+			return "", err
+		}
+		fmt.Printf("Resuming in workspace: %s\n", dir)
+		return dir, nil
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return "", fmt.Errorf("failed to remove old workspace: %w", err)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", fmt.Errorf("failed to create workspace directory: %w", err)
+	}
+
+	fmt.Printf("Cloning %s into %s...\n", url, dir)
+	if err := git.Clone(url, dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}